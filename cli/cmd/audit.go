@@ -0,0 +1,268 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/api-direct/cli/pkg/config"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// auditEvent is an entry in the audit log
+type auditEvent struct {
+	Seq          int64           `json:"seq"`
+	OccurredAt   time.Time       `json:"occurred_at"`
+	ActorType    string          `json:"actor_type"`
+	ActorID      string          `json:"actor_id"`
+	Action       string          `json:"action"`
+	ResourceType string          `json:"resource_type"`
+	ResourceID   string          `json:"resource_id"`
+	ConsumerID   string          `json:"consumer_id,omitempty"`
+	Details      json.RawMessage `json:"details"`
+	Hash         string          `json:"hash"`
+}
+
+// auditCmd represents the audit command
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "View the audit log of your API keys and subscriptions",
+	Long: `View who created, renamed, rotated, revoked or used your API keys, and
+when your subscriptions changed status. The log is append-only and each
+entry is chained to the previous one by its hash, so changes to it can be
+detected.
+
+Examples:
+  apidirect audit                              # Latest activity
+  apidirect audit --key key_123                # One key's history
+  apidirect audit --action api_key.revoked     # Revocations only
+  apidirect audit --since 168h                 # The last week
+  apidirect audit --all --consumer <id>        # Any consumer (admins)`,
+	RunE: runAudit,
+}
+
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check the audit log has not been tampered with (admins)",
+	Long: `Recompute the audit log's hash chain and report the first entry that
+was changed or removed, if any.
+
+Examples:
+  apidirect audit verify`,
+	RunE: runAuditVerify,
+}
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+	auditCmd.AddCommand(auditVerifyCmd)
+
+	auditCmd.Flags().String("key", "", "Only events for this API key or subscription ID")
+	auditCmd.Flags().String("action", "", "Only events with this action, such as api_key.rotated")
+	auditCmd.Flags().String("since", "", "Only events since a duration ago (24h), date (2025-12-31) or RFC 3339 time")
+	auditCmd.Flags().Int("limit", 100, "Maximum number of events (up to 1000)")
+	auditCmd.Flags().Int64("after", 0, "Only events after this sequence number")
+	auditCmd.Flags().Bool("all", false, "Events of all consumers (admins only)")
+	auditCmd.Flags().String("consumer", "", "With --all, only events of this consumer")
+	auditCmd.Flags().StringP("format", "f", "table", "Output format (table, json)")
+}
+
+func runAudit(cmd *cobra.Command, args []string) error {
+	key, _ := cmd.Flags().GetString("key")
+	action, _ := cmd.Flags().GetString("action")
+	since, _ := cmd.Flags().GetString("since")
+	limit, _ := cmd.Flags().GetInt("limit")
+	after, _ := cmd.Flags().GetInt64("after")
+	all, _ := cmd.Flags().GetBool("all")
+	consumer, _ := cmd.Flags().GetString("consumer")
+	format, _ := cmd.Flags().GetString("format")
+
+	if consumer != "" && !all {
+		return fmt.Errorf("--consumer requires --all")
+	}
+
+	query := url.Values{}
+	if key != "" {
+		query.Set("resource_id", key)
+	}
+	if action != "" {
+		query.Set("action", action)
+	}
+	if since != "" {
+		t, err := parseSince(since, time.Now())
+		if err != nil {
+			return err
+		}
+		query.Set("since", t.Format(time.RFC3339))
+	}
+	if after > 0 {
+		query.Set("after", strconv.FormatInt(after, 10))
+	}
+	if consumer != "" {
+		query.Set("consumer_id", consumer)
+	}
+	query.Set("limit", strconv.Itoa(limit))
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	path := "/api/v1/audit"
+	if all {
+		path = "/api/v1/admin/audit"
+	}
+	resp, err := makeAuthenticatedRequest("GET", fmt.Sprintf("%s%s?%s", cfg.APIEndpoint, path, query.Encode()), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return handleErrorResponse(resp)
+	}
+
+	var result struct {
+		Events    []auditEvent `json:"events"`
+		Count     int          `json:"count"`
+		NextAfter *int64       `json:"next_after,omitempty"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(cmd.OutOrStdout())
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result.Events)
+
+	default:
+		fmt.Fprintln(cmd.OutOrStdout())
+		if len(result.Events) == 0 {
+			fmt.Fprintln(cmd.OutOrStdout(), "No audit events found")
+			return nil
+		}
+
+		color.New(color.FgCyan, color.Bold).Fprintf(cmd.OutOrStdout(), "📜 Audit Log (%d)\n\n", len(result.Events))
+
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "SEQ\tTIME\tACTOR\tACTION\tRESOURCE\tDETAILS\n")
+		for _, event := range result.Events {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n",
+				event.Seq,
+				event.OccurredAt.Local().Format("2006-01-02 15:04:05"),
+				describeActor(event.ActorType, event.ActorID),
+				event.Action,
+				event.ResourceID,
+				describeDetails(event.Details),
+			)
+		}
+		w.Flush()
+
+		if result.NextAfter != nil {
+			fmt.Fprintf(cmd.OutOrStdout(), "\nMore events: rerun with --after %d\n", *result.NextAfter)
+		}
+		return nil
+	}
+}
+
+func runAuditVerify(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	resp, err := makeAuthenticatedRequest("GET", fmt.Sprintf("%s/api/v1/admin/audit/verify", cfg.APIEndpoint), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return handleErrorResponse(resp)
+	}
+
+	var result struct {
+		Valid    bool   `json:"valid"`
+		Events   int64  `json:"events"`
+		Head     string `json:"head"`
+		BrokenAt *int64 `json:"broken_at,omitempty"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(cmd.OutOrStdout())
+	if !result.Valid {
+		fmt.Fprintln(cmd.OutOrStdout(), color.RedString("❌ Audit log chain broken at entry %d", *result.BrokenAt))
+		return fmt.Errorf("audit log failed verification")
+	}
+
+	fmt.Fprintln(cmd.OutOrStdout(), color.GreenString("✅ Audit log verified: %d entries", result.Events))
+	if result.Head != "" {
+		fmt.Fprintf(cmd.OutOrStdout(), "Head: %s\n", result.Head)
+	}
+	return nil
+}
+
+// parseSince reads a start time given as a duration ago, a date or an
+// RFC 3339 time
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		if d < 0 {
+			return time.Time{}, fmt.Errorf("--since duration must not be negative")
+		}
+		return now.Add(-d).UTC(), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q: use a duration (24h), date (2025-12-31) or RFC 3339 time", value)
+}
+
+// describeActor names who caused an event
+func describeActor(actorType, actorID string) string {
+	if actorID == "" {
+		return actorType
+	}
+	return actorType + ":" + actorID
+}
+
+// describeDetails flattens an event's details for display
+func describeDetails(details json.RawMessage) string {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(details, &fields); err != nil || len(fields) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		v := fields[k]
+		if v == nil {
+			continue
+		}
+		switch v.(type) {
+		case map[string]interface{}, []interface{}:
+			data, _ := json.Marshal(v)
+			parts = append(parts, fmt.Sprintf("%s=%s", k, data))
+		default:
+			parts = append(parts, fmt.Sprintf("%s=%v", k, v))
+		}
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+func newAuditTestCmd(buf *bytes.Buffer) *cobra.Command {
+	cmd := &cobra.Command{}
+	cmd.SetOut(buf)
+	cmd.Flags().String("key", "", "")
+	cmd.Flags().String("action", "", "")
+	cmd.Flags().String("since", "", "")
+	cmd.Flags().Int("limit", 100, "")
+	cmd.Flags().Int64("after", 0, "")
+	cmd.Flags().Bool("all", false, "")
+	cmd.Flags().String("consumer", "", "")
+	cmd.Flags().String("format", "table", "")
+	return cmd
+}
+
+func TestAuditCommand(t *testing.T) {
+	occurredAt := time.Date(2030, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	oldClient := httpClient
+	httpClient = &mockHTTPClient{responses: map[string]mockResponse{
+		"GET /api/v1/audit?action=api_key.renamed&limit=2&resource_id=key_123": {
+			statusCode: 200,
+			body: map[string]interface{}{
+				"events": []map[string]interface{}{
+					{
+						"seq":           41,
+						"occurred_at":   occurredAt,
+						"actor_type":    "consumer",
+						"actor_id":      "consumer_1",
+						"action":        "api_key.renamed",
+						"resource_type": "api_key",
+						"resource_id":   "key_123",
+						"details":       map[string]interface{}{"from": "Dashboard", "to": "Backend"},
+						"hash":          "abc",
+					},
+					{
+						"seq":           42,
+						"occurred_at":   occurredAt,
+						"actor_type":    "consumer",
+						"actor_id":      "consumer_1",
+						"action":        "api_key.renamed",
+						"resource_type": "api_key",
+						"resource_id":   "key_123",
+						"details":       map[string]interface{}{"from": "Backend", "to": "Worker"},
+						"hash":          "def",
+					},
+				},
+				"count":      2,
+				"next_after": 42,
+			},
+		},
+	}}
+	defer func() { httpClient = oldClient }()
+
+	var buf bytes.Buffer
+	cmd := newAuditTestCmd(&buf)
+	cmd.Flags().Set("key", "key_123")
+	cmd.Flags().Set("action", "api_key.renamed")
+	cmd.Flags().Set("limit", "2")
+
+	err := runAudit(cmd, nil)
+	assert.NoError(t, err)
+
+	output := buf.String()
+	for _, expected := range []string{
+		"Audit Log (2)",
+		"consumer:consumer_1",
+		"api_key.renamed",
+		"from=Dashboard to=Backend",
+		"rerun with --after 42",
+	} {
+		assert.Contains(t, output, expected)
+	}
+}
+
+func TestAuditCommandAdmin(t *testing.T) {
+	oldClient := httpClient
+	httpClient = &mockHTTPClient{responses: map[string]mockResponse{
+		"GET /api/v1/admin/audit?consumer_id=consumer_2&limit=100": {
+			statusCode: 200,
+			body:       map[string]interface{}{"events": []interface{}{}, "count": 0},
+		},
+		"GET /api/v1/audit": {
+			statusCode: 500,
+			body:       map[string]interface{}{"error": "wrong endpoint"},
+		},
+	}}
+	defer func() { httpClient = oldClient }()
+
+	var buf bytes.Buffer
+	cmd := newAuditTestCmd(&buf)
+	cmd.Flags().Set("consumer", "consumer_2")
+	assert.Error(t, runAudit(cmd, nil), "--consumer needs --all")
+
+	cmd.Flags().Set("all", "true")
+	assert.NoError(t, runAudit(cmd, nil))
+	assert.Contains(t, buf.String(), "No audit events found")
+}
+
+func TestAuditVerifyCommand(t *testing.T) {
+	oldClient := httpClient
+	defer func() { httpClient = oldClient }()
+
+	var buf bytes.Buffer
+	cmd := &cobra.Command{}
+	cmd.SetOut(&buf)
+
+	httpClient = &mockHTTPClient{responses: map[string]mockResponse{
+		"GET /api/v1/admin/audit/verify": {
+			statusCode: 200,
+			body:       map[string]interface{}{"valid": true, "events": 42, "head": "abc123"},
+		},
+	}}
+	assert.NoError(t, runAuditVerify(cmd, nil))
+	assert.Contains(t, buf.String(), "Audit log verified: 42 entries")
+	assert.Contains(t, buf.String(), "abc123")
+
+	httpClient = &mockHTTPClient{responses: map[string]mockResponse{
+		"GET /api/v1/admin/audit/verify": {
+			statusCode: 200,
+			body:       map[string]interface{}{"valid": false, "events": 7, "broken_at": 7},
+		},
+	}}
+	assert.Error(t, runAuditVerify(cmd, nil))
+	assert.Contains(t, buf.String(), "chain broken at entry 7")
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2030, 1, 2, 12, 0, 0, 0, time.UTC)
+
+	since, err := parseSince("24h", now)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(-24*time.Hour), since)
+
+	since, err = parseSince("2029-12-31", now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2029, 12, 31, 0, 0, 0, 0, time.UTC), since)
+
+	_, err = parseSince("-1h", now)
+	assert.Error(t, err)
+
+	_, err = parseSince("yesterday", now)
+	assert.Error(t, err)
+}
+
+func TestDescribeDetails(t *testing.T) {
+	assert.Equal(t, "", describeDetails(json.RawMessage(`{}`)))
+	assert.Equal(t, "mode=live name=CI", describeDetails(json.RawMessage(`{"name":"CI","mode":"live","expires_at":null}`)))
+	assert.Equal(t, `scopes={"methods":["GET"]}`, describeDetails(json.RawMessage(`{"scopes":{"methods":["GET"]}}`)))
+}
@@ -0,0 +1,376 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/api-direct/cli/pkg/config"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var keysFormat string
+
+// keyScopes restrict what an API key can call
+type keyScopes struct {
+	APIs        []string   `json:"apis,omitempty"`
+	Paths       []string   `json:"paths,omitempty"`
+	Methods     []string   `json:"methods,omitempty"`
+	SourceCIDRs []string   `json:"source_cidrs,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+// apiKeyInfo is an API key as listed by the API key service
+type apiKeyInfo struct {
+	ID         string     `json:"id"`
+	KeyPrefix  string     `json:"key_prefix"`
+	Name       string     `json:"name"`
+	Mode       string     `json:"mode"`
+	IsActive   bool       `json:"is_active"`
+	Scopes     *keyScopes `json:"scopes,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ReplacedBy *string    `json:"replaced_by,omitempty"`
+
+	QuarantinedAt    *time.Time `json:"quarantined_at,omitempty"`
+	QuarantineReason string     `json:"quarantine_reason,omitempty"`
+}
+
+// keysCmd represents the keys command group
+var keysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Manage your API keys",
+	Long: `View your API keys and restrict what each one can call.
+
+Keys can be limited to specific APIs, endpoints, HTTP methods and source
+networks, and can be given an expiry.`,
+}
+
+var keysListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List your API keys",
+	Long: `List all your API keys with their status and scopes.
+
+Examples:
+  apidirect keys list                # All keys
+  apidirect keys list --format json  # JSON output`,
+	RunE: runKeysList,
+}
+
+var keysScopeCmd = &cobra.Command{
+	Use:   "scope [key-id]",
+	Short: "Set the scopes of an API key",
+	Long: `Restrict what an API key can call. The flags replace the key's current
+scopes; anything not given is unrestricted. Paths ending in /* match every
+endpoint under the prefix.
+
+Examples:
+  apidirect keys scope key_123 --api alice/weather --method GET   # Read-only key for one API
+  apidirect keys scope key_123 --path '/forecast/*' --cidr 10.0.0.0/8
+  apidirect keys scope key_123 --expires 720h                      # Expire in 30 days
+  apidirect keys scope key_123 --clear                             # Remove all restrictions`,
+	Args: cobra.ExactArgs(1),
+	RunE: runKeysScope,
+}
+
+var keysRotateCmd = &cobra.Command{
+	Use:   "rotate [key-id]",
+	Short: "Replace an API key with a new one",
+	Long: `Mint a successor for an API key. The new key takes over the old key's
+subscriptions and scopes, and the old key keeps working for a grace period
+so applications can switch over without downtime.
+
+Examples:
+  apidirect keys rotate key_123              # Old key valid for 24h
+  apidirect keys rotate key_123 --grace 1h   # Shorter grace period
+  apidirect keys rotate key_123 --grace 0s   # Retire a leaked key immediately`,
+	Args: cobra.ExactArgs(1),
+	RunE: runKeysRotate,
+}
+
+var keysConfirmCmd = &cobra.Command{
+	Use:   "confirm [key-id]",
+	Short: "Release a quarantined API key",
+	Long: `Keys are quarantined when their usage suggests they leaked: a sudden
+spike in calls, calls from a network or provider the key hasn't been used
+from, or from places too far apart to travel between. Confirm a key if the
+activity was yours; otherwise rotate it.
+
+Examples:
+  apidirect keys confirm key_123`,
+	Args: cobra.ExactArgs(1),
+	RunE: runKeysConfirm,
+}
+
+func init() {
+	rootCmd.AddCommand(keysCmd)
+
+	keysCmd.AddCommand(keysListCmd)
+	keysCmd.AddCommand(keysScopeCmd)
+	keysCmd.AddCommand(keysRotateCmd)
+	keysCmd.AddCommand(keysConfirmCmd)
+
+	// List flags
+	keysListCmd.Flags().StringVarP(&keysFormat, "format", "f", "table", "Output format (table, json)")
+
+	// Scope flags
+	keysScopeCmd.Flags().StringSlice("api", nil, "Allowed API as creator/api-name (repeatable)")
+	keysScopeCmd.Flags().StringSlice("path", nil, "Allowed endpoint path, /* for a prefix (repeatable)")
+	keysScopeCmd.Flags().StringSlice("method", nil, "Allowed HTTP method (repeatable)")
+	keysScopeCmd.Flags().StringSlice("cidr", nil, "Allowed source network or IP (repeatable)")
+	keysScopeCmd.Flags().String("expires", "", "Expiry as a duration (720h), date (2025-12-31) or RFC 3339 time")
+	keysScopeCmd.Flags().Bool("clear", false, "Remove all scopes and the expiry")
+
+	// Rotate flags
+	keysRotateCmd.Flags().String("grace", "", "How long the old key keeps working (default 24h)")
+}
+
+func runKeysList(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/api/v1/keys", cfg.APIEndpoint)
+	resp, err := makeAuthenticatedRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return handleErrorResponse(resp)
+	}
+
+	var result struct {
+		Keys  []apiKeyInfo `json:"keys"`
+		Count int          `json:"count"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+
+	switch keysFormat {
+	case "json":
+		encoder := json.NewEncoder(cmd.OutOrStdout())
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result.Keys)
+
+	default:
+		fmt.Fprintln(cmd.OutOrStdout())
+		if len(result.Keys) == 0 {
+			fmt.Fprintln(cmd.OutOrStdout(), "No API keys found")
+			return nil
+		}
+
+		color.New(color.FgCyan, color.Bold).Fprintf(cmd.OutOrStdout(), "🔑 API Keys (%d)\n\n", len(result.Keys))
+
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "ID\tKEY\tNAME\tMODE\tSTATUS\tSCOPES\tEXPIRES\n")
+		for _, key := range result.Keys {
+			status := "active"
+			if !key.IsActive {
+				status = "revoked"
+			} else if key.QuarantinedAt != nil {
+				status = "quarantined"
+			} else if key.ReplacedBy != nil {
+				status = "rotated"
+			}
+			expires := "never"
+			if key.Scopes != nil && key.Scopes.ExpiresAt != nil {
+				expires = key.Scopes.ExpiresAt.Format("2006-01-02 15:04")
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				key.ID,
+				key.KeyPrefix,
+				key.Name,
+				key.Mode,
+				status,
+				describeScopes(key.Scopes),
+				expires,
+			)
+		}
+		w.Flush()
+
+		return nil
+	}
+}
+
+func runKeysScope(cmd *cobra.Command, args []string) error {
+	keyID := args[0]
+
+	clearScopes, _ := cmd.Flags().GetBool("clear")
+	apis, _ := cmd.Flags().GetStringSlice("api")
+	paths, _ := cmd.Flags().GetStringSlice("path")
+	methods, _ := cmd.Flags().GetStringSlice("method")
+	cidrs, _ := cmd.Flags().GetStringSlice("cidr")
+	expires, _ := cmd.Flags().GetString("expires")
+
+	scopes := keyScopes{}
+	if !clearScopes {
+		if len(apis) == 0 && len(paths) == 0 && len(methods) == 0 && len(cidrs) == 0 && expires == "" {
+			return fmt.Errorf("specify at least one scope, or --clear to remove them")
+		}
+		scopes.APIs = apis
+		scopes.Paths = paths
+		scopes.Methods = methods
+		scopes.SourceCIDRs = cidrs
+		if expires != "" {
+			expiresAt, err := parseExpiry(expires, time.Now())
+			if err != nil {
+				return err
+			}
+			scopes.ExpiresAt = &expiresAt
+		}
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"scopes": scopes})
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/api/v1/keys/%s", cfg.APIEndpoint, keyID)
+	resp, err := makeAuthenticatedRequest("PUT", url, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return handleErrorResponse(resp)
+	}
+
+	fmt.Fprintln(cmd.OutOrStdout())
+	if clearScopes {
+		fmt.Fprintln(cmd.OutOrStdout(), color.GreenString("✅ Removed all restrictions from key %s", keyID))
+		return nil
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), color.GreenString("✅ Updated scopes of key %s", keyID))
+	fmt.Fprintf(cmd.OutOrStdout(), "Scopes: %s\n", describeScopes(&scopes))
+	if scopes.ExpiresAt != nil {
+		fmt.Fprintf(cmd.OutOrStdout(), "Expires: %s\n", scopes.ExpiresAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func runKeysRotate(cmd *cobra.Command, args []string) error {
+	keyID := args[0]
+	grace, _ := cmd.Flags().GetString("grace")
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	request := map[string]interface{}{}
+	if grace != "" {
+		request["grace_period"] = grace
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/api/v1/keys/%s/rotate", cfg.APIEndpoint, keyID)
+	resp, err := makeAuthenticatedRequest("POST", url, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return handleErrorResponse(resp)
+	}
+
+	var result struct {
+		APIKey            string     `json:"api_key"`
+		KeyInfo           apiKeyInfo `json:"key_info"`
+		PreviousExpiresAt time.Time  `json:"previous_expires_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(cmd.OutOrStdout())
+	fmt.Fprintln(cmd.OutOrStdout(), color.GreenString("✅ Rotated key %s", keyID))
+	fmt.Fprintf(cmd.OutOrStdout(), "\nNew key ID: %s\n", result.KeyInfo.ID)
+	fmt.Fprintf(cmd.OutOrStdout(), "New API Key: %s\n", color.YellowString(result.APIKey))
+	fmt.Fprintf(cmd.OutOrStdout(), "Previous key stops working: %s\n", result.PreviousExpiresAt.Local().Format("2006-01-02 15:04:05"))
+	fmt.Fprintln(cmd.OutOrStdout(), "\n⚠️  Save this key securely - it won't be shown again!")
+	return nil
+}
+
+func runKeysConfirm(cmd *cobra.Command, args []string) error {
+	keyID := args[0]
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/api/v1/keys/%s/confirm", cfg.APIEndpoint, keyID)
+	resp, err := makeAuthenticatedRequest("POST", url, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return handleErrorResponse(resp)
+	}
+
+	fmt.Fprintln(cmd.OutOrStdout())
+	fmt.Fprintln(cmd.OutOrStdout(), color.GreenString("✅ Released key %s from quarantine", keyID))
+	return nil
+}
+
+// parseExpiry reads an expiry given as a duration from now, a date or an
+// RFC 3339 time
+func parseExpiry(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		if d <= 0 {
+			return time.Time{}, fmt.Errorf("expiry must be in the future")
+		}
+		return now.Add(d).UTC(), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid expiry %q: use a duration (720h), date (2025-12-31) or RFC 3339 time", value)
+}
+
+// describeScopes summarizes a key's restrictions for display
+func describeScopes(scopes *keyScopes) string {
+	if scopes == nil {
+		return "all"
+	}
+	var parts []string
+	if len(scopes.APIs) > 0 {
+		parts = append(parts, "apis="+strings.Join(scopes.APIs, ","))
+	}
+	if len(scopes.Paths) > 0 {
+		parts = append(parts, "paths="+strings.Join(scopes.Paths, ","))
+	}
+	if len(scopes.Methods) > 0 {
+		parts = append(parts, "methods="+strings.Join(scopes.Methods, ","))
+	}
+	if len(scopes.SourceCIDRs) > 0 {
+		parts = append(parts, "cidrs="+strings.Join(scopes.SourceCIDRs, ","))
+	}
+	if len(parts) == 0 {
+		return "all"
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,233 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeysListCommand(t *testing.T) {
+	expiresAt := time.Date(2030, 1, 2, 15, 4, 0, 0, time.UTC)
+
+	oldClient := httpClient
+	httpClient = &mockHTTPClient{responses: map[string]mockResponse{
+		"GET /api/v1/keys": {
+			statusCode: 200,
+			body: map[string]interface{}{
+				"keys": []map[string]interface{}{
+					{
+						"id":         "key_123",
+						"key_prefix": "sk_abcd1234...",
+						"name":       "Dashboard",
+						"mode":       "live",
+						"is_active":  true,
+						"scopes": map[string]interface{}{
+							"apis":       []string{"alice/weather"},
+							"methods":    []string{"GET"},
+							"expires_at": expiresAt,
+						},
+						"created_at": time.Now(),
+					},
+					{
+						"id":                "key_789",
+						"key_prefix":        "sk_99887766...",
+						"name":              "CI",
+						"mode":              "live",
+						"is_active":         true,
+						"quarantined_at":    time.Now(),
+						"quarantine_reason": "new_asn",
+						"created_at":        time.Now(),
+					},
+					{
+						"id":         "key_456",
+						"key_prefix": "sk_test_ef567890...",
+						"name":       "Sandbox",
+						"mode":       "test",
+						"is_active":  false,
+						"created_at": time.Now(),
+					},
+				},
+				"count": 3,
+			},
+		},
+	}}
+	defer func() { httpClient = oldClient }()
+
+	var buf bytes.Buffer
+	cmd := &cobra.Command{}
+	cmd.SetOut(&buf)
+
+	keysFormat = "table"
+	err := runKeysList(cmd, nil)
+	assert.NoError(t, err)
+
+	output := buf.String()
+	for _, expected := range []string{
+		"API Keys (3)",
+		"key_123",
+		"apis=alice/weather methods=GET",
+		"2030-01-02 15:04",
+		"sk_test_ef567890...",
+		"revoked",
+		"quarantined",
+		"never",
+	} {
+		assert.Contains(t, output, expected)
+	}
+}
+
+func TestKeysScopeCommand(t *testing.T) {
+	tests := []struct {
+		name           string
+		flags          map[string]string
+		expectedOutput []string
+		expectError    bool
+	}{
+		{
+			name: "restrict key",
+			flags: map[string]string{
+				"api":    "alice/weather",
+				"method": "GET,HEAD",
+				"cidr":   "10.0.0.0/8",
+			},
+			expectedOutput: []string{
+				"Updated scopes of key key_123",
+				"apis=alice/weather methods=GET,HEAD cidrs=10.0.0.0/8",
+			},
+		},
+		{
+			name:  "clear scopes",
+			flags: map[string]string{"clear": "true"},
+			expectedOutput: []string{
+				"Removed all restrictions from key key_123",
+			},
+		},
+		{
+			name:        "no scopes given",
+			flags:       map[string]string{},
+			expectError: true,
+		},
+		{
+			name:        "invalid expiry",
+			flags:       map[string]string{"expires": "soon"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldClient := httpClient
+			httpClient = &mockHTTPClient{responses: map[string]mockResponse{
+				"PUT /api/v1/keys/key_123": {
+					statusCode: 200,
+					body:       map[string]interface{}{"message": "API key updated successfully"},
+				},
+			}}
+			defer func() { httpClient = oldClient }()
+
+			var buf bytes.Buffer
+			cmd := &cobra.Command{}
+			cmd.SetOut(&buf)
+			cmd.SetErr(&buf)
+
+			cmd.Flags().StringSlice("api", nil, "")
+			cmd.Flags().StringSlice("path", nil, "")
+			cmd.Flags().StringSlice("method", nil, "")
+			cmd.Flags().StringSlice("cidr", nil, "")
+			cmd.Flags().String("expires", "", "")
+			cmd.Flags().Bool("clear", false, "")
+			for name, value := range tt.flags {
+				cmd.Flags().Set(name, value)
+			}
+
+			err := runKeysScope(cmd, []string{"key_123"})
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			output := buf.String()
+			for _, expected := range tt.expectedOutput {
+				assert.Contains(t, output, expected)
+			}
+		})
+	}
+}
+
+func TestKeysRotateCommand(t *testing.T) {
+	oldClient := httpClient
+	httpClient = &mockHTTPClient{responses: map[string]mockResponse{
+		"POST /api/v1/keys/key_123/rotate": {
+			statusCode: 201,
+			body: map[string]interface{}{
+				"api_key":             "sk_abcd1234newkey",
+				"key_info":            map[string]interface{}{"id": "key_456", "rotated_from": "key_123"},
+				"previous_key_id":     "key_123",
+				"previous_expires_at": time.Now().Add(time.Hour),
+			},
+		},
+	}}
+	defer func() { httpClient = oldClient }()
+
+	var buf bytes.Buffer
+	cmd := &cobra.Command{}
+	cmd.SetOut(&buf)
+	cmd.Flags().String("grace", "", "")
+	cmd.Flags().Set("grace", "1h")
+
+	err := runKeysRotate(cmd, []string{"key_123"})
+	assert.NoError(t, err)
+
+	output := buf.String()
+	for _, expected := range []string{
+		"Rotated key key_123",
+		"key_456",
+		"sk_abcd1234newkey",
+		"Previous key stops working",
+	} {
+		assert.Contains(t, output, expected)
+	}
+}
+
+func TestKeysConfirmCommand(t *testing.T) {
+	oldClient := httpClient
+	httpClient = &mockHTTPClient{responses: map[string]mockResponse{
+		"POST /api/v1/keys/key_123/confirm": {
+			statusCode: 200,
+			body:       map[string]interface{}{"message": "API key confirmed and released from quarantine"},
+		},
+		"POST /api/v1/keys/key_404/confirm": {
+			statusCode: 404,
+			body:       map[string]interface{}{"error": "No quarantined API key found", "code": "KEY_NOT_FOUND"},
+		},
+	}}
+	defer func() { httpClient = oldClient }()
+
+	var buf bytes.Buffer
+	cmd := &cobra.Command{}
+	cmd.SetOut(&buf)
+
+	assert.NoError(t, runKeysConfirm(cmd, []string{"key_123"}))
+	assert.Contains(t, buf.String(), "Released key key_123 from quarantine")
+
+	assert.Error(t, runKeysConfirm(cmd, []string{"key_404"}))
+}
+
+func TestParseExpiry(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	got, err := parseExpiry("48h", now)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(48*time.Hour), got)
+
+	got, err = parseExpiry("2025-12-31", now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC), got)
+
+	_, err = parseExpiry("-1h", now)
+	assert.Error(t, err)
+}
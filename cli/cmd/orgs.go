@@ -0,0 +1,460 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/api-direct/cli/pkg/config"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// organizationInfo is an organization as listed by the API key service
+type organizationInfo struct {
+	ID                string    `json:"id"`
+	Name              string    `json:"name"`
+	Personal          bool      `json:"personal"`
+	BillingConsumerID string    `json:"billing_consumer_id"`
+	CreatedAt         time.Time `json:"created_at"`
+	Role              string    `json:"role,omitempty"`
+}
+
+// organizationMember is a member of an organization
+type organizationMember struct {
+	ConsumerID string    `json:"consumer_id"`
+	Email      string    `json:"email"`
+	Role       string    `json:"role"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// orgsCmd represents the orgs command group
+var orgsCmd = &cobra.Command{
+	Use:     "orgs",
+	Aliases: []string{"org"},
+	Short:   "Manage organizations and their members",
+	Long: `Share API keys and subscriptions with your team through an organization.
+
+Everyone starts with a personal organization. Team organizations have
+members with roles:
+  owner      everything, including renaming the organization
+  admin      members, API keys and subscriptions
+  developer  API keys
+  billing    subscriptions, payment methods and invoices
+
+Commands act for the organization selected with "apidirect orgs use", or
+the APIDIRECT_ORGANIZATION environment variable.`,
+}
+
+var orgsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the organizations you belong to",
+	Long: `List your organizations and your role in each. The one commands act
+for is marked with *.
+
+Examples:
+  apidirect orgs list
+  apidirect orgs list --format json`,
+	RunE: runOrgsList,
+}
+
+var orgsCreateCmd = &cobra.Command{
+	Use:   "create [name]",
+	Short: "Create a team organization",
+	Long: `Create an organization with you as its owner and paying member.
+
+Examples:
+  apidirect orgs create "Acme Engineering"`,
+	Args: cobra.ExactArgs(1),
+	RunE: runOrgsCreate,
+}
+
+var orgsUseCmd = &cobra.Command{
+	Use:   "use [org-id]",
+	Short: "Select the organization commands act for",
+	Long: `Select the organization whose API keys and subscriptions commands manage.
+
+Examples:
+  apidirect orgs use 5f0c...      # A team organization
+  apidirect orgs use --personal   # Back to your personal organization`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runOrgsUse,
+}
+
+var orgsMembersCmd = &cobra.Command{
+	Use:   "members",
+	Short: "List the members of the selected organization",
+	Long: `List the members of the selected organization and their roles.
+
+Examples:
+  apidirect orgs members
+  apidirect orgs members --org 5f0c...`,
+	RunE: runOrgsMembers,
+}
+
+var orgsAddCmd = &cobra.Command{
+	Use:   "add [email]",
+	Short: "Add a member to the selected organization",
+	Long: `Add someone with an API-Direct account to the selected organization.
+Only owners can add owners.
+
+Examples:
+  apidirect orgs add dev@example.com                 # As a developer
+  apidirect orgs add finance@example.com --role billing`,
+	Args: cobra.ExactArgs(1),
+	RunE: runOrgsAdd,
+}
+
+var orgsRoleCmd = &cobra.Command{
+	Use:   "role [consumer-id] [role]",
+	Short: "Change a member's role",
+	Long: `Change a member's role in the selected organization. An organization
+always keeps at least one owner.
+
+Examples:
+  apidirect orgs role 1b2c... admin`,
+	Args: cobra.ExactArgs(2),
+	RunE: runOrgsRole,
+}
+
+var orgsRemoveCmd = &cobra.Command{
+	Use:   "remove [consumer-id]",
+	Short: "Remove a member from the selected organization",
+	Long: `Remove a member from the selected organization. Keys they created stay
+with the organization; rotate any they may have copied. Remove yourself to
+leave the organization.
+
+Examples:
+  apidirect orgs remove 1b2c...`,
+	Args: cobra.ExactArgs(1),
+	RunE: runOrgsRemove,
+}
+
+func init() {
+	rootCmd.AddCommand(orgsCmd)
+
+	orgsCmd.AddCommand(orgsListCmd)
+	orgsCmd.AddCommand(orgsCreateCmd)
+	orgsCmd.AddCommand(orgsUseCmd)
+	orgsCmd.AddCommand(orgsMembersCmd)
+	orgsCmd.AddCommand(orgsAddCmd)
+	orgsCmd.AddCommand(orgsRoleCmd)
+	orgsCmd.AddCommand(orgsRemoveCmd)
+
+	orgsListCmd.Flags().StringP("format", "f", "table", "Output format (table, json)")
+	orgsUseCmd.Flags().Bool("personal", false, "Act for your personal organization")
+	orgsAddCmd.Flags().String("role", "developer", "Role: owner, admin, developer or billing")
+
+	for _, c := range []*cobra.Command{orgsMembersCmd, orgsAddCmd, orgsRoleCmd, orgsRemoveCmd} {
+		c.Flags().String("org", "", "Organization ID (default: the selected organization)")
+	}
+}
+
+// currentOrganization returns the organization commands act for, or "" for
+// the personal organization
+func currentOrganization(cfg *config.Config) string {
+	if org := os.Getenv("APIDIRECT_ORGANIZATION"); org != "" {
+		return org
+	}
+	if cfg == nil {
+		return ""
+	}
+	return cfg.Preferences.Organization
+}
+
+func runOrgsList(cmd *cobra.Command, args []string) error {
+	format, _ := cmd.Flags().GetString("format")
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	orgs, err := fetchOrganizations(cfg)
+	if err != nil {
+		return err
+	}
+
+	if format == "json" {
+		encoder := json.NewEncoder(cmd.OutOrStdout())
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(orgs)
+	}
+
+	current := currentOrganization(cfg)
+
+	fmt.Fprintln(cmd.OutOrStdout())
+	color.New(color.FgCyan, color.Bold).Fprintf(cmd.OutOrStdout(), "🏢 Organizations (%d)\n\n", len(orgs))
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, " \tID\tNAME\tROLE\tTYPE\n")
+	for _, org := range orgs {
+		marker := " "
+		if org.ID == current || (current == "" && org.Personal) {
+			marker = "*"
+		}
+		kind := "team"
+		if org.Personal {
+			kind = "personal"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", marker, org.ID, org.Name, org.Role, kind)
+	}
+	w.Flush()
+
+	return nil
+}
+
+func runOrgsCreate(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(map[string]string{"name": args[0]})
+	if err != nil {
+		return err
+	}
+
+	resp, err := makeAuthenticatedRequest("POST", fmt.Sprintf("%s/api/v1/organizations", cfg.APIEndpoint), body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return handleErrorResponse(resp)
+	}
+
+	var org organizationInfo
+	if err := json.NewDecoder(resp.Body).Decode(&org); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(cmd.OutOrStdout())
+	fmt.Fprintln(cmd.OutOrStdout(), color.GreenString("✅ Created organization %s", org.Name))
+	fmt.Fprintf(cmd.OutOrStdout(), "ID: %s\n", org.ID)
+	fmt.Fprintf(cmd.OutOrStdout(), "\nSwitch to it with: apidirect orgs use %s\n", org.ID)
+	return nil
+}
+
+func runOrgsUse(cmd *cobra.Command, args []string) error {
+	personal, _ := cmd.Flags().GetBool("personal")
+	if personal == (len(args) == 1) {
+		return fmt.Errorf("specify an organization ID or --personal")
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	orgs, err := fetchOrganizations(cfg)
+	if err != nil {
+		return err
+	}
+
+	var selected *organizationInfo
+	for i, org := range orgs {
+		if (personal && org.Personal) || (!personal && org.ID == args[0]) {
+			selected = &orgs[i]
+			break
+		}
+	}
+	if selected == nil {
+		if personal {
+			return fmt.Errorf("personal organization not found")
+		}
+		return fmt.Errorf("you are not a member of organization %s", args[0])
+	}
+
+	cfg.Preferences.Organization = ""
+	if !selected.Personal {
+		cfg.Preferences.Organization = selected.ID
+	}
+	if err := config.SaveConfig(cfg); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+
+	fmt.Fprintln(cmd.OutOrStdout())
+	fmt.Fprintln(cmd.OutOrStdout(), color.GreenString("✅ Now acting for %s (%s)", selected.Name, selected.Role))
+	return nil
+}
+
+func runOrgsMembers(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	org, err := resolveOrganization(cmd, cfg)
+	if err != nil {
+		return err
+	}
+
+	resp, err := makeAuthenticatedRequest("GET", fmt.Sprintf("%s/api/v1/organizations/%s/members", cfg.APIEndpoint, org), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return handleErrorResponse(resp)
+	}
+
+	var result struct {
+		Members []organizationMember `json:"members"`
+		Count   int                  `json:"count"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(cmd.OutOrStdout())
+	color.New(color.FgCyan, color.Bold).Fprintf(cmd.OutOrStdout(), "👥 Members (%d)\n\n", len(result.Members))
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "CONSUMER ID\tEMAIL\tROLE\tSINCE\n")
+	for _, member := range result.Members {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+			member.ConsumerID,
+			member.Email,
+			member.Role,
+			member.CreatedAt.Local().Format("2006-01-02"),
+		)
+	}
+	w.Flush()
+
+	return nil
+}
+
+func runOrgsAdd(cmd *cobra.Command, args []string) error {
+	role, _ := cmd.Flags().GetString("role")
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	org, err := resolveOrganization(cmd, cfg)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(map[string]string{"email": args[0], "role": role})
+	if err != nil {
+		return err
+	}
+
+	resp, err := makeAuthenticatedRequest("POST", fmt.Sprintf("%s/api/v1/organizations/%s/members", cfg.APIEndpoint, org), body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return handleErrorResponse(resp)
+	}
+
+	fmt.Fprintln(cmd.OutOrStdout())
+	fmt.Fprintln(cmd.OutOrStdout(), color.GreenString("✅ Added %s as %s", args[0], role))
+	return nil
+}
+
+func runOrgsRole(cmd *cobra.Command, args []string) error {
+	consumerID, role := args[0], args[1]
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	org, err := resolveOrganization(cmd, cfg)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(map[string]string{"role": role})
+	if err != nil {
+		return err
+	}
+
+	resp, err := makeAuthenticatedRequest("PUT", fmt.Sprintf("%s/api/v1/organizations/%s/members/%s", cfg.APIEndpoint, org, consumerID), body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return handleErrorResponse(resp)
+	}
+
+	fmt.Fprintln(cmd.OutOrStdout())
+	fmt.Fprintln(cmd.OutOrStdout(), color.GreenString("✅ %s is now %s", consumerID, role))
+	return nil
+}
+
+func runOrgsRemove(cmd *cobra.Command, args []string) error {
+	consumerID := args[0]
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	org, err := resolveOrganization(cmd, cfg)
+	if err != nil {
+		return err
+	}
+
+	resp, err := makeAuthenticatedRequest("DELETE", fmt.Sprintf("%s/api/v1/organizations/%s/members/%s", cfg.APIEndpoint, org, consumerID), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return handleErrorResponse(resp)
+	}
+
+	fmt.Fprintln(cmd.OutOrStdout())
+	fmt.Fprintln(cmd.OutOrStdout(), color.GreenString("✅ Removed %s", consumerID))
+	return nil
+}
+
+// fetchOrganizations lists the organizations the user belongs to
+func fetchOrganizations(cfg *config.Config) ([]organizationInfo, error) {
+	resp, err := makeAuthenticatedRequest("GET", fmt.Sprintf("%s/api/v1/organizations", cfg.APIEndpoint), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, handleErrorResponse(resp)
+	}
+
+	var result struct {
+		Organizations []organizationInfo `json:"organizations"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return result.Organizations, nil
+}
+
+// resolveOrganization returns the ID of the organization named by the --org
+// flag, or else the selected one. Personal organizations have no other
+// members, so member commands need a team organization.
+func resolveOrganization(cmd *cobra.Command, cfg *config.Config) (string, error) {
+	org, _ := cmd.Flags().GetString("org")
+	if org == "" {
+		org = currentOrganization(cfg)
+	}
+	if org == "" {
+		return "", fmt.Errorf("no organization selected: pass --org or run 'apidirect orgs use <org-id>'")
+	}
+	return org, nil
+}
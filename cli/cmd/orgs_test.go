@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/api-direct/cli/pkg/config"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testOrganizations = mockResponse{
+	statusCode: 200,
+	body: map[string]interface{}{
+		"organizations": []map[string]interface{}{
+			{"id": "org_personal", "name": "alice@example.com", "personal": true, "role": "owner"},
+			{"id": "org_team", "name": "Acme", "personal": false, "role": "developer"},
+		},
+		"count": 2,
+	},
+}
+
+func TestOrgsListCommand(t *testing.T) {
+	t.Setenv("APIDIRECT_ORGANIZATION", "org_team")
+
+	oldClient := httpClient
+	httpClient = &mockHTTPClient{responses: map[string]mockResponse{
+		"GET /api/v1/organizations": testOrganizations,
+	}}
+	defer func() { httpClient = oldClient }()
+
+	var buf bytes.Buffer
+	cmd := &cobra.Command{}
+	cmd.SetOut(&buf)
+	cmd.Flags().String("format", "table", "")
+
+	assert.NoError(t, runOrgsList(cmd, nil))
+
+	output := buf.String()
+	assert.Contains(t, output, "Organizations (2)")
+	assert.Regexp(t, `\*\s+org_team\s+Acme\s+developer\s+team`, output)
+	assert.Regexp(t, `\n\s+org_personal\s+alice@example.com\s+owner\s+personal`, output)
+}
+
+func TestOrgsUseCommand(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("APIDIRECT_ORGANIZATION", "")
+
+	oldClient := httpClient
+	httpClient = &mockHTTPClient{responses: map[string]mockResponse{
+		"GET /api/v1/organizations": testOrganizations,
+	}}
+	defer func() { httpClient = oldClient }()
+
+	var buf bytes.Buffer
+	cmd := &cobra.Command{}
+	cmd.SetOut(&buf)
+	cmd.Flags().Bool("personal", false, "")
+
+	assert.Error(t, runOrgsUse(cmd, nil), "needs an organization or --personal")
+	assert.Error(t, runOrgsUse(cmd, []string{"org_other"}), "not a member")
+
+	require.NoError(t, runOrgsUse(cmd, []string{"org_team"}))
+	assert.Contains(t, buf.String(), "Now acting for Acme (developer)")
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	assert.Equal(t, "org_team", cfg.Preferences.Organization)
+
+	cmd.Flags().Set("personal", "true")
+	require.NoError(t, runOrgsUse(cmd, nil))
+	cfg, err = config.Load()
+	require.NoError(t, err)
+	assert.Equal(t, "", cfg.Preferences.Organization)
+}
+
+func TestOrgsAddCommand(t *testing.T) {
+	t.Setenv("APIDIRECT_ORGANIZATION", "")
+
+	oldClient := httpClient
+	httpClient = &mockHTTPClient{responses: map[string]mockResponse{
+		"POST /api/v1/organizations/org_team/members": {
+			statusCode: 201,
+			body:       map[string]interface{}{"consumer_id": "consumer_2", "email": "bob@example.com", "role": "billing"},
+		},
+	}}
+	defer func() { httpClient = oldClient }()
+
+	var buf bytes.Buffer
+	cmd := &cobra.Command{}
+	cmd.SetOut(&buf)
+	cmd.Flags().String("org", "", "")
+	cmd.Flags().String("role", "developer", "")
+	cmd.Flags().Set("role", "billing")
+
+	assert.Error(t, runOrgsAdd(cmd, []string{"bob@example.com"}), "no organization selected")
+
+	cmd.Flags().Set("org", "org_team")
+	assert.NoError(t, runOrgsAdd(cmd, []string{"bob@example.com"}))
+	assert.Contains(t, buf.String(), "Added bob@example.com as billing")
+}
+
+func TestCurrentOrganization(t *testing.T) {
+	cfg := &config.Config{Preferences: config.PreferencesConfig{Organization: "org_saved"}}
+
+	t.Setenv("APIDIRECT_ORGANIZATION", "")
+	assert.Equal(t, "", currentOrganization(nil))
+	assert.Equal(t, "org_saved", currentOrganization(cfg))
+
+	t.Setenv("APIDIRECT_ORGANIZATION", "org_env")
+	assert.Equal(t, "org_env", currentOrganization(cfg))
+}
@@ -33,13 +33,16 @@ Example pricing configuration file:
       "type": "free",
       "call_limit": 1000,
       "rate_limit_per_minute": 10,
-      "rate_limit_per_day": 1000
+      "rate_limit_per_day": 1000,
+      "max_concurrent_requests": 2
     },
     {
       "name": "Basic",
       "type": "subscription",
       "monthly_price": 29.99,
       "call_limit": 100000,
+      "overage_policy": "overage",
+      "overage_price_per_call": 0.0005,
       "rate_limit_per_minute": 60,
       "rate_limit_per_day": 50000
     },
@@ -48,10 +51,37 @@ Example pricing configuration file:
       "type": "pay_per_use",
       "price_per_call": 0.001,
       "rate_limit_per_minute": 100,
-      "rate_limit_per_day": 100000
+      "rate_limit_per_day": 100000,
+      "rate_limit_failure_policy": "local",
+      "endpoint_limits": [
+        {"method": "POST", "path": "/v1/batch-predict", "weight": 100, "per_minute": 5},
+        {"path": "/v1/models/*", "weight": 10}
+      ]
     }
   ]
-}`,
+}
+
+rate_limit_failure_policy controls what happens when the gateway's rate
+limiter is unavailable: "open" allows calls, "closed" rejects them and
+"local" keeps enforcing limits per gateway instance.
+
+max_concurrent_requests caps how many calls one API key can have in flight
+at once, e.g. for slow ML inference. Calls over the cap are rejected with
+CONCURRENCY_LIMIT_EXCEEDED.
+
+endpoint_limits set rules for individual endpoints. A call to an endpoint
+with a weight uses that many units of the plan's rate limits and is billed
+as that many calls on pay-per-use plans; endpoints without a weight count as
+one. per_minute caps calls to the endpoint on its own. A path ending in /*
+matches every path under it, and method is optional.
+
+call_limit is the number of calls (or units, for weighted endpoints) included
+in each billing period, which runs monthly from the day the subscription
+started. overage_policy decides what happens at the limit: "block" (the
+default) rejects calls with QUOTA_EXCEEDED, "overage" allows them and bills
+overage_price_per_call (or price_per_call), and "throttle" allows
+quota_throttle_per_minute calls. Consumers get an X-Quota-Warning header at
+80% and 100% of the limit.`,
 	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		apiIdentifier := args[0]
@@ -159,6 +189,19 @@ var getPricingCmd = &cobra.Command{
 						
 						if callLimit, ok := p["call_limit"].(float64); ok && callLimit > 0 {
 							fmt.Printf("  Call Limit: %.0f/month\n", callLimit)
+							switch p["overage_policy"] {
+							case "overage":
+								if price, ok := p["overage_price_per_call"].(float64); ok {
+									fmt.Printf("  Overage: $%.4f/call\n", price)
+								} else {
+									fmt.Println("  Overage: billed per call")
+								}
+							case "throttle":
+								perMinute, _ := p["quota_throttle_per_minute"].(float64)
+								fmt.Printf("  Overage: throttled to %.0f/minute\n", perMinute)
+							default:
+								fmt.Println("  Overage: blocked")
+							}
 						} else {
 							fmt.Println("  Call Limit: Unlimited")
 						}
@@ -166,6 +209,29 @@ var getPricingCmd = &cobra.Command{
 						fmt.Printf("  Rate Limits:\n")
 						fmt.Printf("    - Per minute: %.0f\n", p["rate_limit_per_minute"])
 						fmt.Printf("    - Per day: %.0f\n", p["rate_limit_per_day"])
+						if concurrent, ok := p["max_concurrent_requests"].(float64); ok && concurrent > 0 {
+							fmt.Printf("    - Concurrent: %.0f\n", concurrent)
+						}
+						if endpoints, ok := p["endpoint_limits"].([]interface{}); ok && len(endpoints) > 0 {
+							fmt.Printf("  Endpoint Limits:\n")
+							for _, endpoint := range endpoints {
+								if e, ok := endpoint.(map[string]interface{}); ok {
+									method, _ := e["method"].(string)
+									if method == "" {
+										method = "*"
+									}
+									weight, _ := e["weight"].(float64)
+									if weight <= 0 {
+										weight = 1
+									}
+									fmt.Printf("    - %s %v: weight %.0f", method, e["path"], weight)
+									if perMinute, ok := e["per_minute"].(float64); ok && perMinute > 0 {
+										fmt.Printf(", %.0f per minute", perMinute)
+									}
+									fmt.Println()
+								}
+							}
+						}
 						fmt.Println()
 					}
 				}
@@ -87,10 +87,15 @@ Examples:
 var subscriptionsKeysCmd = &cobra.Command{
 	Use:   "keys [subscription-id]",
 	Short: "Manage API keys",
-	Long: `View and regenerate API keys for a subscription.
+	Long: `View and rotate API keys for a subscription.
+
+Rotating mints a new key and keeps the old one working for a grace period,
+so applications can switch over without downtime.
 
 Examples:
-  apidirect subscriptions keys sub_123abc      # View API keys`,
+  apidirect subscriptions keys sub_123abc                            # View API keys
+  apidirect subscriptions keys sub_123abc --regenerate               # Rotate, old key valid for 24h
+  apidirect subscriptions keys sub_123abc --regenerate --grace 72h   # Longer grace period`,
 	Args: cobra.ExactArgs(1),
 	RunE: runSubscriptionsKeys,
 }
@@ -487,7 +492,7 @@ func runSubscriptionsShow(cmd *cobra.Command, args []string) error {
 		// Actions hint
 		fmt.Fprintf(cmd.OutOrStdout(), "\n💡 Actions:\n")
 		fmt.Fprintf(cmd.OutOrStdout(), "  • View usage details: apidirect subscriptions usage %s\n", subscription.ID)
-		fmt.Fprintf(cmd.OutOrStdout(), "  • Regenerate API key: apidirect subscriptions keys %s --regenerate\n", subscription.ID)
+		fmt.Fprintf(cmd.OutOrStdout(), "  • Rotate API key: apidirect subscriptions keys %s --regenerate\n", subscription.ID)
 		if subscription.Status == "active" {
 			fmt.Fprintf(cmd.OutOrStdout(), "  • Cancel subscription: apidirect subscriptions cancel %s\n", subscription.ID)
 		}
@@ -755,31 +760,47 @@ func runSubscriptionsKeys(cmd *cobra.Command, args []string) error {
 	}
 	
 	if regenerate {
-		// Confirm regeneration
-		fmt.Fprintf(cmd.OutOrStdout(), "\n⚠️  Regenerate API Key\n\n")
-		fmt.Fprintln(cmd.OutOrStdout(), "This will invalidate your current API key.")
-		fmt.Fprintln(cmd.OutOrStdout(), "You'll need to update it in all your applications.")
+		grace, _ := cmd.Flags().GetString("grace")
+		
+		// Confirm rotation
+		fmt.Fprintf(cmd.OutOrStdout(), "\n⚠️  Rotate API Key\n\n")
+		fmt.Fprintln(cmd.OutOrStdout(), "This will replace your current API key with a new one.")
+		if grace == "" {
+			fmt.Fprintln(cmd.OutOrStdout(), "Your current key keeps working during a grace period so you can update your applications.")
+		} else {
+			fmt.Fprintf(cmd.OutOrStdout(), "Your current key keeps working for %s so you can update your applications.\n", grace)
+		}
 		
-		if !confirmAction("\nContinue with regeneration?") {
-			fmt.Fprintln(cmd.OutOrStdout(), "Regeneration cancelled")
+		if !confirmAction("\nContinue with rotation?") {
+			fmt.Fprintln(cmd.OutOrStdout(), "Rotation cancelled")
 			return nil
 		}
 		
-		// Regenerate key
-		url := fmt.Sprintf("%s/api/v1/subscriptions/%s/keys/regenerate", cfg.APIEndpoint, subscriptionID)
-		resp, err := makeAuthenticatedRequest("POST", url, nil)
+		// Rotate the subscription's key
+		request := map[string]interface{}{"subscription_id": subscriptionID}
+		if grace != "" {
+			request["grace_period"] = grace
+		}
+		body, err := json.Marshal(request)
+		if err != nil {
+			return err
+		}
+		
+		url := fmt.Sprintf("%s/api/v1/keys/rotate", cfg.APIEndpoint)
+		resp, err := makeAuthenticatedRequest("POST", url, body)
 		if err != nil {
 			return err
 		}
 		defer resp.Body.Close()
 		
-		if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode != http.StatusCreated {
 			return handleErrorResponse(resp)
 		}
 		
 		var result struct {
-			Key       string    `json:"key"`
-			CreatedAt time.Time `json:"created_at"`
+			APIKey            string    `json:"api_key"`
+			PreviousKeyID     string    `json:"previous_key_id"`
+			PreviousExpiresAt time.Time `json:"previous_expires_at"`
 		}
 		
 		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
@@ -787,8 +808,9 @@ func runSubscriptionsKeys(cmd *cobra.Command, args []string) error {
 		}
 		
 		fmt.Fprintln(cmd.OutOrStdout())
-		fmt.Fprintln(cmd.OutOrStdout(), color.GreenString("✅ API key regenerated successfully"))
-		fmt.Fprintf(cmd.OutOrStdout(), "\nNew API Key: %s\n", color.YellowString(result.Key))
+		fmt.Fprintln(cmd.OutOrStdout(), color.GreenString("✅ API key rotated successfully"))
+		fmt.Fprintf(cmd.OutOrStdout(), "\nNew API Key: %s\n", color.YellowString(result.APIKey))
+		fmt.Fprintf(cmd.OutOrStdout(), "Previous key stops working: %s\n", result.PreviousExpiresAt.Local().Format("2006-01-02 15:04:05"))
 		fmt.Fprintln(cmd.OutOrStdout(), "\n⚠️  Save this key securely - it won't be shown again!")
 		
 		return nil
@@ -886,16 +908,17 @@ func runSubscriptionsKeys(cmd *cobra.Command, args []string) error {
 		}
 		
 		// Actions
-		fmt.Fprintf(cmd.OutOrStdout(), "💡 To regenerate your API key:\n")
+		fmt.Fprintf(cmd.OutOrStdout(), "💡 To rotate your API key:\n")
 		fmt.Fprintf(cmd.OutOrStdout(), "   apidirect subscriptions keys %s --regenerate\n", subscriptionID)
 		
 		return nil
 	}
 }
 
-// Add regenerate flag to keys command
+// Add regenerate flags to keys command
 func init() {
-	subscriptionsKeysCmd.Flags().Bool("regenerate", false, "Regenerate API key")
+	subscriptionsKeysCmd.Flags().Bool("regenerate", false, "Rotate the API key, keeping the old one valid for a grace period")
+	subscriptionsKeysCmd.Flags().String("grace", "", "How long the old key keeps working, e.g. 1h or 72h (default 24h, 0s to retire it immediately)")
 }
 
 // Helper function
@@ -709,24 +709,50 @@ func TestSubscriptionsKeysCommand(t *testing.T) {
 				"regenerate": "true",
 			},
 			mockResponses: map[string]mockResponse{
-				"POST /api/v1/subscriptions/sub_123/keys/regenerate": {
-					statusCode: 200,
+				"POST /api/v1/keys/rotate": {
+					statusCode: 201,
 					body: map[string]interface{}{
-						"key":        "sk_test_newkey987654321",
-						"created_at": time.Now(),
+						"api_key":             "sk_test_newkey987654321",
+						"previous_key_id":     "key_123",
+						"previous_expires_at": time.Now().Add(24 * time.Hour),
 					},
 				},
 			},
-			userInput: "y\n", // Confirm regeneration
+			userInput: "y\n", // Confirm rotation
 			expectedOutput: []string{
-				"Regenerate API Key",
-				"invalidate your current API key",
-				"API key regenerated successfully",
+				"Rotate API Key",
+				"keeps working during a grace period",
+				"API key rotated successfully",
 				"sk_test_newkey987654321",
+				"Previous key stops working",
 				"Save this key securely",
 			},
 			expectError: false,
 		},
+		{
+			name: "regenerate with grace period",
+			args: []string{"sub_123"},
+			flags: map[string]string{
+				"regenerate": "true",
+				"grace":      "72h",
+			},
+			mockResponses: map[string]mockResponse{
+				"POST /api/v1/keys/rotate": {
+					statusCode: 201,
+					body: map[string]interface{}{
+						"api_key":             "sk_test_newkey987654321",
+						"previous_key_id":     "key_123",
+						"previous_expires_at": time.Now().Add(72 * time.Hour),
+					},
+				},
+			},
+			userInput: "y\n",
+			expectedOutput: []string{
+				"keeps working for 72h",
+				"API key rotated successfully",
+			},
+			expectError: false,
+		},
 		{
 			name: "user cancels regeneration",
 			args: []string{"sub_123"},
@@ -735,7 +761,7 @@ func TestSubscriptionsKeysCommand(t *testing.T) {
 			},
 			userInput: "n\n",
 			expectedOutput: []string{
-				"Regeneration cancelled",
+				"Rotation cancelled",
 			},
 			expectError: false,
 		},
@@ -763,8 +789,9 @@ func TestSubscriptionsKeysCommand(t *testing.T) {
 
 			// Set flags
 			cmd.Flags().Bool("regenerate", false, "")
-			if regenerate, ok := tt.flags["regenerate"]; ok {
-				cmd.Flags().Set("regenerate", regenerate)
+			cmd.Flags().String("grace", "", "")
+			for name, value := range tt.flags {
+				cmd.Flags().Set(name, value)
 			}
 
 			// Execute command
@@ -41,8 +41,13 @@ func makeAuthenticatedRequest(method, url string, body []byte) (*http.Response,
 		req.Header.Set("Authorization", "Bearer "+cfg.Auth.AccessToken)
 	}
 	
-	// Add content type for POST requests
-	if method == "POST" && body != nil {
+	// Act for the selected organization, if any
+	if org := currentOrganization(cfg); org != "" {
+		req.Header.Set("X-Organization-ID", org)
+	}
+	
+	// Add content type for requests with a body
+	if (method == "POST" || method == "PUT") && body != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
 	
@@ -47,6 +47,9 @@ type APIConfig struct {
 type PreferencesConfig struct {
 	DefaultRuntime string `json:"default_runtime,omitempty"`
 	OutputFormat   string `json:"output_format,omitempty"`
+	// Organization is the ID of the organization commands act for; empty
+	// means your personal organization
+	Organization string `json:"organization,omitempty"`
 }
 
 // UserConfig stores user information
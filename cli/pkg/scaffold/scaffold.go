@@ -43,6 +43,7 @@ func InitPythonProject(apiName, runtime string) error {
 		"README.md":         getReadmeTemplate(apiName, "Python"),
 		"tests/__init__.py": "",
 		"tests/test_main.py": getPythonTestTemplate(),
+		"gateway_signature.py": getPythonSignatureTemplate(),
 	}
 
 	for filename, content := range files {
@@ -80,6 +81,7 @@ func InitNodeProject(apiName, runtime string) error {
 		".gitignore":       getNodeGitignoreTemplate(),
 		"README.md":        getReadmeTemplate(apiName, "Node.js"),
 		"tests/main.test.js": getNodeTestTemplate(),
+		"gatewaySignature.js": getNodeSignatureTemplate(),
 	}
 
 	for filename, content := range files {
@@ -629,6 +631,15 @@ This is an API-Direct %s API project.
 - ` + "`main.py/js`" + ` - Main API implementation
 - ` + "`requirements.txt/package.json`" + ` - Dependencies
 - ` + "`tests/`" + ` - Test files
+- ` + "`gateway_signature.py`" + ` or ` + "`gatewaySignature.js`" + ` - Verifies that requests came through the API-Direct gateway
+
+## Verifying Requests
+
+The gateway signs every request it forwards to your API. Call
+` + "`verify_event(event)`" + ` (Python) or ` + "`verifyEvent(event)`" + ` (Node.js) from the
+signature helper to reject calls that didn't come through the gateway.
+Deployed functions receive the verification keys in
+` + "`APIDIRECT_SIGNING_SECRET`" + ` and ` + "`APIDIRECT_SIGNING_PUBLIC_KEY`" + `.
 
 ## Available Endpoints
 
@@ -766,6 +777,7 @@ func getPythonTemplateFiles(apiName, runtime string, template APITemplate, featu
 		"README.md":          getTemplateReadme(apiName, "Python", template, features),
 		"tests/__init__.py":  "",
 		"tests/test_main.py": getPythonTemplateTests(template),
+		"gateway_signature.py": getPythonSignatureTemplate(),
 	}
 	
 	// Add feature-specific files
@@ -793,6 +805,7 @@ func getNodeTemplateFiles(apiName, runtime string, template APITemplate, feature
 		".gitignore":         getNodeGitignoreTemplate(),
 		"README.md":          getTemplateReadme(apiName, "Node.js", template, features),
 		"tests/main.test.js": getNodeTemplateTests(template),
+		"gatewaySignature.js": getNodeSignatureTemplate(),
 	}
 	
 	// Add feature-specific files
@@ -1547,6 +1560,12 @@ func getTemplateReadme(apiName, language string, template APITemplate, features
    apidirect publish %s
    ` + "```" + `
 
+## Verifying Requests
+
+The gateway signs every request it forwards to your API. Use the
+` + "`gateway_signature.py`" + ` or ` + "`gatewaySignature.js`" + ` helper to reject calls
+that didn't come through the gateway.
+
 ## Need Help?
 
 - Documentation: https://docs.api-direct.io
@@ -38,6 +38,7 @@ func TestInitPythonProject(t *testing.T) {
 				assert.FileExists(t, filepath.Join(projectPath, "README.md"))
 				assert.FileExists(t, filepath.Join(projectPath, "tests", "__init__.py"))
 				assert.FileExists(t, filepath.Join(projectPath, "tests", "test_main.py"))
+				assert.FileExists(t, filepath.Join(projectPath, "gateway_signature.py"))
 				
 				// Verify apidirect.yaml is valid YAML and contains required fields
 				configPath := filepath.Join(projectPath, "apidirect.yaml")
@@ -162,6 +163,7 @@ func TestInitNodeProject(t *testing.T) {
 				assert.FileExists(t, filepath.Join(projectPath, ".gitignore"))
 				assert.FileExists(t, filepath.Join(projectPath, "README.md"))
 				assert.FileExists(t, filepath.Join(projectPath, "tests", "main.test.js"))
+				assert.FileExists(t, filepath.Join(projectPath, "gatewaySignature.js"))
 				
 				// Verify package.json is valid JSON
 				packagePath := filepath.Join(projectPath, "package.json")
@@ -0,0 +1,318 @@
+package scaffold
+
+// Helpers generated into projects so functions can check that a request was
+// signed by the API-Direct gateway. They must follow the gateway's signing
+// format: a v1 string to sign over the method, path and canonical query,
+// timestamp, body SHA-256 and consumer headers. The canonical query has its
+// parameters percent-encoded as in RFC 3986 and sorted, so it can be rebuilt
+// from events that only carry the parsed parameters.
+
+func getPythonSignatureTemplate() string {
+	return `"""
+Verify that requests were signed by the API-Direct gateway.
+
+The gateway signs every call it forwards to your API. Deployed functions
+receive the keys in APIDIRECT_SIGNING_SECRET (hmac-sha256) and
+APIDIRECT_SIGNING_PUBLIC_KEY (ed25519). Ed25519 verification needs the
+cryptography package.
+
+Usage:
+    from gateway_signature import verify_event, SignatureError
+
+    def handler(event, context):
+        try:
+            verify_event(event)
+        except SignatureError:
+            return {'statusCode': 401, 'body': 'invalid signature'}
+"""
+import base64
+import hashlib
+import hmac
+import os
+import time
+from typing import Any, Dict, Mapping, Optional, Union
+from urllib.parse import parse_qsl, quote, urlencode
+
+MAX_SKEW_SECONDS = 300
+UNSIGNED_PAYLOAD = 'UNSIGNED-PAYLOAD'
+
+
+class SignatureError(Exception):
+    """Raised when a request was not signed by the gateway"""
+
+
+def _header(headers: Mapping[str, str], name: str) -> str:
+    name = name.lower()
+    for key, value in (headers or {}).items():
+        if key.lower() == name:
+            return value
+    return ''
+
+
+def canonical_query(query: str) -> str:
+    """Returns a query string with its parameters encoded and sorted as the gateway signs them"""
+    pairs = parse_qsl(query, keep_blank_values=True)
+    return '&'.join(sorted(quote(key, safe='~') + '=' + quote(value, safe='~') for key, value in pairs))
+
+
+def string_to_sign(method: str, path: str, headers: Mapping[str, str], timestamp: str, body_hash: str) -> str:
+    """Returns the canonical form of a request the gateway signs"""
+    path, _, query = path.partition('?')
+    query = canonical_query(query)
+    if query:
+        path += '?' + query
+    return '\n'.join([
+        'v1',
+        method.upper(),
+        path or '/',
+        timestamp,
+        body_hash,
+        _header(headers, 'X-Consumer-ID'),
+        _header(headers, 'X-Subscription-ID'),
+    ])
+
+
+def verify_request(
+    method: str,
+    path: str,
+    headers: Mapping[str, str],
+    body: Union[bytes, str, None],
+    secret: Optional[str] = None,
+    public_key: Optional[str] = None,
+    max_skew: int = MAX_SKEW_SECONDS,
+    require_body_hash: bool = False,
+) -> None:
+    """
+    Checks the gateway signature of a request. path includes the query
+    string, in any order and encoding. Raises SignatureError if it doesn't
+    verify.
+    """
+    secret = secret or os.environ.get('APIDIRECT_SIGNING_SECRET')
+    public_key = public_key or os.environ.get('APIDIRECT_SIGNING_PUBLIC_KEY')
+
+    timestamp = _header(headers, 'X-Gateway-Timestamp')
+    try:
+        skew = abs(time.time() - int(timestamp))
+    except ValueError:
+        raise SignatureError('missing or invalid timestamp')
+    if skew > max_skew:
+        raise SignatureError('timestamp out of range')
+
+    if isinstance(body, str):
+        body = body.encode('utf-8')
+    body_hash = _header(headers, 'X-Gateway-Content-SHA256')
+    if body_hash == UNSIGNED_PAYLOAD:
+        if require_body_hash:
+            raise SignatureError('body was not signed')
+    elif not hmac.compare_digest(body_hash, hashlib.sha256(body or b'').hexdigest()):
+        raise SignatureError('body does not match signature')
+
+    algorithm, _, encoded = _header(headers, 'X-Gateway-Signature').partition('=')
+    try:
+        signature = base64.b64decode(encoded, validate=True)
+    except ValueError:
+        raise SignatureError('invalid signature encoding')
+    message = string_to_sign(method, path, headers, timestamp, body_hash).encode('utf-8')
+
+    if algorithm == 'hmac-sha256' and secret:
+        expected = hmac.new(base64.b64decode(secret), message, hashlib.sha256).digest()
+        if hmac.compare_digest(signature, expected):
+            return
+    elif algorithm == 'ed25519' and public_key:
+        from cryptography.exceptions import InvalidSignature
+        from cryptography.hazmat.primitives.asymmetric.ed25519 import Ed25519PublicKey
+
+        key = Ed25519PublicKey.from_public_bytes(base64.b64decode(public_key))
+        try:
+            key.verify(signature, message)
+            return
+        except InvalidSignature:
+            pass
+    raise SignatureError('invalid signature')
+
+
+def verify_event(event: Dict[str, Any], **kwargs: Any) -> None:
+    """Checks the gateway signature of a function event"""
+    path = event.get('rawPath') or event.get('path') or '/'
+    query = event.get('rawQueryString')
+    if query is None:
+        # REST API events only carry the parsed parameters
+        params = event.get('multiValueQueryStringParameters')
+        if params is None:
+            params = {key: [value] for key, value in (event.get('queryStringParameters') or {}).items()}
+        query = urlencode(params, doseq=True)
+    if query:
+        path += '?' + query
+
+    method = event.get('httpMethod') or event.get('requestContext', {}).get('http', {}).get('method', '')
+    body = event.get('body')
+    if body and event.get('isBase64Encoded'):
+        body = base64.b64decode(body)
+    verify_request(method, path, event.get('headers') or {}, body, **kwargs)
+`
+}
+
+func getNodeSignatureTemplate() string {
+	return `/**
+ * Verify that requests were signed by the API-Direct gateway.
+ *
+ * The gateway signs every call it forwards to your API. Deployed functions
+ * receive the keys in APIDIRECT_SIGNING_SECRET (hmac-sha256) and
+ * APIDIRECT_SIGNING_PUBLIC_KEY (ed25519).
+ *
+ * Usage:
+ *   const { verifyEvent } = require('./gatewaySignature');
+ *
+ *   exports.handler = async (event) => {
+ *       if (!verifyEvent(event)) {
+ *           return { statusCode: 401, body: 'invalid signature' };
+ *       }
+ *       ...
+ *   };
+ */
+
+const crypto = require('crypto');
+
+const MAX_SKEW_SECONDS = 300;
+const UNSIGNED_PAYLOAD = 'UNSIGNED-PAYLOAD';
+
+function header(headers, name) {
+    name = name.toLowerCase();
+    for (const [key, value] of Object.entries(headers || {})) {
+        if (key.toLowerCase() === name) {
+            return Array.isArray(value) ? value[0] : String(value);
+        }
+    }
+    return '';
+}
+
+function encode(value) {
+    return encodeURIComponent(value).replace(/[!'()*]/g, (c) => '%' + c.charCodeAt(0).toString(16).toUpperCase());
+}
+
+/**
+ * Returns a query string with its parameters encoded and sorted as the
+ * gateway signs them
+ */
+function canonicalQuery(query) {
+    const pairs = [];
+    for (const [key, value] of new URLSearchParams(query)) {
+        pairs.push(encode(key) + '=' + encode(value));
+    }
+    return pairs.sort().join('&');
+}
+
+/**
+ * Returns the canonical form of a request the gateway signs
+ */
+function stringToSign(method, path, headers, timestamp, bodyHash) {
+    const separator = path.indexOf('?');
+    const query = separator < 0 ? '' : canonicalQuery(path.slice(separator + 1));
+    if (separator >= 0) {
+        path = path.slice(0, separator);
+    }
+    if (query) {
+        path += '?' + query;
+    }
+    return [
+        'v1',
+        method.toUpperCase(),
+        path || '/',
+        timestamp,
+        bodyHash,
+        header(headers, 'X-Consumer-ID'),
+        header(headers, 'X-Subscription-ID'),
+    ].join('\n');
+}
+
+function safeEqual(a, b) {
+    const left = Buffer.from(a);
+    const right = Buffer.from(b);
+    return left.length === right.length && crypto.timingSafeEqual(left, right);
+}
+
+/**
+ * Checks the gateway signature of a request. path includes the query string,
+ * in any order and encoding. Returns true if the request was signed by the
+ * gateway.
+ */
+function verifyRequest({ method, path, headers, body }, options = {}) {
+    const secret = options.secret || process.env.APIDIRECT_SIGNING_SECRET;
+    const publicKey = options.publicKey || process.env.APIDIRECT_SIGNING_PUBLIC_KEY;
+    const maxSkew = options.maxSkew || MAX_SKEW_SECONDS;
+
+    const timestamp = header(headers, 'X-Gateway-Timestamp');
+    if (!/^\d+$/.test(timestamp) || Math.abs(Date.now() / 1000 - Number(timestamp)) > maxSkew) {
+        return false;
+    }
+
+    const bodyHash = header(headers, 'X-Gateway-Content-SHA256');
+    if (bodyHash === UNSIGNED_PAYLOAD) {
+        if (options.requireBodyHash) {
+            return false;
+        }
+    } else {
+        const actual = crypto.createHash('sha256').update(body || '').digest('hex');
+        if (!safeEqual(bodyHash, actual)) {
+            return false;
+        }
+    }
+
+    const signatureHeader = header(headers, 'X-Gateway-Signature');
+    const separator = signatureHeader.indexOf('=');
+    if (separator < 0) {
+        return false;
+    }
+    const algorithm = signatureHeader.slice(0, separator);
+    const signature = Buffer.from(signatureHeader.slice(separator + 1), 'base64');
+    const message = Buffer.from(stringToSign(method, path, headers, timestamp, bodyHash));
+
+    if (algorithm === 'hmac-sha256' && secret) {
+        const expected = crypto.createHmac('sha256', Buffer.from(secret, 'base64')).update(message).digest();
+        return safeEqual(signature, expected);
+    }
+    if (algorithm === 'ed25519' && publicKey) {
+        const key = crypto.createPublicKey({
+            key: { kty: 'OKP', crv: 'Ed25519', x: Buffer.from(publicKey, 'base64').toString('base64url') },
+            format: 'jwk',
+        });
+        return crypto.verify(null, message, key, signature);
+    }
+    return false;
+}
+
+/**
+ * Checks the gateway signature of a function event
+ */
+function verifyEvent(event, options = {}) {
+    let path = event.rawPath || event.path || '/';
+    let query = event.rawQueryString;
+    if (query === undefined) {
+        // REST API events only carry the parsed parameters
+        const params = new URLSearchParams();
+        if (event.multiValueQueryStringParameters) {
+            for (const [key, values] of Object.entries(event.multiValueQueryStringParameters)) {
+                values.forEach((value) => params.append(key, value));
+            }
+        } else {
+            for (const [key, value] of Object.entries(event.queryStringParameters || {})) {
+                params.append(key, value);
+            }
+        }
+        query = params.toString();
+    }
+    if (query) {
+        path += '?' + query;
+    }
+
+    const method = event.httpMethod || (event.requestContext && event.requestContext.http && event.requestContext.http.method) || '';
+    let body = event.body || '';
+    if (body && event.isBase64Encoded) {
+        body = Buffer.from(body, 'base64');
+    }
+    return verifyRequest({ method, path, headers: event.headers, body }, options);
+}
+
+module.exports = { verifyRequest, verifyEvent, stringToSign, canonicalQuery };
+`
+}
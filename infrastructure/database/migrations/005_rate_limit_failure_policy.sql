@@ -0,0 +1,9 @@
+-- Migration: Rate Limit Failure Policy
+-- Version: 005
+-- Description: Let pricing plans choose how the gateway rate limits them when Redis is unavailable
+
+-- open: allow requests uncounted, closed: reject with 503, local: per-instance in-memory limits.
+-- NULL uses the gateway's RATE_LIMIT_FAILURE_POLICY.
+ALTER TABLE api_pricing_plans
+ADD COLUMN IF NOT EXISTS rate_limit_failure_policy VARCHAR(20)
+    CHECK (rate_limit_failure_policy IN ('open', 'closed', 'local'));
@@ -0,0 +1,12 @@
+-- Migration: Streaming Usage
+-- Version: 006
+-- Description: Meter long-lived SSE and WebSocket connections by duration and bytes
+
+ALTER TABLE api_usage
+ADD COLUMN IF NOT EXISTS streaming BOOLEAN NOT NULL DEFAULT false,
+ADD COLUMN IF NOT EXISTS duration_ms BIGINT NOT NULL DEFAULT 0;
+
+-- Long-lived connections can exceed INTEGER byte counts
+ALTER TABLE api_usage
+ALTER COLUMN request_size_bytes TYPE BIGINT,
+ALTER COLUMN response_size_bytes TYPE BIGINT;
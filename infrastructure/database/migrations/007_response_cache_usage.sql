@@ -0,0 +1,7 @@
+-- Migration: Response Cache Usage
+-- Version: 007
+-- Description: Meter gateway cache hits separately so creators can choose whether to bill them
+
+ALTER TABLE api_usage
+ADD COLUMN IF NOT EXISTS cache_hit BOOLEAN NOT NULL DEFAULT false,
+ADD COLUMN IF NOT EXISTS billable BOOLEAN NOT NULL DEFAULT true;
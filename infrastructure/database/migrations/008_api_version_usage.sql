@@ -0,0 +1,6 @@
+-- Migration: API Version Usage
+-- Version: 008
+-- Description: Record which API version served each call so canaries can be compared
+
+ALTER TABLE api_usage
+ADD COLUMN IF NOT EXISTS api_version VARCHAR(100);
@@ -0,0 +1,8 @@
+-- Migration: Concurrency Limits
+-- Version: 009
+-- Description: Let pricing plans cap the number of in-flight requests per API key
+
+-- NULL leaves in-flight requests unlimited; rate limits still apply.
+ALTER TABLE api_pricing_plans
+ADD COLUMN IF NOT EXISTS max_concurrent_requests INTEGER
+    CHECK (max_concurrent_requests IS NULL OR max_concurrent_requests > 0);
@@ -0,0 +1,14 @@
+-- Migration: Endpoint Limits and Weights
+-- Version: 010
+-- Description: Let pricing plans set per-endpoint rate limits and call weights, and meter weighted units
+
+-- Each rule is {"method", "path", "weight", "per_minute"}; a path ending in
+-- /* matches every path under the prefix. Calls to endpoints without a rule
+-- weigh one unit.
+ALTER TABLE api_pricing_plans
+ADD COLUMN IF NOT EXISTS endpoint_limits JSONB NOT NULL DEFAULT '[]'
+    CHECK (jsonb_typeof(endpoint_limits) = 'array');
+
+-- Units is the call's weight; pay-per-use plans are billed by units
+ALTER TABLE api_usage
+ADD COLUMN IF NOT EXISTS units INTEGER NOT NULL DEFAULT 1 CHECK (units > 0);
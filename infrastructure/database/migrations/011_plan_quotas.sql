@@ -0,0 +1,18 @@
+-- Migration: Plan Quotas
+-- Version: 011
+-- Description: Enforce pricing plan call limits per billing period with an overage policy
+
+-- call_limit is the number of units included in each billing period, which
+-- runs monthly from the subscription's start. At the limit, block rejects
+-- calls, overage allows them and bills overage_price_per_call (or
+-- price_per_call when unset), and throttle allows quota_throttle_per_minute.
+ALTER TABLE api_pricing_plans
+ADD COLUMN IF NOT EXISTS overage_policy VARCHAR(20) NOT NULL DEFAULT 'block'
+    CHECK (overage_policy IN ('block', 'overage', 'throttle')),
+ADD COLUMN IF NOT EXISTS overage_price_per_call DECIMAL(10,4),
+ADD COLUMN IF NOT EXISTS quota_throttle_per_minute INTEGER
+    CHECK (quota_throttle_per_minute IS NULL OR quota_throttle_per_minute > 0);
+
+-- Units of a call that were past the subscription's quota
+ALTER TABLE api_usage
+ADD COLUMN IF NOT EXISTS overage_units INTEGER NOT NULL DEFAULT 0 CHECK (overage_units >= 0);
@@ -0,0 +1,21 @@
+-- Migration: OAuth Clients
+-- Version: 012
+-- Description: OAuth2 client credentials that exchange for short-lived access tokens
+
+-- A client is bound to one subscription and exchanges its ID and secret for
+-- signed JWTs the gateway validates without calling the apikey service.
+-- Only a hash of the secret is stored.
+CREATE TABLE IF NOT EXISTS oauth_clients (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    client_id VARCHAR(64) UNIQUE NOT NULL,
+    secret_hash VARCHAR(255) NOT NULL,
+    consumer_id UUID NOT NULL REFERENCES consumers(id) ON DELETE CASCADE,
+    subscription_id UUID NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+    name VARCHAR(255),
+    is_active BOOLEAN NOT NULL DEFAULT true,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_oauth_clients_consumer ON oauth_clients(consumer_id);
+CREATE INDEX IF NOT EXISTS idx_oauth_clients_subscription ON oauth_clients(subscription_id);
@@ -0,0 +1,10 @@
+-- Migration: Test Mode Keys
+-- Version: 013
+-- Description: Sandbox API keys that are served by sandbox deployments or spec examples
+
+-- Test keys (sk_test_...) belong to a consumer rather than a subscription.
+-- They work on every published API, are rate limited separately from live
+-- keys and their calls are never billed.
+ALTER TABLE api_keys
+ADD COLUMN IF NOT EXISTS mode VARCHAR(10) NOT NULL DEFAULT 'live'
+    CHECK (mode IN ('live', 'test'));
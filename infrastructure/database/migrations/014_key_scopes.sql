@@ -0,0 +1,12 @@
+-- Migration: Key Scopes
+-- Version: 014
+-- Description: Restrict API keys by API, endpoint, method and source IP, and let them expire
+
+-- scopes is a JSON object of allowlists: apis ("<creator>/<apiName>"),
+-- paths (a trailing /* matches a prefix), methods and source_cidrs. Empty
+-- or missing lists allow everything. Keys past expires_at stop validating.
+ALTER TABLE api_keys
+ADD COLUMN IF NOT EXISTS scopes JSONB,
+ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_expires_at ON api_keys(expires_at) WHERE expires_at IS NOT NULL;
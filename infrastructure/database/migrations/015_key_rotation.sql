@@ -0,0 +1,15 @@
+-- Migration: Key Rotation
+-- Version: 015
+-- Description: Rotate API keys with a grace period and notify consumers before keys expire
+
+-- Rotating a key mints a successor and moves the key's subscriptions to it.
+-- The old key keeps validating against them through replaced_by, which
+-- always points at the latest key in the chain, until its expires_at.
+ALTER TABLE api_keys
+ADD COLUMN IF NOT EXISTS rotated_from UUID REFERENCES api_keys(id),
+ADD COLUMN IF NOT EXISTS replaced_by UUID REFERENCES api_keys(id),
+ADD COLUMN IF NOT EXISTS rotated_at TIMESTAMP,
+ADD COLUMN IF NOT EXISTS expiry_notified_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_replaced_by ON api_keys(replaced_by) WHERE replaced_by IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_subscriptions_api_key_id ON subscriptions(api_key_id);
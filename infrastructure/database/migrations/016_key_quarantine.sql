@@ -0,0 +1,51 @@
+-- Migration: Key Quarantine
+-- Version: 016
+-- Description: Detect anomalous API key usage, quarantine suspicious keys and revoke reported leaks
+
+-- Quarantined keys stop validating until their consumer confirms them.
+-- The last location is where the key was last used from, for spotting
+-- impossible travel.
+ALTER TABLE api_keys
+ADD COLUMN IF NOT EXISTS quarantined_at TIMESTAMP,
+ADD COLUMN IF NOT EXISTS quarantine_reason TEXT,
+ADD COLUMN IF NOT EXISTS confirmed_at TIMESTAMP,
+ADD COLUMN IF NOT EXISTS last_latitude DOUBLE PRECISION,
+ADD COLUMN IF NOT EXISTS last_longitude DOUBLE PRECISION,
+ADD COLUMN IF NOT EXISTS last_located_at TIMESTAMP;
+
+-- Networks each key has been used from, as reported by the gateway. ip_range
+-- is the wider range (/16 or /32 for IPv6) checked for keys without ASN data.
+CREATE TABLE IF NOT EXISTS api_key_sources (
+    api_key_id UUID NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    network CIDR NOT NULL,
+    ip_range CIDR NOT NULL,
+    asn INTEGER NOT NULL DEFAULT 0,
+    country VARCHAR(2),
+    calls BIGINT NOT NULL DEFAULT 0,
+    first_seen TIMESTAMP NOT NULL,
+    last_seen TIMESTAMP NOT NULL,
+    PRIMARY KEY (api_key_id, network)
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_key_sources_asn ON api_key_sources(api_key_id, asn);
+CREATE INDEX IF NOT EXISTS idx_api_key_sources_range ON api_key_sources(api_key_id, ip_range);
+
+-- Calls per key and hour, the baseline for volume spikes
+CREATE TABLE IF NOT EXISTS api_key_hourly_calls (
+    api_key_id UUID NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    hour TIMESTAMP NOT NULL,
+    calls BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (api_key_id, hour)
+);
+
+-- Why keys were quarantined or revoked: volume_spike, new_asn,
+-- new_network, impossible_travel or leaked
+CREATE TABLE IF NOT EXISTS api_key_anomalies (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    api_key_id UUID NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    kind VARCHAR(32) NOT NULL,
+    details TEXT NOT NULL,
+    detected_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_key_anomalies_key ON api_key_anomalies(api_key_id, detected_at);
@@ -0,0 +1,114 @@
+-- Migration: Audit Log
+-- Version: 017
+-- Description: Append-only, hash-chained audit log of API key and subscription lifecycle events
+
+-- Each event's hash covers the previous event's hash and the event's own
+-- fields, so changing or removing an event breaks the chain from there on.
+-- seq, occurred_at, prev_hash and hash are set by audit_log_chain; writers
+-- only insert the remaining columns.
+CREATE TABLE IF NOT EXISTS audit_log (
+    seq BIGINT PRIMARY KEY,
+    occurred_at TIMESTAMP NOT NULL,
+    actor_type VARCHAR(20) NOT NULL,
+    actor_id VARCHAR(255) NOT NULL DEFAULT '',
+    action VARCHAR(64) NOT NULL,
+    resource_type VARCHAR(32) NOT NULL,
+    resource_id VARCHAR(255) NOT NULL,
+    consumer_id UUID,
+    details JSONB NOT NULL DEFAULT '{}',
+    prev_hash VARCHAR(64) NOT NULL,
+    hash VARCHAR(64) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_consumer ON audit_log(consumer_id, seq);
+CREATE INDEX IF NOT EXISTS idx_audit_log_resource ON audit_log(resource_id, seq);
+
+-- audit_log_canonical is the text an event's hash is computed over. The
+-- apikey service rebuilds it when verifying the chain, so the two must
+-- match: fields are separated by the unit separator (0x1f) and times are
+-- UTC with microseconds.
+CREATE OR REPLACE FUNCTION audit_log_canonical(e audit_log)
+RETURNS TEXT AS $$
+BEGIN
+    RETURN concat_ws(chr(31),
+        e.prev_hash,
+        e.seq::text,
+        to_char(e.occurred_at, 'YYYY-MM-DD"T"HH24:MI:SS.US"Z"'),
+        e.actor_type,
+        e.actor_id,
+        e.action,
+        e.resource_type,
+        e.resource_id,
+        COALESCE(e.consumer_id::text, ''),
+        e.details::text);
+END;
+$$ LANGUAGE plpgsql STABLE;
+
+-- Events are chained one at a time: the advisory lock is held until the
+-- writer's transaction commits, so the next event sees this one.
+CREATE OR REPLACE FUNCTION audit_log_chain()
+RETURNS TRIGGER AS $$
+DECLARE
+    last audit_log%ROWTYPE;
+BEGIN
+    PERFORM pg_advisory_xact_lock(hashtext('audit_log'));
+
+    SELECT * INTO last FROM audit_log ORDER BY seq DESC LIMIT 1;
+
+    NEW.seq := COALESCE(last.seq, 0) + 1;
+    NEW.prev_hash := COALESCE(last.hash, '');
+    NEW.occurred_at := date_trunc('microseconds', clock_timestamp() AT TIME ZONE 'UTC');
+    NEW.details := COALESCE(NEW.details, '{}');
+    NEW.hash := encode(sha256(convert_to(audit_log_canonical(NEW), 'UTF8')), 'hex');
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION audit_log_immutable()
+RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_chain ON audit_log;
+CREATE TRIGGER audit_log_chain BEFORE INSERT ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_chain();
+
+DROP TRIGGER IF EXISTS audit_log_immutable ON audit_log;
+CREATE TRIGGER audit_log_immutable BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_immutable();
+
+DROP TRIGGER IF EXISTS audit_log_no_truncate ON audit_log;
+CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON audit_log
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_log_immutable();
+
+-- Subscription status transitions are logged by the database, whichever
+-- service makes them. Services may name the actor with
+-- SET LOCAL audit.actor_type / audit.actor_id.
+CREATE OR REPLACE FUNCTION audit_subscription_status()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND OLD.status IS NOT DISTINCT FROM NEW.status THEN
+        RETURN NEW;
+    END IF;
+
+    INSERT INTO audit_log (actor_type, actor_id, action, resource_type, resource_id, consumer_id, details)
+    VALUES (
+        COALESCE(NULLIF(current_setting('audit.actor_type', true), ''), 'system'),
+        COALESCE(NULLIF(current_setting('audit.actor_id', true), ''), current_user),
+        CASE WHEN TG_OP = 'INSERT' THEN 'subscription.created' ELSE 'subscription.status_changed' END,
+        'subscription',
+        NEW.id::text,
+        NEW.consumer_id,
+        jsonb_build_object(
+            'from', CASE WHEN TG_OP = 'UPDATE' THEN OLD.status END,
+            'to', NEW.status,
+            'api_id', NEW.api_id));
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_subscription_status ON subscriptions;
+CREATE TRIGGER audit_subscription_status AFTER INSERT OR UPDATE OF status ON subscriptions
+    FOR EACH ROW EXECUTE FUNCTION audit_subscription_status();
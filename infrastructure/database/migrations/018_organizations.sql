@@ -0,0 +1,163 @@
+-- Migration: Organizations
+-- Version: 018
+-- Description: Organizations with members and roles that own API keys, OAuth clients and subscriptions
+
+-- Every consumer has a personal organization, created with them, which owns
+-- what they had before organizations existed. Team organizations are
+-- created explicitly and their members added by an owner or admin.
+CREATE TABLE IF NOT EXISTS organizations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(255) NOT NULL,
+    personal BOOLEAN NOT NULL DEFAULT false,
+    -- The member whose payment account pays for the organization's
+    -- subscriptions
+    billing_consumer_id UUID NOT NULL REFERENCES consumers(id),
+    created_by UUID NOT NULL REFERENCES consumers(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_organizations_personal ON organizations(created_by) WHERE personal;
+
+-- Roles: owner manages everything, including the organization itself;
+-- admin manages members, keys and subscriptions; developer manages keys;
+-- billing manages subscriptions, payment methods and invoices.
+CREATE TABLE IF NOT EXISTS organization_members (
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    consumer_id UUID NOT NULL REFERENCES consumers(id) ON DELETE CASCADE,
+    role VARCHAR(20) NOT NULL CHECK (role IN ('owner', 'admin', 'developer', 'billing')),
+    added_by UUID REFERENCES consumers(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (organization_id, consumer_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_organization_members_consumer ON organization_members(consumer_id);
+
+CREATE OR REPLACE FUNCTION create_personal_organization()
+RETURNS TRIGGER AS $$
+DECLARE
+    org_id UUID;
+BEGIN
+    INSERT INTO organizations (name, personal, billing_consumer_id, created_by)
+    VALUES (NEW.email, true, NEW.id, NEW.id)
+    RETURNING id INTO org_id;
+
+    INSERT INTO organization_members (organization_id, consumer_id, role)
+    VALUES (org_id, NEW.id, 'owner');
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS create_personal_organization ON consumers;
+CREATE TRIGGER create_personal_organization AFTER INSERT ON consumers
+    FOR EACH ROW EXECUTE FUNCTION create_personal_organization();
+
+-- Personal organizations for existing consumers
+WITH created AS (
+    INSERT INTO organizations (name, personal, billing_consumer_id, created_by)
+    SELECT c.email, true, c.id, c.id
+    FROM consumers c
+    WHERE NOT EXISTS (SELECT 1 FROM organizations o WHERE o.created_by = c.id AND o.personal)
+    RETURNING id, created_by
+)
+INSERT INTO organization_members (organization_id, consumer_id, role)
+SELECT id, created_by, 'owner' FROM created;
+
+-- Keys, OAuth clients and subscriptions belong to an organization.
+-- consumer_id stays as the member who created them.
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS organization_id UUID REFERENCES organizations(id);
+ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS organization_id UUID REFERENCES organizations(id);
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS organization_id UUID REFERENCES organizations(id);
+
+UPDATE api_keys ak SET organization_id = o.id
+FROM organizations o
+WHERE ak.organization_id IS NULL AND o.created_by = ak.consumer_id AND o.personal;
+
+UPDATE oauth_clients oc SET organization_id = o.id
+FROM organizations o
+WHERE oc.organization_id IS NULL AND o.created_by = oc.consumer_id AND o.personal;
+
+UPDATE subscriptions s SET organization_id = o.id
+FROM organizations o
+WHERE s.organization_id IS NULL AND o.created_by = s.consumer_id AND o.personal;
+
+-- Writers that don't name an organization use the consumer's personal one
+CREATE OR REPLACE FUNCTION default_organization()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.organization_id IS NULL THEN
+        SELECT id INTO NEW.organization_id
+        FROM organizations
+        WHERE created_by = NEW.consumer_id AND personal;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS default_organization ON api_keys;
+CREATE TRIGGER default_organization BEFORE INSERT ON api_keys
+    FOR EACH ROW EXECUTE FUNCTION default_organization();
+
+DROP TRIGGER IF EXISTS default_organization ON oauth_clients;
+CREATE TRIGGER default_organization BEFORE INSERT ON oauth_clients
+    FOR EACH ROW EXECUTE FUNCTION default_organization();
+
+DROP TRIGGER IF EXISTS default_organization ON subscriptions;
+CREATE TRIGGER default_organization BEFORE INSERT ON subscriptions
+    FOR EACH ROW EXECUTE FUNCTION default_organization();
+
+ALTER TABLE api_keys ALTER COLUMN organization_id SET NOT NULL;
+ALTER TABLE oauth_clients ALTER COLUMN organization_id SET NOT NULL;
+ALTER TABLE subscriptions ALTER COLUMN organization_id SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_organization ON api_keys(organization_id);
+CREATE INDEX IF NOT EXISTS idx_oauth_clients_organization ON oauth_clients(organization_id);
+CREATE INDEX IF NOT EXISTS idx_subscriptions_organization ON subscriptions(organization_id);
+
+-- Audit events are scoped to organizations. Events from before this
+-- migration have none and stay scoped to their consumer. concat_ws skips
+-- NULLs, so the hashes of earlier events are unchanged.
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS organization_id UUID;
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_organization ON audit_log(organization_id, seq);
+
+CREATE OR REPLACE FUNCTION audit_log_canonical(e audit_log)
+RETURNS TEXT AS $$
+BEGIN
+    RETURN concat_ws(chr(31),
+        e.prev_hash,
+        e.seq::text,
+        to_char(e.occurred_at, 'YYYY-MM-DD"T"HH24:MI:SS.US"Z"'),
+        e.actor_type,
+        e.actor_id,
+        e.action,
+        e.resource_type,
+        e.resource_id,
+        COALESCE(e.consumer_id::text, ''),
+        e.details::text,
+        e.organization_id::text);
+END;
+$$ LANGUAGE plpgsql STABLE;
+
+CREATE OR REPLACE FUNCTION audit_subscription_status()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND OLD.status IS NOT DISTINCT FROM NEW.status THEN
+        RETURN NEW;
+    END IF;
+
+    INSERT INTO audit_log (actor_type, actor_id, action, resource_type, resource_id, consumer_id, organization_id, details)
+    VALUES (
+        COALESCE(NULLIF(current_setting('audit.actor_type', true), ''), 'system'),
+        COALESCE(NULLIF(current_setting('audit.actor_id', true), ''), current_user),
+        CASE WHEN TG_OP = 'INSERT' THEN 'subscription.created' ELSE 'subscription.status_changed' END,
+        'subscription',
+        NEW.id::text,
+        NEW.consumer_id,
+        NEW.organization_id,
+        jsonb_build_object(
+            'from', CASE WHEN TG_OP = 'UPDATE' THEN OLD.status END,
+            'to', NEW.status,
+            'api_id', NEW.api_id));
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
@@ -47,8 +47,15 @@ for service_info in "${services[@]}"; do
     continue
   fi
   
-  # Build Docker image
-  docker build -t api-direct-$service:latest services/$service/
+  # Build Docker image; services using the shared module build from services/
+  case "$service" in
+    gateway|apikey|metering)
+      docker build -t api-direct-$service:latest -f services/$service/Dockerfile services/
+      ;;
+    *)
+      docker build -t api-direct-$service:latest services/$service/
+      ;;
+  esac
   
   # Tag for ECR
   docker tag api-direct-$service:latest $ECR_REGISTRY/api-direct-$service:latest
@@ -0,0 +1,237 @@
+package anomaly
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/api-direct/services/apikey/events"
+	"github.com/api-direct/services/apikey/store"
+)
+
+// Config sets how suspicious activity must be before a key is quarantined
+type Config struct {
+	// SpikeFactor is how many times its weekly hourly average a key may
+	// be called in an hour
+	SpikeFactor float64
+	// SpikeMinCalls is the fewest calls in an hour that count as a spike,
+	// so quiet and new keys aren't flagged for modest use
+	SpikeMinCalls int64
+	// LearningPeriod is how long after its first use a key may be used
+	// from new ASNs and networks without being flagged
+	LearningPeriod time.Duration
+	// MaxTravelSpeed is the fastest, in km/h, a key may move between
+	// locations; zero disables the check
+	MaxTravelSpeed float64
+	// MinTravelDistance is the shortest distance, in km, checked for
+	// travel, as IP geolocation is imprecise
+	MinTravelDistance float64
+	// ConfirmationGrace is how long after its consumer confirms a key
+	// its volume isn't checked, so the spike that flagged it can pass
+	ConfirmationGrace time.Duration
+}
+
+// DefaultConfig returns the default detection thresholds
+func DefaultConfig() Config {
+	return Config{
+		SpikeFactor:       10,
+		SpikeMinCalls:     5000,
+		LearningPeriod:    7 * 24 * time.Hour,
+		MaxTravelSpeed:    1000,
+		MinTravelDistance: 500,
+		ConfirmationGrace: 24 * time.Hour,
+	}
+}
+
+// Notifier tells consumers that one of their keys was quarantined
+type Notifier interface {
+	KeyQuarantined(ctx context.Context, alert *store.KeyAlert) error
+}
+
+// Detector looks for signs of leaked keys in the activity reported by the
+// gateways: volume spikes, use from new ASNs or IP ranges, and locations
+// too far apart to travel between. Flagged keys are quarantined until their
+// consumer confirms them.
+type Detector struct {
+	config    Config
+	store     *store.PostgresStore
+	publisher *events.Publisher
+	notifier  Notifier
+}
+
+// NewDetector creates a detector. The notifier may be nil, in which case
+// consumers aren't told about quarantined keys.
+func NewDetector(config Config, s *store.PostgresStore, publisher *events.Publisher, notifier Notifier) *Detector {
+	return &Detector{
+		config:    config,
+		store:     s,
+		publisher: publisher,
+		notifier:  notifier,
+	}
+}
+
+// Process checks and records a batch of observations from a gateway
+func (d *Detector) Process(ctx context.Context, observations []*store.KeyObservation) {
+	byKey := make(map[string][]*store.KeyObservation)
+	for _, obs := range observations {
+		byKey[obs.APIKeyID] = append(byKey[obs.APIKeyID], obs)
+	}
+
+	now := time.Now().UTC()
+	for keyID, keyObservations := range byKey {
+		if err := d.processKey(ctx, keyID, keyObservations, now); err != nil {
+			log.Printf("Failed to check activity of key %s: %v", keyID, err)
+		}
+	}
+}
+
+func (d *Detector) processKey(ctx context.Context, keyID string, observations []*store.KeyObservation, now time.Time) error {
+	profile, err := d.store.GetKeyProfile(keyID, now)
+	if err != nil {
+		if err.Error() == "API key not found" {
+			// Revoked keys and OAuth clients aren't tracked
+			return nil
+		}
+		return err
+	}
+
+	var anomalies []store.Anomaly
+	if !profile.Quarantined {
+		anomalies, err = d.check(profile, observations, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Activity is recorded even when it is flagged, so the sources of a
+	// key its consumer confirms become known
+	if err := d.store.RecordKeyActivity(keyID, observations); err != nil {
+		return err
+	}
+	if len(anomalies) == 0 {
+		return nil
+	}
+
+	if err := d.store.QuarantineAPIKey(keyID, anomalies); err != nil {
+		return err
+	}
+	d.publisher.KeyChanged(keyID)
+	log.Printf("Quarantined API key %s: %s", keyID, anomalies[0].Details)
+
+	if d.notifier != nil {
+		alert := &store.KeyAlert{
+			ID:         profile.ID,
+			KeyPrefix:  profile.KeyPrefix,
+			ConsumerID: profile.ConsumerID,
+			Email:      profile.Email,
+			Name:       profile.Name,
+			Reason:     anomalies[0].Kind,
+			Anomalies:  anomalies,
+		}
+		if err := d.notifier.KeyQuarantined(ctx, alert); err != nil {
+			log.Printf("Failed to notify quarantine of key %s: %v", keyID, err)
+		}
+	}
+	return nil
+}
+
+// check returns the anomalies in a key's new observations
+func (d *Detector) check(profile *store.KeyProfile, observations []*store.KeyObservation, now time.Time) ([]store.Anomaly, error) {
+	var anomalies []store.Anomaly
+	flag := func(kind, format string, args ...interface{}) {
+		anomalies = append(anomalies, store.Anomaly{
+			Kind:       kind,
+			Details:    fmt.Sprintf(format, args...),
+			DetectedAt: now,
+		})
+	}
+
+	// Volume spikes, against the calls so far this hour
+	confirmedRecently := profile.ConfirmedAt != nil && now.Sub(*profile.ConfirmedAt) < d.config.ConfirmationGrace
+	if d.config.SpikeFactor > 0 && !confirmedRecently {
+		hour := now.Truncate(time.Hour)
+		calls := profile.CurrentHourCalls
+		for _, obs := range observations {
+			if !obs.LastSeen.Before(hour) {
+				calls += obs.Calls
+			}
+		}
+		threshold := math.Max(float64(d.config.SpikeMinCalls), d.config.SpikeFactor*profile.BaselineHourlyCalls)
+		if float64(calls) > threshold {
+			flag(store.AnomalyVolumeSpike, "%d calls this hour against an average of %.0f", calls, profile.BaselineHourlyCalls)
+		}
+	}
+
+	// New ASNs, or new IP ranges where the gateway has no ASN, once the
+	// key is past its learning period
+	learned := profile.FirstActivity != nil && now.Sub(*profile.FirstActivity) >= d.config.LearningPeriod
+	if learned {
+		var asns []int
+		var ranges []string
+		for _, obs := range observations {
+			if obs.ASN != 0 {
+				asns = append(asns, obs.ASN)
+			} else if r := obs.IPRange(); r != "" {
+				ranges = append(ranges, r)
+			}
+		}
+		knownASNs, knownRanges, err := d.store.KnownSources(profile.ID, asns, ranges)
+		if err != nil {
+			return nil, err
+		}
+		for _, obs := range observations {
+			if obs.ASN != 0 {
+				if !knownASNs[obs.ASN] {
+					flag(store.AnomalyNewASN, "used from AS%d (%s) for the first time", obs.ASN, obs.Network)
+					knownASNs[obs.ASN] = true
+				}
+			} else if r := obs.IPRange(); r != "" && !knownRanges[r] {
+				flag(store.AnomalyNewNetwork, "used from %s for the first time", r)
+				knownRanges[r] = true
+			}
+		}
+	}
+
+	// Impossible travel between consecutive locations
+	if d.config.MaxTravelSpeed > 0 {
+		located := make([]*store.KeyObservation, 0, len(observations))
+		for _, obs := range observations {
+			if obs.Latitude != nil && obs.Longitude != nil {
+				located = append(located, obs)
+			}
+		}
+		sort.Slice(located, func(i, j int) bool {
+			return located[i].LastSeen.Before(located[j].LastSeen)
+		})
+
+		lat, lon, at := profile.LastLatitude, profile.LastLongitude, profile.LastLocatedAt
+		for _, obs := range located {
+			if lat != nil && lon != nil && at != nil {
+				distance := Distance(*lat, *lon, *obs.Latitude, *obs.Longitude)
+				hours := math.Abs(obs.FirstSeen.Sub(*at).Hours())
+				if distance >= d.config.MinTravelDistance && distance > d.config.MaxTravelSpeed*hours {
+					flag(store.AnomalyImpossibleTravel, "used %.0f km apart within %s", distance, time.Duration(hours*float64(time.Hour)).Round(time.Second))
+					break
+				}
+			}
+			lat, lon, at = obs.Latitude, obs.Longitude, &obs.LastSeen
+		}
+	}
+
+	return anomalies, nil
+}
+
+// Distance returns the great-circle distance in km between two points
+func Distance(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadius = 6371.0
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dLat := toRad(lat2 - lat1)
+	dLon := toRad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
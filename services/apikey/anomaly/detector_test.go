@@ -0,0 +1,174 @@
+package anomaly
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/api-direct/services/apikey/store"
+)
+
+func observation(calls int64, at time.Time) *store.KeyObservation {
+	return &store.KeyObservation{
+		APIKeyID:  "key-1",
+		Network:   "203.0.113.0/24",
+		Calls:     calls,
+		FirstSeen: at,
+		LastSeen:  at,
+	}
+}
+
+func TestVolumeSpikeThreshold(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	confirmed := now.Add(-time.Hour)
+	d := NewDetector(DefaultConfig(), nil, nil, nil)
+
+	tests := []struct {
+		name    string
+		profile store.KeyProfile
+		calls   int64
+		flagged bool
+	}{
+		// Quiet keys are held to SpikeMinCalls, busy ones to SpikeFactor
+		// times their average
+		{"quiet key at the floor", store.KeyProfile{BaselineHourlyCalls: 10}, 5000, false},
+		{"quiet key over the floor", store.KeyProfile{BaselineHourlyCalls: 10}, 5001, true},
+		{"busy key at its factor", store.KeyProfile{BaselineHourlyCalls: 1000}, 10000, false},
+		{"busy key over its factor", store.KeyProfile{BaselineHourlyCalls: 1000}, 10001, true},
+		{"earlier calls this hour count", store.KeyProfile{BaselineHourlyCalls: 10, CurrentHourCalls: 4000}, 1001, true},
+		{"recently confirmed key", store.KeyProfile{BaselineHourlyCalls: 10, ConfirmedAt: &confirmed}, 50000, false},
+	}
+	for _, tt := range tests {
+		anomalies, err := d.check(&tt.profile, []*store.KeyObservation{observation(tt.calls, now)}, now)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if flagged := len(anomalies) > 0; flagged != tt.flagged {
+			t.Errorf("%s: anomalies = %v, want flagged %v", tt.name, anomalies, tt.flagged)
+		}
+		if tt.flagged && anomalies[0].Kind != store.AnomalyVolumeSpike {
+			t.Errorf("%s: kind = %s", tt.name, anomalies[0].Kind)
+		}
+	}
+
+	// Calls reported for an earlier hour don't count towards this one
+	late := observation(6000, now.Truncate(time.Hour).Add(-time.Minute))
+	if anomalies, _ := d.check(&store.KeyProfile{}, []*store.KeyObservation{late}, now); len(anomalies) != 0 {
+		t.Errorf("calls from the previous hour flagged: %v", anomalies)
+	}
+}
+
+func TestImpossibleTravel(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	d := NewDetector(DefaultConfig(), nil, nil, nil)
+
+	// Berlin to New York is about 6,400 km
+	berlinLat, berlinLon := 52.52, 13.405
+	nyLat, nyLon := 40.7128, -74.006
+	lastSeen := now.Add(-time.Hour)
+	profile := &store.KeyProfile{LastLatitude: &berlinLat, LastLongitude: &berlinLon, LastLocatedAt: &lastSeen}
+
+	obs := observation(1, now)
+	obs.Latitude, obs.Longitude = &nyLat, &nyLon
+	anomalies, err := d.check(profile, []*store.KeyObservation{obs}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anomalies) != 1 || anomalies[0].Kind != store.AnomalyImpossibleTravel {
+		t.Errorf("anomalies = %v, want impossible travel", anomalies)
+	}
+
+	// A flight's worth of time later is fine
+	obs.FirstSeen = lastSeen.Add(8 * time.Hour)
+	obs.LastSeen = obs.FirstSeen
+	if anomalies, _ := d.check(profile, []*store.KeyObservation{obs}, obs.LastSeen); len(anomalies) != 0 {
+		t.Errorf("anomalies = %v, want none", anomalies)
+	}
+}
+
+// notifier records the alerts it is sent
+type notifier struct{ alerts []*store.KeyAlert }
+
+func (n *notifier) KeyQuarantined(ctx context.Context, alert *store.KeyAlert) error {
+	n.alerts = append(n.alerts, alert)
+	return nil
+}
+
+var profileColumns = []string{
+	"id", "key_prefix", "consumer_id", "email", "name", "quarantined_at", "confirmed_at",
+	"last_latitude", "last_longitude", "last_located_at", "first_activity", "baseline", "current",
+}
+
+func profileRow(quarantinedAt interface{}) []driver.Value {
+	return []driver.Value{"key-1", "sk_0123abcd...", "consumer-1", "alice@example.com", "production",
+		quarantinedAt, nil, nil, nil, nil, nil, 10.0, 0}
+}
+
+func TestProcessQuarantinesSpikingKeys(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	n := &notifier{}
+	d := NewDetector(DefaultConfig(), store.NewPostgresStore(db), nil, n)
+	now := time.Now().UTC()
+
+	mock.ExpectQuery("FROM api_keys ak").WithArgs("key-1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(profileColumns).AddRow(profileRow(nil)...))
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO api_key_sources").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO api_key_hourly_calls").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SET quarantined_at = \\$2, quarantine_reason = \\$3").
+		WithArgs("key-1", sqlmock.AnyArg(), store.AnomalyVolumeSpike).
+		WillReturnRows(sqlmock.NewRows([]string{"organization_id", "consumer_id"}).AddRow("org-1", "consumer-1"))
+	mock.ExpectExec("INSERT INTO api_key_anomalies").
+		WithArgs("key-1", store.AnomalyVolumeSpike, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_log").
+		WithArgs("system", "apikey", store.AuditKeyQuarantined, "api_key", "key-1", "consumer-1", "org-1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	d.Process(context.Background(), []*store.KeyObservation{observation(5001, now)})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if len(n.alerts) != 1 || n.alerts[0].Reason != store.AnomalyVolumeSpike || n.alerts[0].Email != "alice@example.com" {
+		t.Errorf("alerts = %+v", n.alerts)
+	}
+}
+
+func TestProcessRecordsActivityOfQuarantinedKeys(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	n := &notifier{}
+	d := NewDetector(DefaultConfig(), store.NewPostgresStore(db), nil, n)
+	now := time.Now().UTC()
+
+	// Activity is recorded, but a key already in quarantine isn't checked
+	// or quarantined again
+	mock.ExpectQuery("FROM api_keys ak").
+		WillReturnRows(sqlmock.NewRows(profileColumns).AddRow(profileRow(now.Add(-time.Hour))...))
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO api_key_sources").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO api_key_hourly_calls").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	d.Process(context.Background(), []*store.KeyObservation{observation(50000, now)})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if len(n.alerts) != 0 {
+		t.Errorf("alerts = %+v, want none", n.alerts)
+	}
+}
@@ -0,0 +1,59 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// invalidationChannel must match the gateway's validation cache channel
+const invalidationChannel = "gateway:apikey:invalidations"
+
+// Invalidation identifies validations the gateway must stop serving from cache
+type Invalidation struct {
+	APIKeyID       string `json:"api_key_id,omitempty"`
+	SubscriptionID string `json:"subscription_id,omitempty"`
+}
+
+// Publisher notifies API gateways of key changes. A nil Publisher is valid
+// and publishes nothing, for deployments without Redis.
+type Publisher struct {
+	client *redis.Client
+}
+
+// NewPublisher creates a new event publisher
+func NewPublisher(client *redis.Client) *Publisher {
+	return &Publisher{client: client}
+}
+
+// KeyChanged tells the gateways to drop cached validations for an API key
+func (p *Publisher) KeyChanged(keyID string) {
+	p.publish(Invalidation{APIKeyID: keyID})
+}
+
+// SubscriptionChanged tells the gateways to drop cached validations for a subscription
+func (p *Publisher) SubscriptionChanged(subscriptionID string) {
+	p.publish(Invalidation{SubscriptionID: subscriptionID})
+}
+
+func (p *Publisher) publish(inv Invalidation) {
+	if p == nil || p.client == nil {
+		return
+	}
+
+	payload, err := json.Marshal(inv)
+	if err != nil {
+		log.Printf("Failed to marshal invalidation: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if err := p.client.Publish(ctx, invalidationChannel, payload).Err(); err != nil {
+		log.Printf("Failed to publish invalidation: %v", err)
+	}
+}
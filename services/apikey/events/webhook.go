@@ -0,0 +1,70 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/api-direct/services/apikey/store"
+)
+
+// WebhookNotifier posts notices about a consumer's keys as JSON to a
+// webhook, such as the notification service's
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookNotifier creates a notifier posting to url
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// KeyExpiring posts an api_key.expiring event for the key
+func (n *WebhookNotifier) KeyExpiring(ctx context.Context, key *store.ExpiringKey) error {
+	return n.post(ctx, "api_key.expiring", key)
+}
+
+// KeyQuarantined posts an api_key.quarantined event for the key
+func (n *WebhookNotifier) KeyQuarantined(ctx context.Context, alert *store.KeyAlert) error {
+	return n.post(ctx, "api_key.quarantined", alert)
+}
+
+// KeyLeaked posts an api_key.leaked event for a key revoked after it was
+// reported leaked
+func (n *WebhookNotifier) KeyLeaked(ctx context.Context, alert *store.KeyAlert) error {
+	return n.post(ctx, "api_key.leaked", alert)
+}
+
+func (n *WebhookNotifier) post(ctx context.Context, eventType string, key interface{}) error {
+	payload, err := json.Marshal(map[string]interface{}{
+		"type":    eventType,
+		"api_key": key,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
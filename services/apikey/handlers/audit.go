@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/api-direct/services/apikey/store"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// ListAuditEvents lists the audit events of the organization the request
+// acts in
+func ListAuditEvents(s *store.PostgresStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Resolve the organization the request acts in
+		membership, ok := requireMembership(c, s, store.PermissionAuditRead)
+		if !ok {
+			return
+		}
+
+		filter, err := parseAuditFilter(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid request",
+				"code":    "INVALID_REQUEST",
+				"details": err.Error(),
+			})
+			return
+		}
+		filter.OrganizationID = membership.Organization.ID
+		if membership.Organization.Personal {
+			filter.PersonalConsumerID = membership.ConsumerID
+		}
+
+		listAuditEvents(c, s, filter)
+	}
+}
+
+// ListAllAuditEvents lists audit events across consumers, optionally for
+// one consumer
+func ListAllAuditEvents(s *store.PostgresStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filter, err := parseAuditFilter(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid request",
+				"code":    "INVALID_REQUEST",
+				"details": err.Error(),
+			})
+			return
+		}
+		filter.ConsumerID = c.Query("consumer_id")
+		if filter.ConsumerID != "" {
+			if _, err := uuid.Parse(filter.ConsumerID); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error":   "Invalid request",
+					"code":    "INVALID_REQUEST",
+					"details": "consumer_id must be a UUID",
+				})
+				return
+			}
+		}
+
+		listAuditEvents(c, s, filter)
+	}
+}
+
+// VerifyAuditLog checks that no audit event was changed or removed
+func VerifyAuditLog(s *store.PostgresStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result, err := s.VerifyAuditLog()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "Failed to verify audit log",
+				"code":    "AUDIT_ERROR",
+				"details": err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func listAuditEvents(c *gin.Context, s *store.PostgresStore, filter store.AuditFilter) {
+	auditEvents, err := s.ListAuditEvents(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to list audit events",
+			"code":    "AUDIT_ERROR",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	response := gin.H{
+		"events": auditEvents,
+		"count":  len(auditEvents),
+	}
+	// A full page may have more events after it
+	if len(auditEvents) == filter.Limit {
+		response["next_after"] = auditEvents[len(auditEvents)-1].Seq
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// parseAuditFilter reads the resource_id, action, since, until, after and
+// limit query parameters
+func parseAuditFilter(c *gin.Context) (store.AuditFilter, error) {
+	filter := store.AuditFilter{
+		ResourceID: c.Query("resource_id"),
+		Action:     c.Query("action"),
+		Limit:      defaultAuditLimit,
+	}
+
+	for name, dst := range map[string]**time.Time{"since": &filter.Since, "until": &filter.Until} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return filter, fmt.Errorf("%s must be an RFC 3339 time", name)
+		}
+		*dst = &t
+	}
+
+	if value := c.Query("after"); value != "" {
+		after, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || after < 0 {
+			return filter, fmt.Errorf("after must be a sequence number")
+		}
+		filter.AfterSeq = after
+	}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxAuditLimit {
+			return filter, fmt.Errorf("limit must be between 1 and %d", maxAuditLimit)
+		}
+		filter.Limit = limit
+	}
+
+	return filter, nil
+}
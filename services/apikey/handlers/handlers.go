@@ -1,16 +1,24 @@
 package handlers
 
 import (
+	"io"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/api-direct/services/apikey/events"
 	"github.com/api-direct/services/apikey/store"
 )
 
 // GenerateAPIKeyRequest represents the request to generate a new API key
 type GenerateAPIKeyRequest struct {
-	Name           string `json:"name" binding:"required"`
-	SubscriptionID string `json:"subscription_id" binding:"required"`
+	Name string `json:"name" binding:"required"`
+	// Mode is live (the default) or test. Test keys work on every published
+	// API and don't need a subscription.
+	Mode           string `json:"mode" binding:"omitempty,oneof=live test"`
+	SubscriptionID string `json:"subscription_id" binding:"required_unless=Mode test"`
+	// ExpiresAt optionally limits how long the key is valid
+	ExpiresAt *time.Time `json:"expires_at"`
 }
 
 // GenerateAPIKeyResponse represents the response with the new API key
@@ -19,23 +27,46 @@ type GenerateAPIKeyResponse struct {
 	KeyInfo   *store.APIKey   `json:"key_info"`
 }
 
+// RotateAPIKeyRequest represents the request to rotate an API key. The key
+// is given in the URL or, when rotating a subscription's key, by its
+// subscription.
+type RotateAPIKeyRequest struct {
+	SubscriptionID string `json:"subscription_id"`
+	// GracePeriod is how long the old key keeps working, as a duration
+	// such as "24h". Zero retires it immediately.
+	GracePeriod string `json:"grace_period"`
+}
+
+// RotateAPIKeyResponse represents the response with the rotated API key
+type RotateAPIKeyResponse struct {
+	APIKey            string        `json:"api_key"`
+	KeyInfo           *store.APIKey `json:"key_info"`
+	PreviousKeyID     string        `json:"previous_key_id"`
+	PreviousExpiresAt time.Time     `json:"previous_expires_at"`
+}
+
+// Grace periods for rotated keys
+const (
+	defaultRotationGrace = 24 * time.Hour
+	maxRotationGrace     = 30 * 24 * time.Hour
+)
+
 // ValidateAPIKeyRequest represents the request to validate an API key
 type ValidateAPIKeyRequest struct {
 	APIKey string `json:"api_key" binding:"required"`
 	Path   string `json:"path" binding:"required"`
 }
 
-// UpdateAPIKeyRequest represents the request to update an API key
+// UpdateAPIKeyRequest represents the request to update an API key. Scopes
+// replace the key's current scopes; send empty scopes to lift them.
 type UpdateAPIKeyRequest struct {
-	Name string `json:"name" binding:"required"`
+	Name   *string          `json:"name" binding:"omitempty,min=1"`
+	Scopes *store.KeyScopes `json:"scopes"`
 }
 
-// GenerateAPIKey creates a new API key for a consumer
+// GenerateAPIKey creates a new API key for an organization
 func GenerateAPIKey(s *store.PostgresStore) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Get consumer info from auth middleware
-		userID, _ := c.Get("user_id")
-		email, _ := c.Get("email")
 		userType, _ := c.Get("user_type")
 		
 		// Ensure user is a consumer
@@ -58,19 +89,23 @@ func GenerateAPIKey(s *store.PostgresStore) gin.HandlerFunc {
 			return
 		}
 		
-		// Ensure consumer record exists
-		consumerID, err := s.EnsureConsumer(userID.(string), email.(string))
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Failed to ensure consumer record",
-				"code":  "CONSUMER_ERROR",
-				"details": err.Error(),
+		if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid request",
+				"code":  "INVALID_REQUEST",
+				"details": "expires_at must be in the future",
 			})
 			return
 		}
 		
+		// Resolve the organization the request acts in
+		membership, ok := requireMembership(c, s, store.PermissionKeysWrite)
+		if !ok {
+			return
+		}
+		
 		// Generate the API key
-		fullKey, keyInfo, err := s.GenerateAPIKey(consumerID, req.Name)
+		fullKey, keyInfo, err := s.GenerateAPIKey(membership.Organization.ID, membership.ConsumerID, req.Name, req.Mode, req.ExpiresAt)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": "Failed to generate API key",
@@ -121,10 +156,6 @@ func ValidateAPIKey(s *store.PostgresStore) gin.HandlerFunc {
 // GetAPIKey retrieves details about a specific API key
 func GetAPIKey(s *store.PostgresStore) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Get consumer info from auth middleware
-		userID, _ := c.Get("user_id")
-		email, _ := c.Get("email")
-		
 		// Get key ID from URL
 		keyID := c.Param("keyId")
 		if keyID == "" {
@@ -135,19 +166,14 @@ func GetAPIKey(s *store.PostgresStore) gin.HandlerFunc {
 			return
 		}
 		
-		// Ensure consumer record exists
-		consumerID, err := s.EnsureConsumer(userID.(string), email.(string))
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Failed to ensure consumer record",
-				"code":  "CONSUMER_ERROR",
-				"details": err.Error(),
-			})
+		// Resolve the organization the request acts in
+		membership, ok := requireMembership(c, s, store.PermissionKeysRead)
+		if !ok {
 			return
 		}
 		
 		// Get the API key
-		apiKey, err := s.GetAPIKey(keyID, consumerID)
+		apiKey, err := s.GetAPIKey(keyID, membership.Organization.ID)
 		if err != nil {
 			if err.Error() == "API key not found" {
 				c.JSON(http.StatusNotFound, gin.H{
@@ -168,26 +194,17 @@ func GetAPIKey(s *store.PostgresStore) gin.HandlerFunc {
 	}
 }
 
-// ListAPIKeys lists all API keys for the authenticated consumer
+// ListAPIKeys lists all API keys of the organization the request acts in
 func ListAPIKeys(s *store.PostgresStore) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Get consumer info from auth middleware
-		userID, _ := c.Get("user_id")
-		email, _ := c.Get("email")
-		
-		// Ensure consumer record exists
-		consumerID, err := s.EnsureConsumer(userID.(string), email.(string))
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Failed to ensure consumer record",
-				"code":  "CONSUMER_ERROR",
-				"details": err.Error(),
-			})
+		// Resolve the organization the request acts in
+		membership, ok := requireMembership(c, s, store.PermissionKeysRead)
+		if !ok {
 			return
 		}
 		
 		// List API keys
-		keys, err := s.ListAPIKeys(consumerID)
+		keys, err := s.ListAPIKeys(membership.Organization.ID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": "Failed to list API keys",
@@ -205,12 +222,8 @@ func ListAPIKeys(s *store.PostgresStore) gin.HandlerFunc {
 }
 
 // RevokeAPIKey revokes a specific API key
-func RevokeAPIKey(s *store.PostgresStore) gin.HandlerFunc {
+func RevokeAPIKey(s *store.PostgresStore, publisher *events.Publisher) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Get consumer info from auth middleware
-		userID, _ := c.Get("user_id")
-		email, _ := c.Get("email")
-		
 		// Get key ID from URL
 		keyID := c.Param("keyId")
 		if keyID == "" {
@@ -221,19 +234,14 @@ func RevokeAPIKey(s *store.PostgresStore) gin.HandlerFunc {
 			return
 		}
 		
-		// Ensure consumer record exists
-		consumerID, err := s.EnsureConsumer(userID.(string), email.(string))
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Failed to ensure consumer record",
-				"code":  "CONSUMER_ERROR",
-				"details": err.Error(),
-			})
+		// Resolve the organization the request acts in
+		membership, ok := requireMembership(c, s, store.PermissionKeysWrite)
+		if !ok {
 			return
 		}
 		
 		// Revoke the API key
-		err = s.RevokeAPIKey(keyID, consumerID)
+		err := s.RevokeAPIKey(keyID, membership.Organization.ID, membership.ConsumerID)
 		if err != nil {
 			if err.Error() == "API key not found" {
 				c.JSON(http.StatusNotFound, gin.H{
@@ -250,19 +258,18 @@ func RevokeAPIKey(s *store.PostgresStore) gin.HandlerFunc {
 			return
 		}
 		
+		// Stop gateways from serving the revoked key out of cache
+		publisher.KeyChanged(keyID)
+		
 		c.JSON(http.StatusOK, gin.H{
 			"message": "API key revoked successfully",
 		})
 	}
 }
 
-// UpdateAPIKey updates an API key's name
-func UpdateAPIKey(s *store.PostgresStore) gin.HandlerFunc {
+// UpdateAPIKey updates an API key's name and scopes
+func UpdateAPIKey(s *store.PostgresStore, publisher *events.Publisher) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Get consumer info from auth middleware
-		userID, _ := c.Get("user_id")
-		email, _ := c.Get("email")
-		
 		// Get key ID from URL
 		keyID := c.Param("keyId")
 		if keyID == "" {
@@ -283,20 +290,36 @@ func UpdateAPIKey(s *store.PostgresStore) gin.HandlerFunc {
 			})
 			return
 		}
-		
-		// Ensure consumer record exists
-		consumerID, err := s.EnsureConsumer(userID.(string), email.(string))
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Failed to ensure consumer record",
-				"code":  "CONSUMER_ERROR",
-				"details": err.Error(),
+		if req.Name == nil && req.Scopes == nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Nothing to update",
+				"code":  "INVALID_REQUEST",
+				"details": "name or scopes is required",
 			})
 			return
 		}
+		if req.Scopes != nil {
+			if err := req.Scopes.Normalize(time.Now()); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "Invalid scopes",
+					"code":  "INVALID_SCOPES",
+					"details": err.Error(),
+				})
+				return
+			}
+		}
+		
+		// Resolve the organization the request acts in
+		membership, ok := requireMembership(c, s, store.PermissionKeysWrite)
+		if !ok {
+			return
+		}
 		
 		// Update the API key
-		err = s.UpdateAPIKey(keyID, consumerID, req.Name)
+		err := s.UpdateAPIKey(keyID, membership.Organization.ID, membership.ConsumerID, store.APIKeyUpdate{
+			Name:   req.Name,
+			Scopes: req.Scopes,
+		})
 		if err != nil {
 			if err.Error() == "API key not found" {
 				c.JSON(http.StatusNotFound, gin.H{
@@ -313,8 +336,116 @@ func UpdateAPIKey(s *store.PostgresStore) gin.HandlerFunc {
 			return
 		}
 		
+		// Gateways enforce scopes from cached validations
+		if req.Scopes != nil {
+			publisher.KeyChanged(keyID)
+		}
+		
 		c.JSON(http.StatusOK, gin.H{
 			"message": "API key updated successfully",
 		})
 	}
 }
+
+// RotateAPIKey replaces an API key with a new one, keeping the old key valid
+// for a grace period so consumers can switch over without downtime
+func RotateAPIKey(s *store.PostgresStore, publisher *events.Publisher) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Parse request
+		var req RotateAPIKeyRequest
+		if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid request",
+				"code":  "INVALID_REQUEST",
+				"details": err.Error(),
+			})
+			return
+		}
+		
+		keyID := c.Param("keyId")
+		if keyID == "" && req.SubscriptionID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Key ID or subscription ID required",
+				"code":  "KEY_ID_REQUIRED",
+			})
+			return
+		}
+		
+		grace := defaultRotationGrace
+		if req.GracePeriod != "" {
+			var err error
+			grace, err = time.ParseDuration(req.GracePeriod)
+			if err != nil || grace < 0 || grace > maxRotationGrace {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "Invalid grace period",
+					"code":  "INVALID_GRACE_PERIOD",
+					"details": "grace_period must be a duration between 0s and 720h",
+				})
+				return
+			}
+		}
+		
+		// Resolve the organization the request acts in
+		membership, ok := requireMembership(c, s, store.PermissionKeysWrite)
+		if !ok {
+			return
+		}
+		
+		// Look up the subscription's key
+		if keyID == "" {
+			var err error
+			keyID, err = s.SubscriptionKeyID(req.SubscriptionID, membership.Organization.ID)
+			if err != nil {
+				if err.Error() == "subscription not found" {
+					c.JSON(http.StatusNotFound, gin.H{
+						"error": "Subscription not found",
+						"code":  "SUBSCRIPTION_NOT_FOUND",
+					})
+					return
+				}
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "Failed to get subscription",
+					"code":  "GET_ERROR",
+					"details": err.Error(),
+				})
+				return
+			}
+		}
+		
+		// Rotate the API key
+		fullKey, keyInfo, previousExpiresAt, err := s.RotateAPIKey(keyID, membership.Organization.ID, membership.ConsumerID, grace)
+		if err != nil {
+			if err.Error() == "API key not found" {
+				c.JSON(http.StatusNotFound, gin.H{
+					"error": "API key not found",
+					"code":  "KEY_NOT_FOUND",
+				})
+				return
+			}
+			if err == store.ErrKeyNotRotatable {
+				c.JSON(http.StatusConflict, gin.H{
+					"error": "API key is revoked or has already been rotated",
+					"code":  "KEY_NOT_ROTATABLE",
+				})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to rotate API key",
+				"code":  "ROTATION_ERROR",
+				"details": err.Error(),
+			})
+			return
+		}
+		
+		// Cached validations of the old key don't know its new expiry
+		publisher.KeyChanged(keyID)
+		
+		// Return the full key only once
+		c.JSON(http.StatusCreated, RotateAPIKeyResponse{
+			APIKey:            fullKey,
+			KeyInfo:           keyInfo,
+			PreviousKeyID:     keyID,
+			PreviousExpiresAt: previousExpiresAt,
+		})
+	}
+}
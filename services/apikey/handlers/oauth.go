@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/api-direct/services/apikey/oauth"
+	"github.com/api-direct/services/apikey/store"
+)
+
+// CreateOAuthClientRequest represents the request to create an OAuth client
+type CreateOAuthClientRequest struct {
+	Name           string `json:"name" binding:"required"`
+	SubscriptionID string `json:"subscription_id" binding:"required"`
+}
+
+// CreateOAuthClientResponse represents the response with the new client's secret
+type CreateOAuthClientResponse struct {
+	ClientSecret string             `json:"client_secret"`
+	Client       *store.OAuthClient `json:"client"`
+}
+
+// Token exchanges client credentials for an access token (RFC 6749 section
+// 4.4). Credentials are read from HTTP Basic auth or the form body.
+func Token(s *store.PostgresStore, issuer *oauth.Issuer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Token responses must not be cached
+		c.Header("Cache-Control", "no-store")
+		c.Header("Pragma", "no-cache")
+
+		if c.PostForm("grant_type") != "client_credentials" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":             "unsupported_grant_type",
+				"error_description": "only client_credentials is supported",
+			})
+			return
+		}
+
+		clientID, secret, ok := c.Request.BasicAuth()
+		if !ok {
+			clientID, secret = c.PostForm("client_id"), c.PostForm("client_secret")
+		}
+		if clientID == "" || secret == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":             "invalid_request",
+				"error_description": "client_id and client_secret are required",
+			})
+			return
+		}
+
+		grant, err := s.AuthenticateClient(clientID, secret)
+		if errors.Is(err, store.ErrInvalidClient) {
+			c.Header("WWW-Authenticate", `Basic realm="api-direct"`)
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "invalid_client",
+			})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "server_error",
+			})
+			return
+		}
+
+		token, err := issuer.Issue(grant, time.Now())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "server_error",
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"access_token": token,
+			"token_type":   "Bearer",
+			"expires_in":   int(issuer.TTL().Seconds()),
+		})
+	}
+}
+
+// JWKS publishes the keys access tokens are verified with
+func JWKS(issuer *oauth.Issuer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, issuer.JWKS())
+	}
+}
+
+// CreateOAuthClient creates an OAuth client for one of the organization's subscriptions
+func CreateOAuthClient(s *store.PostgresStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userType, _ := c.Get("user_type")
+
+		// Ensure user is a consumer
+		if userType != "consumer" {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Only consumers can create OAuth clients",
+				"code":  "CONSUMER_ONLY",
+			})
+			return
+		}
+
+		// Parse request
+		var req CreateOAuthClientRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid request",
+				"code":  "INVALID_REQUEST",
+				"details": err.Error(),
+			})
+			return
+		}
+
+		// Resolve the organization the request acts in
+		membership, ok := requireMembership(c, s, store.PermissionKeysWrite)
+		if !ok {
+			return
+		}
+
+		// Create the client
+		secret, client, err := s.CreateOAuthClient(membership.Organization.ID, membership.ConsumerID, req.SubscriptionID, req.Name)
+		if err != nil {
+			if err.Error() == "subscription not found" {
+				c.JSON(http.StatusNotFound, gin.H{
+					"error": "Subscription not found",
+					"code":  "SUBSCRIPTION_NOT_FOUND",
+				})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to create OAuth client",
+				"code":  "GENERATION_ERROR",
+				"details": err.Error(),
+			})
+			return
+		}
+
+		// Return the secret only once
+		c.JSON(http.StatusCreated, CreateOAuthClientResponse{
+			ClientSecret: secret,
+			Client:       client,
+		})
+	}
+}
+
+// ListOAuthClients lists the OAuth clients of the organization
+func ListOAuthClients(s *store.PostgresStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Resolve the organization the request acts in
+		membership, ok := requireMembership(c, s, store.PermissionKeysRead)
+		if !ok {
+			return
+		}
+
+		clients, err := s.ListOAuthClients(membership.Organization.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to list OAuth clients",
+				"code":  "LIST_ERROR",
+				"details": err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"clients": clients,
+			"count":   len(clients),
+		})
+	}
+}
+
+// RevokeOAuthClient stops an OAuth client from getting new tokens
+func RevokeOAuthClient(s *store.PostgresStore, issuer *oauth.Issuer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Resolve the organization the request acts in
+		membership, ok := requireMembership(c, s, store.PermissionKeysWrite)
+		if !ok {
+			return
+		}
+
+		err := s.RevokeOAuthClient(c.Param("clientId"), membership.Organization.ID)
+		if err != nil {
+			if err.Error() == "OAuth client not found" {
+				c.JSON(http.StatusNotFound, gin.H{
+					"error": "OAuth client not found",
+					"code":  "CLIENT_NOT_FOUND",
+				})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to revoke OAuth client",
+				"code":  "REVOKE_ERROR",
+				"details": err.Error(),
+			})
+			return
+		}
+
+		// Issued tokens are validated by the gateway until they expire
+		c.JSON(http.StatusOK, gin.H{
+			"message":            "OAuth client revoked successfully",
+			"tokens_valid_until": time.Now().UTC().Add(issuer.TTL()),
+		})
+	}
+}
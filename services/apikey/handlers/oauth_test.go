@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/api-direct/services/apikey/oauth"
+	"github.com/api-direct/services/apikey/store"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// clientColumns are the columns AuthenticateClient selects
+var clientColumns = []string{
+	"secret_hash", "username", "name", "id", "api_key_id",
+	"consumer_id", "subscription_id", "api_id",
+	"rate_limit_per_minute", "rate_limit_per_day", "rate_limit_per_month",
+	"rate_limit_failure_policy", "max_concurrent_requests", "endpoint_limits",
+	"call_limit", "started_at", "overage_policy", "quota_throttle_per_minute",
+}
+
+func clientRow(secret string) []driver.Value {
+	hash := sha256.Sum256([]byte(secret))
+	return []driver.Value{
+		hex.EncodeToString(hash[:]), "Alice", "Weather", "client-1", "key-1",
+		"consumer-1", "sub-1", "api-1",
+		60, 1000, 10000,
+		"", 0, []byte("[]"),
+		nil, time.Now(), "block", 0,
+	}
+}
+
+func newMockStore(t *testing.T) (*store.PostgresStore, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return store.NewPostgresStore(db), mock
+}
+
+func requestToken(router *gin.Engine, clientID, secret string) *httptest.ResponseRecorder {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	req := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(clientID, secret)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestTokenAuthenticatesClients(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s, mock := newMockStore(t)
+	key, err := oauth.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := oauth.NewIssuer(oauth.Config{PrivateKey: key})
+
+	router := gin.New()
+	router.POST("/oauth/token", Token(s, issuer))
+
+	// Revoked clients and clients of inactive subscriptions aren't selected
+	mock.ExpectQuery("FROM oauth_clients oc").WithArgs("cid_revoked").
+		WillReturnRows(sqlmock.NewRows(clientColumns))
+	if w := requestToken(router, "cid_revoked", "cs_secret"); w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked client: status = %d, want 401", w.Code)
+	}
+
+	mock.ExpectQuery("FROM oauth_clients oc").WithArgs("cid_1").
+		WillReturnRows(sqlmock.NewRows(clientColumns).AddRow(clientRow("cs_secret")...))
+	w := requestToken(router, "cid_1", "cs_wrong")
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "invalid_client") {
+		t.Errorf("wrong secret: status = %d, body = %s", w.Code, w.Body)
+	}
+
+	mock.ExpectQuery("FROM oauth_clients oc").WithArgs("cid_1").
+		WillReturnRows(sqlmock.NewRows(clientColumns).AddRow(clientRow("cs_secret")...))
+	w = requestToken(router, "cid_1", "cs_secret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+	}
+	if w.Header().Get("Cache-Control") != "no-store" {
+		t.Error("token response is cacheable")
+	}
+
+	var response struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.ExpiresIn != int(issuer.TTL().Seconds()) {
+		t.Errorf("expires_in = %d", response.ExpiresIn)
+	}
+	claims := &oauth.Claims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(response.AccessToken, claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims.Audience[0] != "alice/weather" || claims.Subscription.SubscriptionID != "sub-1" {
+		t.Errorf("aud = %v, subscription = %+v", claims.Audience, claims.Subscription)
+	}
+	// Calls are metered against the subscription's key, not the client
+	if claims.Subscription.APIKeyID != "key-1" || claims.ClientID != "cid_1" {
+		t.Errorf("api_key_id = %s, client_id = %s", claims.Subscription.APIKeyID, claims.ClientID)
+	}
+}
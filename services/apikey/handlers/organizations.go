@@ -0,0 +1,417 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/api-direct/services/apikey/store"
+	"github.com/gin-gonic/gin"
+)
+
+// OrganizationHeader selects the organization a request acts in. Without
+// it, requests act in the consumer's personal organization.
+const OrganizationHeader = "X-Organization-ID"
+
+// CreateOrganizationRequest represents the request to create an organization
+type CreateOrganizationRequest struct {
+	Name string `json:"name" binding:"required,max=255"`
+}
+
+// UpdateOrganizationRequest represents the request to update an organization
+type UpdateOrganizationRequest struct {
+	Name              string `json:"name" binding:"max=255"`
+	BillingConsumerID string `json:"billing_consumer_id" binding:"omitempty,uuid"`
+}
+
+// AddMemberRequest represents the request to add a member to an organization
+type AddMemberRequest struct {
+	Email string `json:"email" binding:"required,email"`
+	Role  string `json:"role" binding:"required"`
+}
+
+// ChangeMemberRoleRequest represents the request to change a member's role
+type ChangeMemberRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+// requireMembership resolves the authenticated consumer's membership of the
+// organization the request acts in, from the organization ID in the path or
+// the X-Organization-ID header, and checks it grants a permission. It
+// responds and returns false if not.
+func requireMembership(c *gin.Context, s *store.PostgresStore, permission string) (*store.Membership, bool) {
+	// Get consumer info from auth middleware
+	userID, _ := c.Get("user_id")
+	email, _ := c.Get("email")
+
+	// Ensure consumer record exists
+	consumerID, err := s.EnsureConsumer(userID.(string), email.(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to ensure consumer record",
+			"code":    "CONSUMER_ERROR",
+			"details": err.Error(),
+		})
+		return nil, false
+	}
+
+	organizationID := c.Param("orgId")
+	if organizationID == "" {
+		organizationID = c.GetHeader(OrganizationHeader)
+	}
+
+	membership, err := s.GetMembership(organizationID, consumerID)
+	if errors.Is(err, store.ErrNotMember) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Organization not found",
+			"code":  "ORGANIZATION_NOT_FOUND",
+		})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to get organization",
+			"code":    "ORGANIZATION_ERROR",
+			"details": err.Error(),
+		})
+		return nil, false
+	}
+
+	if !membership.Can(permission) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":   "Your role in this organization doesn't allow this",
+			"code":    "FORBIDDEN",
+			"details": "role " + membership.Role + " lacks " + permission,
+		})
+		return nil, false
+	}
+
+	return membership, true
+}
+
+// ListOrganizations lists the organizations the consumer is a member of
+func ListOrganizations(s *store.PostgresStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Get consumer info from auth middleware
+		userID, _ := c.Get("user_id")
+		email, _ := c.Get("email")
+
+		// Ensure consumer record exists
+		consumerID, err := s.EnsureConsumer(userID.(string), email.(string))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "Failed to ensure consumer record",
+				"code":    "CONSUMER_ERROR",
+				"details": err.Error(),
+			})
+			return
+		}
+
+		orgs, err := s.ListOrganizations(consumerID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "Failed to list organizations",
+				"code":    "LIST_ERROR",
+				"details": err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"organizations": orgs,
+			"count":         len(orgs),
+		})
+	}
+}
+
+// CreateOrganization creates a team organization owned by the consumer
+func CreateOrganization(s *store.PostgresStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CreateOrganizationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid request",
+				"code":    "INVALID_REQUEST",
+				"details": err.Error(),
+			})
+			return
+		}
+
+		// Get consumer info from auth middleware
+		userID, _ := c.Get("user_id")
+		email, _ := c.Get("email")
+
+		// Ensure consumer record exists
+		consumerID, err := s.EnsureConsumer(userID.(string), email.(string))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "Failed to ensure consumer record",
+				"code":    "CONSUMER_ERROR",
+				"details": err.Error(),
+			})
+			return
+		}
+
+		org, err := s.CreateOrganization(consumerID, req.Name)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "Failed to create organization",
+				"code":    "ORGANIZATION_ERROR",
+				"details": err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusCreated, org)
+	}
+}
+
+// GetOrganization returns an organization the consumer is a member of
+func GetOrganization(s *store.PostgresStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		membership, ok := requireMembership(c, s, store.PermissionKeysRead)
+		if !ok {
+			return
+		}
+
+		c.JSON(http.StatusOK, membership.Organization)
+	}
+}
+
+// UpdateOrganization renames an organization or changes who pays for it
+func UpdateOrganization(s *store.PostgresStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req UpdateOrganizationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid request",
+				"code":    "INVALID_REQUEST",
+				"details": err.Error(),
+			})
+			return
+		}
+
+		membership, ok := requireMembership(c, s, store.PermissionOrganizationWrite)
+		if !ok {
+			return
+		}
+
+		err := s.UpdateOrganization(membership.Organization.ID, membership.ConsumerID, req.Name, req.BillingConsumerID)
+		if errors.Is(err, store.ErrNotMember) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "The billing member must be an owner, admin or billing member of the organization",
+				"code":  "INVALID_BILLING_MEMBER",
+			})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "Failed to update organization",
+				"code":    "ORGANIZATION_ERROR",
+				"details": err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Organization updated successfully",
+		})
+	}
+}
+
+// ListMembers lists the members of an organization
+func ListMembers(s *store.PostgresStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		membership, ok := requireMembership(c, s, store.PermissionKeysRead)
+		if !ok {
+			return
+		}
+
+		members, err := s.ListMembers(membership.Organization.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "Failed to list members",
+				"code":    "LIST_ERROR",
+				"details": err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"members": members,
+			"count":   len(members),
+		})
+	}
+}
+
+// AddMember adds a consumer to an organization by email
+func AddMember(s *store.PostgresStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req AddMemberRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid request",
+				"code":    "INVALID_REQUEST",
+				"details": err.Error(),
+			})
+			return
+		}
+		if !store.ValidRole(req.Role) {
+			invalidRole(c)
+			return
+		}
+
+		membership, ok := requireMembership(c, s, store.PermissionMembersWrite)
+		if !ok {
+			return
+		}
+		if !canAssign(membership, req.Role) {
+			ownersOnly(c)
+			return
+		}
+
+		member, err := s.AddMember(membership.Organization, membership.ConsumerID, req.Email, req.Role)
+		if err != nil {
+			memberError(c, err, "Failed to add member")
+			return
+		}
+
+		c.JSON(http.StatusCreated, member)
+	}
+}
+
+// ChangeMemberRole changes the role of an organization's member
+func ChangeMemberRole(s *store.PostgresStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ChangeMemberRoleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid request",
+				"code":    "INVALID_REQUEST",
+				"details": err.Error(),
+			})
+			return
+		}
+		if !store.ValidRole(req.Role) {
+			invalidRole(c)
+			return
+		}
+
+		membership, ok := requireMembership(c, s, store.PermissionMembersWrite)
+		if !ok {
+			return
+		}
+		if !canAssign(membership, req.Role) || !canManage(c, s, membership, c.Param("consumerId")) {
+			ownersOnly(c)
+			return
+		}
+
+		err := s.ChangeMemberRole(membership.Organization, membership.ConsumerID, c.Param("consumerId"), req.Role)
+		if err != nil {
+			memberError(c, err, "Failed to change role")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Role changed successfully",
+		})
+	}
+}
+
+// RemoveMember removes a member from an organization. Members may remove
+// themselves, to leave it.
+func RemoveMember(s *store.PostgresStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Anyone may leave; removing others takes members:write
+		membership, ok := requireMembership(c, s, store.PermissionKeysRead)
+		if !ok {
+			return
+		}
+		consumerID := c.Param("consumerId")
+		if consumerID != membership.ConsumerID {
+			if !membership.Can(store.PermissionMembersWrite) || !canManage(c, s, membership, consumerID) {
+				ownersOnly(c)
+				return
+			}
+		}
+
+		if err := s.RemoveMember(membership.Organization, membership.ConsumerID, consumerID); err != nil {
+			memberError(c, err, "Failed to remove member")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Member removed successfully",
+		})
+	}
+}
+
+// canAssign reports whether a member may give others a role: only owners
+// make owners
+func canAssign(membership *store.Membership, role string) bool {
+	return role != store.RoleOwner || membership.Role == store.RoleOwner
+}
+
+// canManage reports whether a member may change another's membership: only
+// owners change owners
+func canManage(c *gin.Context, s *store.PostgresStore, membership *store.Membership, consumerID string) bool {
+	if membership.Role == store.RoleOwner {
+		return true
+	}
+	target, err := s.GetMembership(membership.Organization.ID, consumerID)
+	if err != nil {
+		// Missing members are reported by the change itself
+		return true
+	}
+	return target.Role != store.RoleOwner
+}
+
+func invalidRole(c *gin.Context) {
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error": "Role must be owner, admin, developer or billing",
+		"code":  "INVALID_ROLE",
+	})
+}
+
+func ownersOnly(c *gin.Context) {
+	c.JSON(http.StatusForbidden, gin.H{
+		"error": "Only owners can manage owners",
+		"code":  "FORBIDDEN",
+	})
+}
+
+// memberError responds to an error changing an organization's members
+func memberError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, store.ErrNotMember), errors.Is(err, store.ErrConsumerAbsent):
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Member not found",
+			"code":  "MEMBER_NOT_FOUND",
+		})
+	case errors.Is(err, store.ErrAlreadyMember):
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Already a member of the organization",
+			"code":  "ALREADY_MEMBER",
+		})
+	case errors.Is(err, store.ErrLastOwner):
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "The organization must keep at least one owner",
+			"code":  "LAST_OWNER",
+		})
+	case errors.Is(err, store.ErrBillingMember):
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "This member pays for the organization; change the billing member first",
+			"code":  "BILLING_MEMBER",
+		})
+	case errors.Is(err, store.ErrPersonalOrg):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Personal organizations can't have other members; create a team organization",
+			"code":  "PERSONAL_ORGANIZATION",
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   message,
+			"code":    "MEMBER_ERROR",
+			"details": err.Error(),
+		})
+	}
+}
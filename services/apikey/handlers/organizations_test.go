@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/api-direct/services/apikey/oauth"
+	"github.com/api-direct/services/apikey/store"
+	"github.com/gin-gonic/gin"
+)
+
+// memberRouter serves the organization scoped routes to consumer-1, as the
+// auth middleware would
+func memberRouter(t *testing.T, s *store.PostgresStore) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	key, err := oauth.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("user_id", "cognito-1")
+		c.Set("email", "alice@example.com")
+	})
+	router.DELETE("/keys/:keyId", RevokeAPIKey(s, nil))
+	router.DELETE("/oauth/clients/:clientId", RevokeOAuthClient(s, oauth.NewIssuer(oauth.Config{PrivateKey: key})))
+	router.PUT("/organizations/:orgId/members/:consumerId", ChangeMemberRole(s))
+	return router
+}
+
+var membershipColumns = []string{"id", "name", "personal", "billing_consumer_id", "created_by", "created_at", "role"}
+
+// expectMembership expects the request's consumer to be resolved and their
+// membership of an organization looked up. An empty role means they aren't
+// a member.
+func expectMembership(mock sqlmock.Sqlmock, organizationID, role string) {
+	mock.ExpectQuery("SELECT id FROM consumers WHERE cognito_user_id = \\$1").
+		WithArgs("cognito-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("consumer-1"))
+	rows := sqlmock.NewRows(membershipColumns)
+	if role != "" {
+		rows.AddRow(organizationID, "Acme", false, "consumer-9", "consumer-9", time.Now(), role)
+	}
+	mock.ExpectQuery("FROM organizations o\\s+JOIN organization_members m").
+		WithArgs(organizationID, "consumer-1").
+		WillReturnRows(rows)
+}
+
+func serve(router *gin.Engine, method, path, organizationID, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if organizationID != "" {
+		req.Header.Set(OrganizationHeader, organizationID)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestMembersCannotRevokeOtherOrganizationsCredentials(t *testing.T) {
+	s, mock := newMockStore(t)
+	router := memberRouter(t, s)
+
+	// Acting in an organization they don't belong to
+	expectMembership(mock, "org-b", "")
+	w := serve(router, http.MethodDelete, "/keys/key-b", "org-b", "")
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "ORGANIZATION_NOT_FOUND") {
+		t.Errorf("other organization: status = %d, body = %s", w.Code, w.Body)
+	}
+
+	// Acting in their own organization, on another's key: the revocation
+	// is scoped to their organization and finds nothing
+	expectMembership(mock, "org-a", store.RoleOwner)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE api_keys\\s+SET is_active = false\\s+WHERE id = \\$1 AND organization_id = \\$2").
+		WithArgs("key-b", "org-a").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	w = serve(router, http.MethodDelete, "/keys/key-b", "org-a", "")
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "KEY_NOT_FOUND") {
+		t.Errorf("other organization's key: status = %d, body = %s", w.Code, w.Body)
+	}
+
+	expectMembership(mock, "org-a", store.RoleOwner)
+	mock.ExpectExec("UPDATE oauth_clients\\s+SET is_active = false\\s+WHERE id = \\$1 AND organization_id = \\$2").
+		WithArgs("client-b", "org-a").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	w = serve(router, http.MethodDelete, "/oauth/clients/client-b", "org-a", "")
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "CLIENT_NOT_FOUND") {
+		t.Errorf("other organization's client: status = %d, body = %s", w.Code, w.Body)
+	}
+
+	// Billing members can't touch keys at all
+	expectMembership(mock, "org-a", store.RoleBilling)
+	if w := serve(router, http.MethodDelete, "/keys/key-a", "org-a", ""); w.Code != http.StatusForbidden {
+		t.Errorf("billing member: status = %d, want 403", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMembersCannotEscalateTheirRole(t *testing.T) {
+	s, mock := newMockStore(t)
+	router := memberRouter(t, s)
+
+	tests := []struct {
+		name, role, target, body string
+	}{
+		{"developer to admin", store.RoleDeveloper, "consumer-1", `{"role":"admin"}`},
+		{"billing to owner", store.RoleBilling, "consumer-1", `{"role":"owner"}`},
+		{"admin to owner", store.RoleAdmin, "consumer-1", `{"role":"owner"}`},
+	}
+	for _, tt := range tests {
+		expectMembership(mock, "org-a", tt.role)
+		w := serve(router, http.MethodPut, "/organizations/org-a/members/"+tt.target, "", tt.body)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s: status = %d, body = %s", tt.name, w.Code, w.Body)
+		}
+	}
+
+	// Admins can't demote owners either
+	expectMembership(mock, "org-a", store.RoleAdmin)
+	mock.ExpectQuery("FROM organizations o\\s+JOIN organization_members m").
+		WithArgs("org-a", "consumer-9").
+		WillReturnRows(sqlmock.NewRows(membershipColumns).
+			AddRow("org-a", "Acme", false, "consumer-9", "consumer-9", time.Now(), store.RoleOwner))
+	w := serve(router, http.MethodPut, "/organizations/org-a/members/consumer-9", "", `{"role":"developer"}`)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("admin demoting owner: status = %d, body = %s", w.Code, w.Body)
+	}
+
+	// None of the above reached the members table
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	// Admins do manage everyone else
+	expectMembership(mock, "org-a", store.RoleAdmin)
+	mock.ExpectQuery("FROM organizations o\\s+JOIN organization_members m").
+		WithArgs("org-a", "consumer-2").
+		WillReturnRows(sqlmock.NewRows(membershipColumns).
+			AddRow("org-a", "Acme", false, "consumer-9", "consumer-9", time.Now(), store.RoleDeveloper))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT role FROM organization_members").
+		WithArgs("org-a", "consumer-2").
+		WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow(store.RoleDeveloper))
+	mock.ExpectExec("UPDATE organization_members SET role = \\$3").
+		WithArgs("org-a", "consumer-2", store.RoleAdmin).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_log").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	w = serve(router, http.MethodPut, "/organizations/org-a/members/consumer-2", "", `{"role":"admin"}`)
+	if w.Code != http.StatusOK {
+		t.Errorf("admin promoting developer: status = %d, body = %s", w.Code, w.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRolePermissions(t *testing.T) {
+	tests := []struct {
+		role, permission string
+		want             bool
+	}{
+		{store.RoleOwner, store.PermissionOrganizationWrite, true},
+		{store.RoleAdmin, store.PermissionOrganizationWrite, false},
+		{store.RoleAdmin, store.PermissionMembersWrite, true},
+		{store.RoleDeveloper, store.PermissionMembersWrite, false},
+		{store.RoleDeveloper, store.PermissionKeysWrite, true},
+		{store.RoleDeveloper, store.PermissionSubscriptionsWrite, false},
+		{store.RoleBilling, store.PermissionKeysWrite, false},
+		{store.RoleBilling, store.PermissionSubscriptionsWrite, true},
+		{"superuser", store.PermissionKeysRead, false},
+	}
+	for _, tt := range tests {
+		if got := store.RoleCan(tt.role, tt.permission); got != tt.want {
+			t.Errorf("RoleCan(%s, %s) = %v, want %v", tt.role, tt.permission, got, tt.want)
+		}
+	}
+
+	owner := &store.Membership{Role: store.RoleOwner}
+	admin := &store.Membership{Role: store.RoleAdmin}
+	if !canAssign(owner, store.RoleOwner) || canAssign(admin, store.RoleOwner) || !canAssign(admin, store.RoleAdmin) {
+		t.Error("only owners may make owners")
+	}
+}
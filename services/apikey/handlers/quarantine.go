@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/api-direct/services/apikey/anomaly"
+	"github.com/api-direct/services/apikey/events"
+	"github.com/api-direct/services/apikey/scanning"
+	"github.com/api-direct/services/apikey/store"
+	"github.com/gin-gonic/gin"
+)
+
+// maxAlertBytes bounds the body of a secret scanning alert
+const maxAlertBytes = 1 << 20
+
+// KeyActivityRequest represents the key activity reported by a gateway
+type KeyActivityRequest struct {
+	Observations []*store.KeyObservation `json:"observations" binding:"required,dive"`
+}
+
+// LeakNotifier tells consumers that one of their keys was revoked after it
+// was reported leaked
+type LeakNotifier interface {
+	KeyLeaked(ctx context.Context, alert *store.KeyAlert) error
+}
+
+// RecordKeyActivity accepts key activity from the gateways and checks it for
+// signs of leaked keys in the background
+func RecordKeyActivity(detector *anomaly.Detector) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req KeyActivityRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid request",
+				"code":    "INVALID_REQUEST",
+				"details": err.Error(),
+			})
+			return
+		}
+
+		// The gateway reports on a timer and doesn't wait for the checks
+		go detector.Process(context.Background(), req.Observations)
+
+		c.JSON(http.StatusAccepted, gin.H{
+			"accepted": len(req.Observations),
+		})
+	}
+}
+
+// ConfirmAPIKey lifts the quarantine of a key whose consumer recognizes the
+// activity that flagged it
+func ConfirmAPIKey(s *store.PostgresStore, publisher *events.Publisher) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		keyID := c.Param("keyId")
+
+		// Resolve the organization the request acts in
+		membership, ok := requireMembership(c, s, store.PermissionKeysWrite)
+		if !ok {
+			return
+		}
+
+		if err := s.ConfirmAPIKey(keyID, membership.Organization.ID, membership.ConsumerID); err != nil {
+			if err.Error() == "API key not found" {
+				c.JSON(http.StatusNotFound, gin.H{
+					"error": "No quarantined API key found",
+					"code":  "KEY_NOT_FOUND",
+				})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "Failed to confirm API key",
+				"code":    "CONFIRM_ERROR",
+				"details": err.Error(),
+			})
+			return
+		}
+
+		// Gateways cache the quarantined validation
+		publisher.KeyChanged(keyID)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "API key confirmed and released from quarantine",
+		})
+	}
+}
+
+// SecretScanningAlert revokes keys that a secret scanner found published,
+// such as in a public repository. Alerts use the GitHub secret scanning
+// partner format and must be signed by one of the scanner's keys.
+func SecretScanningAlert(s *store.PostgresStore, verifier *scanning.Verifier, publisher *events.Publisher, notifier LeakNotifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxAlertBytes))
+		if err != nil {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"error": "Alert too large",
+				"code":  "INVALID_REQUEST",
+			})
+			return
+		}
+
+		err = verifier.Verify(
+			c.Request.Context(),
+			c.GetHeader("Github-Public-Key-Identifier"),
+			c.GetHeader("Github-Public-Key-Signature"),
+			body,
+		)
+		if errors.Is(err, scanning.ErrInvalidSignature) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "Invalid signature",
+				"code":  "INVALID_SIGNATURE",
+			})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error":   "Failed to verify signature",
+				"code":    "SIGNATURE_UNAVAILABLE",
+				"details": err.Error(),
+			})
+			return
+		}
+
+		var alerts []scanning.Alert
+		if err := json.Unmarshal(body, &alerts); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid request",
+				"code":    "INVALID_REQUEST",
+				"details": err.Error(),
+			})
+			return
+		}
+
+		feedback := make([]scanning.Feedback, 0, len(alerts))
+		for _, alert := range alerts {
+			revoked, err := s.RevokeLeakedKey(alert.Token, fmt.Sprintf("reported by secret scanning at %s (%s)", alert.URL, alert.Source))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error":   "Failed to revoke leaked key",
+					"code":    "REVOKE_ERROR",
+					"details": err.Error(),
+				})
+				return
+			}
+
+			label := "false_positive"
+			if revoked != nil {
+				label = "true_positive"
+			}
+			feedback = append(feedback, scanning.Feedback{
+				TokenRaw:  alert.Token,
+				TokenType: alert.Type,
+				Label:     label,
+			})
+
+			// Keys revoked by an earlier alert have no new anomaly
+			if revoked == nil || len(revoked.Anomalies) == 0 {
+				continue
+			}
+			publisher.KeyChanged(revoked.ID)
+			log.Printf("Revoked leaked API key %s found at %s", revoked.ID, alert.URL)
+			if notifier != nil {
+				if err := notifier.KeyLeaked(c.Request.Context(), revoked); err != nil {
+					log.Printf("Failed to notify leak of key %s: %v", revoked.ID, err)
+				}
+			}
+		}
+
+		c.JSON(http.StatusOK, feedback)
+	}
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/api-direct/services/apikey/scanning"
+	"github.com/api-direct/services/apikey/store"
+	"github.com/gin-gonic/gin"
+)
+
+// leakNotifier records the alerts it is sent
+type leakNotifier struct{ alerts []*store.KeyAlert }
+
+func (n *leakNotifier) KeyLeaked(ctx context.Context, alert *store.KeyAlert) error {
+	n.alerts = append(n.alerts, alert)
+	return nil
+}
+
+// newScanningKeys serves a secret scanning key list with one key, k1
+func newScanningKeys(t *testing.T) (*ecdsa.PrivateKey, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"public_keys": []map[string]string{{
+				"key_identifier": "k1",
+				"key":            string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+			}},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return key, server.URL
+}
+
+func postAlert(router *gin.Engine, body []byte, signature string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/secret-scanning", bytes.NewReader(body))
+	req.Header.Set("Github-Public-Key-Identifier", "k1")
+	req.Header.Set("Github-Public-Key-Signature", signature)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+var leakedKeyColumns = []string{"id", "key_prefix", "organization_id", "consumer_id", "email", "name", "is_active"}
+
+func TestSecretScanningAlert(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s, mock := newMockStore(t)
+	key, keysURL := newScanningKeys(t)
+	notifier := &leakNotifier{}
+
+	router := gin.New()
+	router.POST("/secret-scanning", SecretScanningAlert(s, scanning.NewVerifier(keysURL), nil, notifier))
+
+	body := []byte(`[{"token":"sk_0123","type":"api_direct_key","url":"https://github.com/a/b/blob/main/.env","source":"content"}]`)
+	digest := sha256.Sum256(body)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := base64.StdEncoding.EncodeToString(sig)
+
+	// Alerts that aren't signed by the scanner don't touch any key
+	forged := []byte(`[{"token":"sk_4567","type":"api_direct_key"}]`)
+	if w := postAlert(router, forged, signature); w.Code != http.StatusUnauthorized {
+		t.Errorf("bad signature: status = %d, want 401", w.Code)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("WHERE ak.key_hash = \\$1").
+		WillReturnRows(sqlmock.NewRows(leakedKeyColumns).
+			AddRow("key-1", "sk_0123...", "org-1", "consumer-1", "alice@example.com", "production", true))
+	mock.ExpectExec("SET is_active = false, quarantine_reason = \\$2").
+		WithArgs("key-1", store.AnomalyLeaked).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO api_key_anomalies").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_log").
+		WithArgs("scanner", "secret-scanning", store.AuditKeyLeaked, "api_key", "key-1", "consumer-1", "org-1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := postAlert(router, body, signature)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+	}
+	var feedback []scanning.Feedback
+	if err := json.Unmarshal(w.Body.Bytes(), &feedback); err != nil {
+		t.Fatal(err)
+	}
+	if len(feedback) != 1 || feedback[0].Label != "true_positive" || feedback[0].TokenRaw != "sk_0123" {
+		t.Errorf("feedback = %+v", feedback)
+	}
+	if len(notifier.alerts) != 1 || notifier.alerts[0].ID != "key-1" {
+		t.Errorf("alerts = %+v", notifier.alerts)
+	}
+
+	// A replayed alert finds the key already revoked: it is confirmed
+	// again, but not revoked or notified twice
+	mock.ExpectBegin()
+	mock.ExpectQuery("WHERE ak.key_hash = \\$1").
+		WillReturnRows(sqlmock.NewRows(leakedKeyColumns).
+			AddRow("key-1", "sk_0123...", "org-1", "consumer-1", "alice@example.com", "production", false))
+	mock.ExpectRollback()
+
+	w = postAlert(router, body, signature)
+	if w.Code != http.StatusOK {
+		t.Fatalf("replay: status = %d, body = %s", w.Code, w.Body)
+	}
+	feedback = nil
+	if err := json.Unmarshal(w.Body.Bytes(), &feedback); err != nil {
+		t.Fatal(err)
+	}
+	if len(feedback) != 1 || feedback[0].Label != "true_positive" {
+		t.Errorf("replay feedback = %+v", feedback)
+	}
+	if len(notifier.alerts) != 1 {
+		t.Errorf("replay notified %d times, want once", len(notifier.alerts))
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -2,19 +2,28 @@ package main
 
 import (
 	"context"
+	"crypto/rsa"
 	"database/sql"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
 	_ "github.com/lib/pq"
+	"github.com/api-direct/services/apikey/anomaly"
+	"github.com/api-direct/services/apikey/events"
 	"github.com/api-direct/services/apikey/handlers"
 	"github.com/api-direct/services/apikey/middleware"
+	"github.com/api-direct/services/apikey/oauth"
+	"github.com/api-direct/services/apikey/scanning"
 	"github.com/api-direct/services/apikey/store"
+	"github.com/api-direct/services/apikey/workers"
+	"github.com/api-direct/services/shared/tracing"
 )
 
 func main() {
@@ -46,8 +55,129 @@ func main() {
 	// Initialize store
 	apiKeyStore := store.NewPostgresStore(db)
 
+	// Initialize the OAuth token issuer. Every replica must share the
+	// signing key, so a generated key is only suitable for development.
+	var signingKey *rsa.PrivateKey
+	if pemKey := os.Getenv("OAUTH_SIGNING_KEY"); pemKey != "" {
+		signingKey, err = oauth.ParsePrivateKey(pemKey)
+		if err != nil {
+			log.Fatalf("Invalid OAUTH_SIGNING_KEY: %v", err)
+		}
+	} else {
+		log.Println("OAUTH_SIGNING_KEY not set, signing access tokens with a generated key")
+		signingKey, err = oauth.GenerateKey()
+		if err != nil {
+			log.Fatalf("Failed to generate OAuth signing key: %v", err)
+		}
+	}
+	tokenTTL := 15 * time.Minute
+	if value := os.Getenv("OAUTH_TOKEN_TTL"); value != "" {
+		tokenTTL, err = time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Invalid OAUTH_TOKEN_TTL: %v", err)
+		}
+	}
+	issuer := oauth.NewIssuer(oauth.Config{
+		PrivateKey: signingKey,
+		Issuer:     os.Getenv("OAUTH_ISSUER"),
+		TTL:        tokenTTL,
+	})
+
+	// Initialize gateway cache invalidation publisher (optional)
+	var publisher *events.Publisher
+	if redisURL := os.Getenv("REDIS_URL"); redisURL != "" {
+		opt, err := redis.ParseURL(redisURL)
+		if err != nil {
+			log.Fatalf("Failed to parse Redis URL: %v", err)
+		}
+		redisClient := redis.NewClient(opt)
+		defer redisClient.Close()
+		publisher = events.NewPublisher(redisClient)
+	} else {
+		log.Println("REDIS_URL not set, gateway validation caches will not be invalidated on revocation")
+	}
+
+	// Start the key expiry worker. Expiry notices are only sent when a
+	// webhook is configured.
+	expiryInterval := 5 * time.Minute
+	if value := os.Getenv("KEY_EXPIRY_SWEEP_INTERVAL"); value != "" {
+		expiryInterval, err = time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Invalid KEY_EXPIRY_SWEEP_INTERVAL: %v", err)
+		}
+	}
+	expiryNotice := 7 * 24 * time.Hour
+	if value := os.Getenv("KEY_EXPIRY_NOTICE"); value != "" {
+		expiryNotice, err = time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Invalid KEY_EXPIRY_NOTICE: %v", err)
+		}
+	}
+	var notifier workers.Notifier
+	if webhookURL := os.Getenv("KEY_EXPIRY_WEBHOOK_URL"); webhookURL != "" {
+		notifier = events.NewWebhookNotifier(webhookURL)
+	} else {
+		log.Println("KEY_EXPIRY_WEBHOOK_URL not set, consumers will not be notified before keys expire")
+	}
+	expiryWorker := workers.NewExpiryWorker(apiKeyStore, publisher, notifier, expiryInterval, expiryNotice)
+
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	go expiryWorker.Start(workerCtx)
+
+	// Initialize leaked key detection. Consumers are told about quarantined
+	// and leaked keys when an alert webhook is configured.
+	var alertNotifier *events.WebhookNotifier
+	if webhookURL := os.Getenv("KEY_ALERT_WEBHOOK_URL"); webhookURL != "" {
+		alertNotifier = events.NewWebhookNotifier(webhookURL)
+	} else {
+		log.Println("KEY_ALERT_WEBHOOK_URL not set, consumers will not be notified of quarantined or leaked keys")
+	}
+	anomalyConfig := anomaly.DefaultConfig()
+	if value := os.Getenv("KEY_SPIKE_MIN_CALLS"); value != "" {
+		anomalyConfig.SpikeMinCalls, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			log.Fatalf("Invalid KEY_SPIKE_MIN_CALLS: %v", err)
+		}
+	}
+	if value := os.Getenv("KEY_MAX_TRAVEL_SPEED"); value != "" {
+		anomalyConfig.MaxTravelSpeed, err = strconv.ParseFloat(value, 64)
+		if err != nil {
+			log.Fatalf("Invalid KEY_MAX_TRAVEL_SPEED: %v", err)
+		}
+	}
+	var quarantineNotifier anomaly.Notifier
+	var leakNotifier handlers.LeakNotifier
+	if alertNotifier != nil {
+		quarantineNotifier, leakNotifier = alertNotifier, alertNotifier
+	}
+	detector := anomaly.NewDetector(anomalyConfig, apiKeyStore, publisher, quarantineNotifier)
+
+	// Secret scanning alerts are verified against the scanner's published keys
+	// The gateway authenticates its key activity reports with a shared token
+	serviceToken := os.Getenv("GATEWAY_SERVICE_TOKEN")
+	if serviceToken == "" {
+		log.Println("GATEWAY_SERVICE_TOKEN is not set; key activity reports will be rejected")
+	}
+
+	scanningKeysURL := os.Getenv("SECRET_SCANNING_KEYS_URL")
+	if scanningKeysURL == "" {
+		scanningKeysURL = scanning.DefaultKeysURL
+	}
+	scanningVerifier := scanning.NewVerifier(scanningKeysURL)
+
+	// Initialize tracing to continue traces started by the gateway
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		ServiceName: "apikey",
+		Exporter:    os.Getenv("OTEL_TRACES_EXPORTER"),
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
+	}
+
 	// Initialize Gin router
 	router := gin.New()
+	router.Use(tracing.Middleware("apikey", "/health"))
 	router.Use(gin.Logger())
 	router.Use(gin.Recovery())
 	router.Use(middleware.CORS())
@@ -61,6 +191,14 @@ func main() {
 		})
 	})
 
+	// OAuth2 client credentials token endpoint and the keys the gateway
+	// verifies access tokens with
+	router.POST("/oauth/token", handlers.Token(apiKeyStore, issuer))
+	router.GET("/.well-known/jwks.json", handlers.JWKS(issuer))
+
+	// Leaked keys reported by secret scanners (signature verified)
+	router.POST("/webhooks/secret-scanning", handlers.SecretScanningAlert(apiKeyStore, scanningVerifier, publisher, leakNotifier))
+
 	// API routes
 	api := router.Group("/api/v1")
 	{
@@ -73,6 +211,9 @@ func main() {
 			// Validate API key (no auth required - used by gateway)
 			keys.POST("/validate", handlers.ValidateAPIKey(apiKeyStore))
 			
+			// Report key activity for leaked key detection (gateway service token required)
+			keys.POST("/activity", middleware.ServiceAuth(serviceToken), handlers.RecordKeyActivity(detector))
+			
 			// Get API key details (requires auth)
 			keys.GET("/:keyId", middleware.AuthRequired(), handlers.GetAPIKey(apiKeyStore))
 			
@@ -80,10 +221,51 @@ func main() {
 			keys.GET("", middleware.AuthRequired(), handlers.ListAPIKeys(apiKeyStore))
 			
 			// Revoke API key (requires auth)
-			keys.DELETE("/:keyId", middleware.AuthRequired(), handlers.RevokeAPIKey(apiKeyStore))
+			keys.DELETE("/:keyId", middleware.AuthRequired(), handlers.RevokeAPIKey(apiKeyStore, publisher))
+			
+			// Update API key name and scopes (requires auth)
+			keys.PUT("/:keyId", middleware.AuthRequired(), handlers.UpdateAPIKey(apiKeyStore, publisher))
+			
+			// Rotate an API key, or a subscription's key, with a grace period (requires auth)
+			keys.POST("/:keyId/rotate", middleware.AuthRequired(), handlers.RotateAPIKey(apiKeyStore, publisher))
+			keys.POST("/rotate", middleware.AuthRequired(), handlers.RotateAPIKey(apiKeyStore, publisher))
 			
-			// Update API key name (requires auth)
-			keys.PUT("/:keyId", middleware.AuthRequired(), handlers.UpdateAPIKey(apiKeyStore))
+			// Release a quarantined API key (requires auth)
+			keys.POST("/:keyId/confirm", middleware.AuthRequired(), handlers.ConfirmAPIKey(apiKeyStore, publisher))
+		}
+
+		// OAuth client endpoints (require auth)
+		clients := api.Group("/oauth/clients")
+		clients.Use(middleware.AuthRequired())
+		{
+			clients.POST("", handlers.CreateOAuthClient(apiKeyStore))
+			clients.GET("", handlers.ListOAuthClients(apiKeyStore))
+			clients.DELETE("/:clientId", handlers.RevokeOAuthClient(apiKeyStore, issuer))
+		}
+
+		// Organization and member endpoints (require auth)
+		orgs := api.Group("/organizations")
+		orgs.Use(middleware.AuthRequired())
+		{
+			orgs.GET("", handlers.ListOrganizations(apiKeyStore))
+			orgs.POST("", handlers.CreateOrganization(apiKeyStore))
+			orgs.GET("/:orgId", handlers.GetOrganization(apiKeyStore))
+			orgs.PUT("/:orgId", handlers.UpdateOrganization(apiKeyStore))
+			orgs.GET("/:orgId/members", handlers.ListMembers(apiKeyStore))
+			orgs.POST("/:orgId/members", handlers.AddMember(apiKeyStore))
+			orgs.PUT("/:orgId/members/:consumerId", handlers.ChangeMemberRole(apiKeyStore))
+			orgs.DELETE("/:orgId/members/:consumerId", handlers.RemoveMember(apiKeyStore))
+		}
+
+		// Audit log of the organization's keys and subscriptions (requires auth)
+		api.GET("/audit", middleware.AuthRequired(), handlers.ListAuditEvents(apiKeyStore))
+
+		// Audit log across all consumers (requires admin)
+		admin := api.Group("/admin/audit")
+		admin.Use(middleware.AuthRequired(), middleware.AdminOnly())
+		{
+			admin.GET("", handlers.ListAllAuditEvents(apiKeyStore))
+			admin.GET("/verify", handlers.VerifyAuditLog(apiKeyStore))
 		}
 	}
 
@@ -110,6 +292,7 @@ func main() {
 	<-quit
 
 	log.Println("Shutting down server...")
+	stopWorkers()
 
 	// Graceful shutdown with timeout
 	ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
@@ -119,5 +302,9 @@ func main() {
 		log.Printf("Server forced to shutdown: %v", err)
 	}
 
+	if err := shutdownTracing(ctx); err != nil {
+		log.Printf("Error shutting down tracing: %v", err)
+	}
+
 	log.Println("Server exited")
 }
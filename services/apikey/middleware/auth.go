@@ -137,3 +137,20 @@ func ConsumerAuth() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// AdminOnly ensures the user is an admin. It runs after AuthRequired.
+func AdminOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userType, exists := c.Get("user_type")
+		if !exists || userType != "admin" {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Admin access required",
+				"code":  "ADMIN_ONLY",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestServiceAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name, token, header string
+		want                int
+	}{
+		{"service token", "s3cret", "Bearer s3cret", http.StatusOK},
+		{"no token", "s3cret", "", http.StatusUnauthorized},
+		{"other token", "s3cret", "Bearer guess", http.StatusUnauthorized},
+		// Nothing gets through when the service isn't configured with a token
+		{"unconfigured", "", "Bearer ", http.StatusUnauthorized},
+		{"unconfigured without header", "", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		router := gin.New()
+		router.POST("/activity", ServiceAuth(tt.token), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+
+		req := httptest.NewRequest(http.MethodPost, "/activity", nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
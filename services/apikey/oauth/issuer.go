@@ -0,0 +1,150 @@
+// Package oauth issues the short-lived access tokens OAuth clients exchange
+// their credentials for. Tokens are RS256 JWTs the gateway validates
+// against the published JWKS without calling this service.
+package oauth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/api-direct/services/apikey/store"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Config controls token issuance
+type Config struct {
+	// PrivateKey signs tokens
+	PrivateKey *rsa.PrivateKey
+	// Issuer is the iss claim the gateway expects
+	Issuer string
+	// TTL is how long tokens are valid. Revoked clients keep working until
+	// their tokens expire, so keep it short.
+	TTL time.Duration
+}
+
+// Claims are the claims of an access token. Subscription carries what the
+// gateway would otherwise get from validating an API key.
+type Claims struct {
+	ClientID     string                  `json:"client_id"`
+	Subscription *store.APIKeyValidation `json:"subscription"`
+	jwt.RegisteredClaims
+}
+
+// Issuer signs access tokens
+type Issuer struct {
+	config Config
+	keyID  string
+}
+
+// NewIssuer creates a token issuer
+func NewIssuer(config Config) *Issuer {
+	if config.Issuer == "" {
+		config.Issuer = "api-direct"
+	}
+	if config.TTL <= 0 {
+		config.TTL = 15 * time.Minute
+	}
+	return &Issuer{
+		config: config,
+		keyID:  keyID(&config.PrivateKey.PublicKey),
+	}
+}
+
+// TTL returns how long issued tokens are valid
+func (i *Issuer) TTL() time.Duration {
+	return i.config.TTL
+}
+
+// Issue returns an access token for a client's grant
+func (i *Issuer) Issue(grant *store.ClientGrant, now time.Time) (string, error) {
+	claims := Claims{
+		ClientID:     grant.ClientID,
+		Subscription: grant.Validation,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Issuer:    i.config.Issuer,
+			Subject:   grant.Validation.ConsumerID,
+			Audience:  jwt.ClaimStrings{grant.API},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(i.config.TTL)),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = i.keyID
+	signed, err := token.SignedString(i.config.PrivateKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+	return signed, nil
+}
+
+// JWK is a public key in JSON Web Key format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+}
+
+// JWKS is a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the key set tokens are verified with
+func (i *Issuer) JWKS() JWKS {
+	public := i.config.PrivateKey.PublicKey
+	return JWKS{Keys: []JWK{{
+		KeyType:   "RSA",
+		Use:       "sig",
+		Algorithm: "RS256",
+		KeyID:     i.keyID,
+		N:         base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+		E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+	}}}
+}
+
+// ParsePrivateKey parses a PEM encoded RSA private key in PKCS#1 or PKCS#8 form
+func ParsePrivateKey(data string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key is not an RSA key")
+	}
+	return key, nil
+}
+
+// GenerateKey creates a signing key for development. Tokens signed with it
+// stop verifying when the service restarts.
+func GenerateKey() (*rsa.PrivateKey, error) {
+	return rsa.GenerateKey(rand.Reader, 2048)
+}
+
+// keyID identifies a signing key by its public key's thumbprint
+func keyID(public *rsa.PublicKey) string {
+	der, _ := x509.MarshalPKIXPublicKey(public)
+	sum := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
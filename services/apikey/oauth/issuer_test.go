@@ -0,0 +1,153 @@
+package oauth
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/api-direct/services/apikey/store"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var testKey, _ = GenerateKey()
+
+func testGrant() *store.ClientGrant {
+	return &store.ClientGrant{
+		ClientID: "cid_1",
+		API:      "alice/weather",
+		Validation: &store.APIKeyValidation{
+			Valid:          true,
+			ConsumerID:     "consumer-1",
+			SubscriptionID: "sub-1",
+		},
+	}
+}
+
+// verify checks a token the way the gateway does, with the keys of a
+// published key set
+func verify(jwks JWKS, token, audience string, now time.Time) (*Claims, error) {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithIssuer("api-direct"),
+		jwt.WithAudience(audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(func() time.Time { return now }),
+	)
+	var claims Claims
+	_, err := parser.ParseWithClaims(token, &claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		for _, key := range jwks.Keys {
+			if key.KeyID != kid {
+				continue
+			}
+			n, err := base64.RawURLEncoding.DecodeString(key.N)
+			if err != nil {
+				return nil, err
+			}
+			e, err := base64.RawURLEncoding.DecodeString(key.E)
+			if err != nil {
+				return nil, err
+			}
+			return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+		}
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+func TestIssuedTokensVerifyAgainstJWKS(t *testing.T) {
+	issuer := NewIssuer(Config{PrivateKey: testKey})
+	now := time.Now()
+
+	token, err := issuer.Issue(testGrant(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := verify(issuer.JWKS(), token, "alice/weather", now)
+	if err != nil {
+		t.Fatalf("issued token rejected: %v", err)
+	}
+	if claims.ClientID != "cid_1" || claims.Subject != "consumer-1" {
+		t.Errorf("client_id = %q, sub = %q", claims.ClientID, claims.Subject)
+	}
+	if claims.Subscription == nil || claims.Subscription.SubscriptionID != "sub-1" || !claims.Subscription.Valid {
+		t.Errorf("subscription = %+v", claims.Subscription)
+	}
+	if got := claims.ExpiresAt.Sub(claims.IssuedAt.Time); got != issuer.TTL() {
+		t.Errorf("token lifetime = %v, want %v", got, issuer.TTL())
+	}
+}
+
+func TestIssuedTokensAreRejected(t *testing.T) {
+	issuer := NewIssuer(Config{PrivateKey: testKey, TTL: time.Minute})
+	now := time.Now()
+	token, err := issuer.Issue(testGrant(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherKey, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated := NewIssuer(Config{PrivateKey: otherKey})
+
+	tests := []struct {
+		name     string
+		jwks     JWKS
+		audience string
+		now      time.Time
+		want     error
+	}{
+		{"wrong audience", issuer.JWKS(), "alice/maps", now, jwt.ErrTokenInvalidAudience},
+		{"expired", issuer.JWKS(), "alice/weather", now.Add(2 * time.Minute), jwt.ErrTokenExpired},
+		{"unknown kid", rotated.JWKS(), "alice/weather", now, jwt.ErrTokenUnverifiable},
+	}
+	for _, tt := range tests {
+		if _, err := verify(tt.jwks, token, tt.audience, tt.now); !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	// A key set with the right kid but another key fails the signature check
+	forged := rotated.JWKS()
+	forged.Keys[0].KeyID = issuer.JWKS().Keys[0].KeyID
+	if _, err := verify(forged, token, "alice/weather", now); !errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+		t.Errorf("forged key: err = %v, want ErrTokenSignatureInvalid", err)
+	}
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encodings := map[string]*pem.Block{
+		"PKCS#1": {Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(testKey)},
+		"PKCS#8": {Type: "PRIVATE KEY", Bytes: pkcs8},
+	}
+	for name, block := range encodings {
+		key, err := ParsePrivateKey(string(pem.EncodeToMemory(block)))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if keyID(&key.PublicKey) != keyID(&testKey.PublicKey) {
+			t.Errorf("%s: parsed a different key", name)
+		}
+	}
+
+	if _, err := ParsePrivateKey("not a key"); err == nil {
+		t.Error("parsed a key from garbage")
+	}
+}
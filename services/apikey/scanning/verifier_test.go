@@ -0,0 +1,115 @@
+package scanning
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// keyServer lists public keys in the GitHub format and counts fetches
+type keyServer struct {
+	*httptest.Server
+	fetches int64
+}
+
+func newKeyServer(t *testing.T, keys map[string]*ecdsa.PrivateKey) *keyServer {
+	var list struct {
+		PublicKeys []map[string]interface{} `json:"public_keys"`
+	}
+	for id, key := range keys {
+		der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		list.PublicKeys = append(list.PublicKeys, map[string]interface{}{
+			"key_identifier": id,
+			"key":            string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+			"is_current":     true,
+		})
+	}
+
+	s := &keyServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&s.fetches, 1)
+		json.NewEncoder(w).Encode(list)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func sign(t *testing.T, key *ecdsa.PrivateKey, body []byte) string {
+	digest := sha256.Sum256(body)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+func TestVerifyAlertSignatures(t *testing.T) {
+	key := newKey(t)
+	server := newKeyServer(t, map[string]*ecdsa.PrivateKey{"k1": key})
+	verifier := NewVerifier(server.URL)
+	ctx := context.Background()
+
+	body := []byte(`[{"token":"sk_0123","type":"api_direct_key","url":"https://github.com/a/b","source":"content"}]`)
+	signature := sign(t, key, body)
+	if err := verifier.Verify(ctx, "k1", signature, body); err != nil {
+		t.Fatalf("valid signature rejected: %v", err)
+	}
+
+	rejected := []struct {
+		name, keyID, signature string
+		body                   []byte
+	}{
+		{"missing signature", "k1", "", body},
+		{"missing key ID", "", signature, body},
+		{"not base64", "k1", "%%%", body},
+		{"other body", "k1", signature, []byte(`[{"token":"sk_4567"}]`)},
+		{"other key", "k1", sign(t, newKey(t), body), body},
+		{"unknown key ID", "k2", signature, body},
+	}
+	for _, tt := range rejected {
+		if err := verifier.Verify(ctx, tt.keyID, tt.signature, tt.body); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: err = %v, want ErrInvalidSignature", tt.name, err)
+		}
+	}
+
+	// Unknown key IDs don't refetch the keys more than once a minute
+	if server.fetches != 1 {
+		t.Errorf("fetched keys %d times, want once", server.fetches)
+	}
+}
+
+func TestVerifierWithoutKeys(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	key := newKey(t)
+	body := []byte(`[]`)
+	err := NewVerifier(server.URL).Verify(context.Background(), "k1", sign(t, key, body), body)
+	if err == nil || errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("err = %v, want a key fetch error", err)
+	}
+}
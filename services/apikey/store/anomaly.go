@@ -0,0 +1,403 @@
+package store
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Anomaly kinds
+const (
+	AnomalyVolumeSpike      = "volume_spike"
+	AnomalyNewASN           = "new_asn"
+	AnomalyNewNetwork       = "new_network"
+	AnomalyImpossibleTravel = "impossible_travel"
+	AnomalyLeaked           = "leaked"
+)
+
+// KeyObservation is the activity of an API key from one source network, as
+// reported by the gateway
+type KeyObservation struct {
+	APIKeyID string `json:"api_key_id" binding:"required"`
+	// Network is the client's /24 (IPv4) or /48 (IPv6) network
+	Network   string    `json:"network" binding:"required"`
+	ASN       int       `json:"asn"`
+	Country   string    `json:"country"`
+	Latitude  *float64  `json:"latitude"`
+	Longitude *float64  `json:"longitude"`
+	Calls     int64     `json:"calls" binding:"min=1"`
+	FirstSeen time.Time `json:"first_seen" binding:"required"`
+	LastSeen  time.Time `json:"last_seen" binding:"required"`
+}
+
+// IPRange is the wider range of the observation's network checked for new
+// sources when there is no ASN: /16 for IPv4 and /32 for IPv6. It is empty
+// for malformed networks.
+func (o *KeyObservation) IPRange() string {
+	ip, _, err := net.ParseCIDR(o.Network)
+	if err != nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(16, 32)), Mask: net.CIDRMask(16, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(32, 128)), Mask: net.CIDRMask(32, 128)}).String()
+}
+
+// Anomaly is suspicious activity found on a key
+type Anomaly struct {
+	Kind       string    `json:"kind"`
+	Details    string    `json:"details"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+// KeyProfile is what anomaly detection knows about an active live key
+type KeyProfile struct {
+	ID          string
+	KeyPrefix   string
+	ConsumerID  string
+	Email       string
+	Name        string
+	Quarantined bool
+	ConfirmedAt *time.Time
+	// FirstActivity is when the key was first reported used; nil if never
+	FirstActivity *time.Time
+	// LastLatitude and LastLongitude are where the key was last used from
+	LastLatitude  *float64
+	LastLongitude *float64
+	LastLocatedAt *time.Time
+	// BaselineHourlyCalls is the average calls per hour over the week
+	// before the current hour
+	BaselineHourlyCalls float64
+	CurrentHourCalls    int64
+}
+
+// KeyAlert tells a consumer that one of their keys was quarantined or revoked
+type KeyAlert struct {
+	ID         string    `json:"id"`
+	KeyPrefix  string    `json:"key_prefix"`
+	ConsumerID string    `json:"consumer_id"`
+	Email      string    `json:"email"`
+	Name       string    `json:"name"`
+	Reason     string    `json:"reason"`
+	Anomalies  []Anomaly `json:"anomalies"`
+}
+
+// GetKeyProfile returns the detection profile of an active key as of now
+func (s *PostgresStore) GetKeyProfile(keyID string, now time.Time) (*KeyProfile, error) {
+	hour := now.UTC().Truncate(time.Hour)
+
+	var profile KeyProfile
+	var quarantinedAt sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT
+			ak.id,
+			ak.key_prefix,
+			ak.consumer_id,
+			c.email,
+			ak.name,
+			ak.quarantined_at,
+			ak.confirmed_at,
+			ak.last_latitude,
+			ak.last_longitude,
+			ak.last_located_at,
+			(SELECT MIN(first_seen) FROM api_key_sources WHERE api_key_id = ak.id),
+			(SELECT COALESCE(SUM(calls), 0) / 168.0 FROM api_key_hourly_calls
+				WHERE api_key_id = ak.id AND hour >= $2::timestamp - INTERVAL '7 days' AND hour < $2),
+			(SELECT COALESCE(SUM(calls), 0) FROM api_key_hourly_calls
+				WHERE api_key_id = ak.id AND hour = $2)
+		FROM api_keys ak
+		JOIN consumers c ON c.id = ak.consumer_id
+		WHERE ak.id = $1 AND ak.is_active = true
+	`, keyID, hour).Scan(
+		&profile.ID,
+		&profile.KeyPrefix,
+		&profile.ConsumerID,
+		&profile.Email,
+		&profile.Name,
+		&quarantinedAt,
+		&profile.ConfirmedAt,
+		&profile.LastLatitude,
+		&profile.LastLongitude,
+		&profile.LastLocatedAt,
+		&profile.FirstActivity,
+		&profile.BaselineHourlyCalls,
+		&profile.CurrentHourCalls,
+	)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("API key not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get key profile: %w", err)
+	}
+	profile.Quarantined = quarantinedAt.Valid
+
+	return &profile, nil
+}
+
+// KnownSources reports which of the ASNs and IP ranges a key has been used
+// from before
+func (s *PostgresStore) KnownSources(keyID string, asns []int, ranges []string) (map[int]bool, map[string]bool, error) {
+	knownASNs := make(map[int]bool)
+	rows, err := s.db.Query(
+		"SELECT DISTINCT asn FROM api_key_sources WHERE api_key_id = $1 AND asn = ANY($2)",
+		keyID,
+		pq.Array(asns),
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get known ASNs: %w", err)
+	}
+	for rows.Next() {
+		var asn int
+		if err := rows.Scan(&asn); err != nil {
+			rows.Close()
+			return nil, nil, fmt.Errorf("failed to scan ASN: %w", err)
+		}
+		knownASNs[asn] = true
+	}
+	rows.Close()
+
+	knownRanges := make(map[string]bool)
+	rows, err = s.db.Query(
+		"SELECT DISTINCT ip_range::text FROM api_key_sources WHERE api_key_id = $1 AND ip_range = ANY($2::cidr[])",
+		keyID,
+		pq.Array(ranges),
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get known IP ranges: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ipRange string
+		if err := rows.Scan(&ipRange); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan IP range: %w", err)
+		}
+		knownRanges[ipRange] = true
+	}
+
+	return knownASNs, knownRanges, rows.Err()
+}
+
+// RecordKeyActivity adds a key's observations to its sources and hourly
+// calls, and moves its last location to the latest located observation
+func (s *PostgresStore) RecordKeyActivity(keyID string, observations []*KeyObservation) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, obs := range observations {
+		ipRange := obs.IPRange()
+		if ipRange == "" {
+			continue
+		}
+		_, err := tx.Exec(`
+			INSERT INTO api_key_sources (api_key_id, network, ip_range, asn, country, calls, first_seen, last_seen)
+			VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8)
+			ON CONFLICT (api_key_id, network) DO UPDATE
+			SET asn = EXCLUDED.asn,
+				country = COALESCE(EXCLUDED.country, api_key_sources.country),
+				calls = api_key_sources.calls + EXCLUDED.calls,
+				last_seen = GREATEST(api_key_sources.last_seen, EXCLUDED.last_seen)
+		`, keyID, obs.Network, ipRange, obs.ASN, obs.Country, obs.Calls, obs.FirstSeen, obs.LastSeen)
+		if err != nil {
+			return fmt.Errorf("failed to record key source: %w", err)
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO api_key_hourly_calls (api_key_id, hour, calls)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (api_key_id, hour) DO UPDATE
+			SET calls = api_key_hourly_calls.calls + EXCLUDED.calls
+		`, keyID, obs.LastSeen.UTC().Truncate(time.Hour), obs.Calls)
+		if err != nil {
+			return fmt.Errorf("failed to record key calls: %w", err)
+		}
+
+		if obs.Latitude != nil && obs.Longitude != nil {
+			_, err = tx.Exec(`
+				UPDATE api_keys
+				SET last_latitude = $2, last_longitude = $3, last_located_at = $4
+				WHERE id = $1 AND (last_located_at IS NULL OR last_located_at < $4)
+			`, keyID, *obs.Latitude, *obs.Longitude, obs.LastSeen)
+			if err != nil {
+				return fmt.Errorf("failed to record key location: %w", err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit key activity: %w", err)
+	}
+	return nil
+}
+
+// QuarantineAPIKey quarantines a key and records why. Keys already in
+// quarantine are left as they are.
+func (s *PostgresStore) QuarantineAPIKey(keyID string, anomalies []Anomaly) error {
+	if len(anomalies) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var organizationID, consumerID string
+	err = tx.QueryRow(`
+		UPDATE api_keys
+		SET quarantined_at = $2, quarantine_reason = $3
+		WHERE id = $1 AND quarantined_at IS NULL
+		RETURNING organization_id, consumer_id
+	`, keyID, anomalies[0].DetectedAt, anomalies[0].Kind).Scan(&organizationID, &consumerID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to quarantine API key: %w", err)
+	}
+	if err := insertAnomalies(tx, keyID, anomalies); err != nil {
+		return err
+	}
+	err = appendAudit(tx, SystemActor, AuditKeyQuarantined, keyID, organizationID, consumerID, map[string]interface{}{
+		"anomalies": anomalies,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit quarantine: %w", err)
+	}
+	return nil
+}
+
+// ConfirmAPIKey lifts the quarantine of an organization's key after a
+// member confirmed the activity was theirs
+func (s *PostgresStore) ConfirmAPIKey(keyID, organizationID, consumerID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var reason sql.NullString
+	err = tx.QueryRow(`
+		UPDATE api_keys ak
+		SET quarantined_at = NULL, quarantine_reason = NULL, confirmed_at = $3
+		FROM (SELECT id, quarantine_reason FROM api_keys WHERE id = $1 FOR UPDATE) prev
+		WHERE ak.id = prev.id AND ak.organization_id = $2 AND ak.is_active = true AND ak.quarantined_at IS NOT NULL
+		RETURNING prev.quarantine_reason
+	`, keyID, organizationID, time.Now().UTC()).Scan(&reason)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("API key not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to confirm API key: %w", err)
+	}
+
+	err = appendAudit(tx, consumerActor(consumerID), AuditKeyConfirmed, keyID, organizationID, consumerID, map[string]interface{}{
+		"quarantine_reason": reason.String,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit confirmation: %w", err)
+	}
+	return nil
+}
+
+// RevokeLeakedKey revokes the key with the given secret after it was found
+// published somewhere. It returns nil if the secret is not one of our keys;
+// keys revoked earlier are returned without being changed again.
+func (s *PostgresStore) RevokeLeakedKey(secret, details string) (*KeyAlert, error) {
+	hash := sha256.Sum256([]byte(secret))
+	keyHash := hex.EncodeToString(hash[:])
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var alert KeyAlert
+	var organizationID string
+	var isActive bool
+	err = tx.QueryRow(`
+		SELECT ak.id, ak.key_prefix, ak.organization_id, ak.consumer_id, c.email, ak.name, ak.is_active
+		FROM api_keys ak
+		JOIN consumers c ON c.id = ak.consumer_id
+		WHERE ak.key_hash = $1
+		FOR UPDATE OF ak
+	`, keyHash).Scan(
+		&alert.ID,
+		&alert.KeyPrefix,
+		&organizationID,
+		&alert.ConsumerID,
+		&alert.Email,
+		&alert.Name,
+		&isActive,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find leaked key: %w", err)
+	}
+	if !isActive {
+		return &alert, nil
+	}
+
+	anomaly := Anomaly{Kind: AnomalyLeaked, Details: details, DetectedAt: time.Now().UTC()}
+	_, err = tx.Exec(`
+		UPDATE api_keys
+		SET is_active = false, quarantine_reason = $2
+		WHERE id = $1
+	`, alert.ID, AnomalyLeaked)
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke leaked key: %w", err)
+	}
+	if err := insertAnomalies(tx, alert.ID, []Anomaly{anomaly}); err != nil {
+		return nil, err
+	}
+	err = appendAudit(tx, ScannerActor, AuditKeyLeaked, alert.ID, organizationID, alert.ConsumerID, map[string]interface{}{
+		"details": details,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit revocation: %w", err)
+	}
+
+	alert.Reason = AnomalyLeaked
+	alert.Anomalies = []Anomaly{anomaly}
+	return &alert, nil
+}
+
+func insertAnomalies(tx *sql.Tx, keyID string, anomalies []Anomaly) error {
+	for _, anomaly := range anomalies {
+		_, err := tx.Exec(
+			"INSERT INTO api_key_anomalies (api_key_id, kind, details, detected_at) VALUES ($1, $2, $3, $4)",
+			keyID,
+			anomaly.Kind,
+			anomaly.Details,
+			anomaly.DetectedAt,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to record anomaly: %w", err)
+		}
+	}
+	return nil
+}
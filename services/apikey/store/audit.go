@@ -0,0 +1,263 @@
+package store
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Audit actions recorded by this service. Subscription events are recorded
+// by the database.
+const (
+	AuditKeyCreated       = "api_key.created"
+	AuditKeyRenamed       = "api_key.renamed"
+	AuditKeyScopesChanged = "api_key.scopes_changed"
+	AuditKeyRotated       = "api_key.rotated"
+	AuditKeyRevoked       = "api_key.revoked"
+	AuditKeyExpired       = "api_key.expired"
+	AuditKeyQuarantined   = "api_key.quarantined"
+	AuditKeyConfirmed     = "api_key.confirmed"
+	AuditKeyLeaked        = "api_key.leaked"
+	AuditKeyUsed          = "api_key.used"
+
+	AuditOrgCreated        = "organization.created"
+	AuditOrgUpdated        = "organization.updated"
+	AuditMemberAdded       = "member.added"
+	AuditMemberRoleChanged = "member.role_changed"
+	AuditMemberRemoved     = "member.removed"
+)
+
+// Actor is who caused an audit event
+type Actor struct {
+	// Type is consumer, admin, system or scanner
+	Type string
+	ID   string
+}
+
+// Actors of events this service causes by itself
+var (
+	SystemActor  = Actor{Type: "system", ID: "apikey"}
+	GatewayActor = Actor{Type: "system", ID: "gateway"}
+	ScannerActor = Actor{Type: "scanner", ID: "secret-scanning"}
+)
+
+// consumerActor is the consumer acting on their own keys
+func consumerActor(consumerID string) Actor {
+	return Actor{Type: "consumer", ID: consumerID}
+}
+
+// AuditEvent is an entry in the audit log
+type AuditEvent struct {
+	Seq          int64     `json:"seq"`
+	OccurredAt   time.Time `json:"occurred_at"`
+	ActorType    string    `json:"actor_type"`
+	ActorID      string    `json:"actor_id"`
+	Action       string    `json:"action"`
+	ResourceType string    `json:"resource_type"`
+	ResourceID   string    `json:"resource_id"`
+	ConsumerID   string    `json:"consumer_id,omitempty"`
+	// OrganizationID is empty for events from before organizations
+	OrganizationID string          `json:"organization_id,omitempty"`
+	Details        json.RawMessage `json:"details"`
+	PrevHash       string          `json:"prev_hash"`
+	Hash           string          `json:"hash"`
+}
+
+// AuditFilter selects audit events. Empty fields match everything.
+type AuditFilter struct {
+	OrganizationID string
+	// PersonalConsumerID also selects the consumer's events from before
+	// organizations, for their personal organization
+	PersonalConsumerID string
+	ConsumerID         string
+	ResourceID         string
+	Action             string
+	Since              *time.Time
+	Until              *time.Time
+	// AfterSeq pages through the log: only events after it are returned
+	AfterSeq int64
+	Limit    int
+}
+
+// AuditVerification is the result of checking the audit log's hash chain
+type AuditVerification struct {
+	Valid  bool  `json:"valid"`
+	Events int64 `json:"events"`
+	// Head is the hash of the last event, which can be recorded elsewhere
+	// to detect the log being rewritten from the start
+	Head string `json:"head"`
+	// BrokenAt is the first event whose hash doesn't match
+	BrokenAt *int64 `json:"broken_at,omitempty"`
+}
+
+// execer runs a statement on a database or inside a transaction
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// appendAudit adds an event to the audit log of an organization. The
+// database chains it and sets its sequence number and time.
+func appendAudit(db execer, actor Actor, action, resourceID, organizationID, consumerID string, details interface{}) error {
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+	data, err := json.Marshal(details)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit details: %w", err)
+	}
+
+	resourceType, _, _ := strings.Cut(action, ".")
+	_, err = db.Exec(`
+		INSERT INTO audit_log (actor_type, actor_id, action, resource_type, resource_id, consumer_id, organization_id, details)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, '')::uuid, NULLIF($7, '')::uuid, $8::jsonb)
+	`, actor.Type, actor.ID, action, resourceType, resourceID, consumerID, organizationID, string(data))
+	if err != nil {
+		return fmt.Errorf("failed to append audit event: %w", err)
+	}
+	return nil
+}
+
+// auditColumns selects an event with its details as the canonical JSONB
+// text the chain is computed over
+const auditColumns = `seq, occurred_at, actor_type, actor_id, action, resource_type, resource_id,
+	COALESCE(consumer_id::text, ''), COALESCE(organization_id::text, ''), details::text, prev_hash, hash`
+
+func scanAuditEvent(rows *sql.Rows) (*AuditEvent, error) {
+	var event AuditEvent
+	var details string
+	err := rows.Scan(
+		&event.Seq,
+		&event.OccurredAt,
+		&event.ActorType,
+		&event.ActorID,
+		&event.Action,
+		&event.ResourceType,
+		&event.ResourceID,
+		&event.ConsumerID,
+		&event.OrganizationID,
+		&details,
+		&event.PrevHash,
+		&event.Hash,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan audit event: %w", err)
+	}
+	event.Details = json.RawMessage(details)
+	return &event, nil
+}
+
+// ListAuditEvents lists audit events in order
+func (s *PostgresStore) ListAuditEvents(filter AuditFilter) ([]*AuditEvent, error) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	add("seq > $%d", filter.AfterSeq)
+	if filter.OrganizationID != "" {
+		if filter.PersonalConsumerID != "" {
+			args = append(args, filter.OrganizationID, filter.PersonalConsumerID)
+			conditions = append(conditions, fmt.Sprintf(
+				"(organization_id = $%d OR (organization_id IS NULL AND consumer_id = $%d))", len(args)-1, len(args)))
+		} else {
+			add("organization_id = $%d", filter.OrganizationID)
+		}
+	}
+	if filter.ConsumerID != "" {
+		add("consumer_id = $%d", filter.ConsumerID)
+	}
+	if filter.ResourceID != "" {
+		add("resource_id = $%d", filter.ResourceID)
+	}
+	if filter.Action != "" {
+		add("action = $%d", filter.Action)
+	}
+	if filter.Since != nil {
+		add("occurred_at >= $%d", filter.Since.UTC())
+	}
+	if filter.Until != nil {
+		add("occurred_at < $%d", filter.Until.UTC())
+	}
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+
+	query := "SELECT " + auditColumns + " FROM audit_log WHERE " + strings.Join(conditions, " AND ") +
+		" ORDER BY seq LIMIT " + strconv.Itoa(limit)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []*AuditEvent{}
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+// VerifyAuditLog recomputes the audit log's hash chain from the start
+func (s *PostgresStore) VerifyAuditLog() (*AuditVerification, error) {
+	rows, err := s.db.Query("SELECT " + auditColumns + " FROM audit_log ORDER BY seq")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	defer rows.Close()
+
+	result := &AuditVerification{Valid: true}
+	prevHash := ""
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		result.Events++
+
+		if event.Seq != result.Events || event.PrevHash != prevHash || event.computeHash() != event.Hash {
+			result.Valid = false
+			result.BrokenAt = &event.Seq
+			return result, nil
+		}
+		prevHash = event.Hash
+		result.Head = event.Hash
+	}
+
+	return result, rows.Err()
+}
+
+// computeHash hashes the event as the database's audit_log_canonical does
+func (e *AuditEvent) computeHash() string {
+	fields := []string{
+		e.PrevHash,
+		strconv.FormatInt(e.Seq, 10),
+		e.OccurredAt.UTC().Format("2006-01-02T15:04:05.000000Z"),
+		e.ActorType,
+		e.ActorID,
+		e.Action,
+		e.ResourceType,
+		e.ResourceID,
+		e.ConsumerID,
+		string(e.Details),
+	}
+	// Events from before organizations have none and no field for it
+	if e.OrganizationID != "" {
+		fields = append(fields, e.OrganizationID)
+	}
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\x1f")))
+	return hex.EncodeToString(sum[:])
+}
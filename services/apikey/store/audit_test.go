@@ -0,0 +1,154 @@
+package store
+
+import (
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestComputeHashMatchesCanonicalForm(t *testing.T) {
+	event := &AuditEvent{
+		Seq:          7,
+		OccurredAt:   time.Date(2024, 3, 1, 13, 4, 5, 123456789, time.FixedZone("CET", 3600)),
+		ActorType:    "consumer",
+		ActorID:      "consumer-1",
+		Action:       AuditKeyRotated,
+		ResourceType: "api_key",
+		ResourceID:   "key-1",
+		ConsumerID:   "consumer-1",
+		Details:      json.RawMessage(`{"replaced_by": "key-2"}`),
+		PrevHash:     "abc",
+	}
+
+	// audit_log_canonical joins the fields with 0x1f, with times in UTC to
+	// the microsecond, and leaves out a missing organization
+	canonical := "abc\x1f7\x1f2024-03-01T12:04:05.123456Z\x1fconsumer\x1fconsumer-1\x1fapi_key.rotated\x1fapi_key\x1fkey-1\x1fconsumer-1\x1f" + `{"replaced_by": "key-2"}`
+	if got, want := event.computeHash(), sha256Hex(canonical); got != want {
+		t.Errorf("hash without organization = %s, want %s", got, want)
+	}
+
+	event.OrganizationID = "org-1"
+	if got, want := event.computeHash(), sha256Hex(canonical+"\x1forg-1"); got != want {
+		t.Errorf("hash with organization = %s, want %s", got, want)
+	}
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// auditChain returns n chained events as the database writes them
+func auditChain(n int) []*AuditEvent {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	events := make([]*AuditEvent, n)
+	prevHash := ""
+	for i := range events {
+		event := &AuditEvent{
+			Seq:            int64(i + 1),
+			OccurredAt:     start.Add(time.Duration(i) * time.Minute),
+			ActorType:      "consumer",
+			ActorID:        "consumer-1",
+			Action:         AuditKeyCreated,
+			ResourceType:   "api_key",
+			ResourceID:     "key-" + string(rune('a'+i)),
+			ConsumerID:     "consumer-1",
+			OrganizationID: "org-1",
+			Details:        json.RawMessage(`{"name": "production"}`),
+			PrevHash:       prevHash,
+		}
+		event.Hash = event.computeHash()
+		prevHash = event.Hash
+		events[i] = event
+	}
+	return events
+}
+
+func auditRows(events []*AuditEvent) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{
+		"seq", "occurred_at", "actor_type", "actor_id", "action", "resource_type", "resource_id",
+		"consumer_id", "organization_id", "details", "prev_hash", "hash",
+	})
+	for _, e := range events {
+		rows.AddRow([]driver.Value{
+			e.Seq, e.OccurredAt, e.ActorType, e.ActorID, e.Action, e.ResourceType, e.ResourceID,
+			e.ConsumerID, e.OrganizationID, string(e.Details), e.PrevHash, e.Hash,
+		}...)
+	}
+	return rows
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	edited := auditChain(3)
+	edited[1].Details = json.RawMessage(`{"name": "staging"}`)
+
+	deleted := auditChain(3)
+	deleted = append(deleted[:1], deleted[2])
+
+	// Rewriting the rest of the chain after a deleted event still leaves a
+	// gap in the sequence
+	rechained := auditChain(3)
+	rechained = append(rechained[:1], rechained[2])
+	rechained[1].PrevHash = rechained[0].Hash
+	rechained[1].Hash = rechained[1].computeHash()
+
+	tests := []struct {
+		name     string
+		events   []*AuditEvent
+		brokenAt int64
+	}{
+		{"intact", auditChain(3), 0},
+		{"empty", nil, 0},
+		{"edited event", edited, 2},
+		// The first event after a gap is reported
+		{"deleted event", deleted, 3},
+		{"deleted and rechained", rechained, 3},
+	}
+	for _, tt := range tests {
+		s, mock := newMockStore(t)
+		mock.ExpectQuery("FROM audit_log ORDER BY seq").WillReturnRows(auditRows(tt.events))
+
+		result, err := s.VerifyAuditLog()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if tt.brokenAt == 0 {
+			if !result.Valid || result.BrokenAt != nil || result.Events != int64(len(tt.events)) {
+				t.Errorf("%s: result = %+v, want valid", tt.name, result)
+			}
+			if len(tt.events) > 0 && result.Head != tt.events[len(tt.events)-1].Hash {
+				t.Errorf("%s: head = %s", tt.name, result.Head)
+			}
+			continue
+		}
+		if result.Valid || result.BrokenAt == nil || *result.BrokenAt != tt.brokenAt {
+			t.Errorf("%s: result = %+v, want broken at %d", tt.name, result, tt.brokenAt)
+		}
+	}
+}
+
+func TestVerifyAuditLogHeadDetectsTruncation(t *testing.T) {
+	// Dropping the latest events leaves a valid chain, which only the
+	// recorded head shows to be shorter
+	events := auditChain(3)
+	s, mock := newMockStore(t)
+	mock.ExpectQuery("FROM audit_log ORDER BY seq").WillReturnRows(auditRows(events))
+	mock.ExpectQuery("FROM audit_log ORDER BY seq").WillReturnRows(auditRows(events[:2]))
+
+	full, err := s.VerifyAuditLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncated, err := s.VerifyAuditLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !truncated.Valid || truncated.Head == full.Head {
+		t.Errorf("truncated = %+v, full head %s", truncated, full.Head)
+	}
+}
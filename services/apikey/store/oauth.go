@@ -0,0 +1,221 @@
+package store
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidClient is returned when client credentials don't match an
+// active client with an active subscription
+var ErrInvalidClient = errors.New("invalid client credentials")
+
+// OAuthClient is a client that exchanges its credentials for access tokens
+// scoped to one subscription
+type OAuthClient struct {
+	ID             string     `json:"id"`
+	ClientID       string     `json:"client_id"`
+	OrganizationID string     `json:"organization_id"`
+	ConsumerID     string     `json:"consumer_id"`
+	SubscriptionID string     `json:"subscription_id"`
+	Name           string     `json:"name"`
+	IsActive       bool       `json:"is_active"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastUsedAt     *time.Time `json:"last_used_at,omitempty"`
+}
+
+// ClientGrant is what an authenticated client's tokens carry
+type ClientGrant struct {
+	ClientID string
+	// API is "<creator>/<apiName>" of the subscribed API
+	API        string
+	Validation *APIKeyValidation
+}
+
+// CreateOAuthClient creates a client for one of an organization's
+// subscriptions on behalf of a member. The secret is returned only here.
+func (s *PostgresStore) CreateOAuthClient(organizationID, consumerID, subscriptionID, name string) (string, *OAuthClient, error) {
+	idBytes := make([]byte, 16)
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", nil, fmt.Errorf("failed to generate client ID: %w", err)
+	}
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", nil, fmt.Errorf("failed to generate client secret: %w", err)
+	}
+	secret := "cs_" + hex.EncodeToString(secretBytes)
+
+	client := &OAuthClient{
+		ID:             uuid.New().String(),
+		ClientID:       "cid_" + hex.EncodeToString(idBytes),
+		OrganizationID: organizationID,
+		ConsumerID:     consumerID,
+		SubscriptionID: subscriptionID,
+		Name:           name,
+		IsActive:       true,
+	}
+
+	// The subscription must belong to the organization
+	query := `
+		INSERT INTO oauth_clients (id, client_id, secret_hash, consumer_id, subscription_id, name, is_active, created_at, organization_id)
+		SELECT $1, $2, $3, $4, s.id, $6, true, $7, s.organization_id
+		FROM subscriptions s
+		WHERE s.id = $5 AND s.organization_id = $8
+		RETURNING created_at
+	`
+
+	err := s.db.QueryRow(
+		query,
+		client.ID,
+		client.ClientID,
+		hashSecret(secret),
+		client.ConsumerID,
+		client.SubscriptionID,
+		client.Name,
+		time.Now().UTC(),
+		client.OrganizationID,
+	).Scan(&client.CreatedAt)
+
+	if err == sql.ErrNoRows {
+		return "", nil, fmt.Errorf("subscription not found")
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to insert OAuth client: %w", err)
+	}
+
+	return secret, client, nil
+}
+
+// AuthenticateClient checks client credentials and returns the grant its
+// tokens carry
+func (s *PostgresStore) AuthenticateClient(clientID, secret string) (*ClientGrant, error) {
+	query := `
+		SELECT
+			oc.secret_hash,
+			u.username,
+			a.name,
+			oc.id,
+			s.api_key_id,` + validationColumns + `
+		FROM oauth_clients oc
+		JOIN subscriptions s ON s.id = oc.subscription_id
+		JOIN apis a ON a.id = s.api_id
+		JOIN users u ON u.id = a.user_id
+		JOIN api_pricing_plans pp ON pp.id = s.pricing_plan_id
+		WHERE oc.client_id = $1
+			AND oc.is_active = true
+			AND s.status = 'active'
+			AND a.is_published = true
+	`
+
+	// Usage, rate limits and key activity are tracked against the
+	// subscription's API key, as if the client had called with it; the
+	// client itself is named by the token's client_id claim
+	var secretHash, creator, apiName, id string
+	validation, err := scanValidation(s.db.QueryRow(query, clientID), &secretHash, &creator, &apiName, &id)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidClient
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to authenticate client: %w", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(secretHash), []byte(hashSecret(secret))) != 1 {
+		return nil, ErrInvalidClient
+	}
+
+	go s.updateClientLastUsed(id)
+
+	return &ClientGrant{
+		ClientID:   clientID,
+		API:        strings.ToLower(creator + "/" + apiName),
+		Validation: validation,
+	}, nil
+}
+
+// ListOAuthClients lists all OAuth clients of an organization
+func (s *PostgresStore) ListOAuthClients(organizationID string) ([]*OAuthClient, error) {
+	query := `
+		SELECT id, client_id, organization_id, consumer_id, subscription_id, name, is_active, created_at, last_used_at
+		FROM oauth_clients
+		WHERE organization_id = $1
+		ORDER BY created_at DESC
+	`
+
+	rows, err := s.db.Query(query, organizationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list OAuth clients: %w", err)
+	}
+	defer rows.Close()
+
+	var clients []*OAuthClient
+	for rows.Next() {
+		var client OAuthClient
+		err := rows.Scan(
+			&client.ID,
+			&client.ClientID,
+			&client.OrganizationID,
+			&client.ConsumerID,
+			&client.SubscriptionID,
+			&client.Name,
+			&client.IsActive,
+			&client.CreatedAt,
+			&client.LastUsedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan OAuth client: %w", err)
+		}
+		clients = append(clients, &client)
+	}
+
+	return clients, nil
+}
+
+// RevokeOAuthClient stops a client from getting new tokens. Tokens already
+// issued stay valid until they expire.
+func (s *PostgresStore) RevokeOAuthClient(id, organizationID string) error {
+	query := `
+		UPDATE oauth_clients
+		SET is_active = false
+		WHERE id = $1 AND organization_id = $2
+	`
+
+	result, err := s.db.Exec(query, id, organizationID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke OAuth client: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("OAuth client not found")
+	}
+
+	return nil
+}
+
+// updateClientLastUsed updates the last used timestamp for an OAuth client
+func (s *PostgresStore) updateClientLastUsed(id string) {
+	query := `
+		UPDATE oauth_clients
+		SET last_used_at = $2
+		WHERE id = $1
+	`
+
+	_, _ = s.db.Exec(query, id, time.Now().UTC())
+}
+
+// hashSecret returns the stored form of a client secret
+func hashSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,479 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Organization roles
+const (
+	RoleOwner     = "owner"
+	RoleAdmin     = "admin"
+	RoleDeveloper = "developer"
+	RoleBilling   = "billing"
+)
+
+// Permissions granted by roles
+const (
+	PermissionKeysRead           = "keys:read"
+	PermissionKeysWrite          = "keys:write"
+	PermissionSubscriptionsRead  = "subscriptions:read"
+	PermissionSubscriptionsWrite = "subscriptions:write"
+	PermissionAuditRead          = "audit:read"
+	PermissionMembersWrite       = "members:write"
+	PermissionOrganizationWrite  = "organization:write"
+)
+
+var rolePermissions = map[string][]string{
+	RoleOwner: {
+		PermissionKeysRead, PermissionKeysWrite,
+		PermissionSubscriptionsRead, PermissionSubscriptionsWrite,
+		PermissionAuditRead, PermissionMembersWrite, PermissionOrganizationWrite,
+	},
+	RoleAdmin: {
+		PermissionKeysRead, PermissionKeysWrite,
+		PermissionSubscriptionsRead, PermissionSubscriptionsWrite,
+		PermissionAuditRead, PermissionMembersWrite,
+	},
+	RoleDeveloper: {
+		PermissionKeysRead, PermissionKeysWrite,
+		PermissionSubscriptionsRead, PermissionAuditRead,
+	},
+	RoleBilling: {
+		PermissionKeysRead,
+		PermissionSubscriptionsRead, PermissionSubscriptionsWrite,
+		PermissionAuditRead,
+	},
+}
+
+// ValidRole reports whether role is an organization role
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// RoleCan reports whether a role grants a permission
+func RoleCan(role, permission string) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Organization membership errors
+var (
+	ErrNotMember      = errors.New("not a member of the organization")
+	ErrLastOwner      = errors.New("organization must keep an owner")
+	ErrBillingMember  = errors.New("member pays for the organization")
+	ErrPersonalOrg    = errors.New("personal organizations have no other members")
+	ErrAlreadyMember  = errors.New("already a member of the organization")
+	ErrConsumerAbsent = errors.New("no consumer with that email")
+)
+
+// Organization owns API keys, OAuth clients and subscriptions on behalf of
+// its members
+type Organization struct {
+	ID                string    `json:"id"`
+	Name              string    `json:"name"`
+	Personal          bool      `json:"personal"`
+	BillingConsumerID string    `json:"billing_consumer_id"`
+	CreatedBy         string    `json:"created_by"`
+	CreatedAt         time.Time `json:"created_at"`
+	// Role is the requesting consumer's role, when listed for them
+	Role string `json:"role,omitempty"`
+}
+
+// Membership is a consumer acting in an organization
+type Membership struct {
+	Organization *Organization
+	ConsumerID   string
+	Role         string
+}
+
+// Can reports whether the member's role grants a permission
+func (m *Membership) Can(permission string) bool {
+	return RoleCan(m.Role, permission)
+}
+
+// Member is a consumer in an organization
+type Member struct {
+	ConsumerID string    `json:"consumer_id"`
+	Email      string    `json:"email"`
+	Role       string    `json:"role"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+const organizationColumns = "o.id, o.name, o.personal, o.billing_consumer_id, o.created_by, o.created_at"
+
+func scanOrganization(row interface{ Scan(...interface{}) error }, extra ...interface{}) (*Organization, error) {
+	var org Organization
+	dest := append([]interface{}{
+		&org.ID,
+		&org.Name,
+		&org.Personal,
+		&org.BillingConsumerID,
+		&org.CreatedBy,
+		&org.CreatedAt,
+	}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// GetMembership returns a consumer's membership of an organization. An
+// empty organization ID means the consumer's personal organization.
+func (s *PostgresStore) GetMembership(organizationID, consumerID string) (*Membership, error) {
+	query := `
+		SELECT ` + organizationColumns + `, m.role
+		FROM organizations o
+		JOIN organization_members m ON m.organization_id = o.id
+		WHERE m.consumer_id = $2
+			AND (o.id::text = $1 OR ($1 = '' AND o.personal AND o.created_by = $2))
+	`
+
+	var role string
+	org, err := scanOrganization(s.db.QueryRow(query, organizationID, consumerID), &role)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotMember
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get membership: %w", err)
+	}
+	org.Role = role
+
+	return &Membership{Organization: org, ConsumerID: consumerID, Role: role}, nil
+}
+
+// ListOrganizations lists the organizations a consumer is a member of,
+// with their role in each
+func (s *PostgresStore) ListOrganizations(consumerID string) ([]*Organization, error) {
+	rows, err := s.db.Query(`
+		SELECT `+organizationColumns+`, m.role
+		FROM organizations o
+		JOIN organization_members m ON m.organization_id = o.id
+		WHERE m.consumer_id = $1
+		ORDER BY o.personal DESC, o.name
+	`, consumerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list organizations: %w", err)
+	}
+	defer rows.Close()
+
+	orgs := []*Organization{}
+	for rows.Next() {
+		var role string
+		org, err := scanOrganization(rows, &role)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan organization: %w", err)
+		}
+		org.Role = role
+		orgs = append(orgs, org)
+	}
+
+	return orgs, rows.Err()
+}
+
+// CreateOrganization creates a team organization with the consumer as its
+// owner and billing member
+func (s *PostgresStore) CreateOrganization(consumerID, name string) (*Organization, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	org, err := scanOrganization(tx.QueryRow(`
+		INSERT INTO organizations (name, personal, billing_consumer_id, created_by)
+		VALUES ($1, false, $2, $2)
+		RETURNING id, name, personal, billing_consumer_id, created_by, created_at
+	`, name, consumerID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create organization: %w", err)
+	}
+	org.Role = RoleOwner
+
+	_, err = tx.Exec(`
+		INSERT INTO organization_members (organization_id, consumer_id, role, added_by)
+		VALUES ($1, $2, $3, $2)
+	`, org.ID, consumerID, RoleOwner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add owner: %w", err)
+	}
+
+	err = appendAudit(tx, consumerActor(consumerID), AuditOrgCreated, org.ID, org.ID, consumerID, map[string]interface{}{
+		"name": name,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit organization: %w", err)
+	}
+	return org, nil
+}
+
+// UpdateOrganization renames an organization or changes the member who
+// pays for it. Empty values are left unchanged.
+func (s *PostgresStore) UpdateOrganization(organizationID, actorID, name, billingConsumerID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if billingConsumerID != "" {
+		// Only members who can manage subscriptions may pay for them
+		var role string
+		err := tx.QueryRow(
+			"SELECT role FROM organization_members WHERE organization_id = $1 AND consumer_id = $2",
+			organizationID,
+			billingConsumerID,
+		).Scan(&role)
+		if err == sql.ErrNoRows || (err == nil && !RoleCan(role, PermissionSubscriptionsWrite)) {
+			return ErrNotMember
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get member: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE organizations
+		SET name = COALESCE(NULLIF($2, ''), name),
+			billing_consumer_id = COALESCE(NULLIF($3, '')::uuid, billing_consumer_id)
+		WHERE id = $1
+	`, organizationID, name, billingConsumerID)
+	if err != nil {
+		return fmt.Errorf("failed to update organization: %w", err)
+	}
+
+	details := map[string]interface{}{}
+	if name != "" {
+		details["name"] = name
+	}
+	if billingConsumerID != "" {
+		details["billing_consumer_id"] = billingConsumerID
+	}
+	if err := appendAudit(tx, consumerActor(actorID), AuditOrgUpdated, organizationID, organizationID, actorID, details); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit organization: %w", err)
+	}
+	return nil
+}
+
+// ListMembers lists an organization's members
+func (s *PostgresStore) ListMembers(organizationID string) ([]*Member, error) {
+	rows, err := s.db.Query(`
+		SELECT m.consumer_id, c.email, m.role, m.created_at
+		FROM organization_members m
+		JOIN consumers c ON c.id = m.consumer_id
+		WHERE m.organization_id = $1
+		ORDER BY m.created_at
+	`, organizationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list members: %w", err)
+	}
+	defer rows.Close()
+
+	members := []*Member{}
+	for rows.Next() {
+		var member Member
+		if err := rows.Scan(&member.ConsumerID, &member.Email, &member.Role, &member.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan member: %w", err)
+		}
+		members = append(members, &member)
+	}
+
+	return members, rows.Err()
+}
+
+// AddMember adds the consumer with an email to a team organization
+func (s *PostgresStore) AddMember(org *Organization, actorID, email, role string) (*Member, error) {
+	if org.Personal {
+		return nil, ErrPersonalOrg
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	member := Member{Role: role}
+	err = tx.QueryRow(
+		"SELECT id, email FROM consumers WHERE lower(email) = lower($1) ORDER BY created_at LIMIT 1",
+		strings.TrimSpace(email),
+	).Scan(&member.ConsumerID, &member.Email)
+	if err == sql.ErrNoRows {
+		return nil, ErrConsumerAbsent
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find consumer: %w", err)
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO organization_members (organization_id, consumer_id, role, added_by)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (organization_id, consumer_id) DO NOTHING
+		RETURNING created_at
+	`, org.ID, member.ConsumerID, role, actorID).Scan(&member.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrAlreadyMember
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to add member: %w", err)
+	}
+
+	err = appendAudit(tx, consumerActor(actorID), AuditMemberAdded, member.ConsumerID, org.ID, member.ConsumerID, map[string]interface{}{
+		"email": member.Email,
+		"role":  role,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit member: %w", err)
+	}
+	return &member, nil
+}
+
+// ChangeMemberRole changes a member's role. Organizations always keep an
+// owner, and their billing member must be able to manage subscriptions.
+func (s *PostgresStore) ChangeMemberRole(org *Organization, actorID, consumerID, role string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	previous, err := lockMember(tx, org.ID, consumerID)
+	if err != nil {
+		return err
+	}
+	if previous == RoleOwner && role != RoleOwner {
+		if err := ensureOtherOwner(tx, org.ID, consumerID); err != nil {
+			return err
+		}
+	}
+	if consumerID == org.BillingConsumerID && !RoleCan(role, PermissionSubscriptionsWrite) {
+		return ErrBillingMember
+	}
+
+	_, err = tx.Exec(
+		"UPDATE organization_members SET role = $3 WHERE organization_id = $1 AND consumer_id = $2",
+		org.ID,
+		consumerID,
+		role,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to change role: %w", err)
+	}
+
+	err = appendAudit(tx, consumerActor(actorID), AuditMemberRoleChanged, consumerID, org.ID, consumerID, map[string]interface{}{
+		"from": previous,
+		"to":   role,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit role: %w", err)
+	}
+	return nil
+}
+
+// RemoveMember removes a member from an organization. What they created
+// stays with the organization, so nothing needs rotating when people leave.
+func (s *PostgresStore) RemoveMember(org *Organization, actorID, consumerID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	role, err := lockMember(tx, org.ID, consumerID)
+	if err != nil {
+		return err
+	}
+	if role == RoleOwner {
+		if err := ensureOtherOwner(tx, org.ID, consumerID); err != nil {
+			return err
+		}
+	}
+	if consumerID == org.BillingConsumerID {
+		return ErrBillingMember
+	}
+
+	_, err = tx.Exec(
+		"DELETE FROM organization_members WHERE organization_id = $1 AND consumer_id = $2",
+		org.ID,
+		consumerID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to remove member: %w", err)
+	}
+
+	err = appendAudit(tx, consumerActor(actorID), AuditMemberRemoved, consumerID, org.ID, consumerID, map[string]interface{}{
+		"role": role,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit removal: %w", err)
+	}
+	return nil
+}
+
+// lockMember locks a member's row and returns their role
+func lockMember(tx *sql.Tx, organizationID, consumerID string) (string, error) {
+	var role string
+	err := tx.QueryRow(
+		"SELECT role FROM organization_members WHERE organization_id = $1 AND consumer_id = $2 FOR UPDATE",
+		organizationID,
+		consumerID,
+	).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", ErrNotMember
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get member: %w", err)
+	}
+	return role, nil
+}
+
+// ensureOtherOwner returns ErrLastOwner unless an organization has an owner
+// besides one consumer. The owners are locked so two owners can't demote
+// each other at once.
+func ensureOtherOwner(tx *sql.Tx, organizationID, consumerID string) error {
+	rows, err := tx.Query(`
+		SELECT consumer_id FROM organization_members
+		WHERE organization_id = $1 AND role = $2 AND consumer_id <> $3
+		FOR UPDATE
+	`, organizationID, RoleOwner, consumerID)
+	if err != nil {
+		return fmt.Errorf("failed to count owners: %w", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to count owners: %w", err)
+		}
+		return ErrLastOwner
+	}
+	return nil
+}
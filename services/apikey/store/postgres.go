@@ -5,7 +5,9 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"log"
 	"strings"
 	"time"
 
@@ -26,11 +28,33 @@ func NewPostgresStore(db *sql.DB) *PostgresStore {
 type APIKey struct {
 	ID         string    `json:"id"`
 	KeyPrefix  string    `json:"key_prefix"`
+	// OrganizationID owns the key; ConsumerID is the member who created it
+	OrganizationID string `json:"organization_id"`
 	ConsumerID string    `json:"consumer_id"`
 	Name       string    `json:"name"`
+	// Mode is live or test
+	Mode       string    `json:"mode"`
 	IsActive   bool      `json:"is_active"`
+	// Scopes is nil for keys without restrictions
+	Scopes     *KeyScopes `json:"scopes,omitempty"`
 	CreatedAt  time.Time `json:"created_at"`
 	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	// RotatedFrom is the key this one replaced. ReplacedBy is the current
+	// key of a rotated key, which stays valid until its expiry.
+	RotatedFrom *string    `json:"rotated_from,omitempty"`
+	ReplacedBy  *string    `json:"replaced_by,omitempty"`
+	RotatedAt   *time.Time `json:"rotated_at,omitempty"`
+	// QuarantinedAt is set while the key is quarantined after suspicious
+	// activity; QuarantineReason also explains why a key was revoked as leaked
+	QuarantinedAt    *time.Time `json:"quarantined_at,omitempty"`
+	QuarantineReason string     `json:"quarantine_reason,omitempty"`
+}
+
+// APIKeyUpdate changes an API key. Nil fields are left unchanged; Scopes
+// replaces the key's scopes, so an empty KeyScopes lifts every restriction.
+type APIKeyUpdate struct {
+	Name   *string
+	Scopes *KeyScopes
 }
 
 // APIKeyValidation contains validation response data
@@ -40,7 +64,26 @@ type APIKeyValidation struct {
 	SubscriptionID string    `json:"subscription_id"`
 	APIKeyID       string    `json:"api_key_id"`
 	APIID          string    `json:"api_id"`
+	// Mode is "test" for sandbox keys, whose calls aren't billed
+	Mode           string    `json:"mode,omitempty"`
 	RateLimits     RateLimits `json:"rate_limits"`
+	// Quota is nil for plans without a call limit
+	Quota *Quota `json:"quota,omitempty"`
+	// Scopes restrict what the key can call; nil for OAuth clients and
+	// unrestricted keys
+	Scopes *KeyScopes `json:"scopes,omitempty"`
+	// Error explains why a known key is not valid
+	Error string `json:"error,omitempty"`
+}
+
+// Quota is the plan's call limit per billing period. Billing periods are
+// whole months from the subscription's start.
+type Quota struct {
+	Limit        int64     `json:"limit"`
+	PeriodAnchor time.Time `json:"period_anchor"`
+	// Policy is what happens at the limit: block, overage or throttle
+	Policy            string `json:"policy"`
+	ThrottlePerMinute int    `json:"throttle_per_minute,omitempty"`
 }
 
 // RateLimits contains rate limit information
@@ -48,60 +91,155 @@ type RateLimits struct {
 	PerMinute int `json:"per_minute"`
 	PerDay    int `json:"per_day"`
 	PerMonth  int `json:"per_month"`
+	// FailurePolicy is how the gateway treats this plan when its rate
+	// limiter is unavailable: open, closed or local. Empty uses the gateway default.
+	FailurePolicy string `json:"failure_policy,omitempty"`
+	// MaxConcurrent caps in-flight requests per API key; 0 is unlimited
+	MaxConcurrent int `json:"max_concurrent,omitempty"`
+	// Endpoints override limits and weights for individual endpoints
+	Endpoints []EndpointLimit `json:"endpoints,omitempty"`
 }
 
-// GenerateAPIKey creates a new API key
-func (s *PostgresStore) GenerateAPIKey(consumerID, name string) (string, *APIKey, error) {
-	// Generate a random API key
-	keyBytes := make([]byte, 32)
-	if _, err := rand.Read(keyBytes); err != nil {
-		return "", nil, fmt.Errorf("failed to generate random key: %w", err)
+// EndpointLimit is a pricing plan's rule for calls to one endpoint. Path may
+// end in /* to match every path under a prefix; Weight is the number of units
+// a call consumes and is billed for.
+type EndpointLimit struct {
+	Method    string `json:"method,omitempty"`
+	Path      string `json:"path"`
+	Weight    int    `json:"weight,omitempty"`
+	PerMinute int    `json:"per_minute,omitempty"`
+}
+
+// Key modes. Test keys are sandbox keys for building integrations.
+const (
+	ModeLive = "live"
+	ModeTest = "test"
+)
+
+// Key prefixes by mode
+const (
+	liveKeyPrefix = "sk_"
+	testKeyPrefix = "sk_test_"
+)
+
+// SandboxRateLimits apply to every test key, whatever plans the consumer is
+// subscribed to
+var SandboxRateLimits = RateLimits{
+	PerMinute:     10,
+	PerDay:        1000,
+	PerMonth:      10000,
+	MaxConcurrent: 2,
+}
+
+// IsTestKey reports whether an API key is a test mode key
+func IsTestKey(apiKey string) bool {
+	return strings.HasPrefix(apiKey, testKeyPrefix)
+}
+
+// GenerateAPIKey creates a new API key for an organization. Mode is live
+// or test; empty means live. Keys with an expiresAt stop validating after it.
+func (s *PostgresStore) GenerateAPIKey(organizationID, consumerID, name, mode string, expiresAt *time.Time) (string, *APIKey, error) {
+	if mode == "" {
+		mode = ModeLive
+	}
+	fullKey, keyPrefix, keyHash, err := newKeyMaterial(mode)
+	if err != nil {
+		return "", nil, err
 	}
-	
-	// Create the full API key
-	fullKey := "sk_" + hex.EncodeToString(keyBytes)
-	
-	// Create key prefix for display (first 8 chars after prefix)
-	keyPrefix := fullKey[:11] + "..."
-	
-	// Hash the key for storage
-	hash := sha256.Sum256([]byte(fullKey))
-	keyHash := hex.EncodeToString(hash[:])
 	
 	// Insert into database
 	apiKey := &APIKey{
-		ID:         uuid.New().String(),
-		KeyPrefix:  keyPrefix,
-		ConsumerID: consumerID,
+		ID:             uuid.New().String(),
+		KeyPrefix:      keyPrefix,
+		OrganizationID: organizationID,
+		ConsumerID:     consumerID,
 		Name:       name,
+		Mode:       mode,
 		IsActive:   true,
 		CreatedAt:  time.Now().UTC(),
 	}
+	if expiresAt != nil {
+		apiKey.Scopes = &KeyScopes{ExpiresAt: expiresAt}
+	}
 	
 	query := `
-		INSERT INTO api_keys (id, key_hash, key_prefix, consumer_id, name, is_active, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO api_keys (id, key_hash, key_prefix, consumer_id, name, mode, is_active, expires_at, created_at, organization_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 		RETURNING created_at
 	`
 	
-	err := s.db.QueryRow(
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	
+	err = tx.QueryRow(
 		query,
 		apiKey.ID,
 		keyHash,
 		apiKey.KeyPrefix,
 		apiKey.ConsumerID,
 		apiKey.Name,
+		apiKey.Mode,
 		apiKey.IsActive,
+		expiresAt,
 		apiKey.CreatedAt,
+		apiKey.OrganizationID,
 	).Scan(&apiKey.CreatedAt)
 	
 	if err != nil {
 		return "", nil, fmt.Errorf("failed to insert API key: %w", err)
 	}
 	
+	err = appendAudit(tx, consumerActor(consumerID), AuditKeyCreated, apiKey.ID, organizationID, consumerID, map[string]interface{}{
+		"name":       apiKey.Name,
+		"mode":       apiKey.Mode,
+		"key_prefix": apiKey.KeyPrefix,
+		"expires_at": expiresAt,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	
+	if err := tx.Commit(); err != nil {
+		return "", nil, fmt.Errorf("failed to commit API key: %w", err)
+	}
+	
 	return fullKey, apiKey, nil
 }
 
+// newKeyMaterial generates a random API key for a mode, returning the full
+// key, its display prefix and the hash it is stored under
+func newKeyMaterial(mode string) (fullKey, keyPrefix, keyHash string, err error) {
+	prefix := liveKeyPrefix
+	switch mode {
+	case ModeLive:
+	case ModeTest:
+		prefix = testKeyPrefix
+	default:
+		return "", "", "", fmt.Errorf("invalid key mode %q", mode)
+	}
+
+	// Generate a random API key
+	keyBytes := make([]byte, 32)
+	if _, err := rand.Read(keyBytes); err != nil {
+		return "", "", "", fmt.Errorf("failed to generate random key: %w", err)
+	}
+	
+	// Create the full API key
+	fullKey = prefix + hex.EncodeToString(keyBytes)
+	
+	// Create key prefix for display (first 8 chars after prefix)
+	keyPrefix = fullKey[:len(prefix)+8] + "..."
+	
+	// Hash the key for storage
+	hash := sha256.Sum256([]byte(fullKey))
+	keyHash = hex.EncodeToString(hash[:])
+	
+	return fullKey, keyPrefix, keyHash, nil
+}
+
 // ValidateAPIKey validates an API key and returns subscription information
 func (s *PostgresStore) ValidateAPIKey(apiKey, path string) (*APIKeyValidation, error) {
 	// Hash the provided key
@@ -116,71 +254,216 @@ func (s *PostgresStore) ValidateAPIKey(apiKey, path string) (*APIKeyValidation,
 	creator := parts[0]
 	apiName := parts[1]
 	
-	// Query for the API key and subscription information
+	if IsTestKey(apiKey) {
+		return s.validateTestKey(keyHash, creator, apiName)
+	}
+	
+	// Query for the API key and subscription information. Keys rotated
+	// out during their grace period use their successor's subscriptions.
 	query := `
 		SELECT 
-			ak.id,
-			ak.consumer_id,
-			s.id as subscription_id,
-			s.api_id,
-			pp.rate_limit_per_minute,
-			pp.rate_limit_per_day,
-			pp.rate_limit_per_month
+			ak.quarantined_at,
+			ak.scopes,
+			ak.expires_at,
+			ak.id,` + validationColumns + `
 		FROM api_keys ak
-		JOIN subscriptions s ON s.api_key_id = ak.id
+		JOIN subscriptions s ON s.api_key_id = COALESCE(ak.replaced_by, ak.id)
 		JOIN apis a ON a.id = s.api_id
 		JOIN users u ON u.id = a.user_id
 		JOIN api_pricing_plans pp ON pp.id = s.pricing_plan_id
 		WHERE ak.key_hash = $1
 			AND ak.is_active = true
+			AND ak.mode = 'live'
+			AND (ak.expires_at IS NULL OR ak.expires_at > CURRENT_TIMESTAMP)
 			AND s.status = 'active'
 			AND a.name = $2
 			AND u.username = $3
 			AND a.is_published = true
 	`
 	
-	var validation APIKeyValidation
+	var quarantinedAt sql.NullTime
+	var scopes []byte
+	var expiresAt sql.NullTime
+	validation, err := scanValidation(s.db.QueryRow(query, keyHash, apiName, creator), &quarantinedAt, &scopes, &expiresAt)
+	if err == sql.ErrNoRows {
+		return &APIKeyValidation{Valid: false}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to validate API key: %w", err)
+	}
+	if quarantinedAt.Valid {
+		return quarantined(validation.APIKeyID), nil
+	}
+	if validation.Scopes, err = scanScopes(scopes, expiresAt); err != nil {
+		return nil, err
+	}
+	
+	// Update last used timestamp
+	go s.updateLastUsed(validation.APIKeyID)
+	
+	return validation, nil
+}
+
+// validateTestKey validates a test key for any published API. Test keys
+// get the sandbox rate limits and no quota; the subscription is the
+// consumer's latest one to the API, if any, so their calls can be metered.
+func (s *PostgresStore) validateTestKey(keyHash, creator, apiName string) (*APIKeyValidation, error) {
+	query := `
+		SELECT
+			ak.id,
+			ak.consumer_id,
+			COALESCE(sub.id::text, ''),
+			a.id,
+			ak.scopes,
+			ak.expires_at,
+			ak.quarantined_at
+		FROM api_keys ak
+		JOIN apis a ON a.name = $2 AND a.is_published = true
+		JOIN users u ON u.id = a.user_id AND u.username = $3
+		LEFT JOIN LATERAL (
+			SELECT s.id
+			FROM subscriptions s
+			WHERE s.organization_id = ak.organization_id
+				AND s.api_id = a.id
+				AND s.status <> 'cancelled'
+			ORDER BY s.started_at DESC
+			LIMIT 1
+		) sub ON true
+		WHERE ak.key_hash = $1
+			AND ak.is_active = true
+			AND ak.mode = 'test'
+			AND (ak.expires_at IS NULL OR ak.expires_at > CURRENT_TIMESTAMP)
+	`
+	
+	validation := APIKeyValidation{
+		Valid:      true,
+		Mode:       ModeTest,
+		RateLimits: SandboxRateLimits,
+	}
+	var scopes []byte
+	var expiresAt, quarantinedAt sql.NullTime
 	err := s.db.QueryRow(query, keyHash, apiName, creator).Scan(
 		&validation.APIKeyID,
 		&validation.ConsumerID,
 		&validation.SubscriptionID,
 		&validation.APIID,
-		&validation.RateLimits.PerMinute,
-		&validation.RateLimits.PerDay,
-		&validation.RateLimits.PerMonth,
+		&scopes,
+		&expiresAt,
+		&quarantinedAt,
 	)
-	
 	if err == sql.ErrNoRows {
 		return &APIKeyValidation{Valid: false}, nil
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to validate API key: %w", err)
 	}
+	if quarantinedAt.Valid {
+		return quarantined(validation.APIKeyID), nil
+	}
+	if validation.Scopes, err = scanScopes(scopes, expiresAt); err != nil {
+		return nil, err
+	}
 	
 	// Update last used timestamp
 	go s.updateLastUsed(validation.APIKeyID)
 	
+	return &validation, nil
+}
+
+// quarantined is the validation of a quarantined key. It carries the key's
+// ID so gateways drop it from cache once the key is confirmed.
+func quarantined(keyID string) *APIKeyValidation {
+	return &APIKeyValidation{
+		Valid:    false,
+		APIKeyID: keyID,
+		Error:    "API_KEY_QUARANTINED",
+	}
+}
+
+// validationColumns selects what a validation is built from, for queries
+// joining the credential's subscription s and pricing plan pp
+const validationColumns = `
+			s.consumer_id,
+			s.id as subscription_id,
+			s.api_id,
+			pp.rate_limit_per_minute,
+			pp.rate_limit_per_day,
+			pp.rate_limit_per_month,
+			COALESCE(pp.rate_limit_failure_policy, ''),
+			COALESCE(pp.max_concurrent_requests, 0),
+			COALESCE(pp.endpoint_limits, '[]'::jsonb),
+			pp.call_limit,
+			COALESCE(s.started_at, date_trunc('month', CURRENT_TIMESTAMP)),
+			COALESCE(pp.overage_policy, 'block'),
+			COALESCE(pp.quota_throttle_per_minute, 0)`
+
+// scanValidation scans a row selecting the credential's ID followed by
+// validationColumns into a valid validation. Columns selected before the
+// credential's ID are scanned into leading.
+func scanValidation(row *sql.Row, leading ...interface{}) (*APIKeyValidation, error) {
+	var validation APIKeyValidation
+	var endpointLimits []byte
+	var callLimit sql.NullInt64
+	var quota Quota
+	err := row.Scan(append(leading,
+		&validation.APIKeyID,
+		&validation.ConsumerID,
+		&validation.SubscriptionID,
+		&validation.APIID,
+		&validation.RateLimits.PerMinute,
+		&validation.RateLimits.PerDay,
+		&validation.RateLimits.PerMonth,
+		&validation.RateLimits.FailurePolicy,
+		&validation.RateLimits.MaxConcurrent,
+		&endpointLimits,
+		&callLimit,
+		&quota.PeriodAnchor,
+		&quota.Policy,
+		&quota.ThrottlePerMinute,
+	)...)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(endpointLimits, &validation.RateLimits.Endpoints); err != nil {
+		return nil, fmt.Errorf("invalid endpoint limits: %w", err)
+	}
+	if callLimit.Valid && callLimit.Int64 > 0 {
+		quota.Limit = callLimit.Int64
+		validation.Quota = &quota
+	}
 	validation.Valid = true
 	return &validation, nil
 }
 
 // GetAPIKey retrieves an API key by ID
-func (s *PostgresStore) GetAPIKey(keyID, consumerID string) (*APIKey, error) {
+func (s *PostgresStore) GetAPIKey(keyID, organizationID string) (*APIKey, error) {
 	query := `
-		SELECT id, key_prefix, consumer_id, name, is_active, created_at, last_used_at
+		SELECT id, key_prefix, organization_id, consumer_id, name, mode, is_active, scopes, expires_at, created_at, last_used_at,
+			rotated_from, replaced_by, rotated_at, quarantined_at, COALESCE(quarantine_reason, '')
 		FROM api_keys
-		WHERE id = $1 AND consumer_id = $2
+		WHERE id = $1 AND organization_id = $2
 	`
 	
 	var apiKey APIKey
-	err := s.db.QueryRow(query, keyID, consumerID).Scan(
+	var scopes []byte
+	var expiresAt sql.NullTime
+	err := s.db.QueryRow(query, keyID, organizationID).Scan(
 		&apiKey.ID,
 		&apiKey.KeyPrefix,
+		&apiKey.OrganizationID,
 		&apiKey.ConsumerID,
 		&apiKey.Name,
+		&apiKey.Mode,
 		&apiKey.IsActive,
+		&scopes,
+		&expiresAt,
 		&apiKey.CreatedAt,
 		&apiKey.LastUsedAt,
+		&apiKey.RotatedFrom,
+		&apiKey.ReplacedBy,
+		&apiKey.RotatedAt,
+		&apiKey.QuarantinedAt,
+		&apiKey.QuarantineReason,
 	)
 	
 	if err == sql.ErrNoRows {
@@ -189,20 +472,24 @@ func (s *PostgresStore) GetAPIKey(keyID, consumerID string) (*APIKey, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to get API key: %w", err)
 	}
+	if apiKey.Scopes, err = scanScopes(scopes, expiresAt); err != nil {
+		return nil, err
+	}
 	
 	return &apiKey, nil
 }
 
-// ListAPIKeys lists all API keys for a consumer
-func (s *PostgresStore) ListAPIKeys(consumerID string) ([]*APIKey, error) {
+// ListAPIKeys lists all API keys of an organization
+func (s *PostgresStore) ListAPIKeys(organizationID string) ([]*APIKey, error) {
 	query := `
-		SELECT id, key_prefix, consumer_id, name, is_active, created_at, last_used_at
+		SELECT id, key_prefix, organization_id, consumer_id, name, mode, is_active, scopes, expires_at, created_at, last_used_at,
+			rotated_from, replaced_by, rotated_at, quarantined_at, COALESCE(quarantine_reason, '')
 		FROM api_keys
-		WHERE consumer_id = $1
+		WHERE organization_id = $1
 		ORDER BY created_at DESC
 	`
 	
-	rows, err := s.db.Query(query, consumerID)
+	rows, err := s.db.Query(query, organizationID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list API keys: %w", err)
 	}
@@ -211,33 +498,53 @@ func (s *PostgresStore) ListAPIKeys(consumerID string) ([]*APIKey, error) {
 	var keys []*APIKey
 	for rows.Next() {
 		var key APIKey
+		var scopes []byte
+		var expiresAt sql.NullTime
 		err := rows.Scan(
 			&key.ID,
 			&key.KeyPrefix,
+			&key.OrganizationID,
 			&key.ConsumerID,
 			&key.Name,
+			&key.Mode,
 			&key.IsActive,
+			&scopes,
+			&expiresAt,
 			&key.CreatedAt,
 			&key.LastUsedAt,
+			&key.RotatedFrom,
+			&key.ReplacedBy,
+			&key.RotatedAt,
+			&key.QuarantinedAt,
+			&key.QuarantineReason,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan API key: %w", err)
 		}
+		if key.Scopes, err = scanScopes(scopes, expiresAt); err != nil {
+			return nil, err
+		}
 		keys = append(keys, &key)
 	}
 	
 	return keys, nil
 }
 
-// RevokeAPIKey revokes an API key
-func (s *PostgresStore) RevokeAPIKey(keyID, consumerID string) error {
+// RevokeAPIKey revokes an organization's API key on behalf of a member
+func (s *PostgresStore) RevokeAPIKey(keyID, organizationID, consumerID string) error {
 	query := `
 		UPDATE api_keys
 		SET is_active = false
-		WHERE id = $1 AND consumer_id = $2
+		WHERE id = $1 AND organization_id = $2
 	`
 	
-	result, err := s.db.Exec(query, keyID, consumerID)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	
+	result, err := tx.Exec(query, keyID, organizationID)
 	if err != nil {
 		return fmt.Errorf("failed to revoke API key: %w", err)
 	}
@@ -251,45 +558,137 @@ func (s *PostgresStore) RevokeAPIKey(keyID, consumerID string) error {
 		return fmt.Errorf("API key not found")
 	}
 	
+	if err := appendAudit(tx, consumerActor(consumerID), AuditKeyRevoked, keyID, organizationID, consumerID, nil); err != nil {
+		return err
+	}
+	
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit revocation: %w", err)
+	}
+	
 	return nil
 }
-
-// UpdateAPIKey updates an API key's name
-func (s *PostgresStore) UpdateAPIKey(keyID, consumerID, name string) error {
+	
+// UpdateAPIKey updates the name and scopes of an organization's API key on
+// behalf of a member
+func (s *PostgresStore) UpdateAPIKey(keyID, organizationID, consumerID string, update APIKeyUpdate) error {
+	var scopes sql.NullString
+	var expiresAt *time.Time
+	if update.Scopes != nil {
+		var err error
+		if scopes, expiresAt, err = update.Scopes.columns(); err != nil {
+			return err
+		}
+	}
+	
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	
+	// The previous name is kept for the audit log
+	var name string
+	err = tx.QueryRow(
+		"SELECT name FROM api_keys WHERE id = $1 AND organization_id = $2 FOR UPDATE",
+		keyID,
+		organizationID,
+	).Scan(&name)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("API key not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get API key: %w", err)
+	}
+	
 	query := `
 		UPDATE api_keys
-		SET name = $3
-		WHERE id = $1 AND consumer_id = $2
+		SET name = COALESCE($3, name),
+			scopes = CASE WHEN $4 THEN $5::jsonb ELSE scopes END,
+			expires_at = CASE WHEN $4 THEN $6 ELSE expires_at END,
+			expiry_notified_at = CASE WHEN $4 THEN NULL ELSE expiry_notified_at END
+		WHERE id = $1 AND organization_id = $2
 	`
 	
-	result, err := s.db.Exec(query, keyID, consumerID, name)
+	_, err = tx.Exec(query, keyID, organizationID, update.Name, update.Scopes != nil, scopes, expiresAt)
 	if err != nil {
 		return fmt.Errorf("failed to update API key: %w", err)
 	}
 	
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %w", err)
+	actor := consumerActor(consumerID)
+	if update.Name != nil && *update.Name != name {
+		err := appendAudit(tx, actor, AuditKeyRenamed, keyID, organizationID, consumerID, map[string]interface{}{
+			"from": name,
+			"to":   *update.Name,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if update.Scopes != nil {
+		err := appendAudit(tx, actor, AuditKeyScopesChanged, keyID, organizationID, consumerID, map[string]interface{}{
+			"scopes": update.Scopes,
+		})
+		if err != nil {
+			return err
+		}
 	}
 	
-	if rowsAffected == 0 {
-		return fmt.Errorf("API key not found")
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit API key update: %w", err)
 	}
 	
 	return nil
 }
-
-// updateLastUsed updates the last used timestamp for an API key
+	
+// updateLastUsed updates the last used timestamp for an API key. The first
+// use of a key each day is recorded in the audit log; recording every call
+// would swamp it.
 func (s *PostgresStore) updateLastUsed(keyID string) {
+	now := time.Now().UTC()
+	if err := s.recordUse(keyID, now); err != nil {
+		log.Printf("Failed to record use of API key %s: %v", keyID, err)
+	}
+}
+	
+func (s *PostgresStore) recordUse(keyID string, now time.Time) error {
 	query := `
-		UPDATE api_keys
+		UPDATE api_keys ak
 		SET last_used_at = $2
-		WHERE id = $1
+		FROM (SELECT id, last_used_at FROM api_keys WHERE id = $1 FOR UPDATE) prev
+		WHERE ak.id = prev.id
+		RETURNING prev.last_used_at, ak.organization_id, ak.consumer_id
 	`
 	
-	_, _ = s.db.Exec(query, keyID, time.Now().UTC())
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	
+	var lastUsed sql.NullTime
+	var organizationID, consumerID string
+	err = tx.QueryRow(query, keyID, now).Scan(&lastUsed, &organizationID, &consumerID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update last used: %w", err)
+	}
+	
+	day := now.Truncate(24 * time.Hour)
+	if !lastUsed.Valid || lastUsed.Time.UTC().Before(day) {
+		err := appendAudit(tx, GatewayActor, AuditKeyUsed, keyID, organizationID, consumerID, map[string]interface{}{
+			"date": day.Format("2006-01-02"),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	
+	return tx.Commit()
 }
-
+	
 // EnsureConsumer ensures a consumer record exists
 func (s *PostgresStore) EnsureConsumer(cognitoUserID, email string) (string, error) {
 	// Try to get existing consumer
@@ -0,0 +1,286 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrKeyNotRotatable is returned when rotating a key that is revoked or
+// has already been rotated
+var ErrKeyNotRotatable = errors.New("API key cannot be rotated")
+
+// ExpiringKey is an active key nearing its expiry, for notifying its consumer
+type ExpiringKey struct {
+	ID         string    `json:"id"`
+	KeyPrefix  string    `json:"key_prefix"`
+	ConsumerID string    `json:"consumer_id"`
+	Email      string    `json:"email"`
+	Name       string    `json:"name"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// RotateAPIKey replaces an organization's API key with a new one on behalf
+// of a member. The successor takes over the key's name, mode, scopes, expiry
+// and subscriptions; the old key keeps working for the grace period and then
+// expires. It returns the new key and when the old one expires.
+func (s *PostgresStore) RotateAPIKey(keyID, organizationID, consumerID string, grace time.Duration) (string, *APIKey, time.Time, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", nil, time.Time{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var old APIKey
+	var scopes sql.NullString
+	var expiresAt sql.NullTime
+	err = tx.QueryRow(`
+		SELECT id, key_prefix, consumer_id, name, mode, is_active, scopes, expires_at, replaced_by
+		FROM api_keys
+		WHERE id = $1 AND organization_id = $2
+		FOR UPDATE
+	`, keyID, organizationID).Scan(
+		&old.ID,
+		&old.KeyPrefix,
+		&old.ConsumerID,
+		&old.Name,
+		&old.Mode,
+		&old.IsActive,
+		&scopes,
+		&expiresAt,
+		&old.ReplacedBy,
+	)
+	if err == sql.ErrNoRows {
+		return "", nil, time.Time{}, fmt.Errorf("API key not found")
+	}
+	if err != nil {
+		return "", nil, time.Time{}, fmt.Errorf("failed to get API key: %w", err)
+	}
+	if !old.IsActive || old.ReplacedBy != nil {
+		return "", nil, time.Time{}, ErrKeyNotRotatable
+	}
+
+	fullKey, keyPrefix, keyHash, err := newKeyMaterial(old.Mode)
+	if err != nil {
+		return "", nil, time.Time{}, err
+	}
+
+	now := time.Now().UTC()
+	successor := &APIKey{
+		ID:             uuid.New().String(),
+		KeyPrefix:      keyPrefix,
+		OrganizationID: organizationID,
+		ConsumerID:     consumerID,
+		Name:           old.Name,
+		Mode:           old.Mode,
+		IsActive:       true,
+		CreatedAt:      now,
+		RotatedFrom:    &old.ID,
+	}
+	if successor.Scopes, err = scanScopes([]byte(scopes.String), expiresAt); err != nil {
+		return "", nil, time.Time{}, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO api_keys (id, key_hash, key_prefix, consumer_id, name, mode, is_active, scopes, expires_at, rotated_from, created_at, organization_id)
+		VALUES ($1, $2, $3, $4, $5, $6, true, $7::jsonb, $8, $9, $10, $11)
+	`,
+		successor.ID,
+		keyHash,
+		successor.KeyPrefix,
+		successor.ConsumerID,
+		successor.Name,
+		successor.Mode,
+		scopes,
+		expiresAt,
+		old.ID,
+		successor.CreatedAt,
+		successor.OrganizationID,
+	)
+	if err != nil {
+		return "", nil, time.Time{}, fmt.Errorf("failed to insert API key: %w", err)
+	}
+
+	// Move the subscriptions over, and point keys still in their grace
+	// period from earlier rotations at the new key
+	if _, err := tx.Exec(`UPDATE subscriptions SET api_key_id = $2 WHERE api_key_id = $1`, old.ID, successor.ID); err != nil {
+		return "", nil, time.Time{}, fmt.Errorf("failed to move subscriptions: %w", err)
+	}
+	if _, err := tx.Exec(`UPDATE api_keys SET replaced_by = $2 WHERE replaced_by = $1`, old.ID, successor.ID); err != nil {
+		return "", nil, time.Time{}, fmt.Errorf("failed to update rotated keys: %w", err)
+	}
+
+	// The old key expires at the end of the grace period, or earlier if
+	// it was already due to
+	var retiresAt time.Time
+	err = tx.QueryRow(`
+		UPDATE api_keys
+		SET replaced_by = $2,
+			rotated_at = $3,
+			expires_at = LEAST(COALESCE(expires_at, $4), $4)
+		WHERE id = $1
+		RETURNING expires_at
+	`, old.ID, successor.ID, now, now.Add(grace)).Scan(&retiresAt)
+	if err != nil {
+		return "", nil, time.Time{}, fmt.Errorf("failed to retire API key: %w", err)
+	}
+
+	actor := consumerActor(consumerID)
+	err = appendAudit(tx, actor, AuditKeyRotated, old.ID, organizationID, consumerID, map[string]interface{}{
+		"replaced_by": successor.ID,
+		"expires_at":  retiresAt,
+	})
+	if err != nil {
+		return "", nil, time.Time{}, err
+	}
+	err = appendAudit(tx, actor, AuditKeyCreated, successor.ID, organizationID, consumerID, map[string]interface{}{
+		"name":         successor.Name,
+		"mode":         successor.Mode,
+		"key_prefix":   successor.KeyPrefix,
+		"rotated_from": old.ID,
+	})
+	if err != nil {
+		return "", nil, time.Time{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", nil, time.Time{}, fmt.Errorf("failed to commit rotation: %w", err)
+	}
+
+	return fullKey, successor, retiresAt, nil
+}
+
+// SubscriptionKeyID returns the ID of the API key an organization's
+// subscription is bound to
+func (s *PostgresStore) SubscriptionKeyID(subscriptionID, organizationID string) (string, error) {
+	var keyID string
+	err := s.db.QueryRow(
+		"SELECT api_key_id FROM subscriptions WHERE id = $1 AND organization_id = $2",
+		subscriptionID,
+		organizationID,
+	).Scan(&keyID)
+
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("subscription not found")
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get subscription: %w", err)
+	}
+
+	return keyID, nil
+}
+
+// DeactivateExpiredKeys deactivates active keys past their expiry and
+// returns their IDs
+func (s *PostgresStore) DeactivateExpiredKeys() ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		UPDATE api_keys
+		SET is_active = false
+		WHERE is_active = true
+			AND expires_at IS NOT NULL
+			AND expires_at <= CURRENT_TIMESTAMP
+		RETURNING id, organization_id, consumer_id, expires_at
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deactivate expired keys: %w", err)
+	}
+	defer rows.Close()
+
+	type expired struct {
+		id             string
+		organizationID string
+		consumerID     string
+		expiresAt      time.Time
+	}
+	var keys []expired
+	for rows.Next() {
+		var key expired
+		if err := rows.Scan(&key.id, &key.organizationID, &key.consumerID, &key.expiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan key ID: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	ids := make([]string, 0, len(keys))
+	for _, key := range keys {
+		err := appendAudit(tx, SystemActor, AuditKeyExpired, key.id, key.organizationID, key.consumerID, map[string]interface{}{
+			"expires_at": key.expiresAt,
+		})
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, key.id)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit expiry: %w", err)
+	}
+
+	return ids, nil
+}
+
+// ListExpiringKeys lists active keys expiring before a time whose consumers
+// haven't been notified yet. Keys rotated out are left out: their consumer
+// already has the successor.
+func (s *PostgresStore) ListExpiringKeys(before time.Time) ([]*ExpiringKey, error) {
+	rows, err := s.db.Query(`
+		SELECT ak.id, ak.key_prefix, ak.consumer_id, c.email, ak.name, ak.expires_at
+		FROM api_keys ak
+		JOIN consumers c ON c.id = ak.consumer_id
+		WHERE ak.is_active = true
+			AND ak.replaced_by IS NULL
+			AND ak.expiry_notified_at IS NULL
+			AND ak.expires_at > CURRENT_TIMESTAMP
+			AND ak.expires_at <= $1
+		ORDER BY ak.expires_at
+	`, before)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list expiring keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []*ExpiringKey
+	for rows.Next() {
+		var key ExpiringKey
+		err := rows.Scan(
+			&key.ID,
+			&key.KeyPrefix,
+			&key.ConsumerID,
+			&key.Email,
+			&key.Name,
+			&key.ExpiresAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan expiring key: %w", err)
+		}
+		keys = append(keys, &key)
+	}
+
+	return keys, rows.Err()
+}
+
+// MarkExpiryNotified records that a key's consumer was told it is expiring
+func (s *PostgresStore) MarkExpiryNotified(keyID string) error {
+	_, err := s.db.Exec(
+		"UPDATE api_keys SET expiry_notified_at = $2 WHERE id = $1",
+		keyID,
+		time.Now().UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to mark expiry notified: %w", err)
+	}
+	return nil
+}
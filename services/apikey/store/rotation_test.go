@@ -0,0 +1,204 @@
+package store
+
+import (
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func newMockStore(t *testing.T) (*PostgresStore, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return NewPostgresStore(db), mock
+}
+
+// around matches a time within a second of want
+type around struct{ want time.Time }
+
+func (a around) Match(v driver.Value) bool {
+	got, ok := v.(time.Time)
+	return ok && got.Sub(a.want).Abs() < time.Second
+}
+
+var rotatedKeyColumns = []string{"id", "key_prefix", "consumer_id", "name", "mode", "is_active", "scopes", "expires_at", "replaced_by"}
+
+func TestRotateAPIKeyKeepsOldKeyForGracePeriod(t *testing.T) {
+	s, mock := newMockStore(t)
+	grace := 24 * time.Hour
+	retiresAt := time.Now().UTC().Add(grace)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM api_keys\\s+WHERE id = \\$1 AND organization_id = \\$2\\s+FOR UPDATE").
+		WithArgs("key-old", "org-1").
+		WillReturnRows(sqlmock.NewRows(rotatedKeyColumns).
+			AddRow("key-old", "sk_0123abcd...", "consumer-1", "production", ModeLive, true, `{"methods":["GET"]}`, nil, nil))
+	mock.ExpectExec("INSERT INTO api_keys").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "consumer-2", "production", ModeLive,
+			`{"methods":["GET"]}`, nil, "key-old", sqlmock.AnyArg(), "org-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE subscriptions SET api_key_id = \\$2 WHERE api_key_id = \\$1").
+		WithArgs("key-old", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE api_keys SET replaced_by = \\$2 WHERE replaced_by = \\$1").
+		WithArgs("key-old", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	// The old key's expiry is brought forward to the end of the grace period
+	mock.ExpectQuery("expires_at = LEAST\\(COALESCE\\(expires_at, \\$4\\), \\$4\\)").
+		WithArgs("key-old", sqlmock.AnyArg(), sqlmock.AnyArg(), around{retiresAt}).
+		WillReturnRows(sqlmock.NewRows([]string{"expires_at"}).AddRow(retiresAt))
+	mock.ExpectExec("INSERT INTO audit_log").
+		WithArgs("consumer", "consumer-2", AuditKeyRotated, "api_key", "key-old", "consumer-2", "org-1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_log").
+		WithArgs("consumer", "consumer-2", AuditKeyCreated, "api_key", sqlmock.AnyArg(), "consumer-2", "org-1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	fullKey, successor, expires, err := s.RotateAPIKey("key-old", "org-1", "consumer-2", grace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(fullKey, liveKeyPrefix) || IsTestKey(fullKey) {
+		t.Errorf("new key %q is not a live key", fullKey)
+	}
+	if !expires.Equal(retiresAt) {
+		t.Errorf("old key expires at %v, want %v", expires, retiresAt)
+	}
+	if successor.RotatedFrom == nil || *successor.RotatedFrom != "key-old" || successor.Name != "production" {
+		t.Errorf("successor = %+v", successor)
+	}
+	if successor.Scopes == nil || len(successor.Scopes.Methods) != 1 || successor.Scopes.Methods[0] != "GET" {
+		t.Errorf("successor scopes = %+v", successor.Scopes)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRotateAPIKeyRejectsRetiredKeys(t *testing.T) {
+	replacedBy := "key-new"
+	keys := map[string][]driver.Value{
+		"revoked": {"key-old", "sk_0123abcd...", "consumer-1", "production", ModeLive, false, nil, nil, nil},
+		"rotated": {"key-old", "sk_0123abcd...", "consumer-1", "production", ModeLive, true, nil, nil, replacedBy},
+	}
+	for name, row := range keys {
+		s, mock := newMockStore(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery("FROM api_keys").WillReturnRows(sqlmock.NewRows(rotatedKeyColumns).AddRow(row...))
+		mock.ExpectRollback()
+
+		if _, _, _, err := s.RotateAPIKey("key-old", "org-1", "consumer-2", time.Hour); !errors.Is(err, ErrKeyNotRotatable) {
+			t.Errorf("%s: err = %v, want ErrKeyNotRotatable", name, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+// liveValidationColumns are the columns ValidateAPIKey selects for live keys
+var liveValidationColumns = []string{
+	"quarantined_at", "scopes", "expires_at", "id",
+	"consumer_id", "subscription_id", "api_id",
+	"rate_limit_per_minute", "rate_limit_per_day", "rate_limit_per_month",
+	"rate_limit_failure_policy", "max_concurrent_requests", "endpoint_limits",
+	"call_limit", "started_at", "overage_policy", "quota_throttle_per_minute",
+}
+
+func TestRotatedKeyValidatesUntilGracePeriodEnds(t *testing.T) {
+	s, mock := newMockStore(t)
+	mock.MatchExpectationsInOrder(false)
+	retiresAt := time.Now().UTC().Add(time.Hour)
+
+	// During the grace period the old key resolves to its successor's
+	// subscription and carries its expiry, so gateways stop accepting
+	// cached validations when it ends
+	mock.ExpectQuery("JOIN subscriptions s ON s.api_key_id = COALESCE\\(ak.replaced_by, ak.id\\)").
+		WithArgs(sqlmock.AnyArg(), "weather", "alice").
+		WillReturnRows(sqlmock.NewRows(liveValidationColumns).AddRow(
+			nil, nil, retiresAt, "key-old",
+			"consumer-1", "sub-1", "api-1",
+			60, 1000, 10000,
+			"", 0, []byte("[]"),
+			nil, time.Now(), "block", 0,
+		))
+	mock.ExpectExec("UPDATE api_keys").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	validation, err := s.ValidateAPIKey("sk_0123abcd", "/alice/weather/today")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !validation.Valid || validation.SubscriptionID != "sub-1" {
+		t.Errorf("validation during grace period = %+v", validation)
+	}
+	if validation.Scopes == nil || validation.Scopes.ExpiresAt == nil || !validation.Scopes.ExpiresAt.Equal(retiresAt) {
+		t.Errorf("validation scopes = %+v, want expiry %v", validation.Scopes, retiresAt)
+	}
+
+	// After it, the key no longer matches
+	mock.ExpectQuery("AND \\(ak.expires_at IS NULL OR ak.expires_at > CURRENT_TIMESTAMP\\)").
+		WillReturnRows(sqlmock.NewRows(liveValidationColumns))
+
+	validation, err = s.ValidateAPIKey("sk_0123abcd", "/alice/weather/today")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if validation.Valid {
+		t.Error("expired key validated")
+	}
+}
+
+func TestDeactivateExpiredKeys(t *testing.T) {
+	s, mock := newMockStore(t)
+	expiredAt := time.Now().UTC().Add(-time.Minute)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SET is_active = false\\s+WHERE is_active = true\\s+AND expires_at IS NOT NULL\\s+AND expires_at <= CURRENT_TIMESTAMP").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "organization_id", "consumer_id", "expires_at"}).
+			AddRow("key-1", "org-1", "consumer-1", expiredAt).
+			AddRow("key-2", "org-2", "consumer-2", expiredAt))
+	for _, key := range [][]string{{"key-1", "org-1", "consumer-1"}, {"key-2", "org-2", "consumer-2"}} {
+		mock.ExpectExec("INSERT INTO audit_log").
+			WithArgs("system", "apikey", AuditKeyExpired, "api_key", key[0], key[2], key[1], sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectCommit()
+
+	ids, err := s.DeactivateExpiredKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0] != "key-1" || ids[1] != "key-2" {
+		t.Errorf("deactivated %v, want [key-1 key-2]", ids)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDeactivateExpiredKeysRollsBackWithoutAudit(t *testing.T) {
+	s, mock := newMockStore(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SET is_active = false").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "organization_id", "consumer_id", "expires_at"}).
+			AddRow("key-1", "org-1", "consumer-1", time.Now()))
+	mock.ExpectExec("INSERT INTO audit_log").WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+
+	// Keys stay active, and are retried on the next sweep, unless their
+	// expiry is recorded
+	if ids, err := s.DeactivateExpiredKeys(); err == nil || len(ids) != 0 {
+		t.Errorf("ids = %v, err = %v", ids, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,121 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// KeyScopes restrict what an API key can call. Each list is an allowlist;
+// an empty list allows everything. The gateway enforces them.
+type KeyScopes struct {
+	// APIs are "<creator>/<apiName>"
+	APIs []string `json:"apis,omitempty"`
+	// Paths are endpoints below the API, e.g. /v1/forecast. A trailing /*
+	// matches every path under the prefix.
+	Paths   []string `json:"paths,omitempty"`
+	Methods []string `json:"methods,omitempty"`
+	// SourceCIDRs are the networks calls may come from. Plain IPs are
+	// stored as single host networks.
+	SourceCIDRs []string `json:"source_cidrs,omitempty"`
+	// ExpiresAt is when the key stops working
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+var scopeMethods = map[string]bool{
+	"GET": true, "HEAD": true, "POST": true, "PUT": true,
+	"PATCH": true, "DELETE": true, "OPTIONS": true,
+}
+
+// Normalize validates the scopes and rewrites them in canonical form
+func (k *KeyScopes) Normalize(now time.Time) error {
+	for i, api := range k.APIs {
+		api = strings.ToLower(strings.Trim(api, "/"))
+		creator, name, ok := strings.Cut(api, "/")
+		if !ok || creator == "" || name == "" || strings.Contains(name, "/") {
+			return fmt.Errorf("invalid API %q: expected <creator>/<apiName>", k.APIs[i])
+		}
+		k.APIs[i] = api
+	}
+
+	for _, path := range k.Paths {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("invalid path %q: must start with /", path)
+		}
+		if strings.Contains(strings.TrimSuffix(path, "/*"), "*") {
+			return fmt.Errorf("invalid path %q: * is only allowed as a trailing /*", path)
+		}
+	}
+
+	for i, method := range k.Methods {
+		method = strings.ToUpper(method)
+		if !scopeMethods[method] {
+			return fmt.Errorf("invalid method %q", k.Methods[i])
+		}
+		k.Methods[i] = method
+	}
+
+	for i, cidr := range k.SourceCIDRs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return fmt.Errorf("invalid source %q", cidr)
+			}
+			if ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid source %q", k.SourceCIDRs[i])
+		}
+		k.SourceCIDRs[i] = network.String()
+	}
+
+	if k.ExpiresAt != nil {
+		if !k.ExpiresAt.After(now) {
+			return fmt.Errorf("expiry must be in the future")
+		}
+		expiresAt := k.ExpiresAt.UTC()
+		k.ExpiresAt = &expiresAt
+	}
+	return nil
+}
+
+// columns returns the scopes as stored: the allowlists as JSON, or NULL if
+// there are none, and the expiry
+func (k *KeyScopes) columns() (sql.NullString, *time.Time, error) {
+	if len(k.APIs) == 0 && len(k.Paths) == 0 && len(k.Methods) == 0 && len(k.SourceCIDRs) == 0 {
+		return sql.NullString{}, k.ExpiresAt, nil
+	}
+	lists := *k
+	lists.ExpiresAt = nil
+	data, err := json.Marshal(lists)
+	if err != nil {
+		return sql.NullString{}, nil, fmt.Errorf("failed to marshal scopes: %w", err)
+	}
+	return sql.NullString{String: string(data), Valid: true}, k.ExpiresAt, nil
+}
+
+// scanScopes builds a key's scopes from its columns. It returns nil for
+// unrestricted keys.
+func scanScopes(data []byte, expiresAt sql.NullTime) (*KeyScopes, error) {
+	if len(data) == 0 && !expiresAt.Valid {
+		return nil, nil
+	}
+	var scopes KeyScopes
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &scopes); err != nil {
+			return nil, fmt.Errorf("invalid key scopes: %w", err)
+		}
+	}
+	if expiresAt.Valid {
+		scopes.ExpiresAt = &expiresAt.Time
+	}
+	return &scopes, nil
+}
@@ -0,0 +1,96 @@
+package workers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/api-direct/services/apikey/events"
+	"github.com/api-direct/services/apikey/store"
+)
+
+// Notifier tells consumers that one of their API keys is about to expire
+type Notifier interface {
+	KeyExpiring(ctx context.Context, key *store.ExpiringKey) error
+}
+
+// ExpiryWorker deactivates expired API keys and warns consumers about keys
+// that are about to expire
+type ExpiryWorker struct {
+	store        *store.PostgresStore
+	publisher    *events.Publisher
+	notifier     Notifier
+	interval     time.Duration
+	notifyBefore time.Duration
+}
+
+// NewExpiryWorker creates a new expiry worker. The notifier may be nil, in
+// which case no notices are sent.
+func NewExpiryWorker(
+	s *store.PostgresStore,
+	publisher *events.Publisher,
+	notifier Notifier,
+	interval time.Duration,
+	notifyBefore time.Duration,
+) *ExpiryWorker {
+	return &ExpiryWorker{
+		store:        s,
+		publisher:    publisher,
+		notifier:     notifier,
+		interval:     interval,
+		notifyBefore: notifyBefore,
+	}
+}
+
+// Start sweeps for expiring keys every interval until ctx is done
+func (w *ExpiryWorker) Start(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	log.Println("Key expiry worker started")
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Key expiry worker stopped")
+			return
+		case <-ticker.C:
+			w.sweep(ctx)
+		}
+	}
+}
+
+func (w *ExpiryWorker) sweep(ctx context.Context) {
+	// Validation already rejects expired keys; deactivating them makes
+	// that visible to consumers and drops them from gateway caches
+	expired, err := w.store.DeactivateExpiredKeys()
+	if err != nil {
+		log.Printf("Error deactivating expired keys: %v", err)
+	}
+	for _, keyID := range expired {
+		w.publisher.KeyChanged(keyID)
+	}
+	if len(expired) > 0 {
+		log.Printf("Deactivated %d expired API keys", len(expired))
+	}
+
+	if w.notifier == nil {
+		return
+	}
+
+	expiring, err := w.store.ListExpiringKeys(time.Now().Add(w.notifyBefore))
+	if err != nil {
+		log.Printf("Error listing expiring keys: %v", err)
+		return
+	}
+	for _, key := range expiring {
+		// Keys that fail to notify are retried on the next sweep
+		if err := w.notifier.KeyExpiring(ctx, key); err != nil {
+			log.Printf("Failed to notify expiry of key %s: %v", key.ID, err)
+			continue
+		}
+		if err := w.store.MarkExpiryNotified(key.ID); err != nil {
+			log.Printf("Error marking key %s notified: %v", key.ID, err)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// invalidationChannel must match the gateway's API key validation cache channel
+const invalidationChannel = "gateway:apikey:invalidations"
+
+// Publisher notifies API gateways that a subscription changed so cached
+// API key validations for it are dropped
+type Publisher struct {
+	client *redis.Client
+}
+
+// NewPublisher creates a new event publisher
+func NewPublisher(client *redis.Client) *Publisher {
+	return &Publisher{client: client}
+}
+
+// SubscriptionChanged publishes an invalidation for a subscription
+func (p *Publisher) SubscriptionChanged(subscriptionID string) {
+	payload, err := json.Marshal(map[string]string{"subscription_id": subscriptionID})
+	if err != nil {
+		log.Printf("Failed to marshal invalidation: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if err := p.client.Publish(ctx, invalidationChannel, payload).Err(); err != nil {
+		log.Printf("Failed to publish invalidation for subscription %s: %v", subscriptionID, err)
+	}
+}
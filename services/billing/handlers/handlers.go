@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -21,6 +22,7 @@ type BillingHandler struct {
 	consumerStore     *store.ConsumerStore
 	subscriptionStore *store.SubscriptionStore
 	invoiceStore      *store.InvoiceStore
+	orgStore          *store.OrganizationStore
 	stripeClient      *stripe.Client
 	redis             *redis.Client
 	apiKeyServiceURL  string
@@ -32,6 +34,7 @@ func NewBillingHandler(
 	consumerStore *store.ConsumerStore,
 	subscriptionStore *store.SubscriptionStore,
 	invoiceStore *store.InvoiceStore,
+	orgStore *store.OrganizationStore,
 	stripeClient *stripe.Client,
 	redisClient *redis.Client,
 ) *BillingHandler {
@@ -40,6 +43,7 @@ func NewBillingHandler(
 		consumerStore:     consumerStore,
 		subscriptionStore: subscriptionStore,
 		invoiceStore:      invoiceStore,
+		orgStore:          orgStore,
 		stripeClient:      stripeClient,
 		redis:             redisClient,
 		apiKeyServiceURL:  "http://apikey-service:8080", // Configure this
@@ -123,18 +127,21 @@ func (h *BillingHandler) GetConsumer(w http.ResponseWriter, r *http.Request) {
 	respondWithJSON(w, http.StatusOK, consumer)
 }
 
-// CreateSubscription creates a new subscription
+// CreateSubscription creates a new subscription for the caller's organization,
+// paid for by its billing member
 func (h *BillingHandler) CreateSubscription(w http.ResponseWriter, r *http.Request) {
-	userContext, err := middleware.GetUserContext(r)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+	consumer, membership, ok := h.requireMembership(w, r, "")
+	if !ok {
+		return
+	}
+	if !membership.CanManageBilling() {
+		respondWithError(w, http.StatusForbidden, "Your role cannot manage billing for this organization")
 		return
 	}
 
-	// Get consumer
-	consumer, err := h.consumerStore.GetByCognitoID(userContext.CognitoUserID)
-	if err != nil || consumer == nil {
-		respondWithError(w, http.StatusNotFound, "Consumer not found")
+	payer, err := h.billingConsumer(membership)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving payment account")
 		return
 	}
 
@@ -160,8 +167,8 @@ func (h *BillingHandler) CreateSubscription(w http.ResponseWriter, r *http.Reque
 	plan := planData["plan"].(*store.PricingPlan)
 	apiName := planData["api_name"].(string)
 
-	// Check if consumer already has an active subscription to this API
-	hasSubscription, err := h.subscriptionStore.CheckExistingSubscription(consumer.ID, plan.APIID)
+	// Check if the organization already has an active subscription to this API
+	hasSubscription, err := h.subscriptionStore.CheckExistingSubscription(membership.OrganizationID, plan.APIID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error checking subscriptions")
 		return
@@ -236,10 +243,11 @@ func (h *BillingHandler) CreateSubscription(w http.ResponseWriter, r *http.Reque
 	if req.PaymentMethod != "" {
 		// Direct subscription creation with existing payment method
 		stripeSubscription, err := h.stripeClient.CreateSubscription(
-			consumer.StripeCustomerID,
+			payer.StripeCustomerID,
 			stripePriceID,
 			map[string]string{
 				"consumer_id":     consumer.ID,
+				"organization_id": membership.OrganizationID,
 				"api_id":          plan.APIID,
 				"pricing_plan_id": plan.ID,
 				"api_key_id":      apiKey["id"].(string),
@@ -254,6 +262,7 @@ func (h *BillingHandler) CreateSubscription(w http.ResponseWriter, r *http.Reque
 		// Create subscription in database
 		subscription := &store.Subscription{
 			ConsumerID:           consumer.ID,
+			OrganizationID:       membership.OrganizationID,
 			APIID:                plan.APIID,
 			PricingPlanID:        plan.ID,
 			APIKeyID:             apiKey["id"].(string),
@@ -275,12 +284,13 @@ func (h *BillingHandler) CreateSubscription(w http.ResponseWriter, r *http.Reque
 	} else {
 		// Create Stripe Checkout session
 		checkoutSession, err := h.stripeClient.CreateCheckoutSession(
-			consumer.StripeCustomerID,
+			payer.StripeCustomerID,
 			stripePriceID,
 			req.SuccessURL,
 			req.CancelURL,
 			map[string]string{
 				"consumer_id":     consumer.ID,
+				"organization_id": membership.OrganizationID,
 				"api_id":          plan.APIID,
 				"pricing_plan_id": plan.ID,
 				"api_key_id":      apiKey["id"].(string),
@@ -295,6 +305,7 @@ func (h *BillingHandler) CreateSubscription(w http.ResponseWriter, r *http.Reque
 		// Create pending subscription
 		subscription := &store.Subscription{
 			ConsumerID:           consumer.ID,
+			OrganizationID:       membership.OrganizationID,
 			APIID:                plan.APIID,
 			PricingPlanID:        plan.ID,
 			APIKeyID:             apiKey["id"].(string),
@@ -317,23 +328,15 @@ func (h *BillingHandler) CreateSubscription(w http.ResponseWriter, r *http.Reque
 	respondWithJSON(w, http.StatusCreated, response)
 }
 
-// ListSubscriptions lists all subscriptions for the current user
+// ListSubscriptions lists all subscriptions of the caller's organization
 func (h *BillingHandler) ListSubscriptions(w http.ResponseWriter, r *http.Request) {
-	userContext, err := middleware.GetUserContext(r)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
-
-	// Get consumer
-	consumer, err := h.consumerStore.GetByCognitoID(userContext.CognitoUserID)
-	if err != nil || consumer == nil {
-		respondWithError(w, http.StatusNotFound, "Consumer not found")
+	_, membership, ok := h.requireMembership(w, r, "")
+	if !ok {
 		return
 	}
 
 	// Get subscriptions
-	subscriptions, err := h.subscriptionStore.ListByConsumer(consumer.ID)
+	subscriptions, err := h.subscriptionStore.ListByOrganization(membership.OrganizationID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error retrieving subscriptions")
 		return
@@ -358,6 +361,10 @@ func (h *BillingHandler) GetSubscription(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if _, _, ok := h.requireMembership(w, r, subscription.OrganizationID); !ok {
+		return
+	}
+
 	respondWithJSON(w, http.StatusOK, subscription)
 }
 
@@ -377,6 +384,10 @@ func (h *BillingHandler) CancelSubscription(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	if !h.requireBillingRole(w, r, subscription.OrganizationID) {
+		return
+	}
+
 	// Cancel in Stripe
 	if subscription.StripeSubscriptionID != "" {
 		_, err = h.stripeClient.CancelSubscription(subscription.StripeSubscriptionID, false)
@@ -419,6 +430,10 @@ func (h *BillingHandler) UpgradeSubscription(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	if !h.requireBillingRole(w, r, subscription.OrganizationID) {
+		return
+	}
+
 	// Get new pricing plan
 	newPlan, err := h.billingStore.PricingPlan.GetByID(req.NewPricingPlanID)
 	if err != nil || newPlan == nil {
@@ -457,6 +472,16 @@ func (h *BillingHandler) GetSubscriptionUsage(w http.ResponseWriter, r *http.Req
 	vars := mux.Vars(r)
 	subscriptionID := vars["subscriptionId"]
 
+	subscription, err := h.subscriptionStore.GetByID(subscriptionID)
+	if err != nil || subscription == nil {
+		respondWithError(w, http.StatusNotFound, "Subscription not found")
+		return
+	}
+
+	if _, _, ok := h.requireMembership(w, r, subscription.OrganizationID); !ok {
+		return
+	}
+
 	// Get date range from query params
 	startStr := r.URL.Query().Get("start")
 	endStr := r.URL.Query().Get("end")
@@ -487,18 +512,10 @@ func (h *BillingHandler) GetSubscriptionUsage(w http.ResponseWriter, r *http.Req
 	respondWithJSON(w, http.StatusOK, usage)
 }
 
-// AddPaymentMethod adds a new payment method
+// AddPaymentMethod adds a new payment method to the organization's payment account
 func (h *BillingHandler) AddPaymentMethod(w http.ResponseWriter, r *http.Request) {
-	userContext, err := middleware.GetUserContext(r)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
-
-	// Get consumer
-	consumer, err := h.consumerStore.GetByCognitoID(userContext.CognitoUserID)
-	if err != nil || consumer == nil {
-		respondWithError(w, http.StatusNotFound, "Consumer not found")
+	payer, ok := h.requirePayer(w, r)
+	if !ok {
 		return
 	}
 
@@ -512,7 +529,7 @@ func (h *BillingHandler) AddPaymentMethod(w http.ResponseWriter, r *http.Request
 	}
 
 	// Attach payment method to customer
-	paymentMethod, err := h.stripeClient.AttachPaymentMethod(req.PaymentMethodID, consumer.StripeCustomerID)
+	paymentMethod, err := h.stripeClient.AttachPaymentMethod(req.PaymentMethodID, payer.StripeCustomerID)
 	if err != nil {
 		log.Printf("Error attaching payment method: %v", err)
 		respondWithError(w, http.StatusInternalServerError, "Error adding payment method")
@@ -521,7 +538,7 @@ func (h *BillingHandler) AddPaymentMethod(w http.ResponseWriter, r *http.Request
 
 	// Set as default if requested
 	if req.SetAsDefault {
-		_, err = h.stripeClient.SetDefaultPaymentMethod(consumer.StripeCustomerID, req.PaymentMethodID)
+		_, err = h.stripeClient.SetDefaultPaymentMethod(payer.StripeCustomerID, req.PaymentMethodID)
 		if err != nil {
 			log.Printf("Error setting default payment method: %v", err)
 		}
@@ -534,23 +551,15 @@ func (h *BillingHandler) AddPaymentMethod(w http.ResponseWriter, r *http.Request
 	})
 }
 
-// ListPaymentMethods lists all payment methods
+// ListPaymentMethods lists all payment methods of the organization's payment account
 func (h *BillingHandler) ListPaymentMethods(w http.ResponseWriter, r *http.Request) {
-	userContext, err := middleware.GetUserContext(r)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
-
-	// Get consumer
-	consumer, err := h.consumerStore.GetByCognitoID(userContext.CognitoUserID)
-	if err != nil || consumer == nil {
-		respondWithError(w, http.StatusNotFound, "Consumer not found")
+	payer, ok := h.requirePayer(w, r)
+	if !ok {
 		return
 	}
 
 	// List payment methods from Stripe
-	methods, err := h.stripeClient.ListPaymentMethods(consumer.StripeCustomerID)
+	methods, err := h.stripeClient.ListPaymentMethods(payer.StripeCustomerID)
 	if err != nil {
 		log.Printf("Error listing payment methods: %v", err)
 		respondWithError(w, http.StatusInternalServerError, "Error retrieving payment methods")
@@ -562,6 +571,10 @@ func (h *BillingHandler) ListPaymentMethods(w http.ResponseWriter, r *http.Reque
 
 // RemovePaymentMethod removes a payment method
 func (h *BillingHandler) RemovePaymentMethod(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requirePayer(w, r); !ok {
+		return
+	}
+
 	vars := mux.Vars(r)
 	paymentMethodID := vars["paymentMethodId"]
 
@@ -578,26 +591,18 @@ func (h *BillingHandler) RemovePaymentMethod(w http.ResponseWriter, r *http.Requ
 	})
 }
 
-// SetDefaultPaymentMethod sets a default payment method
+// SetDefaultPaymentMethod sets the default payment method of the organization's payment account
 func (h *BillingHandler) SetDefaultPaymentMethod(w http.ResponseWriter, r *http.Request) {
-	userContext, err := middleware.GetUserContext(r)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+	payer, ok := h.requirePayer(w, r)
+	if !ok {
 		return
 	}
 
 	vars := mux.Vars(r)
 	paymentMethodID := vars["paymentMethodId"]
 
-	// Get consumer
-	consumer, err := h.consumerStore.GetByCognitoID(userContext.CognitoUserID)
-	if err != nil || consumer == nil {
-		respondWithError(w, http.StatusNotFound, "Consumer not found")
-		return
-	}
-
 	// Set default payment method
-	_, err = h.stripeClient.SetDefaultPaymentMethod(consumer.StripeCustomerID, paymentMethodID)
+	_, err := h.stripeClient.SetDefaultPaymentMethod(payer.StripeCustomerID, paymentMethodID)
 	if err != nil {
 		log.Printf("Error setting default payment method: %v", err)
 		respondWithError(w, http.StatusInternalServerError, "Error updating payment method")
@@ -609,18 +614,10 @@ func (h *BillingHandler) SetDefaultPaymentMethod(w http.ResponseWriter, r *http.
 	})
 }
 
-// ListInvoices lists all invoices for the current user
+// ListInvoices lists all invoices of the organization's payment account
 func (h *BillingHandler) ListInvoices(w http.ResponseWriter, r *http.Request) {
-	userContext, err := middleware.GetUserContext(r)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
-
-	// Get consumer
-	consumer, err := h.consumerStore.GetByCognitoID(userContext.CognitoUserID)
-	if err != nil || consumer == nil {
-		respondWithError(w, http.StatusNotFound, "Consumer not found")
+	payer, ok := h.requirePayer(w, r)
+	if !ok {
 		return
 	}
 
@@ -635,7 +632,7 @@ func (h *BillingHandler) ListInvoices(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get invoices
-	invoices, err := h.invoiceStore.ListByConsumer(consumer.ID, limit, offset)
+	invoices, err := h.invoiceStore.ListByConsumer(payer.ID, limit, offset)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error retrieving invoices")
 		return
@@ -660,6 +657,15 @@ func (h *BillingHandler) GetInvoice(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	payer, ok := h.requirePayer(w, r)
+	if !ok {
+		return
+	}
+	if invoice.ConsumerID != payer.ID {
+		respondWithError(w, http.StatusNotFound, "Invoice not found")
+		return
+	}
+
 	respondWithJSON(w, http.StatusOK, invoice)
 }
 
@@ -674,6 +680,15 @@ func (h *BillingHandler) DownloadInvoice(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	payer, ok := h.requirePayer(w, r)
+	if !ok {
+		return
+	}
+	if invoice.ConsumerID != payer.ID {
+		respondWithError(w, http.StatusNotFound, "Invoice not found")
+		return
+	}
+
 	if invoice.PDFURL == "" {
 		respondWithError(w, http.StatusNotFound, "Invoice PDF not available")
 		return
@@ -776,6 +791,91 @@ func (h *BillingHandler) generateAPIKey(consumerID, apiID string) (map[string]in
 }
 
 // Helper functions
+// OrganizationHeader names the organization a request acts for. Without it,
+// requests act for the caller's personal organization.
+const OrganizationHeader = "X-Organization-ID"
+
+// requireMembership resolves the caller and their membership of an
+// organization: organizationID when given, otherwise the one named by the
+// X-Organization-ID header. It responds with an error and returns false if
+// the caller is not a member.
+func (h *BillingHandler) requireMembership(w http.ResponseWriter, r *http.Request, organizationID string) (*store.Consumer, *store.Membership, bool) {
+	userContext, err := middleware.GetUserContext(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return nil, nil, false
+	}
+
+	consumer, err := h.consumerStore.GetByCognitoID(userContext.CognitoUserID)
+	if err != nil || consumer == nil {
+		respondWithError(w, http.StatusNotFound, "Consumer not found")
+		return nil, nil, false
+	}
+
+	if organizationID == "" {
+		organizationID = r.Header.Get(OrganizationHeader)
+	}
+
+	membership, err := h.orgStore.GetMembership(organizationID, consumer.ID)
+	if errors.Is(err, store.ErrNotMember) {
+		respondWithError(w, http.StatusNotFound, "Organization not found")
+		return nil, nil, false
+	}
+	if err != nil {
+		log.Printf("Error retrieving membership: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving organization")
+		return nil, nil, false
+	}
+
+	return consumer, membership, true
+}
+
+// requireBillingRole checks the caller may manage billing for an
+// organization, responding with an error if not
+func (h *BillingHandler) requireBillingRole(w http.ResponseWriter, r *http.Request, organizationID string) bool {
+	_, membership, ok := h.requireMembership(w, r, organizationID)
+	if !ok {
+		return false
+	}
+	if !membership.CanManageBilling() {
+		respondWithError(w, http.StatusForbidden, "Your role cannot manage billing for this organization")
+		return false
+	}
+	return true
+}
+
+// requirePayer returns the consumer whose payment account pays for the
+// caller's organization, provided the caller may manage its billing
+func (h *BillingHandler) requirePayer(w http.ResponseWriter, r *http.Request) (*store.Consumer, bool) {
+	_, membership, ok := h.requireMembership(w, r, "")
+	if !ok {
+		return nil, false
+	}
+	if !membership.CanManageBilling() {
+		respondWithError(w, http.StatusForbidden, "Your role cannot manage billing for this organization")
+		return nil, false
+	}
+
+	payer, err := h.billingConsumer(membership)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving payment account")
+		return nil, false
+	}
+	return payer, true
+}
+
+// billingConsumer returns the member who pays for an organization
+func (h *BillingHandler) billingConsumer(membership *store.Membership) (*store.Consumer, error) {
+	payer, err := h.consumerStore.GetByID(membership.BillingConsumerID)
+	if err != nil {
+		return nil, err
+	}
+	if payer == nil {
+		return nil, fmt.Errorf("billing consumer %s not found", membership.BillingConsumerID)
+	}
+	return payer, nil
+}
+
 func respondWithError(w http.ResponseWriter, code int, message string) {
 	respondWithJSON(w, code, map[string]string{"error": message})
 }
@@ -9,6 +9,7 @@ import (
 	"os/signal"
 	"time"
 
+	"github.com/api-platform/billing-service/events"
 	"github.com/api-platform/billing-service/handlers"
 	"github.com/api-platform/billing-service/middleware"
 	"github.com/api-platform/billing-service/store"
@@ -78,6 +79,12 @@ func main() {
 	consumerStore := store.NewConsumerStore(db)
 	subscriptionStore := store.NewSubscriptionStore(db)
 	invoiceStore := store.NewInvoiceStore(db)
+	orgStore := store.NewOrganizationStore(db)
+
+	// Drop cached API key validations in the gateway when subscriptions change
+	publisher := events.NewPublisher(redisClient)
+	billingStore.Subscription.SetNotifier(publisher)
+	subscriptionStore.SetNotifier(publisher)
 
 	// Initialize handlers
 	billingHandler := handlers.NewBillingHandler(
@@ -85,6 +92,7 @@ func main() {
 		consumerStore,
 		subscriptionStore,
 		invoiceStore,
+		orgStore,
 		stripeClient,
 		redisClient,
 	)
@@ -159,7 +167,7 @@ func main() {
 	c := cors.New(cors.Options{
 		AllowedOrigins:   []string{"http://localhost:3000", "http://localhost:3001"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Authorization", "Content-Type"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type", "X-Organization-ID"},
 		AllowCredentials: true,
 	})
 
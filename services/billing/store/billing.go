@@ -44,6 +44,11 @@ type PricingPlan struct {
 	Features           map[string]interface{} `json:"features,omitempty"`
 	IsActive           bool                   `json:"is_active"`
 	StripePriceID      string                 `json:"stripe_price_id,omitempty"`
+
+	// OveragePolicy is what the gateway does once CallLimit is used up in a
+	// billing period: block, overage or throttle
+	OveragePolicy       string   `json:"overage_policy"`
+	OveragePricePerCall *float64 `json:"overage_price_per_call,omitempty"`
 }
 
 // PricingPlanStore handles pricing plan operations
@@ -62,7 +67,8 @@ func (s *PricingPlanStore) GetByID(id string) (*PricingPlan, error) {
 		SELECT 
 			id, api_id, name, type, price_per_call, monthly_price,
 			call_limit, rate_limit_per_minute, rate_limit_per_day,
-			rate_limit_per_month, features, is_active
+			rate_limit_per_month, features, is_active,
+			overage_policy, overage_price_per_call
 		FROM api_pricing_plans
 		WHERE id = $1
 	`
@@ -83,6 +89,8 @@ func (s *PricingPlanStore) GetByID(id string) (*PricingPlan, error) {
 		&plan.RateLimitPerMonth,
 		&features,
 		&plan.IsActive,
+		&plan.OveragePolicy,
+		&plan.OveragePricePerCall,
 	)
 	
 	if err == sql.ErrNoRows {
@@ -108,7 +116,8 @@ func (s *PricingPlanStore) ListByAPI(apiID string) ([]*PricingPlan, error) {
 		SELECT 
 			id, api_id, name, type, price_per_call, monthly_price,
 			call_limit, rate_limit_per_minute, rate_limit_per_day,
-			rate_limit_per_month, features, is_active
+			rate_limit_per_month, features, is_active,
+			overage_policy, overage_price_per_call
 		FROM api_pricing_plans
 		WHERE api_id = $1 AND is_active = true
 		ORDER BY monthly_price ASC NULLS FIRST
@@ -138,6 +147,8 @@ func (s *PricingPlanStore) ListByAPI(apiID string) ([]*PricingPlan, error) {
 			&plan.RateLimitPerMonth,
 			&features,
 			&plan.IsActive,
+			&plan.OveragePolicy,
+			&plan.OveragePricePerCall,
 		)
 		if err != nil {
 			return nil, err
@@ -175,6 +186,7 @@ func (s *PricingPlanStore) GetPricingPlanWithAPI(planID string) (map[string]inte
 			p.id, p.api_id, p.name, p.type, p.price_per_call, p.monthly_price,
 			p.call_limit, p.rate_limit_per_minute, p.rate_limit_per_day,
 			p.rate_limit_per_month, p.features, p.is_active,
+			p.overage_policy, p.overage_price_per_call,
 			a.name as api_name, a.user_id as creator_id
 		FROM api_pricing_plans p
 		JOIN apis a ON p.api_id = a.id
@@ -199,6 +211,8 @@ func (s *PricingPlanStore) GetPricingPlanWithAPI(planID string) (map[string]inte
 		&plan.RateLimitPerMonth,
 		&features,
 		&plan.IsActive,
+		&plan.OveragePolicy,
+		&plan.OveragePricePerCall,
 		&apiName,
 		&creatorID,
 	)
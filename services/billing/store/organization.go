@@ -0,0 +1,76 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Organization roles, managed by the API key service
+const (
+	RoleOwner     = "owner"
+	RoleAdmin     = "admin"
+	RoleDeveloper = "developer"
+	RoleBilling   = "billing"
+)
+
+// ErrNotMember is returned when a consumer is not a member of an organization
+var ErrNotMember = errors.New("not a member of the organization")
+
+// Membership is a consumer's role in an organization
+type Membership struct {
+	OrganizationID    string `json:"organization_id"`
+	Personal          bool   `json:"personal"`
+	BillingConsumerID string `json:"billing_consumer_id"`
+	ConsumerID        string `json:"consumer_id"`
+	Role              string `json:"role"`
+}
+
+// CanManageBilling reports whether the member may change the organization's
+// subscriptions and payment methods and see its invoices. Every member may
+// see its subscriptions.
+func (m *Membership) CanManageBilling() bool {
+	switch m.Role {
+	case RoleOwner, RoleAdmin, RoleBilling:
+		return true
+	}
+	return false
+}
+
+// OrganizationStore reads organization memberships
+type OrganizationStore struct {
+	db *sql.DB
+}
+
+// NewOrganizationStore creates a new organization store
+func NewOrganizationStore(db *sql.DB) *OrganizationStore {
+	return &OrganizationStore{db: db}
+}
+
+// GetMembership returns a consumer's membership of an organization. An
+// empty organizationID means the consumer's personal organization.
+func (s *OrganizationStore) GetMembership(organizationID, consumerID string) (*Membership, error) {
+	query := `
+		SELECT o.id, o.personal, o.billing_consumer_id, m.role
+		FROM organizations o
+		JOIN organization_members m ON m.organization_id = o.id
+		WHERE m.consumer_id = $2
+			AND (o.id::text = $1 OR ($1 = '' AND o.personal AND o.created_by = $2))
+	`
+
+	membership := &Membership{ConsumerID: consumerID}
+	err := s.db.QueryRow(query, organizationID, consumerID).Scan(
+		&membership.OrganizationID,
+		&membership.Personal,
+		&membership.BillingConsumerID,
+		&membership.Role,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotMember
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get membership: %w", err)
+	}
+
+	return membership, nil
+}
@@ -10,6 +10,7 @@ import (
 type Subscription struct {
 	ID                   string    `json:"id"`
 	ConsumerID           string    `json:"consumer_id"`
+	OrganizationID       string    `json:"organization_id"`
 	APIID                string    `json:"api_id"`
 	PricingPlanID        string    `json:"pricing_plan_id"`
 	APIKeyID             string    `json:"api_key_id"`
@@ -31,9 +32,15 @@ type SubscriptionWithDetails struct {
 	CallLimit     *int    `json:"call_limit,omitempty"`
 }
 
+// ChangeNotifier is told when a subscription's status or plan changes
+type ChangeNotifier interface {
+	SubscriptionChanged(subscriptionID string)
+}
+
 // SubscriptionStore handles subscription data operations
 type SubscriptionStore struct {
-	db *sql.DB
+	db       *sql.DB
+	notifier ChangeNotifier
 }
 
 // NewSubscriptionStore creates a new subscription store
@@ -41,14 +48,26 @@ func NewSubscriptionStore(db *sql.DB) *SubscriptionStore {
 	return &SubscriptionStore{db: db}
 }
 
+// SetNotifier registers a notifier for subscription changes
+func (s *SubscriptionStore) SetNotifier(notifier ChangeNotifier) {
+	s.notifier = notifier
+}
+
+// notifyChanged tells the notifier, if any, that a subscription changed
+func (s *SubscriptionStore) notifyChanged(id string) {
+	if s.notifier != nil {
+		s.notifier.SubscriptionChanged(id)
+	}
+}
+
 // Create creates a new subscription
 func (s *SubscriptionStore) Create(subscription *Subscription) error {
 	query := `
 		INSERT INTO subscriptions (
 			consumer_id, api_id, pricing_plan_id, api_key_id, 
-			stripe_subscription_id, status, expires_at
+			stripe_subscription_id, status, expires_at, organization_id
 		)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, '')::uuid)
 		RETURNING id, started_at
 	`
 	
@@ -61,6 +80,7 @@ func (s *SubscriptionStore) Create(subscription *Subscription) error {
 		subscription.StripeSubscriptionID,
 		subscription.Status,
 		subscription.ExpiresAt,
+		subscription.OrganizationID,
 	).Scan(&subscription.ID, &subscription.StartedAt)
 	
 	return err
@@ -70,7 +90,7 @@ func (s *SubscriptionStore) Create(subscription *Subscription) error {
 func (s *SubscriptionStore) GetByID(id string) (*Subscription, error) {
 	query := `
 		SELECT 
-			id, consumer_id, api_id, pricing_plan_id, api_key_id,
+			id, consumer_id, organization_id, api_id, pricing_plan_id, api_key_id,
 			stripe_subscription_id, status, started_at, cancelled_at, expires_at
 		FROM subscriptions
 		WHERE id = $1
@@ -80,6 +100,7 @@ func (s *SubscriptionStore) GetByID(id string) (*Subscription, error) {
 	err := s.db.QueryRow(query, id).Scan(
 		&subscription.ID,
 		&subscription.ConsumerID,
+		&subscription.OrganizationID,
 		&subscription.APIID,
 		&subscription.PricingPlanID,
 		&subscription.APIKeyID,
@@ -101,7 +122,7 @@ func (s *SubscriptionStore) GetByID(id string) (*Subscription, error) {
 func (s *SubscriptionStore) GetByStripeID(stripeID string) (*Subscription, error) {
 	query := `
 		SELECT 
-			id, consumer_id, api_id, pricing_plan_id, api_key_id,
+			id, consumer_id, organization_id, api_id, pricing_plan_id, api_key_id,
 			stripe_subscription_id, status, started_at, cancelled_at, expires_at
 		FROM subscriptions
 		WHERE stripe_subscription_id = $1
@@ -111,6 +132,7 @@ func (s *SubscriptionStore) GetByStripeID(stripeID string) (*Subscription, error
 	err := s.db.QueryRow(query, stripeID).Scan(
 		&subscription.ID,
 		&subscription.ConsumerID,
+		&subscription.OrganizationID,
 		&subscription.APIID,
 		&subscription.PricingPlanID,
 		&subscription.APIKeyID,
@@ -128,22 +150,22 @@ func (s *SubscriptionStore) GetByStripeID(stripeID string) (*Subscription, error
 	return subscription, err
 }
 
-// ListByConsumer lists all subscriptions for a consumer
-func (s *SubscriptionStore) ListByConsumer(consumerID string) ([]*SubscriptionWithDetails, error) {
+// ListByOrganization lists all subscriptions owned by an organization
+func (s *SubscriptionStore) ListByOrganization(organizationID string) ([]*SubscriptionWithDetails, error) {
 	query := `
 		SELECT 
-			s.id, s.consumer_id, s.api_id, s.pricing_plan_id, s.api_key_id,
+			s.id, s.consumer_id, s.organization_id, s.api_id, s.pricing_plan_id, s.api_key_id,
 			s.stripe_subscription_id, s.status, s.started_at, s.cancelled_at, s.expires_at,
 			a.name as api_name, p.name as plan_name, p.type as plan_type,
 			p.monthly_price, p.price_per_call, p.call_limit
 		FROM subscriptions s
 		JOIN apis a ON s.api_id = a.id
 		JOIN api_pricing_plans p ON s.pricing_plan_id = p.id
-		WHERE s.consumer_id = $1
+		WHERE s.organization_id = $1
 		ORDER BY s.started_at DESC
 	`
 	
-	rows, err := s.db.Query(query, consumerID)
+	rows, err := s.db.Query(query, organizationID)
 	if err != nil {
 		return nil, err
 	}
@@ -155,6 +177,7 @@ func (s *SubscriptionStore) ListByConsumer(consumerID string) ([]*SubscriptionWi
 		err := rows.Scan(
 			&sub.ID,
 			&sub.ConsumerID,
+			&sub.OrganizationID,
 			&sub.APIID,
 			&sub.PricingPlanID,
 			&sub.APIKeyID,
@@ -183,7 +206,7 @@ func (s *SubscriptionStore) ListByConsumer(consumerID string) ([]*SubscriptionWi
 func (s *SubscriptionStore) ListByAPI(apiID string) ([]*Subscription, error) {
 	query := `
 		SELECT 
-			id, consumer_id, api_id, pricing_plan_id, api_key_id,
+			id, consumer_id, organization_id, api_id, pricing_plan_id, api_key_id,
 			stripe_subscription_id, status, started_at, cancelled_at, expires_at
 		FROM subscriptions
 		WHERE api_id = $1
@@ -202,6 +225,7 @@ func (s *SubscriptionStore) ListByAPI(apiID string) ([]*Subscription, error) {
 		err := rows.Scan(
 			&sub.ID,
 			&sub.ConsumerID,
+			&sub.OrganizationID,
 			&sub.APIID,
 			&sub.PricingPlanID,
 			&sub.APIKeyID,
@@ -242,8 +266,12 @@ func (s *SubscriptionStore) Update(subscription *Subscription) error {
 		subscription.CancelledAt,
 		subscription.ExpiresAt,
 	)
+	if err != nil {
+		return err
+	}
 	
-	return err
+	s.notifyChanged(subscription.ID)
+	return nil
 }
 
 // UpdateStatus updates the status of a subscription
@@ -255,7 +283,12 @@ func (s *SubscriptionStore) UpdateStatus(id, status string) error {
 	`
 	
 	_, err := s.db.Exec(query, id, status)
-	return err
+	if err != nil {
+		return err
+	}
+	
+	s.notifyChanged(id)
+	return nil
 }
 
 // Cancel cancels a subscription
@@ -267,7 +300,12 @@ func (s *SubscriptionStore) Cancel(id string, cancelledAt time.Time) error {
 	`
 	
 	_, err := s.db.Exec(query, id, cancelledAt)
-	return err
+	if err != nil {
+		return err
+	}
+	
+	s.notifyChanged(id)
+	return nil
 }
 
 // GetActiveCount gets the count of active subscriptions for an API
@@ -287,7 +325,7 @@ func (s *SubscriptionStore) GetActiveCount(apiID string) (int, error) {
 func (s *SubscriptionStore) GetActiveByPlan(planID string) ([]*Subscription, error) {
 	query := `
 		SELECT 
-			id, consumer_id, api_id, pricing_plan_id, api_key_id,
+			id, consumer_id, organization_id, api_id, pricing_plan_id, api_key_id,
 			stripe_subscription_id, status, started_at, cancelled_at, expires_at
 		FROM subscriptions
 		WHERE pricing_plan_id = $1 AND status = 'active'
@@ -306,6 +344,7 @@ func (s *SubscriptionStore) GetActiveByPlan(planID string) ([]*Subscription, err
 		err := rows.Scan(
 			&sub.ID,
 			&sub.ConsumerID,
+			&sub.OrganizationID,
 			&sub.APIID,
 			&sub.PricingPlanID,
 			&sub.APIKeyID,
@@ -324,16 +363,16 @@ func (s *SubscriptionStore) GetActiveByPlan(planID string) ([]*Subscription, err
 	return subscriptions, rows.Err()
 }
 
-// CheckExistingSubscription checks if a consumer already has an active subscription to an API
-func (s *SubscriptionStore) CheckExistingSubscription(consumerID, apiID string) (bool, error) {
+// CheckExistingSubscription checks if an organization already has an active subscription to an API
+func (s *SubscriptionStore) CheckExistingSubscription(organizationID, apiID string) (bool, error) {
 	query := `
 		SELECT COUNT(*)
 		FROM subscriptions
-		WHERE consumer_id = $1 AND api_id = $2 AND status IN ('active', 'trial')
+		WHERE organization_id = $1 AND api_id = $2 AND status IN ('active', 'trial')
 	`
 	
 	var count int
-	err := s.db.QueryRow(query, consumerID, apiID).Scan(&count)
+	err := s.db.QueryRow(query, organizationID, apiID).Scan(&count)
 	if err != nil {
 		return false, err
 	}
@@ -345,7 +384,7 @@ func (s *SubscriptionStore) CheckExistingSubscription(consumerID, apiID string)
 func (s *SubscriptionStore) GetWithDetails(id string) (*SubscriptionWithDetails, error) {
 	query := `
 		SELECT 
-			s.id, s.consumer_id, s.api_id, s.pricing_plan_id, s.api_key_id,
+			s.id, s.consumer_id, s.organization_id, s.api_id, s.pricing_plan_id, s.api_key_id,
 			s.stripe_subscription_id, s.status, s.started_at, s.cancelled_at, s.expires_at,
 			a.name as api_name, p.name as plan_name, p.type as plan_type,
 			p.monthly_price, p.price_per_call, p.call_limit
@@ -359,6 +398,7 @@ func (s *SubscriptionStore) GetWithDetails(id string) (*SubscriptionWithDetails,
 	err := s.db.QueryRow(query, id).Scan(
 		&sub.ID,
 		&sub.ConsumerID,
+		&sub.OrganizationID,
 		&sub.APIID,
 		&sub.PricingPlanID,
 		&sub.APIKeyID,
@@ -386,7 +426,7 @@ func (s *SubscriptionStore) GetWithDetails(id string) (*SubscriptionWithDetails,
 func (s *SubscriptionStore) GetExpiredSubscriptions() ([]*Subscription, error) {
 	query := `
 		SELECT 
-			id, consumer_id, api_id, pricing_plan_id, api_key_id,
+			id, consumer_id, organization_id, api_id, pricing_plan_id, api_key_id,
 			stripe_subscription_id, status, started_at, cancelled_at, expires_at
 		FROM subscriptions
 		WHERE status = 'active' AND expires_at < NOW()
@@ -404,6 +444,7 @@ func (s *SubscriptionStore) GetExpiredSubscriptions() ([]*Subscription, error) {
 		err := rows.Scan(
 			&sub.ID,
 			&sub.ConsumerID,
+			&sub.OrganizationID,
 			&sub.APIID,
 			&sub.PricingPlanID,
 			&sub.APIKeyID,
@@ -191,14 +191,26 @@ func (w *BillingWorker) fetchUsageFromMetering(subscriptionID string, start, end
 	}
 
 	var usage struct {
-		TotalCalls int64 `json:"total_calls"`
+		Summary struct {
+			TotalCalls    int64  `json:"total_calls"`
+			BillableCalls *int64 `json:"billable_calls"`
+			BillableUnits *int64 `json:"billable_units"`
+		} `json:"summary"`
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&usage); err != nil {
 		return 0, err
 	}
 
-	return usage.TotalCalls, nil
+	// Weighted endpoints are charged per unit. Cache hits the API doesn't
+	// charge for are excluded from billable units and calls.
+	if usage.Summary.BillableUnits != nil {
+		return *usage.Summary.BillableUnits, nil
+	}
+	if usage.Summary.BillableCalls != nil {
+		return *usage.Summary.BillableCalls, nil
+	}
+	return usage.Summary.TotalCalls, nil
 }
 
 // deactivateAPIKey calls the API key service to deactivate a key
@@ -8,21 +8,28 @@ import (
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/api-direct/services/deployment/auth"
 	"github.com/api-direct/services/deployment/k8s"
+	"github.com/api-direct/services/deployment/registry"
+	"github.com/api-direct/services/deployment/signing"
 )
 
 // DeployRequest represents a deployment request
 type DeployRequest struct {
-	APIId        string            `json:"api_id" binding:"required"`
-	Version      string            `json:"version" binding:"required"`
-	Runtime      string            `json:"runtime" binding:"required"`
-	CodeURL      string            `json:"code_url" binding:"required"`
-	Environment  map[string]string `json:"environment"`
-	Replicas     int32             `json:"replicas"`
-	Resources    ResourceSpec      `json:"resources"`
+	APIId       string             `json:"api_id" binding:"required"`
+	Creator     string             `json:"creator"`
+	APIName     string             `json:"api_name"`
+	Version     string             `json:"version" binding:"required"`
+	Runtime     string             `json:"runtime" binding:"required"`
+	CodeURL     string             `json:"code_url" binding:"required"`
+	Environment map[string]string  `json:"environment"`
+	Replicas    int32              `json:"replicas"`
+	Resources   ResourceSpec       `json:"resources"`
+	Settings    *registry.Settings `json:"settings"`
 }
 
 // ResourceSpec defines resource requirements
@@ -33,8 +40,25 @@ type ResourceSpec struct {
 	MemoryLimit   string `json:"memory_limit"`
 }
 
+// RegisterRouteRequest registers an externally hosted (BYOA) endpoint with the gateway
+type RegisterRouteRequest struct {
+	Creator   string             `json:"creator"`
+	APIName   string             `json:"api_name"`
+	Version   string             `json:"version" binding:"required"`
+	Upstreams []string           `json:"upstreams" binding:"required,min=1,dive,url"`
+	Settings  *registry.Settings `json:"settings"`
+}
+
+// RoutingRequest represents a request to split traffic between API versions
+type RoutingRequest struct {
+	Creator string           `json:"creator"`
+	APIName string           `json:"api_name"`
+	Splits  []registry.Split `json:"splits"`
+	Sticky  bool             `json:"sticky"`
+}
+
 // DeployAPI handles API deployment requests
-func DeployAPI(client *k8s.Client) gin.HandlerFunc {
+func DeployAPI(client *k8s.Client, routes *registry.Publisher, keyring *signing.Keyring) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		apiId := c.Param("apiId")
 		if apiId == "" {
@@ -50,6 +74,14 @@ func DeployAPI(client *k8s.Client) gin.HandlerFunc {
 
 		// Override API ID from path
 		req.APIId = apiId
+		if !signing.Supported(req.Settings) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported signing algorithm"})
+			return
+		}
+		creator, apiName, ok := routeName(c, req.Creator, req.APIName, req.APIId)
+		if !ok {
+			return
+		}
 
 		// Get user info from context
 		userId, _ := c.Get("user_id")
@@ -85,6 +117,10 @@ func DeployAPI(client *k8s.Client) gin.HandlerFunc {
 		config.Environment["API_ID"] = req.APIId
 		config.Environment["API_VERSION"] = req.Version
 		config.Environment["USER_ID"] = userId.(string)
+		if keyring != nil {
+			// Let the function verify that calls were signed by the gateway
+			keyring.Environment(config.Environment, creator, apiName)
+		}
 
 		// Deploy to Kubernetes
 		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Minute)
@@ -95,6 +131,21 @@ func DeployAPI(client *k8s.Client) gin.HandlerFunc {
 			return
 		}
 
+		// Point the gateway at the new deployment
+		route := registry.Route{
+			Creator:   creator,
+			APIName:   apiName,
+			Version:   req.Version,
+			Upstreams: []string{client.ServiceURL(req.APIId)},
+			Mode:      registry.ModeKubernetes,
+			APIID:     req.APIId,
+			Settings:  req.Settings,
+		}
+		if err := routes.Register(ctx, route); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to register API route: %v", err)})
+			return
+		}
+
 		// Generate API endpoint URL
 		endpoint := fmt.Sprintf("https://api.api-direct.io/apis/%s", req.APIId)
 
@@ -145,7 +196,7 @@ func GetDeploymentStatus(client *k8s.Client) gin.HandlerFunc {
 }
 
 // UndeployAPI removes a deployed API
-func UndeployAPI(client *k8s.Client) gin.HandlerFunc {
+func UndeployAPI(client *k8s.Client, routes *registry.Publisher) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		apiId := c.Param("apiId")
 		if apiId == "" {
@@ -155,6 +206,16 @@ func UndeployAPI(client *k8s.Client) gin.HandlerFunc {
 
 		// TODO: Verify user owns this API
 
+		// Stop routing traffic before tearing the deployment down
+		creator, apiName, ok := routeName(c, c.Query("creator"), c.Query("api_name"), apiId)
+		if !ok {
+			return
+		}
+		if err := routes.Deregister(c.Request.Context(), creator, apiName, c.Query("version")); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to deregister API route: %v", err)})
+			return
+		}
+
 		if err := client.DeleteDeployment(c.Request.Context(), apiId); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to undeploy API: %v", err)})
 			return
@@ -167,6 +228,98 @@ func UndeployAPI(client *k8s.Client) gin.HandlerFunc {
 	}
 }
 
+// RegisterRoute registers an API deployed outside the platform's cluster
+// (e.g. a BYOA endpoint) so the gateway can route to it
+func RegisterRoute(routes *registry.Publisher, keyring *signing.Keyring) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		apiId := c.Param("apiId")
+		if apiId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "API ID is required"})
+			return
+		}
+
+		var req RegisterRouteRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		if !signing.Supported(req.Settings) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported signing algorithm"})
+			return
+		}
+
+		// Routes are only ever registered under the caller, so the signing
+		// keys below are theirs
+		creator, apiName, ok := routeName(c, req.Creator, req.APIName, apiId)
+		if !ok {
+			return
+		}
+		route := registry.Route{
+			Creator:   creator,
+			APIName:   apiName,
+			Version:   req.Version,
+			Upstreams: req.Upstreams,
+			Mode:      registry.ModeBYOA,
+			APIID:     apiId,
+			Settings:  req.Settings,
+		}
+		if err := routes.Register(c.Request.Context(), route); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to register API route: %v", err)})
+			return
+		}
+
+		response := gin.H{
+			"message":   "API route registered",
+			"api_id":    apiId,
+			"version":   req.Version,
+			"upstreams": req.Upstreams,
+		}
+		if keyring != nil {
+			// Endpoints outside the platform need the keys to verify that
+			// calls were signed by the gateway
+			response["signing"] = keyring.Keys(route.Creator, route.APIName)
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// SetRouting splits an API's traffic between its registered versions, e.g.
+// to send a share of calls to a canary. An empty split list sends all
+// traffic to the latest version again.
+func SetRouting(routes *registry.Publisher) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		apiId := c.Param("apiId")
+		if apiId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "API ID is required"})
+			return
+		}
+
+		var req RoutingRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		rule := registry.RoutingRule{Splits: req.Splits, Sticky: req.Sticky}
+		creator, apiName, ok := routeName(c, req.Creator, req.APIName, apiId)
+		if !ok {
+			return
+		}
+		if err := routes.SetRouting(c.Request.Context(), creator, apiName, rule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Failed to update routing: %v", err)})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "API routing updated",
+			"api_id":  apiId,
+			"splits":  req.Splits,
+			"sticky":  req.Sticky,
+		})
+	}
+}
+
 // ScaleDeployment adjusts the number of replicas
 func ScaleDeployment(client *k8s.Client) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -332,6 +485,48 @@ func GetMetrics(client *k8s.Client) gin.HandlerFunc {
 
 // Helper functions
 
+// routeName returns the creator and API name a route is registered under,
+// rejecting names the gateway couldn't route to. The response has been
+// written when ok is false.
+func routeName(c *gin.Context, creator, apiName, apiId string) (string, string, bool) {
+	creator, ok := routeCreator(c, creator)
+	if !ok {
+		return "", "", false
+	}
+	apiName = routeAPIName(apiName, apiId)
+	if err := registry.ValidateName(creator, apiName); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid API route: %v", err)})
+		return "", "", false
+	}
+	return creator, apiName, true
+}
+
+// routeCreator returns the creator a route is registered under, which is
+// always the authenticated user. A request naming another creator is
+// rejected, as are unauthenticated ones; the response has been written when
+// ok is false.
+func routeCreator(c *gin.Context, creator string) (string, bool) {
+	user, ok := auth.GetUserFromContext(c)
+	if !ok || user == nil || user.Username == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return "", false
+	}
+	if creator != "" && !strings.EqualFold(creator, user.Username) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot manage another creator's APIs"})
+		return "", false
+	}
+	return user.Username, true
+}
+
+// routeAPIName returns the API name a route is registered under. The CLI
+// deploys with the API name as its ID, so that is the default.
+func routeAPIName(apiName, apiId string) string {
+	if apiName != "" {
+		return apiName
+	}
+	return apiId
+}
+
 func buildContainerImage(runtime, version string) string {
 	// In production, this would return the actual container image URL
 	// from ECR based on the runtime and version
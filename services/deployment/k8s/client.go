@@ -135,6 +135,11 @@ func (c *Client) DeleteDeployment(ctx context.Context, apiId string) error {
 	return nil
 }
 
+// ServiceURL returns the in-cluster URL of a deployed API's service
+func (c *Client) ServiceURL(apiId string) string {
+	return fmt.Sprintf("http://%s.%s.svc.cluster.local:80", apiId, c.namespace)
+}
+
 // ScaleDeployment adjusts the number of replicas
 func (c *Client) ScaleDeployment(ctx context.Context, apiId string, replicas int32) error {
 	deployment, err := c.clientset.AppsV1().Deployments(c.namespace).Get(ctx, apiId, metav1.GetOptions{})
@@ -10,9 +10,12 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
 	"github.com/api-direct/services/deployment/handlers"
 	"github.com/api-direct/services/deployment/k8s"
 	"github.com/api-direct/services/deployment/middleware"
+	"github.com/api-direct/services/deployment/registry"
+	"github.com/api-direct/services/deployment/signing"
 )
 
 func main() {
@@ -22,6 +25,29 @@ func main() {
 		port = "8081"
 	}
 
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		redisURL = "redis://localhost:6379"
+	}
+
+	// Initialize Redis client for the gateway route registry
+	opt, err := redis.ParseURL(redisURL)
+	if err != nil {
+		log.Fatalf("Failed to parse Redis URL: %v", err)
+	}
+	redisClient := redis.NewClient(opt)
+	defer redisClient.Close()
+
+	if err := redisClient.Ping(context.Background()).Err(); err != nil {
+		log.Fatalf("Failed to connect to Redis: %v", err)
+	}
+
+	routes := registry.NewPublisher(redisClient)
+
+	// Functions get the keys to verify gateway signatures when the gateway
+	// signs requests
+	keyring := signing.NewKeyring(os.Getenv("GATEWAY_SIGNING_KEY"))
+
 	// Initialize Kubernetes client
 	k8sClient, err := k8s.NewClient()
 	if err != nil {
@@ -48,9 +74,13 @@ func main() {
 	api.Use(middleware.AuthRequired())
 	{
 		// Deployment endpoints
-		api.POST("/deploy/:apiId", handlers.DeployAPI(k8sClient))
+		api.POST("/deploy/:apiId", handlers.DeployAPI(k8sClient, routes, keyring))
 		api.GET("/status/:apiId", handlers.GetDeploymentStatus(k8sClient))
-		api.DELETE("/deploy/:apiId", handlers.UndeployAPI(k8sClient))
+		api.DELETE("/deploy/:apiId", handlers.UndeployAPI(k8sClient, routes))
+
+		// Gateway routes for endpoints hosted outside the cluster (BYOA)
+		api.PUT("/routes/:apiId", handlers.RegisterRoute(routes, keyring))
+		api.PUT("/routing/:apiId", handlers.SetRouting(routes))
 		api.PUT("/scale/:apiId", handlers.ScaleDeployment(k8sClient))
 		
 		// Environment management
@@ -0,0 +1,202 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// routeKeyPrefix, routingKeyPrefix and updatesChannel must match the
+	// gateway's route registry
+	routeKeyPrefix   = "gateway:routes:"
+	routingKeyPrefix = "gateway:routing:"
+	updatesChannel   = "gateway:routes:updates"
+)
+
+// Deployment modes a route can be registered with
+const (
+	ModeKubernetes = "kubernetes"
+	ModeBYOA       = "byoa"
+)
+
+// SandboxVersion is the reserved version the gateway sends test mode calls
+// to. It is never selected for live traffic.
+const SandboxVersion = "sandbox"
+
+// Route describes where a single version of an API is served from
+type Route struct {
+	Creator   string    `json:"creator"`
+	APIName   string    `json:"api_name"`
+	Version   string    `json:"version"`
+	Upstreams []string  `json:"upstreams"`
+	Mode      string    `json:"mode"`
+	UpdatedAt time.Time `json:"updated_at"`
+	APIID     string    `json:"api_id,omitempty"`
+	Settings  *Settings `json:"settings,omitempty"`
+}
+
+// Settings are per-API overrides of the gateway's proxy defaults
+type Settings struct {
+	TimeoutMs        int        `json:"timeout_ms,omitempty"`
+	MaxRetries       *int       `json:"max_retries,omitempty"`
+	StreamTimeoutMs  int        `json:"stream_timeout_ms,omitempty"`
+	Cache            *CacheRule `json:"cache,omitempty"`
+	ValidateRequests bool       `json:"validate_requests,omitempty"`
+	Signing          string     `json:"signing,omitempty"`
+}
+
+// CacheRule opts an API into gateway response caching
+type CacheRule struct {
+	TTLSeconds   int      `json:"ttl_seconds,omitempty"`
+	VaryHeaders  []string `json:"vary_headers,omitempty"`
+	PerConsumer  bool     `json:"per_consumer,omitempty"`
+	BillableHits bool     `json:"billable_hits,omitempty"`
+}
+
+// Split sends a share of an API's traffic to one version
+type Split struct {
+	Version string `json:"version"`
+	Weight  int    `json:"weight"`
+}
+
+// RoutingRule splits unpinned traffic between versions of an API
+type RoutingRule struct {
+	Splits    []Split   `json:"splits"`
+	Sticky    bool      `json:"sticky,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Publisher writes routes to the registry read by the API gateway
+type Publisher struct {
+	client *redis.Client
+}
+
+// NewPublisher creates a new route publisher
+func NewPublisher(client *redis.Client) *Publisher {
+	return &Publisher{client: client}
+}
+
+// Register stores a route and notifies the gateways to reload it
+func (p *Publisher) Register(ctx context.Context, route Route) error {
+	if err := ValidateName(route.Creator, route.APIName); err != nil {
+		return err
+	}
+	if route.Version == "" {
+		return fmt.Errorf("version is required")
+	}
+	if len(route.Upstreams) == 0 {
+		return fmt.Errorf("at least one upstream is required")
+	}
+	route.UpdatedAt = time.Now().UTC()
+
+	data, err := json.Marshal(route)
+	if err != nil {
+		return fmt.Errorf("failed to marshal route: %w", err)
+	}
+
+	id := routeID(route.Creator, route.APIName)
+	pipe := p.client.TxPipeline()
+	pipe.HSet(ctx, routeKeyPrefix+id, route.Version, data)
+	pipe.Publish(ctx, updatesChannel, id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to register route: %w", err)
+	}
+	return nil
+}
+
+// Deregister removes a version of an API. An empty version removes every version.
+func (p *Publisher) Deregister(ctx context.Context, creator, apiName, version string) error {
+	if err := ValidateName(creator, apiName); err != nil {
+		return err
+	}
+	id := routeID(creator, apiName)
+	pipe := p.client.TxPipeline()
+	if version == "" {
+		pipe.Del(ctx, routeKeyPrefix+id, routingKeyPrefix+id)
+	} else {
+		pipe.HDel(ctx, routeKeyPrefix+id, version)
+	}
+	pipe.Publish(ctx, updatesChannel, id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to deregister route: %w", err)
+	}
+	return nil
+}
+
+// SetRouting stores how an API's traffic is split between its versions.
+// Every version in the rule must be registered. A rule without splits
+// removes it, sending traffic to the latest version.
+func (p *Publisher) SetRouting(ctx context.Context, creator, apiName string, rule RoutingRule) error {
+	if err := ValidateName(creator, apiName); err != nil {
+		return err
+	}
+	id := routeID(creator, apiName)
+
+	if len(rule.Splits) > 0 {
+		versions := make([]string, len(rule.Splits))
+		total := 0
+		for i, split := range rule.Splits {
+			if split.Version == "" || split.Weight < 0 {
+				return fmt.Errorf("splits need a version and a non-negative weight")
+			}
+			if split.Version == SandboxVersion {
+				return fmt.Errorf("the sandbox version can't receive live traffic")
+			}
+			versions[i] = split.Version
+			total += split.Weight
+		}
+		if total == 0 {
+			return fmt.Errorf("at least one split needs a positive weight")
+		}
+
+		registered, err := p.client.HMGet(ctx, routeKeyPrefix+id, versions...).Result()
+		if err != nil {
+			return fmt.Errorf("failed to load routes: %w", err)
+		}
+		for i, route := range registered {
+			if route == nil {
+				return fmt.Errorf("version %s is not registered", versions[i])
+			}
+		}
+	}
+
+	pipe := p.client.TxPipeline()
+	if len(rule.Splits) == 0 {
+		pipe.Del(ctx, routingKeyPrefix+id)
+	} else {
+		rule.UpdatedAt = time.Now().UTC()
+		data, err := json.Marshal(rule)
+		if err != nil {
+			return fmt.Errorf("failed to marshal routing rule: %w", err)
+		}
+		pipe.Set(ctx, routingKeyPrefix+id, data, 0)
+	}
+	pipe.Publish(ctx, updatesChannel, id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to store routing rule: %w", err)
+	}
+	return nil
+}
+
+// ValidateName checks the creator and API name a route is stored under.
+// They are the gateway's /api/:creator/:apiName path segments, so neither
+// may be empty or contain a slash, and the API name can't contain the @
+// that pins a version.
+func ValidateName(creator, apiName string) error {
+	if creator == "" || apiName == "" {
+		return fmt.Errorf("creator and api name are required")
+	}
+	if strings.Contains(creator, "/") || strings.ContainsAny(apiName, "/@") {
+		return fmt.Errorf("creator and api name can't contain a slash, nor the api name an @")
+	}
+	return nil
+}
+
+func routeID(creator, apiName string) string {
+	return strings.ToLower(creator) + "/" + strings.ToLower(apiName)
+}
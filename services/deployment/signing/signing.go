@@ -0,0 +1,78 @@
+// Package signing derives the keys creator functions use to verify that
+// requests were signed by the gateway. The derivation must match the
+// gateway's signing package.
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+
+	"github.com/api-direct/services/deployment/registry"
+)
+
+// Environment variables the keys are passed to functions in
+const (
+	SecretEnv    = "APIDIRECT_SIGNING_SECRET"
+	PublicKeyEnv = "APIDIRECT_SIGNING_PUBLIC_KEY"
+)
+
+// Supported reports whether a route's signing setting is one the gateway
+// can sign with
+func Supported(settings *registry.Settings) bool {
+	if settings == nil {
+		return true
+	}
+	switch settings.Signing {
+	case "", "hmac-sha256", "ed25519":
+		return true
+	}
+	return false
+}
+
+// Keys are the verification keys of one API, base64 encoded
+type Keys struct {
+	// Secret verifies hmac-sha256 signatures
+	Secret string `json:"secret"`
+	// PublicKey verifies ed25519 signatures
+	PublicKey string `json:"public_key"`
+}
+
+// Keyring derives per-API keys from the gateway's master signing key
+type Keyring struct {
+	key []byte
+}
+
+// NewKeyring returns a keyring for the master key, or nil if it's empty
+func NewKeyring(key string) *Keyring {
+	if key == "" {
+		return nil
+	}
+	return &Keyring{key: []byte(key)}
+}
+
+// Keys returns the keys of creator/apiName
+func (k *Keyring) Keys(creator, apiName string) Keys {
+	api := strings.ToLower(creator + "/" + apiName)
+	seed := k.derive("ed25519", api)
+	publicKey := ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey)
+	return Keys{
+		Secret:    base64.StdEncoding.EncodeToString(k.derive("hmac-sha256", api)),
+		PublicKey: base64.StdEncoding.EncodeToString(publicKey),
+	}
+}
+
+// Environment adds the keys of creator/apiName to a function's environment
+func (k *Keyring) Environment(env map[string]string, creator, apiName string) {
+	keys := k.Keys(creator, apiName)
+	env[SecretEnv] = keys.Secret
+	env[PublicKeyEnv] = keys.PublicKey
+}
+
+func (k *Keyring) derive(algorithm, api string) []byte {
+	mac := hmac.New(sha256.New, k.key)
+	mac.Write([]byte("apidirect-signing/" + algorithm + "/" + api))
+	return mac.Sum(nil)
+}
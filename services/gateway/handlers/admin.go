@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/api-direct/services/gateway/proxy"
+	"github.com/api-direct/services/gateway/signing"
+)
+
+// ListCircuits returns the circuit breaker state of every upstream
+func ListCircuits(proxyHandler *proxy.Handler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"circuits": proxyHandler.Circuits(),
+		})
+	}
+}
+
+// ResetCircuit manually closes an upstream's circuit
+func ResetCircuit(proxyHandler *proxy.Handler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Upstream string `json:"upstream" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "upstream is required",
+				"code":  "INVALID_REQUEST",
+			})
+			return
+		}
+
+		if !proxyHandler.ResetCircuit(req.Upstream) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Unknown upstream",
+				"code":  "UPSTREAM_NOT_FOUND",
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":  "Circuit reset",
+			"upstream": req.Upstream,
+		})
+	}
+}
+
+// SigningKeys returns the keys an API's upstream verifies gateway signatures
+// with, for creators who deploy outside the platform
+func SigningKeys(signer *signing.Signer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		api := c.Param("creator") + "/" + c.Param("apiName")
+		c.JSON(http.StatusOK, gin.H{
+			"api":        api,
+			"algorithm":  signer.Algorithm(),
+			"secret":     base64.StdEncoding.EncodeToString(signer.Secret(api)),
+			"public_key": base64.StdEncoding.EncodeToString(signer.PublicKey(api)),
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/api-direct/services/gateway/proxy"
+	"github.com/api-direct/services/gateway/registry"
+	"github.com/api-direct/services/gateway/respcache"
+	"github.com/gin-gonic/gin"
+)
+
+// proxyWithCache serves GET and HEAD requests for APIs with a cache rule from
+// the response cache, falling back to the upstream and storing what it
+// returns when its Cache-Control allows
+func proxyWithCache(c *gin.Context, proxyHandler *proxy.Handler, store respcache.Store, route *registry.Route) {
+	rule := route.CacheRule()
+	method := c.Request.Method
+	if store == nil || rule == nil || (method != http.MethodGet && method != http.MethodHead) {
+		proxyHandler.ProxyRequest(c, route)
+		return
+	}
+
+	skipLookup, skipStore := respcache.RequestBypass(c.Request)
+	key := respcache.Key(cacheScope(c, route, rule), c.Request, rule.VaryHeaders)
+
+	if !skipLookup {
+		if entry, ok := store.Get(c.Request.Context(), key); ok {
+			// Hits are metered separately; the API's rule decides if they're billed
+			c.Set("cache_hit", true)
+			c.Set("billable", rule.BillableHits)
+			c.Set("upstream", "cache")
+			serveCached(c, entry)
+			return
+		}
+	}
+
+	c.Header("X-Cache", "MISS")
+	capture := &captureWriter{ResponseWriter: c.Writer}
+	c.Writer = capture
+	proxyHandler.ProxyRequest(c, route)
+	c.Writer = capture.ResponseWriter
+
+	if skipStore || method != http.MethodGet || !capture.complete() {
+		return
+	}
+
+	header := respcache.StorableHeader(capture.Header())
+	now := time.Now()
+	defaultTTL := time.Duration(rule.TTLSeconds) * time.Second
+	ttl, ok := respcache.TTL(capture.Status(), header, defaultTTL, rule.VaryHeaders, now)
+	if !ok {
+		return
+	}
+	store.Set(c.Request.Context(), key, &respcache.Entry{
+		Status:   capture.Status(),
+		Header:   header,
+		Body:     capture.body.Bytes(),
+		StoredAt: now,
+		Expires:  now.Add(ttl),
+	})
+}
+
+// cacheScope identifies whose responses an entry may be served to. The
+// version is included so a new deployment never serves the old one's responses.
+func cacheScope(c *gin.Context, route *registry.Route, rule *registry.CacheRule) string {
+	scope := route.Creator + "/" + route.APIName + "@" + route.Version
+	if rule.PerConsumer {
+		consumerID, _ := c.Get("consumer_id")
+		consumerIDStr, _ := consumerID.(string)
+		scope += "#" + consumerIDStr
+	}
+	return scope
+}
+
+// serveCached writes a cached response, answering conditional requests that
+// match its validators with 304 Not Modified
+func serveCached(c *gin.Context, entry *respcache.Entry) {
+	header := c.Writer.Header()
+	for name, values := range entry.Header {
+		header[name] = append([]string(nil), values...)
+	}
+	age := int(time.Since(entry.StoredAt).Seconds())
+	header.Set("Age", strconv.Itoa(age))
+	header.Set("X-Cache", "HIT")
+
+	if respcache.NotModified(c.Request, entry) {
+		header.Del("Content-Length")
+		c.Status(http.StatusNotModified)
+		c.Writer.WriteHeaderNow()
+		return
+	}
+
+	c.Status(entry.Status)
+	if c.Request.Method == http.MethodHead {
+		c.Writer.WriteHeaderNow()
+		return
+	}
+	c.Writer.Write(entry.Body)
+}
+
+// captureWriter keeps a copy of the response body for the cache while
+// passing everything through to the client
+type captureWriter struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	tooLarge bool
+	flushes  int
+	hijacked bool
+}
+
+func (w *captureWriter) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *captureWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *captureWriter) Flush() {
+	w.flushes++
+	w.ResponseWriter.Flush()
+}
+
+func (w *captureWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.hijacked = true
+	return w.ResponseWriter.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying connection
+func (w *captureWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *captureWriter) capture(data []byte) {
+	if w.tooLarge {
+		return
+	}
+	if w.body.Len()+len(data) > respcache.MaxBodyBytes {
+		w.tooLarge = true
+		w.body = bytes.Buffer{}
+		return
+	}
+	w.body.Write(data)
+}
+
+// complete reports whether the whole response was captured. Streams and
+// upgraded connections are never cached.
+func (w *captureWriter) complete() bool {
+	if w.tooLarge || w.hijacked || w.flushes > 1 {
+		return false
+	}
+	return !strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream")
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/api-direct/services/gateway/proxy"
+	"github.com/api-direct/services/gateway/registry"
+	"github.com/api-direct/services/gateway/respcache"
+	"github.com/gin-gonic/gin"
+)
+
+// newCachingRouter proxies every request to route through the response
+// cache, recording whether the gateway metered it as a cache hit
+func newCachingRouter(route *registry.Route, hits *int64) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	proxyHandler := proxy.NewHandler("", nil, proxy.Config{})
+	store := respcache.NewMemoryStore(0)
+
+	router := gin.New()
+	router.Any("/api/:creator/:apiName/*path", func(c *gin.Context) {
+		c.Set("consumer_id", c.GetHeader("X-Test-Consumer"))
+		proxyWithCache(c, proxyHandler, store, route)
+		if c.GetBool("cache_hit") {
+			atomic.AddInt64(hits, 1)
+		}
+	})
+	return router
+}
+
+func get(router *gin.Engine, path string, header http.Header) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestResponseCacheServesHitsAndConditionalRequests(t *testing.T) {
+	var calls int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("forecast"))
+	}))
+	defer upstream.Close()
+
+	var hits int64
+	route := &registry.Route{
+		Creator:   "alice",
+		APIName:   "weather",
+		Upstreams: []string{upstream.URL},
+		Settings:  &registry.Settings{Cache: &registry.CacheRule{}},
+	}
+	router := newCachingRouter(route, &hits)
+
+	if w := get(router, "/api/alice/weather/today?b=2&a=1", nil); w.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("first request X-Cache = %q", w.Header().Get("X-Cache"))
+	}
+
+	w := get(router, "/api/alice/weather/today?a=1&b=2", nil)
+	if w.Header().Get("X-Cache") != "HIT" || w.Body.String() != "forecast" {
+		t.Fatalf("expected hit, got %q %q", w.Header().Get("X-Cache"), w.Body.String())
+	}
+	if w.Header().Get("Age") == "" {
+		t.Error("hit is missing Age header")
+	}
+
+	w = get(router, "/api/alice/weather/today?a=1&b=2", http.Header{"If-None-Match": {`W/"v1"`}})
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("conditional request got %d %q", w.Code, w.Body.String())
+	}
+
+	// no-cache revalidates with the upstream
+	get(router, "/api/alice/weather/today?a=1&b=2", http.Header{"Cache-Control": {"no-cache"}})
+
+	if calls != 2 {
+		t.Errorf("upstream called %d times, want 2", calls)
+	}
+	if hits != 2 {
+		t.Errorf("metered %d cache hits, want 2", hits)
+	}
+}
+
+func TestResponseCacheHonorsUpstreamAndVaryHeaders(t *testing.T) {
+	var calls int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		if r.URL.Path == "/private" {
+			w.Header().Set("Cache-Control", "private, max-age=60")
+		}
+		w.Header().Set("Vary", "Accept-Language")
+		w.Write([]byte(r.Header.Get("Accept-Language")))
+	}))
+	defer upstream.Close()
+
+	var hits int64
+	route := &registry.Route{
+		Creator:   "alice",
+		APIName:   "weather",
+		Upstreams: []string{upstream.URL},
+		Settings: &registry.Settings{Cache: &registry.CacheRule{
+			TTLSeconds:  30,
+			VaryHeaders: []string{"Accept-Language"},
+		}},
+	}
+	router := newCachingRouter(route, &hits)
+
+	get(router, "/api/alice/weather/private", nil)
+	get(router, "/api/alice/weather/private", nil)
+	if calls != 2 {
+		t.Fatalf("private response was cached")
+	}
+
+	english := http.Header{"Accept-Language": {"en"}}
+	french := http.Header{"Accept-Language": {"fr"}}
+	get(router, "/api/alice/weather/today", english)
+	get(router, "/api/alice/weather/today", french)
+	if w := get(router, "/api/alice/weather/today", french); w.Body.String() != "fr" || w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("expected cached fr response, got %q %q", w.Header().Get("X-Cache"), w.Body.String())
+	}
+	if calls != 4 {
+		t.Errorf("upstream called %d times, want 4", calls)
+	}
+}
+
+func TestResponseCacheHitsCarryTheCallersQuota(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		w.Write([]byte("forecast"))
+	}))
+	defer upstream.Close()
+
+	gin.SetMode(gin.TestMode)
+	proxyHandler := proxy.NewHandler("", nil, proxy.Config{})
+	store := respcache.NewMemoryStore(0)
+	route := &registry.Route{
+		Creator:   "alice",
+		APIName:   "weather",
+		Upstreams: []string{upstream.URL},
+		Settings:  &registry.Settings{Cache: &registry.CacheRule{}},
+	}
+
+	// The quota middleware sets the caller's quota headers before proxying
+	router := gin.New()
+	router.Any("/api/:creator/:apiName/*path", func(c *gin.Context) {
+		c.Header("X-Quota-Remaining", c.GetHeader("X-Test-Remaining"))
+		proxyWithCache(c, proxyHandler, store, route)
+	})
+
+	get(router, "/api/alice/weather/today", http.Header{"X-Test-Remaining": {"900"}})
+	w := get(router, "/api/alice/weather/today", http.Header{"X-Test-Remaining": {"12"}})
+	if w.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("X-Cache = %q, want HIT", w.Header().Get("X-Cache"))
+	}
+	if got := w.Header().Values("X-Quota-Remaining"); len(got) != 1 || got[0] != "12" {
+		t.Errorf("X-Quota-Remaining = %v, want the caller's 12", got)
+	}
+}
@@ -1,15 +1,22 @@
 package handlers
 
 import (
-	"fmt"
+	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/api-direct/services/gateway/proxy"
+	"github.com/api-direct/services/gateway/registry"
+	"github.com/api-direct/services/gateway/respcache"
+	"github.com/api-direct/services/gateway/validation"
 )
 
-// ProxyToFunction handles proxying requests to creator functions
-func ProxyToFunction(proxyHandler *proxy.Handler) gin.HandlerFunc {
+// ProxyToFunction handles proxying requests to creator functions. APIs with a
+// cache rule are served through responseCache when it isn't nil, and APIs that
+// opted in to validation are checked against the spec loaded by specs. Calls
+// with test mode keys go to the API's sandbox instead.
+func ProxyToFunction(proxyHandler *proxy.Handler, responseCache respcache.Store, specs *validation.Loader) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get creator and API name from URL
 		creator := c.Param("creator")
@@ -35,23 +42,53 @@ func ProxyToFunction(proxyHandler *proxy.Handler) gin.HandlerFunc {
 			}
 		}
 		
-		// Get the target URL for the creator's function
-		targetURL, err := proxyHandler.GetCreatorFunctionURL(creator, apiName)
+		// Consumers can pin a version with apiName@version or X-API-Version;
+		// otherwise the API's routing rule picks one, per API key if sticky
+		apiName, version, _ := strings.Cut(apiName, "@")
+		if version == "" {
+			version = c.GetHeader("X-API-Version")
+		}
+		apiKeyID := c.GetString("api_key_id")
+
+		// Test mode keys are served by the API's sandbox
+		if c.GetBool("test_mode") {
+			serveSandbox(c, proxyHandler, specs, creator, apiName)
+			return
+		}
+
+		// Look up where the creator's function is deployed
+		route, err := proxyHandler.SelectRoute(c.Request.Context(), creator, apiName, version, apiKeyID)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
+			if errors.Is(err, registry.ErrVersionNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{
+					"error": "API version is not deployed",
+					"code":  "VERSION_NOT_FOUND",
+				})
+				return
+			}
+			if errors.Is(err, registry.ErrRouteNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{
+					"error": "API is not deployed",
+					"code":  "ROUTE_NOT_FOUND",
+				})
+				return
+			}
+			c.JSON(http.StatusServiceUnavailable, gin.H{
 				"error": "Failed to determine target URL",
 				"code":  "TARGET_URL_ERROR",
 				"details": err.Error(),
 			})
 			return
 		}
-		
-		// Add debugging information in development
-		if gin.Mode() == gin.DebugMode {
-			fmt.Printf("Proxying request to: %s\n", targetURL)
+		c.Set("api_version", route.Version)
+		c.Header("X-API-Version", route.Version)
+
+		// Reject requests that don't match the API's OpenAPI document
+		if !validateRequest(c, specs, route) {
+			return
 		}
 		
 		// Proxy the request
-		proxyHandler.ProxyRequest(c, targetURL)
+		proxyWithCache(c, proxyHandler, responseCache, route)
 	}
 }
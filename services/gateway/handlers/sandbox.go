@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/api-direct/services/gateway/proxy"
+	"github.com/api-direct/services/gateway/registry"
+	"github.com/api-direct/services/gateway/validation"
+	"github.com/gin-gonic/gin"
+)
+
+// serveSandbox answers a test mode call. APIs with a sandbox deployment are
+// proxied to it; otherwise the call is answered with the example response
+// documented in the API's OpenAPI document. Test mode calls never reach the
+// live deployment and are never cached.
+func serveSandbox(c *gin.Context, proxyHandler *proxy.Handler, specs *validation.Loader, creator, apiName string) {
+	c.Set("api_version", registry.SandboxVersion)
+	c.Header("X-API-Version", registry.SandboxVersion)
+
+	route, err := proxyHandler.SandboxRoute(c.Request.Context(), creator, apiName)
+	if err == nil {
+		c.Header("X-Sandbox", "deployment")
+		if validateRequest(c, specs, route) {
+			proxyHandler.ProxyRequest(c, route)
+		}
+		return
+	}
+	if !errors.Is(err, registry.ErrRouteNotFound) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error":   "Failed to determine target URL",
+			"code":    "TARGET_URL_ERROR",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	serveExample(c, specs)
+}
+
+// serveExample answers a test mode call from the API's spec. Mocked calls
+// are always validated, since there is no upstream to reject them.
+func serveExample(c *gin.Context, specs *validation.Loader) {
+	apiID := c.GetString("api_id")
+	if specs == nil || apiID == "" {
+		sandboxUnavailable(c)
+		return
+	}
+
+	ctx := c.Request.Context()
+	validator, err := specs.Validator(ctx, apiID)
+	if err != nil {
+		if !errors.Is(err, validation.ErrNoSpec) {
+			log.Printf("Sandbox unavailable for API %s: %v", apiID, err)
+		}
+		sandboxUnavailable(c)
+		return
+	}
+
+	spec := &registry.Route{
+		APIID:    apiID,
+		Settings: &registry.Settings{ValidateRequests: true},
+	}
+	if !validateRequest(c, specs, spec) {
+		return
+	}
+
+	// Specs describe paths relative to the API root
+	req := c.Request.Clone(ctx)
+	req.URL.Path = c.Param("path")
+	req.URL.RawPath = ""
+
+	example, err := validator.Example(req)
+	if err != nil {
+		if !errors.Is(err, validation.ErrNoExample) {
+			log.Printf("Sandbox example lookup failed for API %s: %v", apiID, err)
+		}
+		c.JSON(http.StatusNotImplemented, gin.H{
+			"error": "No example response is documented for this endpoint",
+			"code":  "SANDBOX_NO_EXAMPLE",
+		})
+		return
+	}
+
+	c.Set("upstream", "sandbox")
+	c.Header("X-Sandbox", "mock")
+	if example.ContentType == "" {
+		c.Status(example.Status)
+		return
+	}
+	c.Data(example.Status, example.ContentType, example.Body)
+}
+
+func sandboxUnavailable(c *gin.Context) {
+	c.JSON(http.StatusNotImplemented, gin.H{
+		"error": "This API has no sandbox deployment or documented examples for test mode",
+		"code":  "SANDBOX_UNAVAILABLE",
+	})
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/api-direct/services/gateway/proxy"
+	"github.com/api-direct/services/gateway/registry"
+	"github.com/api-direct/services/gateway/validation"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+const forecastSpec = `{
+  "openapi": "3.0.0",
+  "info": {"title": "Weather", "version": "1.0.0"},
+  "paths": {
+    "/forecast": {
+      "get": {
+        "parameters": [{"name": "city", "in": "query", "required": true, "schema": {"type": "string"}}],
+        "responses": {
+          "200": {
+            "description": "OK",
+            "content": {"application/json": {"example": {"city": "london", "temp": 12}}}
+          },
+          "400": {"description": "Bad request"}
+        }
+      }
+    },
+    "/alerts": {
+      "get": {"responses": {"200": {
+        "description": "OK",
+        "content": {"application/json": {"schema": {"type": "array", "items": {"type": "string"}}}}
+      }}}
+    }
+  }
+}`
+
+func TestTestModeCallsAreServedBySandbox(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+
+	var live, sandbox int
+	liveUpstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		live++
+	}))
+	defer liveUpstream.Close()
+	sandboxUpstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sandbox++
+		w.Write([]byte(`{"sandbox": true}`))
+	}))
+	defer sandboxUpstream.Close()
+
+	marketplace := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"has_openapi": true, "openapi_spec": ` + forecastSpec + `}`))
+	}))
+	defer marketplace.Close()
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	routes := registry.NewRegistry(client, time.Minute)
+	for _, route := range []*registry.Route{
+		{Creator: "alice", APIName: "weather", Version: "v1", Upstreams: []string{liveUpstream.URL}},
+		{Creator: "alice", APIName: "deployed", Version: "v1", Upstreams: []string{liveUpstream.URL}},
+		{Creator: "alice", APIName: "deployed", Version: registry.SandboxVersion, Upstreams: []string{sandboxUpstream.URL}},
+	} {
+		if err := routes.Register(ctx, route); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	proxyHandler := proxy.NewHandler("", routes, proxy.Config{})
+	specs := validation.NewLoader(marketplace.URL, time.Minute)
+
+	var billable []bool
+	router := gin.New()
+	router.GET("/api/:creator/:apiName/*path", func(c *gin.Context) {
+		c.Set("api_id", "api-1")
+		c.Set("test_mode", true)
+		c.Set("billable", false)
+		c.Next()
+		billable = append(billable, c.GetBool("billable"))
+	}, ProxyToFunction(proxyHandler, nil, specs))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	// APIs without a sandbox deployment answer from the spec's examples
+	w := get("/api/alice/weather/forecast?city=london")
+	if w.Code != http.StatusOK || w.Header().Get("X-Sandbox") != "mock" {
+		t.Fatalf("mock status = %d, sandbox = %q", w.Code, w.Header().Get("X-Sandbox"))
+	}
+	if body := strings.TrimSpace(w.Body.String()); body != `{"city":"london","temp":12}` {
+		t.Errorf("mock body = %s", body)
+	}
+	if w := get("/api/alice/weather/forecast"); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid mocked request status = %d, want 400", w.Code)
+	}
+	if w := get("/api/alice/weather/alerts"); w.Code != http.StatusNotImplemented {
+		t.Errorf("undocumented example status = %d, want 501", w.Code)
+	}
+
+	// APIs with a sandbox deployment are proxied to it
+	w = get("/api/alice/deployed/forecast?city=london")
+	if w.Code != http.StatusOK || w.Header().Get("X-API-Version") != registry.SandboxVersion {
+		t.Fatalf("sandbox status = %d, version = %q", w.Code, w.Header().Get("X-API-Version"))
+	}
+	if sandbox != 1 || live != 0 {
+		t.Errorf("sandbox calls = %d, live calls = %d", sandbox, live)
+	}
+
+	for i, b := range billable {
+		if b {
+			t.Errorf("test mode call %d was billable", i)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/api-direct/services/gateway/registry"
+	"github.com/api-direct/services/gateway/validation"
+	"github.com/gin-gonic/gin"
+)
+
+// validateRequest checks the request against the API's OpenAPI document for
+// routes that opted in, and rejects it if it doesn't conform. Rejected calls
+// are not billed. It reports whether the request should be proxied.
+func validateRequest(c *gin.Context, specs *validation.Loader, route *registry.Route) bool {
+	if specs == nil || !route.ValidatesRequests() {
+		return true
+	}
+
+	ctx := c.Request.Context()
+	validator, err := specs.Validator(ctx, route.APIID)
+	if err != nil {
+		// Forward unvalidated rather than failing calls the API would accept
+		if !errors.Is(err, validation.ErrNoSpec) {
+			log.Printf("Request validation unavailable for API %s: %v", route.APIID, err)
+		}
+		return true
+	}
+
+	// Specs describe paths relative to the API root
+	req := c.Request.Clone(ctx)
+	req.URL.Path = c.Param("path")
+	req.URL.RawPath = ""
+
+	fieldErrors, err := validator.Validate(ctx, req)
+	// The validator reads the body and leaves a fresh copy on req
+	c.Request.Body = req.Body
+
+	switch {
+	case errors.Is(err, validation.ErrPathNotFound):
+		c.Set("billable", false)
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Endpoint is not documented by this API",
+			"code":  "ENDPOINT_NOT_FOUND",
+		})
+		return false
+	case errors.Is(err, validation.ErrMethodNotAllowed):
+		c.Set("billable", false)
+		c.JSON(http.StatusMethodNotAllowed, gin.H{
+			"error": "Method is not documented for this endpoint",
+			"code":  "METHOD_NOT_ALLOWED",
+		})
+		return false
+	case err != nil:
+		log.Printf("Request validation failed for API %s: %v", route.APIID, err)
+		return true
+	case len(fieldErrors) > 0:
+		c.Set("billable", false)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Request does not match the API specification",
+			"code":    "REQUEST_VALIDATION_FAILED",
+			"details": fieldErrors,
+		})
+		return false
+	}
+	return true
+}
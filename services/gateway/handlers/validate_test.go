@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/api-direct/services/gateway/proxy"
+	"github.com/api-direct/services/gateway/registry"
+	"github.com/api-direct/services/gateway/validation"
+	"github.com/gin-gonic/gin"
+)
+
+const alertsSpec = `{
+  "openapi": "3.0.0",
+  "info": {"title": "Alerts", "version": "1.0.0"},
+  "paths": {
+    "/alerts": {
+      "post": {
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {
+            "type": "object",
+            "required": ["city"],
+            "properties": {"city": {"type": "string"}}
+          }}}
+        },
+        "responses": {"201": {"description": "Created"}}
+      }
+    }
+  }
+}`
+
+func TestInvalidRequestsAreRejectedBeforeTheUpstream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var forwarded []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		forwarded = append(forwarded, string(body))
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer upstream.Close()
+
+	marketplace := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"api_id": "api-1", "has_openapi": true, "openapi_spec": ` + alertsSpec + `}`))
+	}))
+	defer marketplace.Close()
+
+	route := &registry.Route{
+		APIID:     "api-1",
+		Upstreams: []string{upstream.URL},
+		Settings:  &registry.Settings{ValidateRequests: true},
+	}
+	proxyHandler := proxy.NewHandler("", nil, proxy.Config{})
+	specs := validation.NewLoader(marketplace.URL, time.Minute)
+
+	var billable []bool
+	router := gin.New()
+	router.POST("/api/:creator/:apiName/*path", func(c *gin.Context) {
+		if validateRequest(c, specs, route) {
+			proxyHandler.ProxyRequest(c, route)
+		}
+		billable = append(billable, c.GetBool("billable"))
+	})
+
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/alice/alerts/alerts", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post(`{"severity": "high"}`)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", w.Code)
+	}
+	var resp struct {
+		Code    string                  `json:"code"`
+		Details []validation.FieldError `json:"details"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Code != "REQUEST_VALIDATION_FAILED" || len(resp.Details) != 1 || resp.Details[0].In != "body" {
+		t.Errorf("unexpected error body %s", w.Body.String())
+	}
+
+	if w := post(`{"city": "london"}`); w.Code != http.StatusCreated {
+		t.Fatalf("valid request status = %d", w.Code)
+	}
+	if len(forwarded) != 1 || forwarded[0] != `{"city": "london"}` {
+		t.Errorf("upstream received %q", forwarded)
+	}
+	if len(billable) != 2 || billable[0] {
+		t.Errorf("rejected call should be non-billable, got %v", billable)
+	}
+}
@@ -0,0 +1,200 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const redisKeyPrefix = "gateway:idempotency:"
+
+var (
+	// ErrInProgress is returned when a request with the same key hasn't finished
+	ErrInProgress = errors.New("request with this idempotency key is in progress")
+
+	// ErrMismatch is returned when a key is reused for a different request
+	ErrMismatch = errors.New("idempotency key was used for a different request")
+)
+
+// Config controls how long keys and in-flight locks are kept
+type Config struct {
+	// TTL is how long a completed response can be replayed
+	TTL time.Duration
+	// LockTTL bounds how long an in-flight request holds its key, so a
+	// crashed gateway doesn't block retries forever
+	LockTTL time.Duration
+	// MaxBodyBytes is the largest response body that is stored
+	MaxBodyBytes int
+	// MaxRequestBytes is the largest request body accepted with an
+	// Idempotency-Key, which is read into memory to fingerprint it
+	MaxRequestBytes int64
+}
+
+// Response is a stored upstream response
+type Response struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// record is the Redis value for a key; Response is nil while in flight
+type record struct {
+	Token       string    `json:"token"`
+	Fingerprint string    `json:"fingerprint"`
+	Response    *Response `json:"response,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Lock is held by the request that first used a key
+type Lock struct {
+	key   string
+	token string
+}
+
+// Store records responses by API key and Idempotency-Key in Redis
+type Store struct {
+	client *redis.Client
+	config Config
+}
+
+// NewStore creates a Redis-backed idempotency store
+func NewStore(client *redis.Client, config Config) *Store {
+	if config.TTL <= 0 {
+		config.TTL = 24 * time.Hour
+	}
+	if config.LockTTL <= 0 {
+		config.LockTTL = time.Minute
+	}
+	if config.MaxBodyBytes <= 0 {
+		config.MaxBodyBytes = 1 << 20
+	}
+	if config.MaxRequestBytes <= 0 {
+		config.MaxRequestBytes = 10 << 20
+	}
+	return &Store{client: client, config: config}
+}
+
+// MaxBodyBytes returns the largest response body the store keeps
+func (s *Store) MaxBodyBytes() int {
+	return s.config.MaxBodyBytes
+}
+
+// MaxRequestBytes returns the largest request body accepted with a key
+func (s *Store) MaxRequestBytes() int64 {
+	return s.config.MaxRequestBytes
+}
+
+// Fingerprint identifies the request an idempotency key was first used with
+func Fingerprint(method, path, query string, body []byte) string {
+	h := sha256.New()
+	for _, part := range []string{method, path, query} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Begin claims an idempotency key. It returns the stored response if the key
+// has completed, or a Lock the caller must Complete or Release otherwise.
+func (s *Store) Begin(ctx context.Context, apiKeyID, idempotencyKey, fingerprint string) (*Response, *Lock, error) {
+	key := redisKey(apiKeyID, idempotencyKey)
+	pending := record{Token: newToken(), Fingerprint: fingerprint, CreatedAt: time.Now().UTC()}
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Retry once in case the existing record expires between the two calls
+	for attempt := 0; attempt < 2; attempt++ {
+		claimed, err := s.client.SetNX(ctx, key, data, s.config.LockTTL).Result()
+		if err != nil {
+			return nil, nil, err
+		}
+		if claimed {
+			return nil, &Lock{key: key, token: pending.Token}, nil
+		}
+
+		existing, err := s.client.Get(ctx, key).Bytes()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var rec record
+		if err := json.Unmarshal(existing, &rec); err != nil {
+			return nil, nil, err
+		}
+		if rec.Fingerprint != fingerprint {
+			return nil, nil, ErrMismatch
+		}
+		if rec.Response == nil {
+			return nil, nil, ErrInProgress
+		}
+		return rec.Response, nil, nil
+	}
+	return nil, nil, ErrInProgress
+}
+
+// Complete stores the response for replay, provided lock still holds the key
+func (s *Store) Complete(ctx context.Context, lock *Lock, fingerprint string, response *Response) error {
+	data, err := json.Marshal(record{
+		Token:       lock.token,
+		Fingerprint: fingerprint,
+		Response:    response,
+		CreatedAt:   time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	return completeScript.Run(ctx, s.client, []string{lock.key}, lock.token, data, s.config.TTL.Milliseconds()).Err()
+}
+
+// Release frees a key without storing a response so the request can be retried
+func (s *Store) Release(ctx context.Context, lock *Lock) error {
+	return releaseScript.Run(ctx, s.client, []string{lock.key}, lock.token).Err()
+}
+
+// redisKey scopes idempotency keys to the API key that sent them
+func redisKey(apiKeyID, idempotencyKey string) string {
+	sum := sha256.Sum256([]byte(idempotencyKey))
+	return redisKeyPrefix + apiKeyID + ":" + hex.EncodeToString(sum[:])
+}
+
+func newToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// completeScript replaces the in-flight record only if the caller still owns it
+var completeScript = redis.NewScript(`
+local current = redis.call('GET', KEYS[1])
+if not current or cjson.decode(current).token ~= ARGV[1] then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+return 1
+`)
+
+// releaseScript deletes the in-flight record only if the caller still owns it
+var releaseScript = redis.NewScript(`
+local current = redis.call('GET', KEYS[1])
+if not current then
+	return 0
+end
+local rec = cjson.decode(current)
+if rec.token ~= ARGV[1] or rec.response then
+	return 0
+end
+return redis.call('DEL', KEYS[1])
+`)
@@ -0,0 +1,330 @@
+package keycache
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// InvalidationChannel is the pub/sub channel the apikey and billing
+	// services publish to when a key or subscription changes
+	InvalidationChannel = "gateway:apikey:invalidations"
+
+	redisKeyPrefix   = "gateway:keycache:"
+	redisIndexPrefix = "gateway:keycache:idx:"
+)
+
+// Invalidation identifies cached validations that are no longer valid.
+// Either field may be empty.
+type Invalidation struct {
+	APIKeyID       string `json:"api_key_id,omitempty"`
+	SubscriptionID string `json:"subscription_id,omitempty"`
+}
+
+// Entry is a cached validation response
+type Entry struct {
+	// Response is the raw JSON returned by the apikey service
+	Response       json.RawMessage `json:"response"`
+	APIKeyID       string          `json:"api_key_id,omitempty"`
+	SubscriptionID string          `json:"subscription_id,omitempty"`
+	ExpiresAt      time.Time       `json:"expires_at"`
+}
+
+// Config controls cache size and lifetimes
+type Config struct {
+	// MaxEntries bounds the in-process cache
+	MaxEntries int
+	// TTL is how long a successful validation is cached
+	TTL time.Duration
+	// NegativeTTL is how long a rejected key is cached
+	NegativeTTL time.Duration
+}
+
+// Stats reports cache effectiveness
+type Stats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	Size   int    `json:"size"`
+}
+
+type item struct {
+	key   string
+	entry *Entry
+}
+
+// Cache is a bounded LRU cache of API key validation responses, optionally
+// backed by Redis so gateway instances share results. Entries are looked up
+// by a hash of the API key and the requested API, never the raw key.
+type Cache struct {
+	config Config
+	redis  *redis.Client
+
+	mu      sync.Mutex
+	ll      *list.List
+	items   map[string]*list.Element
+	byKeyID map[string]map[string]struct{}
+	bySubID map[string]map[string]struct{}
+	hits    uint64
+	misses  uint64
+
+	// generation is bumped by every invalidation so results fetched before
+	// an invalidation are not cached after it
+	generation uint64
+}
+
+// NewCache creates a validation cache. redisClient may be nil to keep the
+// cache purely in-process.
+func NewCache(config Config, redisClient *redis.Client) *Cache {
+	if config.MaxEntries <= 0 {
+		config.MaxEntries = 10000
+	}
+	if config.TTL <= 0 {
+		config.TTL = 30 * time.Second
+	}
+	if config.NegativeTTL <= 0 {
+		config.NegativeTTL = 5 * time.Second
+	}
+
+	return &Cache{
+		config:  config,
+		redis:   redisClient,
+		ll:      list.New(),
+		items:   make(map[string]*list.Element),
+		byKeyID: make(map[string]map[string]struct{}),
+		bySubID: make(map[string]map[string]struct{}),
+	}
+}
+
+// Key returns the cache key for an API key and the API it is used against
+func Key(apiKey, path string) string {
+	sum := sha256.Sum256([]byte(apiKey + "|" + path))
+	return hex.EncodeToString(sum[:])
+}
+
+// Generation returns the current invalidation generation. Callers read it
+// before fetching a validation and pass it to Set.
+func (c *Cache) Generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// Get returns a cached validation, checking Redis if it is not held locally
+func (c *Cache) Get(ctx context.Context, key string) (*Entry, bool) {
+	c.mu.Lock()
+	generation := c.generation
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*item).entry
+		if time.Now().Before(entry.ExpiresAt) {
+			c.ll.MoveToFront(el)
+			c.hits++
+			c.mu.Unlock()
+			return entry, true
+		}
+		c.removeElement(el)
+	}
+	c.mu.Unlock()
+
+	if c.redis != nil {
+		data, err := c.redis.Get(ctx, redisKeyPrefix+key).Bytes()
+		if err == nil {
+			var entry Entry
+			if err := json.Unmarshal(data, &entry); err == nil && time.Now().Before(entry.ExpiresAt) {
+				c.mu.Lock()
+				if c.generation == generation {
+					c.add(key, &entry)
+				}
+				c.hits++
+				c.mu.Unlock()
+				return &entry, true
+			}
+		} else if err != redis.Nil {
+			log.Printf("Validation cache lookup failed: %v", err)
+		}
+	}
+
+	c.mu.Lock()
+	c.misses++
+	c.mu.Unlock()
+	return nil, false
+}
+
+// Set caches a validation response fetched at the given generation. valid
+// selects the positive or negative TTL. The response is dropped if an
+// invalidation arrived while it was being fetched.
+func (c *Cache) Set(ctx context.Context, key string, generation uint64, response []byte, apiKeyID, subscriptionID string, valid bool) {
+	ttl := c.config.TTL
+	if !valid {
+		ttl = c.config.NegativeTTL
+	}
+
+	entry := &Entry{
+		Response:       response,
+		APIKeyID:       apiKeyID,
+		SubscriptionID: subscriptionID,
+		ExpiresAt:      time.Now().Add(ttl),
+	}
+
+	c.mu.Lock()
+	if c.generation != generation {
+		c.mu.Unlock()
+		return
+	}
+	c.add(key, entry)
+	c.mu.Unlock()
+
+	if c.redis == nil {
+		return
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	pipe := c.redis.Pipeline()
+	pipe.Set(ctx, redisKeyPrefix+key, data, ttl)
+	if apiKeyID != "" {
+		pipe.SAdd(ctx, redisIndexPrefix+"key:"+apiKeyID, key)
+		pipe.Expire(ctx, redisIndexPrefix+"key:"+apiKeyID, c.config.TTL)
+	}
+	if subscriptionID != "" {
+		pipe.SAdd(ctx, redisIndexPrefix+"sub:"+subscriptionID, key)
+		pipe.Expire(ctx, redisIndexPrefix+"sub:"+subscriptionID, c.config.TTL)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Failed to store validation in Redis: %v", err)
+	}
+}
+
+// Invalidate drops every cached validation for an API key or subscription
+func (c *Cache) Invalidate(ctx context.Context, inv Invalidation) {
+	// Clear the shared tier first so a concurrent local miss can't reload
+	// the entry from Redis after it has been dropped locally
+	if c.redis != nil {
+		var indexes []string
+		if inv.APIKeyID != "" {
+			indexes = append(indexes, redisIndexPrefix+"key:"+inv.APIKeyID)
+		}
+		if inv.SubscriptionID != "" {
+			indexes = append(indexes, redisIndexPrefix+"sub:"+inv.SubscriptionID)
+		}
+		for _, index := range indexes {
+			keys, err := c.redis.SMembers(ctx, index).Result()
+			if err != nil {
+				log.Printf("Failed to read validation cache index %s: %v", index, err)
+				continue
+			}
+			redisKeys := make([]string, 0, len(keys)+1)
+			for _, key := range keys {
+				redisKeys = append(redisKeys, redisKeyPrefix+key)
+			}
+			redisKeys = append(redisKeys, index)
+			if err := c.redis.Del(ctx, redisKeys...).Err(); err != nil {
+				log.Printf("Failed to invalidate cached validations: %v", err)
+			}
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for key := range c.byKeyID[inv.APIKeyID] {
+		if el, ok := c.items[key]; ok {
+			c.removeElement(el)
+		}
+	}
+	for key := range c.bySubID[inv.SubscriptionID] {
+		if el, ok := c.items[key]; ok {
+			c.removeElement(el)
+		}
+	}
+}
+
+// Watch applies invalidations published on InvalidationChannel until ctx is done
+func (c *Cache) Watch(ctx context.Context, client *redis.Client) {
+	pubsub := client.Subscribe(ctx, InvalidationChannel)
+	defer pubsub.Close()
+
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			var inv Invalidation
+			if err := json.Unmarshal([]byte(msg.Payload), &inv); err != nil {
+				log.Printf("Ignoring malformed invalidation %q: %v", msg.Payload, err)
+				continue
+			}
+			c.Invalidate(ctx, inv)
+		}
+	}
+}
+
+// Stats returns hit/miss counters and the current size
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Stats{Hits: c.hits, Misses: c.misses, Size: c.ll.Len()}
+}
+
+// add inserts or replaces an entry, evicting the least recently used
+// entries over the size bound. Callers must hold c.mu.
+func (c *Cache) add(key string, entry *Entry) {
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+
+	c.items[key] = c.ll.PushFront(&item{key: key, entry: entry})
+	if entry.APIKeyID != "" {
+		addIndex(c.byKeyID, entry.APIKeyID, key)
+	}
+	if entry.SubscriptionID != "" {
+		addIndex(c.bySubID, entry.SubscriptionID, key)
+	}
+
+	for c.ll.Len() > c.config.MaxEntries {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+// removeElement drops an entry and its index references. Callers must hold c.mu.
+func (c *Cache) removeElement(el *list.Element) {
+	it := el.Value.(*item)
+	c.ll.Remove(el)
+	delete(c.items, it.key)
+	removeIndex(c.byKeyID, it.entry.APIKeyID, it.key)
+	removeIndex(c.bySubID, it.entry.SubscriptionID, it.key)
+}
+
+func addIndex(index map[string]map[string]struct{}, id, key string) {
+	keys, ok := index[id]
+	if !ok {
+		keys = make(map[string]struct{})
+		index[id] = keys
+	}
+	keys[key] = struct{}{}
+}
+
+func removeIndex(index map[string]map[string]struct{}, id, key string) {
+	keys, ok := index[id]
+	if !ok {
+		return
+	}
+	delete(keys, key)
+	if len(keys) == 0 {
+		delete(index, id)
+	}
+}
@@ -0,0 +1,110 @@
+package keycache
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	cache := NewCache(Config{MaxEntries: 2, TTL: time.Minute}, nil)
+
+	cache.Set(ctx, "a", 0, []byte(`{"valid":true}`), "key-a", "sub-a", true)
+	cache.Set(ctx, "b", 0, []byte(`{"valid":true}`), "key-b", "sub-b", true)
+
+	// Touch "a" so "b" becomes the eviction candidate
+	if _, ok := cache.Get(ctx, "a"); !ok {
+		t.Fatal("expected hit for a")
+	}
+	cache.Set(ctx, "c", 0, []byte(`{"valid":true}`), "key-c", "sub-c", true)
+
+	if _, ok := cache.Get(ctx, "b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	if _, ok := cache.Get(ctx, "a"); !ok {
+		t.Error("expected a to survive eviction")
+	}
+
+	stats := cache.Stats()
+	if stats.Size != 2 || stats.Hits != 2 || stats.Misses != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestCacheNegativeEntriesExpireSooner(t *testing.T) {
+	ctx := context.Background()
+	cache := NewCache(Config{TTL: time.Minute, NegativeTTL: 10 * time.Millisecond}, nil)
+
+	cache.Set(ctx, "bad", 0, []byte(`{"valid":false}`), "", "", false)
+	if _, ok := cache.Get(ctx, "bad"); !ok {
+		t.Fatal("expected negative entry to be cached")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := cache.Get(ctx, "bad"); ok {
+		t.Error("expected negative entry to expire")
+	}
+}
+
+func TestSetDropsResultsFetchedBeforeInvalidation(t *testing.T) {
+	ctx := context.Background()
+	cache := NewCache(Config{TTL: time.Minute}, nil)
+
+	generation := cache.Generation()
+	// The key is revoked while its validation is in flight
+	cache.Invalidate(ctx, Invalidation{APIKeyID: "key-1"})
+	cache.Set(ctx, "k", generation, []byte(`{"valid":true}`), "key-1", "sub-1", true)
+
+	if _, ok := cache.Get(ctx, "k"); ok {
+		t.Error("expected stale validation to be dropped")
+	}
+}
+
+func TestInvalidationPropagatesAcrossInstances(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	gatewayA := NewCache(Config{TTL: time.Minute}, client)
+	gatewayB := NewCache(Config{TTL: time.Minute}, client)
+	go gatewayB.Watch(ctx, client)
+
+	key := Key("sk_live", "alice/weather")
+	gatewayA.Set(ctx, key, 0, []byte(`{"valid":true}`), "key-1", "sub-1", true)
+
+	// gatewayB picks the entry up from Redis
+	if _, ok := gatewayB.Get(ctx, key); !ok {
+		t.Fatal("expected shared entry to be visible to second instance")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	// The apikey service revokes the key
+	payload, _ := json.Marshal(Invalidation{APIKeyID: "key-1"})
+	if err := client.Publish(ctx, InvalidationChannel, payload).Err(); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, ok := gatewayB.Get(ctx, key); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("revoked key is still cached")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if mr.Exists(redisKeyPrefix + key) {
+		t.Error("expected shared Redis entry to be removed")
+	}
+}
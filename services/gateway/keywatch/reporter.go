@@ -0,0 +1,258 @@
+package keywatch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/api-direct/services/shared/tracing"
+)
+
+// Config controls how key activity is aggregated and reported
+type Config struct {
+	// APIKeyServiceURL is the base URL of the API Key Management Service
+	APIKeyServiceURL string
+	// ServiceToken authenticates the gateway's reports to the service
+	ServiceToken string
+	// FlushInterval is how often aggregated activity is reported
+	FlushInterval time.Duration
+	// MaxEntries bounds the activity held between flushes; calls from new
+	// sources are dropped beyond it
+	MaxEntries int
+	// Headers names the request headers carrying the client's IP metadata
+	Headers Headers
+}
+
+// Headers names the request headers an edge proxy or CDN sets with the
+// client's IP metadata. Empty names are not read.
+type Headers struct {
+	Country   string
+	ASN       string
+	Latitude  string
+	Longitude string
+}
+
+// Header presets for common edges
+var (
+	CloudFrontHeaders = Headers{
+		Country:   "CloudFront-Viewer-Country",
+		ASN:       "CloudFront-Viewer-ASN",
+		Latitude:  "CloudFront-Viewer-Latitude",
+		Longitude: "CloudFront-Viewer-Longitude",
+	}
+	CloudflareHeaders = Headers{
+		Country:   "CF-IPCountry",
+		Latitude:  "CF-IPLatitude",
+		Longitude: "CF-IPLongitude",
+	}
+)
+
+// ParseHeaders returns the header preset for an edge: cloudfront (the
+// default), cloudflare or none
+func ParseHeaders(name string) (Headers, error) {
+	switch name {
+	case "", "cloudfront":
+		return CloudFrontHeaders, nil
+	case "cloudflare":
+		return CloudflareHeaders, nil
+	case "none":
+		return Headers{}, nil
+	default:
+		return Headers{}, fmt.Errorf("unknown IP metadata headers %q", name)
+	}
+}
+
+// Observation is the activity of an API key from one source network since
+// the last report
+type Observation struct {
+	APIKeyID string `json:"api_key_id"`
+	// Network is the client's /24 (IPv4) or /48 (IPv6) network
+	Network string `json:"network"`
+	ASN     int    `json:"asn,omitempty"`
+	Country string `json:"country,omitempty"`
+	// Latitude and Longitude are where the network's last call came from
+	Latitude  *float64  `json:"latitude,omitempty"`
+	Longitude *float64  `json:"longitude,omitempty"`
+	Calls     int64     `json:"calls"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// Source is where a call came from
+type Source struct {
+	IP        net.IP
+	ASN       int
+	Country   string
+	Latitude  *float64
+	Longitude *float64
+}
+
+type sourceKey struct {
+	keyID   string
+	network string
+	asn     int
+	country string
+}
+
+// Reporter aggregates API key activity by source and reports it to the API
+// Key Management Service, which looks for signs of leaked keys. Reporting is
+// best effort: activity that can't be delivered is dropped.
+type Reporter struct {
+	config Config
+	client *http.Client
+
+	mu      sync.Mutex
+	entries map[sourceKey]*Observation
+	dropped uint64
+}
+
+// NewReporter creates a reporter
+func NewReporter(config Config) *Reporter {
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = 30 * time.Second
+	}
+	if config.MaxEntries <= 0 {
+		config.MaxEntries = 10000
+	}
+	return &Reporter{
+		config: config,
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: tracing.Transport(nil),
+		},
+		entries: make(map[sourceKey]*Observation),
+	}
+}
+
+// SourceFromRequest reads the client's IP metadata from the configured
+// headers. Malformed values are ignored.
+func (r *Reporter) SourceFromRequest(req *http.Request, clientIP string) Source {
+	h := r.config.Headers
+	source := Source{IP: net.ParseIP(clientIP)}
+	if h.Country != "" {
+		source.Country = req.Header.Get(h.Country)
+	}
+	if h.ASN != "" {
+		source.ASN, _ = strconv.Atoi(req.Header.Get(h.ASN))
+	}
+	if h.Latitude != "" && h.Longitude != "" {
+		lat, latErr := strconv.ParseFloat(req.Header.Get(h.Latitude), 64)
+		lon, lonErr := strconv.ParseFloat(req.Header.Get(h.Longitude), 64)
+		if latErr == nil && lonErr == nil {
+			source.Latitude, source.Longitude = &lat, &lon
+		}
+	}
+	return source
+}
+
+// Observe records a call made with an API key
+func (r *Reporter) Observe(keyID string, source Source, at time.Time) {
+	network := Network(source.IP)
+	if keyID == "" || network == "" {
+		return
+	}
+	k := sourceKey{keyID: keyID, network: network, asn: source.ASN, country: source.Country}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	obs, ok := r.entries[k]
+	if !ok {
+		if len(r.entries) >= r.config.MaxEntries {
+			r.dropped++
+			return
+		}
+		obs = &Observation{
+			APIKeyID:  keyID,
+			Network:   network,
+			ASN:       source.ASN,
+			Country:   source.Country,
+			FirstSeen: at,
+		}
+		r.entries[k] = obs
+	}
+	obs.Calls++
+	obs.LastSeen = at
+	if source.Latitude != nil {
+		obs.Latitude, obs.Longitude = source.Latitude, source.Longitude
+	}
+}
+
+// Watch reports activity every flush interval until ctx is done
+func (r *Reporter) Watch(ctx context.Context) {
+	ticker := time.NewTicker(r.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Flush(ctx); err != nil {
+				log.Printf("Failed to report key activity: %v", err)
+			}
+		}
+	}
+}
+
+// Flush reports the activity aggregated since the last flush
+func (r *Reporter) Flush(ctx context.Context) error {
+	r.mu.Lock()
+	entries, dropped := r.entries, r.dropped
+	r.entries = make(map[sourceKey]*Observation, len(entries))
+	r.dropped = 0
+	r.mu.Unlock()
+
+	if dropped > 0 {
+		log.Printf("Dropped key activity for %d calls, raise KEY_ACTIVITY_MAX_ENTRIES", dropped)
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	observations := make([]*Observation, 0, len(entries))
+	for _, obs := range entries {
+		observations = append(observations, obs)
+	}
+	payload, err := json.Marshal(map[string]interface{}{"observations": observations})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.config.APIKeyServiceURL+"/api/v1/keys/activity", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+r.config.ServiceToken)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("apikey service returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Network returns the /24 (IPv4) or /48 (IPv6) network of an IP, or "" for
+// a nil IP
+func Network(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	if ip != nil && len(ip) == net.IPv6len {
+		return (&net.IPNet{IP: ip.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+	}
+	return ""
+}
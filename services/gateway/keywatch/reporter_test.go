@@ -0,0 +1,88 @@
+package keywatch
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReporterAggregatesBySourceNetwork(t *testing.T) {
+	var received []Observation
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/keys/activity" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer s3cret" {
+			t.Errorf("report sent without the service token")
+		}
+		var body struct {
+			Observations []Observation `json:"observations"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		received = append(received, body.Observations...)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	r := NewReporter(Config{APIKeyServiceURL: server.URL, ServiceToken: "s3cret", Headers: CloudFrontHeaders})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/alice/weather", nil)
+	req.Header.Set("CloudFront-Viewer-Country", "DE")
+	req.Header.Set("CloudFront-Viewer-ASN", "3320")
+	req.Header.Set("CloudFront-Viewer-Latitude", "52.52")
+	req.Header.Set("CloudFront-Viewer-Longitude", "13.40")
+	source := r.SourceFromRequest(req, "192.0.2.10")
+	if source.ASN != 3320 || source.Country != "DE" || source.Latitude == nil || *source.Latitude != 52.52 {
+		t.Fatalf("unexpected source %+v", source)
+	}
+
+	start := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	r.Observe("key-1", source, start)
+	source.IP = net.ParseIP("192.0.2.99")
+	r.Observe("key-1", source, start.Add(time.Second))
+	r.Observe("key-1", Source{IP: net.ParseIP("2001:db8:1:2::1")}, start)
+	r.Observe("", source, start)
+
+	if err := r.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 2 {
+		t.Fatalf("got %d observations, want 2: %+v", len(received), received)
+	}
+	for _, obs := range received {
+		switch obs.Network {
+		case "192.0.2.0/24":
+			if obs.Calls != 2 || obs.ASN != 3320 || !obs.LastSeen.Equal(start.Add(time.Second)) {
+				t.Errorf("unexpected IPv4 observation %+v", obs)
+			}
+		case "2001:db8:1::/48":
+			if obs.Calls != 1 || obs.Latitude != nil {
+				t.Errorf("unexpected IPv6 observation %+v", obs)
+			}
+		default:
+			t.Errorf("unexpected network %s", obs.Network)
+		}
+	}
+
+	// Flushed activity isn't reported twice
+	received = nil
+	if err := r.Flush(context.Background()); err != nil || len(received) != 0 {
+		t.Errorf("second flush reported %d observations, err %v", len(received), err)
+	}
+}
+
+func TestReporterDropsNewSourcesWhenFull(t *testing.T) {
+	r := NewReporter(Config{MaxEntries: 1})
+	now := time.Now()
+	r.Observe("key-1", Source{IP: net.ParseIP("192.0.2.1")}, now)
+	r.Observe("key-1", Source{IP: net.ParseIP("198.51.100.1")}, now)
+	r.Observe("key-1", Source{IP: net.ParseIP("192.0.2.2")}, now)
+
+	if len(r.entries) != 1 || r.dropped != 1 {
+		t.Errorf("got %d entries and %d dropped, want 1 and 1", len(r.entries), r.dropped)
+	}
+}
@@ -6,15 +6,28 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
 	"github.com/api-direct/services/gateway/handlers"
+	"github.com/api-direct/services/gateway/idempotency"
+	"github.com/api-direct/services/gateway/keycache"
+	"github.com/api-direct/services/gateway/keywatch"
+	"github.com/api-direct/services/gateway/metrics"
 	"github.com/api-direct/services/gateway/middleware"
+	"github.com/api-direct/services/gateway/oauth"
 	"github.com/api-direct/services/gateway/proxy"
 	"github.com/api-direct/services/gateway/ratelimit"
+	"github.com/api-direct/services/gateway/registry"
+	"github.com/api-direct/services/gateway/respcache"
+	"github.com/api-direct/services/gateway/signing"
+	"github.com/api-direct/services/shared/tracing"
+	"github.com/api-direct/services/gateway/usage"
+	"github.com/api-direct/services/gateway/validation"
 )
 
 func main() {
@@ -39,6 +52,70 @@ func main() {
 		meteringServiceURL = "http://localhost:8084"
 	}
 
+	marketplaceServiceURL := os.Getenv("MARKETPLACE_SERVICE_URL")
+	if marketplaceServiceURL == "" {
+		marketplaceServiceURL = "http://localhost:8086"
+	}
+
+	rateLimitAlgorithm, err := ratelimit.ParseAlgorithm(os.Getenv("RATE_LIMIT_ALGORITHM"))
+	if err != nil {
+		log.Fatalf("Invalid RATE_LIMIT_ALGORITHM: %v", err)
+	}
+
+	rateLimitPolicy, err := ratelimit.ParsePolicy(os.Getenv("RATE_LIMIT_FAILURE_POLICY"))
+	if err != nil {
+		log.Fatalf("Invalid RATE_LIMIT_FAILURE_POLICY: %v", err)
+	}
+
+	routeCacheTTL := getEnvDuration("ROUTE_CACHE_TTL", 30*time.Second)
+
+	proxyConfig := proxy.Config{
+		Timeout:       getEnvDuration("UPSTREAM_TIMEOUT", 30*time.Second),
+		StreamTimeout: getEnvDuration("STREAM_TIMEOUT", time.Hour),
+		MaxRetries:    getEnvInt("UPSTREAM_MAX_RETRIES", 2),
+		RetryBackoff:  getEnvDuration("UPSTREAM_RETRY_BACKOFF", 100*time.Millisecond),
+		Breaker: proxy.BreakerConfig{
+			FailureThreshold: getEnvInt("CIRCUIT_FAILURE_THRESHOLD", 5),
+			OpenTimeout:      getEnvDuration("CIRCUIT_OPEN_TIMEOUT", 30*time.Second),
+		},
+	}
+
+	// Requests to upstreams are signed with keys derived from
+	// GATEWAY_SIGNING_KEY so creator functions can verify them
+	if key := os.Getenv("GATEWAY_SIGNING_KEY"); key != "" {
+		signer, err := signing.NewSigner(signing.Config{
+			Key:          []byte(key),
+			Algorithm:    os.Getenv("GATEWAY_SIGNING_ALGORITHM"),
+			MaxBodyBytes: int64(getEnvInt("GATEWAY_SIGNING_MAX_BODY", 10<<20)),
+		})
+		if err != nil {
+			log.Fatalf("Invalid request signing configuration: %v", err)
+		}
+		proxyConfig.Signer = signer
+		log.Printf("Signing upstream requests with %s by default", signer.Algorithm())
+	} else {
+		log.Printf("GATEWAY_SIGNING_KEY is not set; upstream requests are not signed")
+	}
+
+	// Admin endpoints are disabled unless a token is configured
+	adminToken := os.Getenv("GATEWAY_ADMIN_TOKEN")
+
+	keyCacheConfig := keycache.Config{
+		MaxEntries:  getEnvInt("KEY_CACHE_SIZE", 10000),
+		TTL:         getEnvDuration("KEY_CACHE_TTL", 30*time.Second),
+		NegativeTTL: getEnvDuration("KEY_CACHE_NEGATIVE_TTL", 5*time.Second),
+	}
+
+	// Initialize tracing; trace context is propagated to upstreams even when
+	// spans aren't exported
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		ServiceName: "gateway",
+		Exporter:    os.Getenv("OTEL_TRACES_EXPORTER"),
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
+	}
+
 	// Initialize Redis client
 	opt, err := redis.ParseURL(redisURL)
 	if err != nil {
@@ -52,38 +129,197 @@ func main() {
 		log.Fatalf("Failed to connect to Redis: %v", err)
 	}
 
-	// Initialize rate limiter
-	rateLimiter := ratelimit.NewRedisRateLimiter(redisClient)
+	// Initialize rate limiter, degrading per plan policy if Redis is unavailable
+	redisLimiter := ratelimit.NewRedisRateLimiter(redisClient, rateLimitAlgorithm)
+	rateLimiter := ratelimit.NewFailoverLimiter(redisLimiter, ratelimit.FailoverConfig{
+		Policy:   rateLimitPolicy,
+		Timeout:  getEnvDuration("RATE_LIMIT_TIMEOUT", 250*time.Millisecond),
+		Cooldown: getEnvDuration("RATE_LIMIT_COOLDOWN", 5*time.Second),
+	})
+	log.Printf("Using %s rate limiting", redisLimiter.Algorithm())
+
+	// Initialize concurrency limiter for plans that cap in-flight requests
+	concurrencyLimiter := ratelimit.NewConcurrencyLimiter(redisClient, ratelimit.ConcurrencyConfig{
+		LeaseTTL: getEnvDuration("CONCURRENCY_LEASE_TTL", 30*time.Second),
+		Timeout:  getEnvDuration("RATE_LIMIT_TIMEOUT", 250*time.Millisecond),
+		Policy:   rateLimitPolicy,
+	})
+
+	// Initialize quota counter for plans with a call limit per billing period
+	quotaCounter := ratelimit.NewQuotaCounter(redisClient, ratelimit.QuotaConfig{
+		Timeout: getEnvDuration("RATE_LIMIT_TIMEOUT", 250*time.Millisecond),
+		Policy:  rateLimitPolicy,
+	})
+
+	// Initialize idempotency store for POST and PATCH retries
+	idempotencyStore := idempotency.NewStore(redisClient, idempotency.Config{
+		TTL:             getEnvDuration("IDEMPOTENCY_TTL", 24*time.Hour),
+		LockTTL:         getEnvDuration("IDEMPOTENCY_LOCK_TTL", time.Minute),
+		MaxBodyBytes:    getEnvInt("IDEMPOTENCY_MAX_BODY", 1<<20),
+		MaxRequestBytes: int64(getEnvInt("IDEMPOTENCY_MAX_REQUEST_BODY", 10<<20)),
+	})
+
+	// Initialize OpenAPI document loader for request validation
+	specs := validation.NewLoader(marketplaceServiceURL, getEnvDuration("OPENAPI_CACHE_TTL", 5*time.Minute))
+
+	// Initialize route registry and watch for route changes
+	routes := registry.NewRegistry(redisClient, routeCacheTTL)
+	watchCtx, stopWatching := context.WithCancel(ctx)
+	defer stopWatching()
+	go routes.Watch(watchCtx)
+
+	// Initialize API key validation cache, shared through Redis unless disabled
+	var keyCacheRedis *redis.Client
+	if os.Getenv("KEY_CACHE_SHARED") != "false" {
+		keyCacheRedis = redisClient
+	}
+	keyCache := keycache.NewCache(keyCacheConfig, keyCacheRedis)
+	go keyCache.Watch(watchCtx, redisClient)
+
+	// Initialize OAuth access token validation against the apikey service's
+	// signing keys, which are cached and refreshed in the background
+	jwksURL := os.Getenv("OAUTH_JWKS_URL")
+	if jwksURL == "" {
+		jwksURL = apiKeyServiceURL + "/.well-known/jwks.json"
+	}
+	tokenVerifier := oauth.NewVerifier(oauth.Config{
+		JWKSURL:         jwksURL,
+		Issuer:          os.Getenv("OAUTH_ISSUER"),
+		RefreshInterval: getEnvDuration("OAUTH_JWKS_REFRESH", 5*time.Minute),
+	})
+	go tokenVerifier.Watch(watchCtx)
+
+	// Initialize key activity reporting for leaked key detection
+	ipMetadataHeaders, err := keywatch.ParseHeaders(os.Getenv("IP_METADATA_HEADERS"))
+	if err != nil {
+		log.Fatalf("Invalid IP_METADATA_HEADERS: %v", err)
+	}
+	keyActivity := keywatch.NewReporter(keywatch.Config{
+		APIKeyServiceURL: apiKeyServiceURL,
+		ServiceToken:     os.Getenv("GATEWAY_SERVICE_TOKEN"),
+		FlushInterval:    getEnvDuration("KEY_ACTIVITY_FLUSH_INTERVAL", 30*time.Second),
+		MaxEntries:       getEnvInt("KEY_ACTIVITY_MAX_ENTRIES", 10000),
+		Headers:          ipMetadataHeaders,
+	})
+	go keyActivity.Watch(watchCtx)
 
 	// Initialize proxy handler
-	proxyHandler := proxy.NewHandler(meteringServiceURL)
+	proxyHandler := proxy.NewHandler(meteringServiceURL, routes, proxyConfig)
+
+	// Initialize usage shipper, spooling to disk while metering is unavailable
+	shipper, err := usage.NewShipper(usage.Config{
+		MeteringURL:   meteringServiceURL,
+		BatchSize:     getEnvInt("USAGE_BATCH_SIZE", 500),
+		FlushInterval: getEnvDuration("USAGE_FLUSH_INTERVAL", time.Second),
+		BufferSize:    getEnvInt("USAGE_BUFFER_SIZE", 10000),
+		SpoolDir:      os.Getenv("USAGE_SPOOL_DIR"),
+		MaxSpoolBytes: int64(getEnvInt("USAGE_MAX_SPOOL_BYTES", 512<<20)),
+		MaxBackoff:    getEnvDuration("USAGE_MAX_BACKOFF", time.Minute),
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize usage shipper: %v", err)
+	}
+	shipper.Start()
+
+	// Initialize response cache for APIs that opt in to caching
+	var responseCache respcache.Store
+	switch mode := os.Getenv("RESPONSE_CACHE"); mode {
+	case "", "memory":
+		responseCache = respcache.NewMemoryStore(getEnvInt("RESPONSE_CACHE_SIZE", 64<<20))
+	case "redis":
+		responseCache = respcache.NewRedisStore(redisClient)
+	case "off":
+	default:
+		log.Fatalf("Invalid RESPONSE_CACHE: %q", mode)
+	}
+
+	// Initialize Prometheus metrics
+	gatewayMetrics := metrics.New()
+	gatewayMetrics.RegisterKeyCache(keyCache)
+	gatewayMetrics.RegisterCircuits(proxyHandler)
+	metricsToken := os.Getenv("GATEWAY_METRICS_TOKEN")
 
 	// Initialize Gin router
 	router := gin.New()
+	// Source IP key scopes depend on the client IP, which gin takes from
+	// X-Forwarded-For when the peer is a trusted proxy. No proxy is trusted
+	// unless configured, so the header can't be used to pass a scope.
+	if proxies := os.Getenv("GATEWAY_TRUSTED_PROXIES"); proxies != "" {
+		var trusted []string
+		for _, cidr := range strings.Split(proxies, ",") {
+			trusted = append(trusted, strings.TrimSpace(cidr))
+		}
+		if err := router.SetTrustedProxies(trusted); err != nil {
+			log.Fatalf("Invalid GATEWAY_TRUSTED_PROXIES: %v", err)
+		}
+	} else {
+		if err := router.SetTrustedProxies(nil); err != nil {
+			log.Fatalf("Failed to disable trusted proxies: %v", err)
+		}
+		log.Println("GATEWAY_TRUSTED_PROXIES is not set; client IPs are the connecting peers' and X-Forwarded-For is ignored")
+	}
+	router.Use(tracing.Middleware("gateway", "/health", "/metrics"))
 	router.Use(gin.Logger())
 	router.Use(gin.Recovery())
 	router.Use(middleware.CORS())
 
 	// Health check endpoint
 	router.GET("/health", func(c *gin.Context) {
+		// A degraded limiter doesn't take the gateway out of rotation
+		limiterHealth := rateLimiter.Health()
+		status := "healthy"
+		if limiterHealth.Status != "healthy" {
+			status = "degraded"
+		}
 		c.JSON(http.StatusOK, gin.H{
-			"status":    "healthy",
-			"service":   "gateway",
-			"timestamp": time.Now().UTC(),
+			"status":       status,
+			"service":      "gateway",
+			"timestamp":    time.Now().UTC(),
+			"rate_limiter": limiterHealth,
 		})
 	})
 
-	// API Gateway routes - all requests go through API key validation and rate limiting
+	// Prometheus scrape endpoint, requiring a bearer token if one is configured
+	metricsHandler := gin.WrapH(gatewayMetrics.Handler())
+	if metricsToken != "" {
+		router.GET("/metrics", middleware.AdminAuth(metricsToken), metricsHandler)
+	} else {
+		router.GET("/metrics", metricsHandler)
+	}
+
+	// Operational endpoints for gateway administrators
+	if adminToken != "" {
+		admin := router.Group("/admin")
+		admin.Use(middleware.AdminAuth(adminToken))
+		{
+			admin.GET("/circuits", handlers.ListCircuits(proxyHandler))
+			admin.POST("/circuits/reset", handlers.ResetCircuit(proxyHandler))
+			if proxyConfig.Signer != nil {
+				admin.GET("/signing/:creator/:apiName", handlers.SigningKeys(proxyConfig.Signer))
+			}
+		}
+	}
+
+	// API Gateway routes - all requests go through API key validation and rate
+	// limiting. Idempotent replays are answered before quota is consumed or
+	// usage is logged.
 	api := router.Group("/api")
-	api.Use(middleware.ValidateAPIKey(apiKeyServiceURL))
+	api.Use(middleware.Metrics(gatewayMetrics))
+	api.Use(middleware.ValidateAPIKey(apiKeyServiceURL, keyCache, tokenVerifier))
+	api.Use(middleware.ObserveKeyActivity(keyActivity))
+	api.Use(middleware.EnforceKeyScopes())
 	api.Use(middleware.RateLimit(rateLimiter))
-	api.Use(middleware.LogRequest(meteringServiceURL))
+	api.Use(middleware.ConcurrencyLimit(concurrencyLimiter))
+	api.Use(middleware.Idempotency(idempotencyStore))
+	api.Use(middleware.QuotaLimit(quotaCounter, rateLimiter))
+	api.Use(middleware.LogRequest(shipper))
 	{
 		// Proxy all requests to the appropriate creator function
-		api.Any("/:creator/:apiName/*path", handlers.ProxyToFunction(proxyHandler))
+		api.Any("/:creator/:apiName/*path", handlers.ProxyToFunction(proxyHandler, responseCache, specs))
 	}
 
-	// Create HTTP server
+	// Create HTTP server. The proxy lifts the read and write timeouts for
+	// streaming responses and upgraded connections up to STREAM_TIMEOUT.
 	srv := &http.Server{
 		Addr:         ":" + port,
 		Handler:      router,
@@ -115,6 +351,16 @@ func main() {
 		log.Printf("Server forced to shutdown: %v", err)
 	}
 
+	// Deliver buffered usage; anything left stays spooled for the next start
+	if err := shipper.Close(ctx); err != nil {
+		log.Printf("Usage shipper did not drain: %v", err)
+	}
+
+	// Flush spans of the last requests
+	if err := shutdownTracing(ctx); err != nil {
+		log.Printf("Error shutting down tracing: %v", err)
+	}
+
 	// Close Redis connection
 	if err := redisClient.Close(); err != nil {
 		log.Printf("Error closing Redis connection: %v", err)
@@ -122,3 +368,29 @@ func main() {
 
 	log.Println("Server exited")
 }
+
+// getEnvDuration reads a duration such as "30s" from the environment
+func getEnvDuration(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", name, err)
+	}
+	return parsed
+}
+
+// getEnvInt reads an integer from the environment
+func getEnvInt(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", name, err)
+	}
+	return parsed
+}
@@ -0,0 +1,180 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/api-direct/services/gateway/keycache"
+	"github.com/api-direct/services/gateway/proxy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gateway"
+
+// latencyBuckets covers fast cached responses up to the default upstream
+// timeout
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+// Metrics holds the gateway's Prometheus collectors
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests    *prometheus.CounterVec
+	duration    *prometheus.HistogramVec
+	rateLimited *prometheus.CounterVec
+}
+
+// New creates the gateway metrics along with the Go runtime and process
+// collectors
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "requests_total",
+			Help:      "API requests handled by the gateway.",
+		}, []string{"creator", "api", "status_class", "upstream"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "request_duration_seconds",
+			Help:      "Time to serve API requests, including the upstream.",
+			Buckets:   latencyBuckets,
+		}, []string{"creator", "api", "status_class", "upstream"}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rate_limit_rejections_total",
+			Help:      "Requests rejected because a rate limit window was exhausted.",
+		}, []string{"creator", "api", "window"}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.duration,
+		m.rateLimited,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest records a finished API request. upstream is empty for
+// requests that never reached one.
+func (m *Metrics) ObserveRequest(creator, api, upstream string, status int, elapsed time.Duration) {
+	if upstream == "" {
+		upstream = "none"
+	}
+	class := StatusClass(status)
+	m.requests.WithLabelValues(creator, api, class, upstream).Inc()
+	m.duration.WithLabelValues(creator, api, class, upstream).Observe(elapsed.Seconds())
+}
+
+// ObserveRateLimited records a request rejected by a rate limit window
+func (m *Metrics) ObserveRateLimited(creator, api, window string) {
+	m.rateLimited.WithLabelValues(creator, api, window).Inc()
+}
+
+// RegisterKeyCache exposes the API key validation cache's effectiveness
+func (m *Metrics) RegisterKeyCache(cache *keycache.Cache) {
+	m.registry.MustRegister(&keyCacheCollector{cache: cache})
+}
+
+// RegisterCircuits exposes the circuit breaker state of every upstream
+func (m *Metrics) RegisterCircuits(proxyHandler *proxy.Handler) {
+	m.registry.MustRegister(&circuitCollector{proxy: proxyHandler})
+}
+
+// StatusClass returns the class of an HTTP status code, e.g. "5xx"
+func StatusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "unknown"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
+
+var (
+	keyCacheLookupsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "key_cache", "lookups_total"),
+		"API key validation cache lookups by result.",
+		[]string{"result"}, nil,
+	)
+	keyCacheHitRatioDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "key_cache", "hit_ratio"),
+		"Share of API key validation cache lookups served from the cache since start.",
+		nil, nil,
+	)
+	keyCacheEntriesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "key_cache", "entries"),
+		"API key validations held in the in-process cache.",
+		nil, nil,
+	)
+)
+
+// keyCacheCollector reads the validation cache's counters at scrape time
+type keyCacheCollector struct {
+	cache *keycache.Cache
+}
+
+func (c *keyCacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- keyCacheLookupsDesc
+	ch <- keyCacheHitRatioDesc
+	ch <- keyCacheEntriesDesc
+}
+
+func (c *keyCacheCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.cache.Stats()
+	ch <- prometheus.MustNewConstMetric(keyCacheLookupsDesc, prometheus.CounterValue, float64(stats.Hits), "hit")
+	ch <- prometheus.MustNewConstMetric(keyCacheLookupsDesc, prometheus.CounterValue, float64(stats.Misses), "miss")
+
+	ratio := 0.0
+	if total := stats.Hits + stats.Misses; total > 0 {
+		ratio = float64(stats.Hits) / float64(total)
+	}
+	ch <- prometheus.MustNewConstMetric(keyCacheHitRatioDesc, prometheus.GaugeValue, ratio)
+	ch <- prometheus.MustNewConstMetric(keyCacheEntriesDesc, prometheus.GaugeValue, float64(stats.Size))
+}
+
+var circuitStates = []string{proxy.StateClosed, proxy.StateOpen, proxy.StateHalfOpen}
+
+var (
+	circuitStateDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "upstream", "circuit_state"),
+		"Circuit breaker state of each upstream; 1 for the current state.",
+		[]string{"upstream", "state"}, nil,
+	)
+	circuitFailuresDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "upstream", "consecutive_failures"),
+		"Consecutive failed requests to each upstream.",
+		[]string{"upstream"}, nil,
+	)
+)
+
+// circuitCollector reads the proxy's circuit breakers at scrape time
+type circuitCollector struct {
+	proxy *proxy.Handler
+}
+
+func (c *circuitCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- circuitStateDesc
+	ch <- circuitFailuresDesc
+}
+
+func (c *circuitCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, circuit := range c.proxy.Circuits() {
+		for _, state := range circuitStates {
+			value := 0.0
+			if circuit.State == state {
+				value = 1
+			}
+			ch <- prometheus.MustNewConstMetric(circuitStateDesc, prometheus.GaugeValue, value, circuit.Upstream, state)
+		}
+		ch <- prometheus.MustNewConstMetric(circuitFailuresDesc, prometheus.GaugeValue, float64(circuit.ConsecutiveFailures), circuit.Upstream)
+	}
+}
@@ -3,12 +3,18 @@ package middleware
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/api-direct/services/gateway/keycache"
+	"github.com/api-direct/services/gateway/oauth"
+	"github.com/api-direct/services/shared/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type APIKeyValidationRequest struct {
@@ -22,16 +28,28 @@ type APIKeyValidationResponse struct {
 	SubscriptionID string `json:"subscription_id"`
 	APIKeyID       string `json:"api_key_id"`
 	APIID          string `json:"api_id"`
-	RateLimits     struct {
-		PerMinute int `json:"per_minute"`
-		PerDay    int `json:"per_day"`
-		PerMonth  int `json:"per_month"`
-	} `json:"rate_limits"`
-	Error string `json:"error,omitempty"`
+	// Mode is "test" for sandbox keys
+	Mode           string     `json:"mode,omitempty"`
+	RateLimits     RateLimits `json:"rate_limits"`
+	// Quota is nil for plans without a call limit
+	Quota          *Quota     `json:"quota,omitempty"`
+	// Scopes is nil for unrestricted keys
+	Scopes         *KeyScopes `json:"scopes,omitempty"`
+	Error          string     `json:"error,omitempty"`
 }
 
-// ValidateAPIKey middleware validates API keys by calling the API Key Management Service
-func ValidateAPIKey(apiKeyServiceURL string) gin.HandlerFunc {
+// validationClient calls the API Key Management Service on cache misses
+var validationClient = &http.Client{
+	Timeout:   5 * time.Second,
+	Transport: tracing.Transport(nil),
+}
+
+// ValidateAPIKey middleware validates API keys by calling the API Key Management
+// Service. Results are served from cache when one is provided; the cache is
+// invalidated by the apikey and billing services when keys or subscriptions change.
+// OAuth access tokens sent as bearer tokens are validated locally by tokens
+// when it is set.
+func ValidateAPIKey(apiKeyServiceURL string, cache *keycache.Cache, tokens *oauth.Verifier) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Extract API key from header
 		apiKey := c.GetHeader("X-API-Key")
@@ -52,9 +70,10 @@ func ValidateAPIKey(apiKeyServiceURL string) gin.HandlerFunc {
 			return
 		}
 
-		// Extract creator and API name from path
+		// Extract creator and API name from path. Keys and tokens are granted
+		// for the API, not a version, so a pin (apiName@version) is dropped.
 		creator := c.Param("creator")
-		apiName := c.Param("apiName")
+		apiName, _, _ := strings.Cut(c.Param("apiName"), "@")
 		if creator == "" || apiName == "" {
 			c.JSON(http.StatusBadRequest, gin.H{
 				"error": "Invalid API path",
@@ -64,47 +83,105 @@ func ValidateAPIKey(apiKeyServiceURL string) gin.HandlerFunc {
 			return
 		}
 
-		// Validate API key with the API Key Management Service
-		validationReq := APIKeyValidationRequest{
-			APIKey: apiKey,
-			Path:   fmt.Sprintf("%s/%s", creator, apiName),
-		}
+		path := fmt.Sprintf("%s/%s", creator, apiName)
+		cacheKey := keycache.Key(apiKey, path)
 
-		jsonData, err := json.Marshal(validationReq)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Internal server error",
-				"code":  "MARSHAL_ERROR",
-			})
-			c.Abort()
-			return
-		}
+		// The span covers validation only, not the rest of the pipeline
+		ctx, span := tracing.Start(c, "gateway.validate_api_key")
+		defer span.End()
 
-		// Call API Key Management Service
-		resp, err := http.Post(
-			fmt.Sprintf("%s/api/v1/keys/validate", apiKeyServiceURL),
-			"application/json",
-			bytes.NewBuffer(jsonData),
-		)
-		if err != nil {
-			c.JSON(http.StatusServiceUnavailable, gin.H{
-				"error": "API key validation service unavailable",
-				"code":  "SERVICE_UNAVAILABLE",
-			})
-			c.Abort()
-			return
+		var body []byte
+		var generation uint64
+		cached, cacheable := false, false
+		if tokens != nil && oauth.IsToken(apiKey) {
+			// Access tokens carry their subscription, so there is nothing
+			// to look up or cache
+			span.SetAttributes(attribute.String("gateway.credential", "access_token"))
+			subscription, err := tokens.Verify(ctx, apiKey, path)
+			if errors.Is(err, oauth.ErrKeysUnavailable) {
+				c.JSON(http.StatusServiceUnavailable, gin.H{
+					"error": "Access token validation unavailable",
+					"code":  "SERVICE_UNAVAILABLE",
+				})
+				c.Abort()
+				return
+			}
+			if err != nil {
+				c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+				c.JSON(http.StatusUnauthorized, gin.H{
+					"error": "Invalid or expired access token",
+					"code":  "INVALID_ACCESS_TOKEN",
+				})
+				c.Abort()
+				return
+			}
+			body = subscription
+		} else if cache != nil {
+			generation = cache.Generation()
+			if entry, ok := cache.Get(ctx, cacheKey); ok {
+				body = entry.Response
+				cached = true
+			}
 		}
-		defer resp.Body.Close()
 
-		// Read response
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Failed to read validation response",
-				"code":  "READ_ERROR",
-			})
-			c.Abort()
-			return
+		if body == nil {
+			// Validate API key with the API Key Management Service
+			validationReq := APIKeyValidationRequest{
+				APIKey: apiKey,
+				Path:   path,
+			}
+
+			jsonData, err := json.Marshal(validationReq)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "Internal server error",
+					"code":  "MARSHAL_ERROR",
+				})
+				c.Abort()
+				return
+			}
+
+			// Call API Key Management Service
+			req, err := http.NewRequestWithContext(
+				ctx,
+				http.MethodPost,
+				fmt.Sprintf("%s/api/v1/keys/validate", apiKeyServiceURL),
+				bytes.NewBuffer(jsonData),
+			)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "Internal server error",
+					"code":  "REQUEST_ERROR",
+				})
+				c.Abort()
+				return
+			}
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := validationClient.Do(req)
+			if err != nil {
+				c.JSON(http.StatusServiceUnavailable, gin.H{
+					"error": "API key validation service unavailable",
+					"code":  "SERVICE_UNAVAILABLE",
+				})
+				c.Abort()
+				return
+			}
+			defer resp.Body.Close()
+
+			// Read response
+			body, err = io.ReadAll(resp.Body)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": "Failed to read validation response",
+					"code":  "READ_ERROR",
+				})
+				c.Abort()
+				return
+			}
+
+			// Only definitive answers are cached, not service errors
+			cacheable = resp.StatusCode == http.StatusOK
 		}
 
 		// Parse validation response
@@ -118,12 +195,27 @@ func ValidateAPIKey(apiKeyServiceURL string) gin.HandlerFunc {
 			return
 		}
 
+		span.SetAttributes(attribute.Bool("gateway.key_cache_hit", cached))
+
+		if cache != nil && cacheable {
+			cache.Set(ctx, cacheKey, generation, body, validationResp.APIKeyID, validationResp.SubscriptionID, validationResp.Valid)
+		}
+
 		// Check if API key is valid
 		if !validationResp.Valid {
 			statusCode := http.StatusUnauthorized
 			if validationResp.Error == "API_NOT_FOUND" {
 				statusCode = http.StatusNotFound
 			}
+			if validationResp.Error == "API_KEY_QUARANTINED" {
+				// The consumer has to confirm the key before it works again
+				c.JSON(http.StatusForbidden, gin.H{
+					"error": "API key is quarantined after suspicious activity; confirm or rotate it",
+					"code":  "API_KEY_QUARANTINED",
+				})
+				c.Abort()
+				return
+			}
 			c.JSON(statusCode, gin.H{
 				"error": validationResp.Error,
 				"code":  "INVALID_API_KEY",
@@ -138,7 +230,19 @@ func ValidateAPIKey(apiKeyServiceURL string) gin.HandlerFunc {
 		c.Set("api_key_id", validationResp.APIKeyID)
 		c.Set("api_id", validationResp.APIID)
 		c.Set("rate_limits", validationResp.RateLimits)
+		if validationResp.Quota != nil {
+			c.Set("quota", *validationResp.Quota)
+		}
+		if validationResp.Scopes != nil {
+			c.Set("key_scopes", *validationResp.Scopes)
+		}
+		if validationResp.Mode == "test" {
+			// Test mode calls are served by the sandbox and never billed
+			c.Set("test_mode", true)
+			c.Set("billable", false)
+		}
 
+		span.End()
 		c.Next()
 	}
 }
@@ -0,0 +1,204 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/api-direct/services/gateway/oauth"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestValidateAPIKeyAcceptsAccessTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(gin.H{"keys": []gin.H{{
+			"kty": "RSA",
+			"kid": "k1",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	defer jwks.Close()
+
+	var validations int64
+	apikey := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&validations, 1)
+		w.Write([]byte(`{"valid": true, "subscription_id": "sub-key", "api_key_id": "key-1", "rate_limits": {"per_minute": 10}}`))
+	}))
+	defer apikey.Close()
+
+	router := gin.New()
+	router.Use(ValidateAPIKey(apikey.URL, nil, oauth.NewVerifier(oauth.Config{JWKSURL: jwks.URL})))
+	router.GET("/api/:creator/:apiName/*path", func(c *gin.Context) {
+		limits, _ := c.Get("rate_limits")
+		c.JSON(http.StatusOK, gin.H{
+			"subscription_id": c.GetString("subscription_id"),
+			"api_key_id":      c.GetString("api_key_id"),
+			"per_minute":      limits.(RateLimits).PerMinute,
+		})
+	})
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":       "api-direct",
+		"aud":       "alice/weather",
+		"exp":       time.Now().Add(time.Minute).Unix(),
+		"client_id": "cid_1",
+		"subscription": gin.H{
+			"valid":           true,
+			"consumer_id":     "consumer-1",
+			"subscription_id": "sub-token",
+			"api_key_id":      "client-1",
+			"rate_limits":     gin.H{"per_minute": 60},
+		},
+	})
+	token.Header["kid"] = "k1"
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	call := func(path, header, value string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set(header, value)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := call("/api/alice/weather/today", "Authorization", "Bearer "+signed)
+	if w.Code != http.StatusOK {
+		t.Fatalf("token status = %d: %s", w.Code, w.Body.String())
+	}
+	if w.Body.String() != `{"api_key_id":"client-1","per_minute":60,"subscription_id":"sub-token"}` {
+		t.Errorf("token context = %s", w.Body.String())
+	}
+	if validations != 0 {
+		t.Errorf("token validation called the apikey service %d times", validations)
+	}
+
+	// Tokens are scoped to one API
+	w = call("/api/alice/maps/today", "Authorization", "Bearer "+signed)
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("token for another API = %d", w.Code)
+	}
+
+	// API keys are still validated by the apikey service
+	w = call("/api/alice/weather/today", "X-API-Key", "sk_123")
+	if w.Code != http.StatusOK || validations != 1 {
+		t.Errorf("API key status = %d after %d validations", w.Code, validations)
+	}
+}
+
+func TestValidateAPIKeyRejectsQuarantinedKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	apikey := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"valid": false, "api_key_id": "key-1", "error": "API_KEY_QUARANTINED"}`))
+	}))
+	defer apikey.Close()
+
+	router := gin.New()
+	router.Use(ValidateAPIKey(apikey.URL, nil, nil))
+	router.GET("/api/:creator/:apiName/*path", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/alice/weather/forecast", nil)
+	req.Header.Set("X-API-Key", "sk_leaked")
+	router.ServeHTTP(w, req)
+
+	var resp struct {
+		Code string `json:"code"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusForbidden || resp.Code != "API_KEY_QUARANTINED" {
+		t.Errorf("got %d %q, want 403 API_KEY_QUARANTINED", w.Code, resp.Code)
+	}
+}
+
+func TestValidateAPIKeyIgnoresVersionPins(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(gin.H{"keys": []gin.H{{
+			"kty": "RSA",
+			"kid": "k1",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	defer jwks.Close()
+
+	var paths []string
+	apikey := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req APIKeyValidationRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		paths = append(paths, req.Path)
+		if req.Path != "creator/weather" {
+			w.Write([]byte(`{"valid": false, "error": "INVALID_API_KEY"}`))
+			return
+		}
+		w.Write([]byte(`{"valid": true, "subscription_id": "sub-1", "api_key_id": "key-1"}`))
+	}))
+	defer apikey.Close()
+
+	router := gin.New()
+	router.Use(ValidateAPIKey(apikey.URL, nil, oauth.NewVerifier(oauth.Config{JWKSURL: jwks.URL})))
+	router.GET("/api/:creator/:apiName/*path", func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString("subscription_id"))
+	})
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":       "api-direct",
+		"aud":       "creator/weather",
+		"exp":       time.Now().Add(time.Minute).Unix(),
+		"client_id": "cid_1",
+		"subscription": gin.H{
+			"valid":           true,
+			"subscription_id": "sub-token",
+			"api_key_id":      "client-1",
+		},
+	})
+	token.Header["kid"] = "k1"
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		header, value, want string
+	}{
+		{"X-API-Key", "sk_123", "sub-1"},
+		{"Authorization", "Bearer " + signed, "sub-token"},
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/creator/weather@v2/today", nil)
+		req.Header.Set(tc.header, tc.value)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || w.Body.String() != tc.want {
+			t.Errorf("%s on pinned path = %d %q, want 200 %q", tc.header, w.Code, w.Body.String(), tc.want)
+		}
+	}
+	if len(paths) != 1 || paths[0] != "creator/weather" {
+		t.Errorf("validated paths = %v, want [creator/weather]", paths)
+	}
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/api-direct/services/gateway/ratelimit"
+	"github.com/api-direct/services/shared/tracing"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ConcurrencyLimit middleware caps the number of in-flight requests per API
+// key for plans that set MaxConcurrent. The slot is held until the rest of
+// the chain, including the upstream call, has finished.
+func ConcurrencyLimit(limiter *ratelimit.ConcurrencyLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rateLimits, _ := c.Get("rate_limits")
+		limits, _ := rateLimits.(RateLimits)
+		apiKeyID := c.GetString("api_key_id")
+		if limits.MaxConcurrent <= 0 || apiKeyID == "" {
+			c.Next()
+			return
+		}
+
+		policy, err := ratelimit.ParsePolicy(limits.FailurePolicy)
+		if err != nil {
+			policy = ""
+		}
+
+		ctx, span := tracing.Start(c, "gateway.concurrency_limit")
+		defer span.End()
+
+		result, err := limiter.Acquire(ctx, "concurrency:"+apiKeyID, limits.MaxConcurrent, policy)
+		if errors.Is(err, ratelimit.ErrUnavailable) {
+			c.Header("Retry-After", "5")
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "Rate limiting is temporarily unavailable",
+				"code":  "RATE_LIMIT_UNAVAILABLE",
+			})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to check concurrency limit",
+				"code":  "RATE_LIMIT_ERROR",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Header("X-RateLimit-Limit-Concurrent", fmt.Sprintf("%d", result.Limit))
+		if result.Degraded {
+			c.Header("X-RateLimit-Degraded", "true")
+		}
+
+		if !result.Allowed {
+			c.Set("rate_limit_window", "concurrent")
+			span.SetAttributes(attribute.String("gateway.rate_limit_window", "concurrent"))
+			c.Header("X-RateLimit-Remaining-Concurrent", "0")
+			c.Header("Retry-After", "1")
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error": fmt.Sprintf("Too many concurrent requests (limit %d)", result.Limit),
+				"code":  "CONCURRENCY_LIMIT_EXCEEDED",
+			})
+			c.Abort()
+			return
+		}
+		defer result.Lease.Release()
+
+		c.Header("X-RateLimit-Remaining-Concurrent", fmt.Sprintf("%d", result.Limit-result.InFlight))
+		span.End()
+		c.Next()
+	}
+}
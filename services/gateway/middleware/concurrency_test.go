@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/api-direct/services/gateway/ratelimit"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestConcurrencyLimitRejectsExtraInFlightRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("api_key_id", "key-1")
+		c.Set("rate_limits", RateLimits{PerMinute: 100, MaxConcurrent: 1})
+	})
+	router.Use(ConcurrencyLimit(ratelimit.NewConcurrencyLimiter(client, ratelimit.ConcurrencyConfig{})))
+	router.GET("/infer", func(c *gin.Context) {
+		if c.Query("block") != "" {
+			close(entered)
+			<-release
+		}
+		c.String(http.StatusOK, "done")
+	})
+
+	first := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/infer?block=1", nil))
+		first <- w.Code
+	}()
+	<-entered
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/infer", nil))
+	var resp struct {
+		Code string `json:"code"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusTooManyRequests || resp.Code != "CONCURRENCY_LIMIT_EXCEEDED" {
+		t.Fatalf("second request = %d %s", w.Code, w.Body.String())
+	}
+
+	close(release)
+	if code := <-first; code != http.StatusOK {
+		t.Fatalf("first request = %d", code)
+	}
+
+	// The slot is free again once the first request finished
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/infer", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("request after release = %d", w.Code)
+	}
+}
@@ -0,0 +1,194 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/api-direct/services/gateway/idempotency"
+	"github.com/api-direct/services/gateway/respcache"
+	"github.com/gin-gonic/gin"
+)
+
+// maxIdempotencyKeyLength bounds the Idempotency-Key header
+const maxIdempotencyKeyLength = 255
+
+// Idempotency middleware replays the first response to a POST or PATCH that
+// carries an Idempotency-Key, so retries after a timeout neither reach the
+// upstream again nor get metered twice. It must run before LogRequest.
+func Idempotency(store *idempotency.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		method := c.Request.Method
+		if key == "" || (method != http.MethodPost && method != http.MethodPatch) {
+			c.Next()
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Idempotency-Key must be at most 255 characters",
+				"code":  "INVALID_IDEMPOTENCY_KEY",
+			})
+			c.Abort()
+			return
+		}
+
+		// The body is held in memory to fingerprint it, so its size is capped
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, store.MaxRequestBytes()))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"error": fmt.Sprintf("Requests with an Idempotency-Key are limited to %d bytes", tooLarge.Limit),
+				"code":  "REQUEST_TOO_LARGE",
+			})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Failed to read request body",
+				"code":  "INVALID_REQUEST",
+			})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		apiKeyID := c.GetString("api_key_id")
+		fingerprint := idempotency.Fingerprint(method, c.Request.URL.Path, c.Request.URL.RawQuery, body)
+
+		stored, lock, err := store.Begin(c.Request.Context(), apiKeyID, key, fingerprint)
+		switch {
+		case errors.Is(err, idempotency.ErrInProgress):
+			c.Header("Retry-After", "1")
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "A request with this Idempotency-Key is still in progress",
+				"code":  "IDEMPOTENCY_KEY_IN_USE",
+			})
+			c.Abort()
+			return
+		case errors.Is(err, idempotency.ErrMismatch):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error": "Idempotency-Key was already used for a different request",
+				"code":  "IDEMPOTENCY_KEY_REUSED",
+			})
+			c.Abort()
+			return
+		case err != nil:
+			// Don't fail the call because the store is unavailable
+			log.Printf("Idempotency store unavailable: %v", err)
+			c.Next()
+			return
+		case stored != nil:
+			replayResponse(c, stored)
+			c.Abort()
+			return
+		}
+
+		// Let the upstream finish even if the client gives up, so its retry
+		// is answered from the stored response
+		ctx := context.WithoutCancel(c.Request.Context())
+		c.Request = c.Request.WithContext(ctx)
+
+		capture := &idempotencyWriter{ResponseWriter: c.Writer, limit: store.MaxBodyBytes()}
+		c.Writer = capture
+		c.Next()
+		c.Writer = capture.ResponseWriter
+
+		if !capture.storable() {
+			if err := store.Release(ctx, lock); err != nil {
+				log.Printf("Failed to release idempotency key: %v", err)
+			}
+			return
+		}
+
+		response := &idempotency.Response{
+			Status: capture.Status(),
+			Header: respcache.StorableHeader(capture.Header()),
+			Body:   capture.body.Bytes(),
+		}
+		if err := store.Complete(ctx, lock, fingerprint, response); err != nil {
+			log.Printf("Failed to store idempotent response: %v", err)
+		}
+	}
+}
+
+// replayResponse writes a stored response
+func replayResponse(c *gin.Context, response *idempotency.Response) {
+	header := c.Writer.Header()
+	for name, values := range response.Header {
+		header[name] = values
+	}
+	header.Set("Idempotent-Replayed", "true")
+	c.Status(response.Status)
+	c.Writer.Write(response.Body)
+}
+
+// idempotencyWriter keeps a copy of the response for replay
+type idempotencyWriter struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	limit    int
+	tooLarge bool
+	flushes  int
+	hijacked bool
+}
+
+func (w *idempotencyWriter) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *idempotencyWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *idempotencyWriter) Flush() {
+	w.flushes++
+	w.ResponseWriter.Flush()
+}
+
+func (w *idempotencyWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.hijacked = true
+	return w.ResponseWriter.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying connection
+func (w *idempotencyWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *idempotencyWriter) capture(data []byte) {
+	if w.tooLarge {
+		return
+	}
+	if w.body.Len()+len(data) > w.limit {
+		w.tooLarge = true
+		w.body = bytes.Buffer{}
+		return
+	}
+	w.body.Write(data)
+}
+
+// storable reports whether the response can be replayed. Gateway errors and
+// rate or quota limits are released so the request can be retried, and
+// streams are never stored.
+func (w *idempotencyWriter) storable() bool {
+	switch w.Status() {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return false
+	}
+	if w.tooLarge || w.hijacked || w.flushes > 1 {
+		return false
+	}
+	return !strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream")
+}
@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/api-direct/services/gateway/idempotency"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// newIdempotentRouter serves POST /charge behind the Idempotency middleware,
+// counting how many requests reach the handler
+func newIdempotentRouter(t *testing.T, handler gin.HandlerFunc) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("api_key_id", "key-1")
+	})
+	router.Use(Idempotency(idempotency.NewStore(client, idempotency.Config{})))
+	router.POST("/charge", handler)
+	return router
+}
+
+func postCharge(router *gin.Engine, key, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/charge", strings.NewReader(body))
+	req.Header.Set("Idempotency-Key", key)
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotencyReplaysFirstResponse(t *testing.T) {
+	var calls int64
+	router := newIdempotentRouter(t, func(c *gin.Context) {
+		n := atomic.AddInt64(&calls, 1)
+		c.Header("X-Charge", "ch_1")
+		c.JSON(http.StatusCreated, gin.H{"call": n})
+	})
+
+	first := postCharge(router, "abc", `{"amount":100}`)
+	replay := postCharge(router, "abc", `{"amount":100}`)
+
+	if calls != 1 {
+		t.Fatalf("handler called %d times, want 1", calls)
+	}
+	if replay.Code != http.StatusCreated || replay.Body.String() != first.Body.String() {
+		t.Errorf("replay = %d %q, want %d %q", replay.Code, replay.Body.String(), first.Code, first.Body.String())
+	}
+	if replay.Header().Get("Idempotent-Replayed") != "true" || replay.Header().Get("X-Charge") != "ch_1" {
+		t.Errorf("unexpected replay headers %v", replay.Header())
+	}
+
+	if w := postCharge(router, "abc", `{"amount":200}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("reused key with different body got %d, want 422", w.Code)
+	}
+	if w := postCharge(router, "other", `{"amount":100}`); w.Code != http.StatusCreated || calls != 2 {
+		t.Errorf("new key got %d after %d calls", w.Code, calls)
+	}
+}
+
+func TestIdempotencyRejectsConcurrentRetries(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	router := newIdempotentRouter(t, func(c *gin.Context) {
+		close(started)
+		<-release
+		c.Status(http.StatusOK)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		postCharge(router, "abc", "")
+		close(done)
+	}()
+	<-started
+
+	w := postCharge(router, "abc", "")
+	if w.Code != http.StatusConflict || w.Header().Get("Retry-After") == "" {
+		t.Errorf("concurrent retry got %d, want 409 with Retry-After", w.Code)
+	}
+
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("first request did not finish")
+	}
+}
+
+func TestIdempotencyReleasesGatewayErrors(t *testing.T) {
+	var calls int64
+	router := newIdempotentRouter(t, func(c *gin.Context) {
+		if atomic.AddInt64(&calls, 1) == 1 {
+			c.Status(http.StatusServiceUnavailable)
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+
+	postCharge(router, "abc", "")
+	if w := postCharge(router, "abc", ""); w.Code != http.StatusOK || calls != 2 {
+		t.Errorf("retry after 503 got %d after %d calls", w.Code, calls)
+	}
+}
+
+func TestIdempotencyRejectsOversizedBodies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	var calls int64
+	router := gin.New()
+	router.Use(Idempotency(idempotency.NewStore(client, idempotency.Config{MaxRequestBytes: 16})))
+	router.POST("/charge", func(c *gin.Context) {
+		atomic.AddInt64(&calls, 1)
+		c.Status(http.StatusCreated)
+	})
+
+	if w := postCharge(router, "abc", `{"amount":100}`); w.Code != http.StatusCreated {
+		t.Errorf("small body = %d", w.Code)
+	}
+	if w := postCharge(router, "def", strings.Repeat("x", 17)); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized body = %d, want 413", w.Code)
+	}
+	if calls != 1 {
+		t.Errorf("handler called %d times, want 1", calls)
+	}
+}
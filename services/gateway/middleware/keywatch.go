@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/api-direct/services/gateway/keywatch"
+	"github.com/gin-gonic/gin"
+)
+
+// ObserveKeyActivity middleware reports where each API key is used from, so
+// the apikey service can quarantine keys that look leaked. It runs before
+// scope enforcement so calls rejected by a key's scopes are reported too.
+func ObserveKeyActivity(reporter *keywatch.Reporter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if reporter != nil {
+			if keyID := c.GetString("api_key_id"); keyID != "" {
+				reporter.Observe(keyID, reporter.SourceFromRequest(c.Request, c.ClientIP()), time.Now().UTC())
+			}
+		}
+		c.Next()
+	}
+}
@@ -1,33 +1,32 @@
 package middleware
 
 import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"io"
+	"bufio"
+	"net"
 	"net/http"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/api-direct/services/gateway/usage"
+	"github.com/api-direct/services/shared/tracing"
 	"github.com/gin-gonic/gin"
 )
 
-type UsageLogRequest struct {
-	SubscriptionID     string `json:"subscription_id"`
-	APIKeyID           string `json:"api_key_id"`
-	Timestamp          string `json:"timestamp"`
-	Endpoint           string `json:"endpoint"`
-	Method             string `json:"method"`
-	StatusCode         int    `json:"status_code"`
-	ResponseTimeMs     int64  `json:"response_time_ms"`
-	RequestSizeBytes   int64  `json:"request_size_bytes"`
-	ResponseSizeBytes  int64  `json:"response_size_bytes"`
-}
+// UsageLogRequest is the usage record reported for each call
+type UsageLogRequest = usage.Record
 
-// Custom response writer to capture response size and status code
+// Custom response writer to capture response size and status code. It passes
+// flushes and connection upgrades through so streaming responses and
+// WebSockets work, and counts bytes on hijacked connections.
 type responseWriter struct {
 	gin.ResponseWriter
-	size       int64
-	statusCode int
+	size         int64
+	statusCode   int
+	streaming    bool
+	flushes      int
+	firstByteAt  time.Time
+	hijackedConn *countingConn
 }
 
 func (w *responseWriter) WriteHeader(code int) {
@@ -36,13 +35,74 @@ func (w *responseWriter) WriteHeader(code int) {
 }
 
 func (w *responseWriter) Write(data []byte) (int, error) {
+	w.markFirstByte()
 	n, err := w.ResponseWriter.Write(data)
 	w.size += int64(n)
 	return n, err
 }
 
-// LogRequest middleware sends usage data to the Metering Service
-func LogRequest(meteringServiceURL string) gin.HandlerFunc {
+func (w *responseWriter) WriteString(s string) (int, error) {
+	w.markFirstByte()
+	n, err := w.ResponseWriter.WriteString(s)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *responseWriter) Flush() {
+	// Upstreams that send their body in several flushed chunks are streaming
+	w.flushes++
+	if w.flushes > 1 {
+		w.streaming = true
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := w.ResponseWriter.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.markFirstByte()
+	w.streaming = true
+	w.statusCode = http.StatusSwitchingProtocols
+	w.hijackedConn = &countingConn{Conn: conn}
+	return w.hijackedConn, rw, nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying connection, e.g.
+// to extend write deadlines for long-lived streams
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *responseWriter) markFirstByte() {
+	if w.firstByteAt.IsZero() {
+		w.firstByteAt = time.Now()
+	}
+}
+
+// countingConn counts bytes exchanged over a hijacked connection
+type countingConn struct {
+	net.Conn
+	read    int64
+	written int64
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.read, int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.written, int64(n))
+	return n, err
+}
+
+// LogRequest middleware hands usage data to the shipper, which delivers it
+// to the Metering Service in batches
+func LogRequest(shipper *usage.Shipper) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Start timer
 		startTime := time.Now()
@@ -63,8 +123,25 @@ func LogRequest(meteringServiceURL string) gin.HandlerFunc {
 		// Process request
 		c.Next()
 
-		// Calculate response time
-		responseTime := time.Since(startTime).Milliseconds()
+		_, span := tracing.Start(c, "gateway.log_request")
+		defer span.End()
+
+		// Calculate response time. Streams report time to first byte, with
+		// their full lifetime in duration.
+		duration := time.Since(startTime).Milliseconds()
+		responseTime := duration
+		if strings.HasPrefix(rw.Header().Get("Content-Type"), "text/event-stream") {
+			rw.streaming = true
+		}
+		if rw.streaming && !rw.firstByteAt.IsZero() {
+			responseTime = rw.firstByteAt.Sub(startTime).Milliseconds()
+		}
+
+		responseSize := rw.size
+		if rw.hijackedConn != nil {
+			responseSize += atomic.LoadInt64(&rw.hijackedConn.written)
+			requestSize += atomic.LoadInt64(&rw.hijackedConn.read)
+		}
 
 		// Get data from context
 		subscriptionID, _ := c.Get("subscription_id")
@@ -73,10 +150,29 @@ func LogRequest(meteringServiceURL string) gin.HandlerFunc {
 		subscriptionIDStr, _ := subscriptionID.(string)
 		apiKeyIDStr, _ := apiKeyID.(string)
 
+		// Calls are billable unless a handler says otherwise
+		cacheHit := c.GetBool("cache_hit")
+		billable := true
+		if value, exists := c.Get("billable"); exists {
+			billable, _ = value.(bool)
+		}
+
+		// Weighted endpoints are billed by the units set by rate limiting
+		units := c.GetInt("units")
+		if units <= 0 {
+			units = 1
+		}
+		// Calls that aren't billed aren't overage either
+		overageUnits := 0
+		if billable {
+			overageUnits = c.GetInt("overage_units")
+		}
+
 		// Only log if we have valid subscription and API key IDs
 		if subscriptionIDStr != "" && apiKeyIDStr != "" {
 			// Prepare usage log
 			usageLog := UsageLogRequest{
+				ID:                usage.NewID(),
 				SubscriptionID:    subscriptionIDStr,
 				APIKeyID:          apiKeyIDStr,
 				Timestamp:         time.Now().UTC().Format(time.RFC3339),
@@ -85,33 +181,17 @@ func LogRequest(meteringServiceURL string) gin.HandlerFunc {
 				StatusCode:        rw.statusCode,
 				ResponseTimeMs:    responseTime,
 				RequestSizeBytes:  requestSize,
-				ResponseSizeBytes: rw.size,
+				ResponseSizeBytes: responseSize,
+				Streaming:         rw.streaming,
+				DurationMs:        duration,
+				CacheHit:          cacheHit,
+				Billable:          billable,
+				Units:             units,
+				OverageUnits:      overageUnits,
+				APIVersion:        c.GetString("api_version"),
 			}
 
-			// Send to metering service asynchronously
-			go func() {
-				jsonData, err := json.Marshal(usageLog)
-				if err != nil {
-					fmt.Printf("Failed to marshal usage log: %v\n", err)
-					return
-				}
-
-				resp, err := http.Post(
-					fmt.Sprintf("%s/api/v1/usage", meteringServiceURL),
-					"application/json",
-					bytes.NewBuffer(jsonData),
-				)
-				if err != nil {
-					fmt.Printf("Failed to send usage log: %v\n", err)
-					return
-				}
-				defer resp.Body.Close()
-
-				if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
-					body, _ := io.ReadAll(resp.Body)
-					fmt.Printf("Metering service returned error: %d - %s\n", resp.StatusCode, string(body))
-				}
-			}()
+			shipper.Ship(usageLog)
 		}
 	}
 }
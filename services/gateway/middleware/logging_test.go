@@ -0,0 +1,173 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/api-direct/services/gateway/proxy"
+	"github.com/api-direct/services/gateway/registry"
+	"github.com/api-direct/services/gateway/usage"
+	"github.com/gin-gonic/gin"
+)
+
+// newStreamingGateway serves route through LogRequest and the proxy, and
+// returns the usage logs the gateway reports
+func newStreamingGateway(t *testing.T, upstreamURL string) (*httptest.Server, <-chan UsageLogRequest) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	reported := make(chan UsageLogRequest, 1)
+	metering := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch struct {
+			Records []UsageLogRequest `json:"records"`
+		}
+		json.NewDecoder(r.Body).Decode(&batch)
+		for _, log := range batch.Records {
+			reported <- log
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(metering.Close)
+
+	shipper, err := usage.NewShipper(usage.Config{
+		MeteringURL:   metering.URL,
+		FlushInterval: 10 * time.Millisecond,
+		SpoolDir:      t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	shipper.Start()
+	t.Cleanup(func() { shipper.Close(context.Background()) })
+
+	route := &registry.Route{Upstreams: []string{upstreamURL}}
+	proxyHandler := proxy.NewHandler(metering.URL, nil, proxy.Config{Timeout: time.Second})
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("subscription_id", "sub-1")
+		c.Set("api_key_id", "key-1")
+	})
+	router.Use(LogRequest(shipper))
+	router.Any("/api/:creator/:apiName/*path", func(c *gin.Context) {
+		proxyHandler.ProxyRequest(c, route)
+	})
+
+	gateway := httptest.NewServer(router)
+	t.Cleanup(gateway.Close)
+	return gateway, reported
+}
+
+func TestServerSentEventsAreFlushedThrough(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		<-release
+		fmt.Fprint(w, "data: second\n\n")
+	}))
+	defer upstream.Close()
+
+	gateway, usage := newStreamingGateway(t, upstream.URL)
+
+	resp, err := http.Get(gateway.URL + "/api/alice/llm/generate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// The first event must arrive while the upstream is still streaming
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	if err != nil || line != "data: first\n" {
+		t.Fatalf("first event = %q, %v", line, err)
+	}
+
+	close(release)
+	rest, _ := io.ReadAll(reader)
+	if !strings.Contains(string(rest), "data: second") {
+		t.Errorf("missing second event in %q", rest)
+	}
+
+	select {
+	case log := <-usage:
+		if !log.Streaming {
+			t.Error("expected stream to be metered as streaming")
+		}
+		if log.ResponseSizeBytes != int64(len("data: first\n\ndata: second\n\n")) {
+			t.Errorf("response size = %d", log.ResponseSizeBytes)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no usage reported")
+	}
+}
+
+func TestUpgradedConnectionsAreMeteredByBytes(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" {
+			t.Errorf("upgrade header not forwarded")
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		rw.Flush()
+
+		// Echo one message back
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(rw, buf); err == nil {
+			conn.Write(buf)
+		}
+	}))
+	defer upstream.Close()
+
+	gateway, usage := newStreamingGateway(t, upstream.URL)
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(gateway.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	fmt.Fprint(conn, "GET /api/alice/chat/ws HTTP/1.1\r\nHost: gateway\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+
+	conn.Write([]byte("ping"))
+	echo := make([]byte, 4)
+	if _, err := io.ReadFull(reader, echo); err != nil || string(echo) != "ping" {
+		t.Fatalf("echo = %q, %v", echo, err)
+	}
+	conn.Close()
+
+	select {
+	case log := <-usage:
+		if log.StatusCode != http.StatusSwitchingProtocols || !log.Streaming {
+			t.Errorf("unexpected usage %+v", log)
+		}
+		if log.RequestSizeBytes < 4 || log.ResponseSizeBytes < 4 {
+			t.Errorf("hijacked bytes not counted: %+v", log)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no usage reported")
+	}
+}
@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"strings"
+	"time"
+
+	"github.com/api-direct/services/gateway/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// unknownLabel labels calls to APIs that aren't known to exist
+const unknownLabel = "unknown"
+
+// Metrics middleware records request rate, errors and latency per API, and
+// the rate limit rejections reported by RateLimit. Calls that didn't pass
+// API key validation are recorded under an unknown API, as anyone can put
+// any creator and API name in a URL.
+func Metrics(m *metrics.Metrics) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		creator, apiName := unknownLabel, unknownLabel
+		if c.GetString("api_key_id") != "" {
+			creator = c.Param("creator")
+			apiName, _, _ = strings.Cut(c.Param("apiName"), "@")
+		}
+		m.ObserveRequest(creator, apiName, c.GetString("upstream"), c.Writer.Status(), time.Since(start))
+
+		if window := c.GetString("rate_limit_window"); window != "" {
+			m.ObserveRateLimited(creator, apiName, window)
+		}
+	}
+}
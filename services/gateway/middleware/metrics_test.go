@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/api-direct/services/gateway/metrics"
+	"github.com/api-direct/services/gateway/proxy"
+	"github.com/api-direct/services/gateway/ratelimit"
+	"github.com/api-direct/services/gateway/registry"
+	"github.com/gin-gonic/gin"
+)
+
+func TestMetricsAreLabelledPerAPI(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer upstream.Close()
+
+	m := metrics.New()
+	proxyHandler := proxy.NewHandler("", nil, proxy.Config{Timeout: time.Second, MaxRetries: 0})
+	m.RegisterCircuits(proxyHandler)
+	route := &registry.Route{Upstreams: []string{upstream.URL}}
+
+	router := gin.New()
+	router.Use(Metrics(m))
+	router.Use(func(c *gin.Context) {
+		c.Set("api_key_id", "key-1")
+		c.Set("rate_limits", RateLimits{PerMinute: 1})
+	})
+	router.Use(RateLimit(ratelimit.NewLocalLimiter()))
+	router.Any("/api/:creator/:apiName/*path", func(c *gin.Context) {
+		proxyHandler.ProxyRequest(c, route)
+	})
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/alice/weather@v2/forecast", nil))
+	}
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := io.ReadAll(w.Body)
+	exposition := string(body)
+
+	for _, want := range []string{
+		`gateway_requests_total{api="weather",creator="alice",status_class="5xx",upstream="` + upstream.URL + `"} 1`,
+		`gateway_requests_total{api="weather",creator="alice",status_class="4xx",upstream="none"} 1`,
+		`gateway_rate_limit_rejections_total{api="weather",creator="alice",window="minute"} 1`,
+		`gateway_request_duration_seconds_count{api="weather",creator="alice",status_class="5xx",upstream="` + upstream.URL + `"} 1`,
+		`gateway_upstream_circuit_state{state="closed",upstream="` + upstream.URL + `"} 1`,
+		`gateway_upstream_consecutive_failures{upstream="` + upstream.URL + `"} 1`,
+	} {
+		if !strings.Contains(exposition, want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+}
+
+func TestMetricsDoNotLabelUnvalidatedCalls(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := metrics.New()
+
+	router := gin.New()
+	router.Use(Metrics(m))
+	router.Any("/api/:creator/:apiName/*path", func(c *gin.Context) {
+		c.Status(http.StatusUnauthorized)
+	})
+
+	for _, path := range []string{"/api/mallory/x1/", "/api/mallory/x2/", "/api/trudy/x3/"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	exposition := w.Body.String()
+
+	want := `gateway_requests_total{api="unknown",creator="unknown",status_class="4xx",upstream="none"} 3`
+	if !strings.Contains(exposition, want) {
+		t.Errorf("metrics missing %s", want)
+	}
+	if strings.Contains(exposition, "mallory") || strings.Contains(exposition, "trudy") {
+		t.Error("unvalidated calls were labelled with their URL")
+	}
+}
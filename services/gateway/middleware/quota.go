@@ -0,0 +1,181 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/api-direct/services/gateway/ratelimit"
+	"github.com/api-direct/services/shared/tracing"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Overage policies decide what happens once a subscription uses up its quota
+const (
+	// OverageBlock rejects calls until the next billing period
+	OverageBlock = "block"
+	// OverageBill allows calls and bills them as overage
+	OverageBill = "overage"
+	// OverageThrottle allows calls at ThrottlePerMinute
+	OverageThrottle = "throttle"
+)
+
+// quotaWarnings are the shares of the quota at which consumers are warned
+var quotaWarnings = []int64{100, 80}
+
+// Quota is a subscription's call allowance per billing period, returned by
+// API key validation
+type Quota struct {
+	// Limit is the number of units included in each billing period
+	Limit int64 `json:"limit"`
+	// PeriodAnchor is when the subscription started; billing periods are
+	// whole months from it
+	PeriodAnchor time.Time `json:"period_anchor"`
+	// Policy is one of block, overage or throttle; empty blocks
+	Policy string `json:"policy,omitempty"`
+	// ThrottlePerMinute is the rate allowed past the quota under the
+	// throttle policy
+	ThrottlePerMinute int `json:"throttle_per_minute,omitempty"`
+}
+
+// QuotaLimit middleware enforces the subscription's quota for the current
+// billing period. Calls consume the units set by RateLimit, so it must run
+// after it, and after Idempotency so replays don't consume quota. Units are
+// refunded for calls a handler marks as not billable, such as rejected
+// requests and free cache hits. Consumers are warned with X-Quota-Warning at
+// 80% and 100% of the quota.
+func QuotaLimit(counter *ratelimit.QuotaCounter, throttle ratelimit.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, _ := c.Get("quota")
+		quota, ok := value.(Quota)
+		subscriptionID := c.GetString("subscription_id")
+		if !ok || quota.Limit <= 0 || subscriptionID == "" {
+			c.Next()
+			return
+		}
+
+		units := c.GetInt("units")
+		if units <= 0 {
+			units = 1
+		}
+		rateLimits, _ := c.Get("rate_limits")
+		limits, _ := rateLimits.(RateLimits)
+		policy, err := ratelimit.ParsePolicy(limits.FailurePolicy)
+		if err != nil {
+			policy = ""
+		}
+
+		periodStart, periodEnd := ratelimit.BillingPeriod(quota.PeriodAnchor, time.Now())
+		key := fmt.Sprintf("quota:%s:%d", subscriptionID, periodStart.Unix())
+
+		ctx, span := tracing.Start(c, "gateway.quota", attribute.String("gateway.overage_policy", quota.Policy))
+		defer span.End()
+
+		// Under the throttle policy calls past the quota are counted only
+		// if the throttle lets them through
+		result, err := counter.Consume(ctx, key, units, quota.Limit, periodEnd, quota.Policy != OverageBill, policy)
+		if err == nil && !result.Allowed && quota.Policy == OverageThrottle {
+			if !throttleAllows(c, throttle, subscriptionID, quota, units) {
+				return
+			}
+			result, err = counter.Consume(ctx, key, units, quota.Limit, periodEnd, false, policy)
+		}
+		if errors.Is(err, ratelimit.ErrUnavailable) {
+			c.Header("Retry-After", "5")
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "Quota enforcement is temporarily unavailable",
+				"code":  "QUOTA_UNAVAILABLE",
+			})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to check quota",
+				"code":  "QUOTA_ERROR",
+			})
+			c.Abort()
+			return
+		}
+		if result.Degraded {
+			c.Header("X-Quota-Degraded", "true")
+			span.End()
+			c.Next()
+			return
+		}
+
+		c.Header("X-Quota-Limit", fmt.Sprintf("%d", result.Limit))
+		c.Header("X-Quota-Remaining", fmt.Sprintf("%d", result.Remaining()))
+		c.Header("X-Quota-Reset", fmt.Sprintf("%d", periodEnd.Unix()))
+		for _, percent := range quotaWarnings {
+			if result.Used*100 >= result.Limit*percent {
+				c.Header("X-Quota-Warning", fmt.Sprintf("%d%%", percent))
+				break
+			}
+		}
+
+		if !result.Allowed {
+			c.Set("rate_limit_window", "quota")
+			span.SetAttributes(attribute.String("gateway.rate_limit_window", "quota"))
+			c.Header("X-Quota-Warning", "100%")
+			c.Header("Retry-After", fmt.Sprintf("%d", int64(math.Ceil(time.Until(periodEnd).Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error":       "Quota for the billing period is used up",
+				"code":        "QUOTA_EXCEEDED",
+				"retry_after": periodEnd.Unix(),
+			})
+			c.Abort()
+			return
+		}
+
+		if quota.Policy == OverageBill && result.Used > result.Limit {
+			// Only the units past the limit are overage
+			c.Set("overage_units", int(min(int64(units), result.Used-result.Limit)))
+			c.Header("X-Quota-Overage", "true")
+		}
+
+		span.End()
+		c.Next()
+
+		if billable, exists := c.Get("billable"); exists && billable == false {
+			if err := counter.Refund(context.WithoutCancel(ctx), key, units); err != nil {
+				log.Printf("Failed to refund quota for %s: %v", subscriptionID, err)
+			}
+		}
+	}
+}
+
+// throttleAllows applies the throttle rate to a call past the quota,
+// rejecting it if the rate is exceeded
+func throttleAllows(c *gin.Context, limiter ratelimit.Limiter, subscriptionID string, quota Quota, units int) bool {
+	if quota.ThrottlePerMinute > 0 {
+		limit := ratelimit.PerMinute(quota.ThrottlePerMinute).WithCost(units)
+		result, err := limiter.Allow(c.Request.Context(), "quota:throttle:"+subscriptionID, limit)
+		if err != nil {
+			// The limiter applies its own failure policy; anything else lets
+			// the call through rather than blocking a paying consumer
+			log.Printf("Failed to check quota throttle: %v", err)
+			return true
+		}
+		c.Header("X-RateLimit-Limit-Throttle", fmt.Sprintf("%d", result.Limit))
+		c.Header("X-RateLimit-Remaining-Throttle", fmt.Sprintf("%d", result.Remaining))
+		if result.Allowed {
+			return true
+		}
+		c.Header("Retry-After", fmt.Sprintf("%d", int64(math.Ceil(result.RetryAfter.Seconds()))))
+	}
+
+	c.Set("rate_limit_window", "quota")
+	c.Header("X-Quota-Warning", "100%")
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error": "Quota for the billing period is used up; calls are throttled",
+		"code":  "QUOTA_THROTTLED",
+	})
+	c.Abort()
+	return false
+}
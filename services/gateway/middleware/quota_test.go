@@ -0,0 +1,156 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/api-direct/services/gateway/idempotency"
+	"github.com/api-direct/services/gateway/ratelimit"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestQuotaLimitPolicies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	counter := ratelimit.NewQuotaCounter(client, ratelimit.QuotaConfig{})
+
+	newRouter := func(subscriptionID string, quota Quota, overage *int) *gin.Engine {
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Set("subscription_id", subscriptionID)
+			c.Set("quota", quota)
+		})
+		router.Use(QuotaLimit(counter, ratelimit.NewLocalLimiter()))
+		router.GET("/call", func(c *gin.Context) {
+			*overage = c.GetInt("overage_units")
+			c.Status(http.StatusOK)
+		})
+		return router
+	}
+	call := func(router *gin.Engine) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/call", nil))
+		return w
+	}
+	anchor := time.Now().AddDate(0, -2, 0)
+
+	t.Run("block", func(t *testing.T) {
+		var overage int
+		router := newRouter("sub-block", Quota{Limit: 5, PeriodAnchor: anchor, Policy: OverageBlock}, &overage)
+
+		for i := 1; i <= 5; i++ {
+			w := call(router)
+			if w.Code != http.StatusOK {
+				t.Fatalf("call %d = %d", i, w.Code)
+			}
+			want := map[int]string{4: "80%", 5: "100%"}[i]
+			if got := w.Header().Get("X-Quota-Warning"); got != want {
+				t.Errorf("call %d warning = %q, want %q", i, got, want)
+			}
+		}
+
+		w := call(router)
+		var resp struct {
+			Code string `json:"code"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Code != http.StatusTooManyRequests || resp.Code != "QUOTA_EXCEEDED" || w.Header().Get("Retry-After") == "" {
+			t.Errorf("call past quota = %d %s %v", w.Code, w.Body.String(), w.Header())
+		}
+	})
+
+	t.Run("overage", func(t *testing.T) {
+		var overage int
+		router := newRouter("sub-overage", Quota{Limit: 2, PeriodAnchor: anchor, Policy: OverageBill}, &overage)
+
+		for i := 1; i <= 4; i++ {
+			w := call(router)
+			if w.Code != http.StatusOK {
+				t.Fatalf("call %d = %d", i, w.Code)
+			}
+			wantOverage := 0
+			if i > 2 {
+				wantOverage = 1
+			}
+			if overage != wantOverage || (w.Header().Get("X-Quota-Overage") == "true") != (i > 2) {
+				t.Errorf("call %d overage units = %d, headers %v", i, overage, w.Header())
+			}
+		}
+	})
+
+	t.Run("throttle", func(t *testing.T) {
+		var overage int
+		router := newRouter("sub-throttle", Quota{Limit: 1, PeriodAnchor: anchor, Policy: OverageThrottle, ThrottlePerMinute: 1}, &overage)
+
+		for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+			if w := call(router); w.Code != want {
+				t.Errorf("call %d = %d, want %d", i+1, w.Code, want)
+			}
+		}
+		if overage != 0 {
+			t.Errorf("throttled calls recorded %d overage units", overage)
+		}
+	})
+}
+
+func TestQuotaLimitSkipsReplaysAndRefundsUnbilledCalls(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	counter := ratelimit.NewQuotaCounter(client, ratelimit.QuotaConfig{})
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("api_key_id", "key-1")
+		c.Set("subscription_id", "sub-1")
+		c.Set("quota", Quota{Limit: 2, PeriodAnchor: time.Now().AddDate(0, -1, 0), Policy: OverageBlock})
+	})
+	router.Use(Idempotency(idempotency.NewStore(client, idempotency.Config{})))
+	router.Use(QuotaLimit(counter, ratelimit.NewLocalLimiter()))
+	router.POST("/charge", func(c *gin.Context) {
+		c.Status(http.StatusCreated)
+	})
+	router.POST("/invalid", func(c *gin.Context) {
+		c.Set("billable", false)
+		c.Status(http.StatusBadRequest)
+	})
+
+	post := func(path, key string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{}`))
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// The first call uses one of two units; its replays use none
+	for i := 0; i < 3; i++ {
+		if code := post("/charge", "abc"); code != http.StatusCreated {
+			t.Fatalf("charge %d = %d", i+1, code)
+		}
+	}
+	// Unbilled calls are refunded
+	for i := 0; i < 3; i++ {
+		if code := post("/invalid", ""); code != http.StatusBadRequest {
+			t.Fatalf("invalid call %d = %d", i+1, code)
+		}
+	}
+
+	if code := post("/charge", "def"); code != http.StatusCreated {
+		t.Errorf("second billable call = %d, want it within the quota", code)
+	}
+	if code := post("/charge", "ghi"); code != http.StatusTooManyRequests {
+		t.Errorf("third billable call = %d, want the quota used up", code)
+	}
+}
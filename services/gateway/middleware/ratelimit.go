@@ -1,16 +1,151 @@
 package middleware
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/api-direct/services/gateway/ratelimit"
+	"github.com/api-direct/services/shared/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-// RateLimit middleware enforces rate limits based on subscription
-func RateLimit(limiter *ratelimit.RedisRateLimiter) gin.HandlerFunc {
+// RateLimits are the subscription limits returned by API key validation
+type RateLimits struct {
+	PerMinute int `json:"per_minute"`
+	PerDay    int `json:"per_day"`
+	PerMonth  int `json:"per_month"`
+	// MaxConcurrent caps in-flight requests per API key; 0 is unlimited
+	MaxConcurrent int `json:"max_concurrent,omitempty"`
+	// FailurePolicy is the plan's behaviour when the shared limiter is
+	// unavailable; empty uses the gateway default
+	FailurePolicy string `json:"failure_policy,omitempty"`
+	// Endpoints override limits and weights for individual endpoints
+	Endpoints []EndpointLimit `json:"endpoints,omitempty"`
+}
+
+// EndpointLimit sets how calls to one endpoint count against the plan
+type EndpointLimit struct {
+	// Method restricts the rule to one HTTP method; empty matches any
+	Method string `json:"method,omitempty"`
+	// Path is the endpoint below the API, e.g. /v1/batch-predict. A
+	// trailing /* matches every path under the prefix.
+	Path string `json:"path"`
+	// Weight is the number of units a call consumes from the plan's limits
+	// and is billed for; 0 counts as 1
+	Weight int `json:"weight,omitempty"`
+	// PerMinute caps calls to this endpoint per API key; 0 is no cap
+	PerMinute int `json:"per_minute,omitempty"`
+}
+
+// weight returns the units a call to the endpoint consumes
+func (e *EndpointLimit) weight() int {
+	if e == nil || e.Weight <= 0 {
+		return 1
+	}
+	return e.Weight
+}
+
+// scope identifies the endpoint's own rate limit key
+func (e *EndpointLimit) scope() string {
+	method := strings.ToUpper(e.Method)
+	if method == "" {
+		method = "*"
+	}
+	return method + ":" + e.Path
+}
+
+// endpoint returns the most specific rule matching a call, or nil. Exact
+// paths win over prefixes, longer prefixes over shorter ones and rules for
+// the call's method over rules for any method.
+func (r RateLimits) endpoint(method, path string) *EndpointLimit {
+	var best *EndpointLimit
+	bestScore := -1
+	for i := range r.Endpoints {
+		rule := &r.Endpoints[i]
+		if rule.Method != "" && !strings.EqualFold(rule.Method, method) {
+			continue
+		}
+
+		var score int
+		if prefix, ok := strings.CutSuffix(rule.Path, "/*"); ok {
+			if path != prefix && !strings.HasPrefix(path, prefix+"/") {
+				continue
+			}
+			score = 2 * len(prefix)
+		} else if rule.Path == path {
+			score = 2*len(path) + 2
+		} else {
+			continue
+		}
+		if rule.Method != "" {
+			score++
+		}
+
+		if score > bestScore {
+			best, bestScore = rule, score
+		}
+	}
+	return best
+}
+
+// rateWindow is a single limit enforced by the RateLimit middleware
+type rateWindow struct {
+	name   string
+	header string
+	limit  ratelimit.Limit
+	// scope separates the counters of windows that share a name
+	scope string
+}
+
+// key returns the limiter key of the window for an API key
+func (w rateWindow) key(apiKeyID string) string {
+	key := fmt.Sprintf("rate:%s:%s", w.name, apiKeyID)
+	if w.scope != "" {
+		key += ":" + w.scope
+	}
+	return key
+}
+
+// windows returns the limits that apply to a call to endpoint, which may be
+// nil. The endpoint's own cap comes first so calls it rejects don't use up
+// the plan's limits; the plan's limits follow, shortest first, each charged
+// the endpoint's weight.
+func (r RateLimits) windows(endpoint *EndpointLimit) []rateWindow {
+	policy, err := ratelimit.ParsePolicy(r.FailurePolicy)
+	if err != nil {
+		// Unknown policies fall back to the gateway default
+		policy = ""
+	}
+
+	var windows []rateWindow
+	if endpoint != nil && endpoint.PerMinute > 0 {
+		windows = append(windows, rateWindow{"endpoint", "Endpoint", ratelimit.PerMinute(endpoint.PerMinute), endpoint.scope()})
+	}
+	weight := endpoint.weight()
+	if r.PerMinute > 0 {
+		windows = append(windows, rateWindow{"minute", "Minute", ratelimit.PerMinute(r.PerMinute).WithCost(weight), ""})
+	}
+	if r.PerDay > 0 {
+		windows = append(windows, rateWindow{"day", "Day", ratelimit.PerDay(r.PerDay).WithCost(weight), ""})
+	}
+	if r.PerMonth > 0 {
+		windows = append(windows, rateWindow{"month", "Month", ratelimit.PerMonth(r.PerMonth).WithCost(weight), ""})
+	}
+	for i := range windows {
+		windows[i].limit.OnFailure = policy
+	}
+	return windows
+}
+
+// RateLimit middleware enforces rate limits based on subscription. Calls to
+// endpoints with a weight consume that many units, which are also recorded
+// as the call's billable units.
+func RateLimit(limiter ratelimit.Limiter) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get rate limits from context (set by API key validation)
 		rateLimitsInterface, exists := c.Get("rate_limits")
@@ -23,12 +158,7 @@ func RateLimit(limiter *ratelimit.RedisRateLimiter) gin.HandlerFunc {
 			return
 		}
 
-		// Type assert the rate limits
-		rateLimits, ok := rateLimitsInterface.(struct {
-			PerMinute int `json:"per_minute"`
-			PerDay    int `json:"per_day"`
-			PerMonth  int `json:"per_month"`
-		})
+		rateLimits, ok := rateLimitsInterface.(RateLimits)
 		if !ok {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": "Invalid rate limits format",
@@ -50,69 +180,42 @@ func RateLimit(limiter *ratelimit.RedisRateLimiter) gin.HandlerFunc {
 			return
 		}
 
-		// Check per-minute rate limit
-		if rateLimits.PerMinute > 0 {
-			key := fmt.Sprintf("rate:minute:%s", apiKeyIDStr)
-			allowed, remaining, resetTime, err := limiter.CheckLimit(c.Request.Context(), key, rateLimits.PerMinute, time.Minute)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": "Failed to check rate limit",
-					"code":  "RATE_LIMIT_ERROR",
-				})
-				c.Abort()
-				return
-			}
+		endpoint := rateLimits.endpoint(c.Request.Method, endpointPath(c.Param("path")))
+		weight := endpoint.weight()
+		c.Set("units", weight)
+		if endpoint != nil {
+			c.Header("X-RateLimit-Cost", fmt.Sprintf("%d", weight))
+		}
 
-			// Set rate limit headers
-			c.Header("X-RateLimit-Limit-Minute", fmt.Sprintf("%d", rateLimits.PerMinute))
-			c.Header("X-RateLimit-Remaining-Minute", fmt.Sprintf("%d", remaining))
-			c.Header("X-RateLimit-Reset", fmt.Sprintf("%d", resetTime.Unix()))
+		ctx, span := tracing.Start(c, "gateway.rate_limit", attribute.Int("gateway.call_weight", weight))
+		defer span.End()
 
-			if !allowed {
-				c.Header("Retry-After", fmt.Sprintf("%d", resetTime.Unix()-time.Now().Unix()))
+		for _, window := range rateLimits.windows(endpoint) {
+			if window.limit.Cost > window.limit.Count {
+				// The call can never fit in this window, so don't ask for a retry
+				c.Set("rate_limit_window", window.name)
 				c.JSON(http.StatusTooManyRequests, gin.H{
-					"error": "Rate limit exceeded (per minute)",
+					"error": fmt.Sprintf("Call weight %d exceeds the per %s limit of %d", window.limit.Cost, window.name, window.limit.Count),
 					"code":  "RATE_LIMIT_EXCEEDED",
-					"retry_after": resetTime.Unix(),
 				})
 				c.Abort()
 				return
 			}
-		}
 
-		// Check per-day rate limit
-		if rateLimits.PerDay > 0 {
-			key := fmt.Sprintf("rate:day:%s", apiKeyIDStr)
-			allowed, remaining, resetTime, err := limiter.CheckLimit(c.Request.Context(), key, rateLimits.PerDay, 24*time.Hour)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": "Failed to check rate limit",
-					"code":  "RATE_LIMIT_ERROR",
+			result, err := limiter.Allow(ctx, window.key(apiKeyIDStr), window.limit)
+			if errors.Is(err, ratelimit.ErrUnavailable) {
+				retryAfter := 5 * time.Second
+				if r, ok := limiter.(interface{ RetryAfter() time.Duration }); ok {
+					retryAfter = r.RetryAfter()
+				}
+				c.Header("Retry-After", fmt.Sprintf("%d", int64(math.Ceil(retryAfter.Seconds()))))
+				c.JSON(http.StatusServiceUnavailable, gin.H{
+					"error": "Rate limiting is temporarily unavailable",
+					"code":  "RATE_LIMIT_UNAVAILABLE",
 				})
 				c.Abort()
 				return
 			}
-
-			// Set rate limit headers
-			c.Header("X-RateLimit-Limit-Day", fmt.Sprintf("%d", rateLimits.PerDay))
-			c.Header("X-RateLimit-Remaining-Day", fmt.Sprintf("%d", remaining))
-
-			if !allowed {
-				c.Header("Retry-After", fmt.Sprintf("%d", resetTime.Unix()-time.Now().Unix()))
-				c.JSON(http.StatusTooManyRequests, gin.H{
-					"error": "Rate limit exceeded (per day)",
-					"code":  "RATE_LIMIT_EXCEEDED",
-					"retry_after": resetTime.Unix(),
-				})
-				c.Abort()
-				return
-			}
-		}
-
-		// Check per-month rate limit
-		if rateLimits.PerMonth > 0 {
-			key := fmt.Sprintf("rate:month:%s", apiKeyIDStr)
-			allowed, remaining, resetTime, err := limiter.CheckLimit(c.Request.Context(), key, rateLimits.PerMonth, 30*24*time.Hour)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{
 					"error": "Failed to check rate limit",
@@ -123,21 +226,31 @@ func RateLimit(limiter *ratelimit.RedisRateLimiter) gin.HandlerFunc {
 			}
 
 			// Set rate limit headers
-			c.Header("X-RateLimit-Limit-Month", fmt.Sprintf("%d", rateLimits.PerMonth))
-			c.Header("X-RateLimit-Remaining-Month", fmt.Sprintf("%d", remaining))
+			c.Header("X-RateLimit-Limit-"+window.header, fmt.Sprintf("%d", result.Limit))
+			c.Header("X-RateLimit-Remaining-"+window.header, fmt.Sprintf("%d", result.Remaining))
+			if window.name == "minute" {
+				c.Header("X-RateLimit-Reset", fmt.Sprintf("%d", result.ResetAt.Unix()))
+			}
+			if result.Degraded {
+				c.Header("X-RateLimit-Degraded", "true")
+			}
 
-			if !allowed {
-				c.Header("Retry-After", fmt.Sprintf("%d", resetTime.Unix()-time.Now().Unix()))
+			if !result.Allowed {
+				c.Set("rate_limit_window", window.name)
+				span.SetAttributes(attribute.String("gateway.rate_limit_window", window.name))
+				retryAt := time.Now().Add(result.RetryAfter)
+				c.Header("Retry-After", fmt.Sprintf("%d", int64(math.Ceil(result.RetryAfter.Seconds()))))
 				c.JSON(http.StatusTooManyRequests, gin.H{
-					"error": "Rate limit exceeded (per month)",
-					"code":  "RATE_LIMIT_EXCEEDED",
-					"retry_after": resetTime.Unix(),
+					"error":       fmt.Sprintf("Rate limit exceeded (per %s)", window.name),
+					"code":        "RATE_LIMIT_EXCEEDED",
+					"retry_after": retryAt.Unix(),
 				})
 				c.Abort()
 				return
 			}
 		}
 
+		span.End()
 		c.Next()
 	}
 }
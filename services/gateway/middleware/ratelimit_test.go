@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/api-direct/services/gateway/ratelimit"
+	"github.com/gin-gonic/gin"
+)
+
+func TestEndpointRuleMatching(t *testing.T) {
+	limits := RateLimits{Endpoints: []EndpointLimit{
+		{Path: "/v1/*", Weight: 2},
+		{Path: "/v1/models/*", Weight: 3},
+		{Path: "/v1/models/large", Weight: 10},
+		{Method: "POST", Path: "/v1/models/*", Weight: 5},
+	}}
+
+	tests := []struct {
+		method, path string
+		weight       int
+	}{
+		{"GET", "/v1/health", 2},
+		{"GET", "/v1/models/small", 3},
+		{"POST", "/v1/models/small", 5},
+		{"POST", "/v1/models/large", 10},
+		{"GET", "/v1/models", 3},
+		{"GET", "/v2/health", 1},
+		{"GET", "/v1models", 1},
+		// Rules match the endpoint a path resolves to
+		{"GET", "/v2/../v1/models/large", 10},
+		{"GET", "/v1/models/../../v2/health", 1},
+		{"GET", "/v1//models/large", 10},
+	}
+	for _, tt := range tests {
+		if got := limits.endpoint(tt.method, endpointPath(tt.path)).weight(); got != tt.weight {
+			t.Errorf("%s %s weight = %d, want %d", tt.method, tt.path, got, tt.weight)
+		}
+	}
+}
+
+func TestRateLimitChargesEndpointWeights(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var units int
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("api_key_id", "key-1")
+		c.Set("rate_limits", RateLimits{
+			PerMinute: 10,
+			Endpoints: []EndpointLimit{
+				{Method: "POST", Path: "/v1/batch-predict", Weight: 4},
+				{Path: "/v1/train", Weight: 20},
+				{Path: "/v1/models/*", PerMinute: 1},
+			},
+		})
+	})
+	router.Use(RateLimit(ratelimit.NewLocalLimiter()))
+	router.Any("/api/:creator/:apiName/*path", func(c *gin.Context) {
+		units = c.GetInt("units")
+		c.Status(http.StatusOK)
+	})
+
+	call := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, "/api/alice/ml"+path, nil))
+		return w
+	}
+
+	// The endpoint's own cap rejects without using up the plan's limit
+	if w := call("GET", "/v1/models/a"); w.Code != http.StatusOK || units != 1 {
+		t.Fatalf("first model call = %d, units %d", w.Code, units)
+	}
+	if w := call("GET", "/v1/models/b"); w.Code != http.StatusTooManyRequests || w.Header().Get("X-RateLimit-Remaining-Endpoint") != "0" {
+		t.Fatalf("second model call = %d %v", w.Code, w.Header())
+	}
+
+	for i := 0; i < 2; i++ {
+		w := call("POST", "/v1/batch-predict")
+		if w.Code != http.StatusOK || units != 4 || w.Header().Get("X-RateLimit-Cost") != "4" {
+			t.Fatalf("batch call %d = %d, units %d, headers %v", i, w.Code, units, w.Header())
+		}
+	}
+	if w := call("POST", "/v1/batch-predict"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("batch call over the limit = %d", w.Code)
+	}
+
+	// One unit is left for an unweighted call
+	if w := call("GET", "/v1/health"); w.Code != http.StatusOK || units != 1 || w.Header().Get("X-RateLimit-Remaining-Minute") != "0" {
+		t.Errorf("health call = %d, units %d, headers %v", w.Code, units, w.Header())
+	}
+
+	// A call heavier than the whole window is rejected without a retry hint
+	if w := call("GET", "/v1/train"); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "" {
+		t.Errorf("train call = %d %v", w.Code, w.Header())
+	}
+}
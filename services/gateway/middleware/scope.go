@@ -0,0 +1,130 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// KeyScopes restrict what an API key can call, as returned by API key
+// validation. Each list is an allowlist; an empty list allows everything.
+type KeyScopes struct {
+	// APIs are "<creator>/<apiName>" in lower case
+	APIs []string `json:"apis,omitempty"`
+	// Paths are endpoints below the API. A trailing /* matches every path
+	// under the prefix.
+	Paths       []string   `json:"paths,omitempty"`
+	Methods     []string   `json:"methods,omitempty"`
+	SourceCIDRs []string   `json:"source_cidrs,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+// EnforceKeyScopes middleware rejects calls outside the scopes of the API
+// key they were made with. It runs after ValidateAPIKey, so validations
+// served from cache can't outlive the key's expiry.
+func EnforceKeyScopes() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, exists := c.Get("key_scopes")
+		if !exists {
+			c.Next()
+			return
+		}
+		scopes, _ := value.(KeyScopes)
+
+		if scopes.ExpiresAt != nil && !time.Now().Before(*scopes.ExpiresAt) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "API key has expired",
+				"code":  "API_KEY_EXPIRED",
+			})
+			c.Abort()
+			return
+		}
+
+		// The API name may carry a pinned version
+		apiName, _, _ := strings.Cut(c.Param("apiName"), "@")
+		api := strings.ToLower(c.Param("creator") + "/" + apiName)
+		if len(scopes.APIs) > 0 && !contains(scopes.APIs, api) {
+			scopeDenied(c, "KEY_SCOPE_API", "API key is not allowed to call this API")
+			return
+		}
+
+		if len(scopes.Methods) > 0 && !contains(scopes.Methods, c.Request.Method) {
+			scopeDenied(c, "KEY_SCOPE_METHOD", "API key is not allowed to use this method")
+			return
+		}
+
+		if len(scopes.Paths) > 0 && !pathAllowed(scopes.Paths, endpointPath(c.Param("path"))) {
+			scopeDenied(c, "KEY_SCOPE_PATH", "API key is not allowed to call this endpoint")
+			return
+		}
+
+		if len(scopes.SourceCIDRs) > 0 && !sourceAllowed(scopes.SourceCIDRs, c.ClientIP()) {
+			scopeDenied(c, "KEY_SCOPE_SOURCE_IP", "API key is not allowed from this IP address")
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func scopeDenied(c *gin.Context, code, message string) {
+	c.JSON(http.StatusForbidden, gin.H{
+		"error": message,
+		"code":  code,
+	})
+	c.Abort()
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// endpointPath resolves the dot segments and repeated slashes of a call's
+// path, as the upstream will, so that scopes and endpoint rules see the
+// endpoint actually called rather than e.g. /public/../admin
+func endpointPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// pathAllowed reports whether path matches one of the patterns, exactly or
+// below a /* prefix
+func pathAllowed(patterns []string, path string) bool {
+	if path == "" {
+		path = "/"
+	}
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+			if path == prefix || strings.HasPrefix(path, prefix+"/") {
+				return true
+			}
+		} else if pattern == path {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceAllowed reports whether ip is in one of the networks. Malformed
+// networks match nothing.
+func sourceAllowed(cidrs []string, ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err == nil && network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestEnforceKeyScopes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	expired := time.Now().Add(-time.Minute)
+	readOnly := KeyScopes{
+		APIs:        []string{"alice/weather"},
+		Paths:       []string{"/forecast/*", "/status"},
+		Methods:     []string{"GET"},
+		SourceCIDRs: []string{"10.0.0.0/8"},
+	}
+
+	tests := []struct {
+		name     string
+		scopes   *KeyScopes
+		method   string
+		path     string
+		remote   string
+		wantCode int
+		wantErr  string
+	}{
+		{"unrestricted key", nil, http.MethodDelete, "/api/bob/maps/tiles", "192.0.2.1:1234", http.StatusOK, ""},
+		{"allowed call", &readOnly, http.MethodGet, "/api/Alice/weather@v2/forecast/london", "10.1.2.3:1234", http.StatusOK, ""},
+		{"exact path", &readOnly, http.MethodGet, "/api/alice/weather/status", "10.1.2.3:1234", http.StatusOK, ""},
+		{"other API", &readOnly, http.MethodGet, "/api/alice/maps/forecast/london", "10.1.2.3:1234", http.StatusForbidden, "KEY_SCOPE_API"},
+		{"write method", &readOnly, http.MethodPost, "/api/alice/weather/forecast/london", "10.1.2.3:1234", http.StatusForbidden, "KEY_SCOPE_METHOD"},
+		{"other path", &readOnly, http.MethodGet, "/api/alice/weather/forecasts", "10.1.2.3:1234", http.StatusForbidden, "KEY_SCOPE_PATH"},
+		{"dot segments", &readOnly, http.MethodGet, "/api/alice/weather/forecast/../admin", "10.1.2.3:1234", http.StatusForbidden, "KEY_SCOPE_PATH"},
+		{"encoded dot segments", &readOnly, http.MethodGet, "/api/alice/weather/forecast/%2e%2e/admin", "10.1.2.3:1234", http.StatusForbidden, "KEY_SCOPE_PATH"},
+		{"dot segments within scope", &readOnly, http.MethodGet, "/api/alice/weather/forecast/../forecast/london", "10.1.2.3:1234", http.StatusOK, ""},
+		{"other network", &readOnly, http.MethodGet, "/api/alice/weather/status", "192.0.2.1:1234", http.StatusForbidden, "KEY_SCOPE_SOURCE_IP"},
+		{"expired key", &KeyScopes{ExpiresAt: &expired}, http.MethodGet, "/api/alice/weather/status", "10.1.2.3:1234", http.StatusUnauthorized, "API_KEY_EXPIRED"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Any("/api/:creator/:apiName/*path", func(c *gin.Context) {
+				if tt.scopes != nil {
+					c.Set("key_scopes", *tt.scopes)
+				}
+			}, EnforceKeyScopes(), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.RemoteAddr = tt.remote
+			router.ServeHTTP(w, req)
+
+			var resp struct {
+				Code string `json:"code"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			if w.Code != tt.wantCode || resp.Code != tt.wantErr {
+				t.Errorf("got %d %q, want %d %q", w.Code, resp.Code, tt.wantCode, tt.wantErr)
+			}
+		})
+	}
+}
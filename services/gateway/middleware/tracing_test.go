@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/api-direct/services/gateway/proxy"
+	"github.com/api-direct/services/gateway/ratelimit"
+	"github.com/api-direct/services/gateway/registry"
+	"github.com/api-direct/services/gateway/usage"
+	"github.com/api-direct/services/shared/tracing"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTraceContextFlowsThroughThePipeline(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	exporter := tracetest.NewInMemoryExporter()
+	shutdown, err := tracing.Setup(context.Background(), tracing.Config{
+		ServiceName:  "gateway",
+		SpanExporter: exporter,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown(context.Background())
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	var apikeyParent, upstreamParent string
+
+	apikey := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apikeyParent = r.Header.Get("traceparent")
+		w.Write([]byte(`{"valid": true, "subscription_id": "sub-1", "api_key_id": "key-1", "rate_limits": {"per_minute": 10}}`))
+	}))
+	defer apikey.Close()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamParent = r.Header.Get("traceparent")
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	shipper, err := usage.NewShipper(usage.Config{MeteringURL: "http://127.0.0.1:0", SpoolDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	route := &registry.Route{Upstreams: []string{upstream.URL}}
+	proxyHandler := proxy.NewHandler("", nil, proxy.Config{Timeout: time.Second})
+
+	router := gin.New()
+	router.Use(tracing.Middleware("gateway"))
+	router.Use(ValidateAPIKey(apikey.URL, nil, nil))
+	router.Use(RateLimit(ratelimit.NewLocalLimiter()))
+	router.Use(LogRequest(shipper))
+	router.Any("/api/:creator/:apiName/*path", func(c *gin.Context) {
+		proxyHandler.ProxyRequest(c, route)
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/alice/weather/forecast", nil)
+	req.Header.Set("X-API-Key", "key")
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+
+	for name, parent := range map[string]string{"apikey": apikeyParent, "upstream": upstreamParent} {
+		if !strings.HasPrefix(parent, "00-"+traceID+"-") {
+			t.Errorf("%s received traceparent %q, want trace %s", name, parent, traceID)
+		}
+	}
+
+	spans := map[string]bool{}
+	for _, span := range exporter.GetSpans() {
+		if span.SpanContext.TraceID().String() != traceID {
+			t.Errorf("span %s is in trace %s", span.Name, span.SpanContext.TraceID())
+		}
+		spans[span.Name] = true
+	}
+	for _, name := range []string{"gateway.validate_api_key", "gateway.rate_limit", "gateway.proxy", "gateway.log_request"} {
+		if !spans[name] {
+			t.Errorf("missing span %s in %v", name, spans)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+// Package oauth validates access tokens issued by the apikey service's
+// OAuth2 token endpoint. Tokens are verified locally against a cached JWKS,
+// so validating one doesn't need a network call.
+package oauth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// ErrInvalidToken is returned for tokens that are malformed, expired,
+	// for another API or not signed by a known key
+	ErrInvalidToken = errors.New("invalid access token")
+	// ErrKeysUnavailable is returned when no signing keys could be loaded
+	ErrKeysUnavailable = errors.New("token signing keys unavailable")
+)
+
+// Config controls token validation
+type Config struct {
+	// JWKSURL is where the apikey service publishes its signing keys
+	JWKSURL string
+	// Issuer is the expected iss claim
+	Issuer string
+	// RefreshInterval is how often keys are refetched
+	RefreshInterval time.Duration
+	// MinRefreshInterval bounds refetches triggered by tokens signed with
+	// an unknown key
+	MinRefreshInterval time.Duration
+	// Leeway allows for clock skew when checking exp and nbf
+	Leeway time.Duration
+	Client *http.Client
+}
+
+// claims are the claims of an access token. Subscription has the shape of
+// an API key validation response.
+type claims struct {
+	ClientID     string          `json:"client_id"`
+	Subscription json.RawMessage `json:"subscription"`
+	jwt.RegisteredClaims
+}
+
+// Verifier validates access tokens against the apikey service's JWKS
+type Verifier struct {
+	config Config
+	parser *jwt.Parser
+
+	mu        sync.RWMutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+
+	// fetchMu serializes refetches
+	fetchMu sync.Mutex
+}
+
+// NewVerifier creates a token verifier. Keys are loaded on first use and
+// refreshed by Watch.
+func NewVerifier(config Config) *Verifier {
+	if config.Issuer == "" {
+		config.Issuer = "api-direct"
+	}
+	if config.RefreshInterval <= 0 {
+		config.RefreshInterval = 5 * time.Minute
+	}
+	if config.MinRefreshInterval <= 0 {
+		config.MinRefreshInterval = 30 * time.Second
+	}
+	if config.Leeway <= 0 {
+		config.Leeway = 30 * time.Second
+	}
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: 5 * time.Second}
+	}
+	return &Verifier{
+		config: config,
+		parser: jwt.NewParser(
+			jwt.WithValidMethods([]string{"RS256"}),
+			jwt.WithIssuer(config.Issuer),
+			jwt.WithLeeway(config.Leeway),
+			jwt.WithExpirationRequired(),
+		),
+		keys: make(map[string]*rsa.PublicKey),
+	}
+}
+
+// IsToken reports whether a credential looks like a JWT rather than an API key
+func IsToken(credential string) bool {
+	return strings.HasPrefix(credential, "eyJ") && strings.Count(credential, ".") == 2
+}
+
+// Verify checks an access token for an API ("<creator>/<apiName>") and
+// returns its subscription claim
+func (v *Verifier) Verify(ctx context.Context, token, api string) (json.RawMessage, error) {
+	var parsed claims
+	_, err := v.parser.ParseWithClaims(token, &parsed, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.key(ctx, kid)
+	})
+	if errors.Is(err, ErrKeysUnavailable) {
+		return nil, ErrKeysUnavailable
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	// Tokens are scoped to the subscribed API
+	audience := strings.ToLower(api)
+	matched := false
+	for _, aud := range parsed.Audience {
+		if aud == audience {
+			matched = true
+		}
+	}
+	if !matched || len(parsed.Subscription) == 0 {
+		return nil, fmt.Errorf("%w: token is not for %s", ErrInvalidToken, api)
+	}
+	return parsed.Subscription, nil
+}
+
+// key returns the public key with a key ID, refetching the key set if the
+// ID is unknown and the keys weren't fetched recently
+func (v *Verifier) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	v.mu.RLock()
+	key, ok := v.keys[kid]
+	stale := time.Since(v.fetchedAt) >= v.config.MinRefreshInterval
+	v.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+	if !stale {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	if err := v.refresh(ctx, v.config.MinRefreshInterval); err != nil {
+		v.mu.RLock()
+		empty := len(v.keys) == 0
+		v.mu.RUnlock()
+		if empty {
+			return nil, ErrKeysUnavailable
+		}
+		log.Printf("Failed to refresh token signing keys: %v", err)
+	}
+
+	v.mu.RLock()
+	key, ok = v.keys[kid]
+	v.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// Watch refreshes the signing keys until ctx is cancelled
+func (v *Verifier) Watch(ctx context.Context) {
+	if err := v.refresh(ctx, 0); err != nil {
+		log.Printf("Failed to load token signing keys: %v", err)
+	}
+
+	ticker := time.NewTicker(v.config.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := v.refresh(ctx, 0); err != nil {
+				log.Printf("Failed to refresh token signing keys: %v", err)
+			}
+		}
+	}
+}
+
+// refresh fetches the key set unless it was fetched within minAge
+func (v *Verifier) refresh(ctx context.Context, minAge time.Duration) error {
+	v.fetchMu.Lock()
+	defer v.fetchMu.Unlock()
+
+	v.mu.RLock()
+	fresh := minAge > 0 && time.Since(v.fetchedAt) < minAge
+	v.mu.RUnlock()
+	if fresh {
+		// Another request refreshed while this one waited
+		return nil
+	}
+
+	keys, err := v.fetch(ctx)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	// Failed fetches count too, so an unavailable apikey service isn't
+	// called for every token with an unknown key
+	v.fetchedAt = time.Now()
+	if err != nil {
+		return err
+	}
+	v.keys = keys
+	return nil
+}
+
+// jwk is an RSA key of a JSON Web Key Set
+type jwk struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+}
+
+func (v *Verifier) fetch(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.config.JWKSURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := v.config.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS returned status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.KeyType != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus of key %q: %w", k.KeyID, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent of key %q: %w", k.KeyID, err)
+		}
+		keys[k.KeyID] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("JWKS has no RSA keys")
+	}
+	return keys, nil
+}
@@ -0,0 +1,138 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// keyServer publishes a JWKS of the given keys and counts fetches
+type keyServer struct {
+	*httptest.Server
+	keys    atomic.Value
+	fetches int64
+}
+
+func newKeyServer(t *testing.T, keys map[string]*rsa.PrivateKey) *keyServer {
+	s := &keyServer{}
+	s.keys.Store(keys)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&s.fetches, 1)
+		var set struct {
+			Keys []jwk `json:"keys"`
+		}
+		for kid, key := range s.keys.Load().(map[string]*rsa.PrivateKey) {
+			set.Keys = append(set.Keys, jwk{
+				KeyType: "RSA",
+				KeyID:   kid,
+				N:       base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func sign(t *testing.T, key *rsa.PrivateKey, kid, audience string, expires time.Time) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":          "api-direct",
+		"sub":          "consumer-1",
+		"aud":          []string{audience},
+		"exp":          expires.Unix(),
+		"client_id":    "cid_1",
+		"subscription": map[string]interface{}{"valid": true, "subscription_id": "sub-1"},
+	})
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestVerifyAccessTokens(t *testing.T) {
+	key := newKey(t)
+	server := newKeyServer(t, map[string]*rsa.PrivateKey{"k1": key})
+	verifier := NewVerifier(Config{JWKSURL: server.URL})
+	ctx := context.Background()
+	future := time.Now().Add(time.Minute)
+
+	token := sign(t, key, "k1", "alice/weather", future)
+	if !IsToken(token) || IsToken("sk_0123") {
+		t.Error("IsToken misclassified a credential")
+	}
+	subscription, err := verifier.Verify(ctx, token, "Alice/Weather")
+	if err != nil {
+		t.Fatalf("valid token rejected: %v", err)
+	}
+	if string(subscription) != `{"subscription_id":"sub-1","valid":true}` {
+		t.Errorf("subscription = %s", subscription)
+	}
+
+	rejected := map[string]string{
+		"other API":   sign(t, key, "k1", "alice/maps", future),
+		"expired":     sign(t, key, "k1", "alice/weather", time.Now().Add(-time.Hour)),
+		"foreign key": sign(t, newKey(t), "k1", "alice/weather", future),
+		"unknown kid": sign(t, key, "k9", "alice/weather", future),
+	}
+	for name, token := range rejected {
+		if _, err := verifier.Verify(ctx, token, "alice/weather"); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: err = %v, want ErrInvalidToken", name, err)
+		}
+	}
+	if server.fetches != 1 {
+		t.Errorf("fetched keys %d times, want once", server.fetches)
+	}
+}
+
+func TestVerifierPicksUpRotatedKeys(t *testing.T) {
+	oldKey, newKeyPair := newKey(t), newKey(t)
+	server := newKeyServer(t, map[string]*rsa.PrivateKey{"k1": oldKey})
+	verifier := NewVerifier(Config{JWKSURL: server.URL, MinRefreshInterval: time.Millisecond})
+	ctx := context.Background()
+	future := time.Now().Add(time.Minute)
+
+	if _, err := verifier.Verify(ctx, sign(t, oldKey, "k1", "alice/weather", future), "alice/weather"); err != nil {
+		t.Fatal(err)
+	}
+
+	server.keys.Store(map[string]*rsa.PrivateKey{"k1": oldKey, "k2": newKeyPair})
+	time.Sleep(2 * time.Millisecond)
+	if _, err := verifier.Verify(ctx, sign(t, newKeyPair, "k2", "alice/weather", future), "alice/weather"); err != nil {
+		t.Errorf("token signed with rotated key rejected: %v", err)
+	}
+}
+
+func TestVerifierWithoutKeys(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	verifier := NewVerifier(Config{JWKSURL: server.URL})
+	token := sign(t, newKey(t), "k1", "alice/weather", time.Now().Add(time.Minute))
+	if _, err := verifier.Verify(context.Background(), token, "alice/weather"); !errors.Is(err, ErrKeysUnavailable) {
+		t.Errorf("err = %v, want ErrKeysUnavailable", err)
+	}
+}
@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	StateClosed   = "closed"
+	StateOpen     = "open"
+	StateHalfOpen = "half_open"
+)
+
+// BreakerConfig controls when an upstream's circuit opens
+type BreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures that opens the circuit
+	FailureThreshold int
+	// OpenTimeout is how long the circuit stays open before a probe is let through
+	OpenTimeout time.Duration
+}
+
+// BreakerState is a snapshot of a circuit breaker for the admin API
+type BreakerState struct {
+	Upstream            string     `json:"upstream"`
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+	RetryAt             *time.Time `json:"retry_at,omitempty"`
+}
+
+// Breaker is a consecutive-failure circuit breaker. While open, requests are
+// rejected without contacting the upstream; after OpenTimeout a single probe
+// is allowed and its outcome closes or re-opens the circuit.
+type Breaker struct {
+	config BreakerConfig
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewBreaker creates a new closed circuit breaker
+func NewBreaker(config BreakerConfig) *Breaker {
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = 5
+	}
+	if config.OpenTimeout <= 0 {
+		config.OpenTimeout = 30 * time.Second
+	}
+	return &Breaker{config: config, state: StateClosed}
+}
+
+// Allow reports whether a request may be sent. When it returns false,
+// retryAfter is how long until the next probe will be allowed.
+func (b *Breaker) Allow() (ok bool, retryAfter time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		retryAt := b.openedAt.Add(b.config.OpenTimeout)
+		if time.Now().Before(retryAt) {
+			return false, time.Until(retryAt)
+		}
+		b.state = StateHalfOpen
+		b.probing = true
+		return true, 0
+	case StateHalfOpen:
+		if b.probing {
+			return false, time.Second
+		}
+		b.probing = true
+		return true, 0
+	default:
+		return true, 0
+	}
+}
+
+// Ready reports whether Allow would currently let a request through,
+// without claiming a half-open probe. When it returns false, retryAfter is
+// how long until the next probe will be allowed.
+func (b *Breaker) Ready() (ok bool, retryAfter time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		retryAt := b.openedAt.Add(b.config.OpenTimeout)
+		if time.Now().Before(retryAt) {
+			return false, time.Until(retryAt)
+		}
+		return true, 0
+	case StateHalfOpen:
+		if b.probing {
+			return false, time.Second
+		}
+		return true, 0
+	default:
+		return true, 0
+	}
+}
+
+// Record reports the outcome of a request that Allow let through
+func (b *Breaker) Record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if success {
+		b.state = StateClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == StateHalfOpen || b.failures >= b.config.FailureThreshold {
+		b.state = StateOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// Release gives up a request's slot without recording an outcome, e.g. when
+// the client went away before the upstream answered
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// Reset closes the circuit
+func (b *Breaker) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = StateClosed
+	b.failures = 0
+	b.probing = false
+}
+
+// State returns a snapshot of the breaker
+func (b *Breaker) State(upstream string) BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := BreakerState{
+		Upstream:            upstream,
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+	}
+	if b.state != StateClosed {
+		openedAt := b.openedAt
+		retryAt := openedAt.Add(b.config.OpenTimeout)
+		state.OpenedAt = &openedAt
+		state.RetryAt = &retryAt
+	}
+	return state
+}
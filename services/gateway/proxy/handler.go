@@ -1,37 +1,100 @@
 package proxy
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/api-direct/services/gateway/registry"
+	"github.com/api-direct/services/gateway/signing"
+	"github.com/api-direct/services/shared/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// Config holds proxy defaults. Routes can override Timeout and MaxRetries.
+type Config struct {
+	// Timeout bounds how long an upstream has to start responding,
+	// including retries
+	Timeout time.Duration
+	// StreamTimeout bounds the lifetime of a proxied response, so streams
+	// and upgraded connections can outlive Timeout
+	StreamTimeout time.Duration
+	// MaxRetries is how many times failed GET and HEAD requests are retried
+	MaxRetries int
+	// RetryBackoff is the base delay between retries
+	RetryBackoff time.Duration
+	// DialTimeout bounds connecting to an upstream
+	DialTimeout time.Duration
+	// MaxIdleConnsPerUpstream bounds each upstream's idle connection pool
+	MaxIdleConnsPerUpstream int
+	Breaker                 BreakerConfig
+	// Signer signs requests to upstreams; nil sends them unsigned
+	Signer *signing.Signer
+}
+
 // Handler manages proxying requests to creator functions
 type Handler struct {
 	meteringServiceURL string
-	httpClient         *http.Client
+	routes             *registry.Registry
+	config             Config
+
+	mu        sync.Mutex
+	upstreams map[string]*upstream
+	lastSweep time.Time
 }
 
 // NewHandler creates a new proxy handler
-func NewHandler(meteringServiceURL string) *Handler {
+func NewHandler(meteringServiceURL string, routes *registry.Registry, config Config) *Handler {
+	if config.Timeout <= 0 {
+		config.Timeout = 30 * time.Second
+	}
+	if config.StreamTimeout <= 0 {
+		config.StreamTimeout = time.Hour
+	}
+	if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = 100 * time.Millisecond
+	}
+	if config.DialTimeout <= 0 {
+		config.DialTimeout = 5 * time.Second
+	}
+	if config.MaxIdleConnsPerUpstream <= 0 {
+		config.MaxIdleConnsPerUpstream = 32
+	}
+
 	return &Handler{
 		meteringServiceURL: meteringServiceURL,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		routes:             routes,
+		config:             config,
+		upstreams:          make(map[string]*upstream),
+		lastSweep:          time.Now(),
 	}
 }
 
-// ProxyRequest forwards a request to the appropriate creator function
-func (h *Handler) ProxyRequest(c *gin.Context, targetURL string) {
-	target, err := url.Parse(targetURL)
+// ProxyRequest forwards a request to one of the route's upstreams, skipping
+// upstreams whose circuit is open
+func (h *Handler) ProxyRequest(c *gin.Context, route *registry.Route) {
+	target, err := h.pickUpstream(route)
 	if err != nil {
+		var open *CircuitOpenError
+		if errors.As(err, &open) {
+			c.Set("upstream", open.Upstream)
+			writeCircuitOpen(c.Writer, open)
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Invalid target URL",
 			"code":  "INVALID_TARGET",
@@ -39,59 +102,233 @@ func (h *Handler) ProxyRequest(c *gin.Context, targetURL string) {
 		return
 	}
 
-	// Create reverse proxy
-	proxy := httputil.NewSingleHostReverseProxy(target)
-	
-	// Customize the director to preserve the original path
-	originalDirector := proxy.Director
-	proxy.Director = func(req *http.Request) {
-		originalDirector(req)
-		
-		// Preserve the original path after the API name
-		path := c.Param("path")
-		if path != "" {
-			req.URL.Path = path
+	c.Set("upstream", target.url)
+
+	// Add debugging information in development
+	if gin.Mode() == gin.DebugMode {
+		fmt.Printf("Proxying request to: %s\n", target.url)
+	}
+
+	opts := &requestOptions{
+		path:       c.Param("path"),
+		maxRetries: route.Retries(h.config.MaxRetries),
+	}
+	if consumerID, exists := c.Get("consumer_id"); exists {
+		opts.consumerID, _ = consumerID.(string)
+	}
+	if subscriptionID, exists := c.Get("subscription_id"); exists {
+		opts.subscriptionID, _ = subscriptionID.(string)
+	}
+	if h.config.Signer != nil {
+		if err := h.prepareSigning(c, route, opts); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+				"code":  "SIGNING_ERROR",
+			})
+			return
+		}
+	}
+
+	// The upstream must start responding within the API's timeout; after
+	// that the response may stream until the stream timeout
+	streamTimeout := route.StreamTimeout(h.config.StreamTimeout)
+	ctx, span := tracing.Start(c, "gateway.proxy", attribute.String("gateway.upstream", target.url))
+	defer span.End()
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	ctx, stop := context.WithTimeout(ctx, streamTimeout)
+	defer stop()
+
+	headerTimer := time.AfterFunc(route.Timeout(h.config.Timeout), func() {
+		cancel(ErrUpstreamTimeout)
+	})
+	defer headerTimer.Stop()
+	opts.headersReceived = func() { headerTimer.Stop() }
+
+	// Lift the server's fixed write timeout for this response, and the read
+	// timeout for upgraded connections. Writers that can't set deadlines
+	// (e.g. in tests) are left alone.
+	deadline := time.Now().Add(streamTimeout)
+	rc := http.NewResponseController(c.Writer)
+	rc.SetWriteDeadline(deadline)
+	if c.GetHeader("Upgrade") != "" {
+		rc.SetReadDeadline(deadline)
+	}
+
+	target.proxy.ServeHTTP(c.Writer, c.Request.WithContext(withOptions(ctx, opts)))
+}
+
+// prepareSigning sets up opts to sign the request for the route's API,
+// buffering the body so its hash can be signed. Bodies over the signer's
+// limit are streamed and signed as UNSIGNED-PAYLOAD.
+func (h *Handler) prepareSigning(c *gin.Context, route *registry.Route, opts *requestOptions) error {
+	algorithm := route.SigningAlgorithm(h.config.Signer.Algorithm())
+	if !signing.Supported(algorithm) {
+		return fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	opts.signer = h.config.Signer
+	// The route names the API without any version pin, matching the key the
+	// deployment gives the creator's function
+	opts.api = route.Creator + "/" + route.APIName
+	opts.algorithm = algorithm
+
+	body := c.Request.Body
+	if body == nil || body == http.NoBody {
+		opts.bodyHash = signing.BodyHash(nil)
+		return nil
+	}
+
+	limit := h.config.Signer.MaxBodyBytes()
+	buffered, err := io.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		return fmt.Errorf("failed to read request body: %w", err)
+	}
+	if int64(len(buffered)) > limit {
+		opts.bodyHash = signing.UnsignedPayload
+		c.Request.Body = readCloser{io.MultiReader(bytes.NewReader(buffered), body), body}
+		return nil
+	}
+	body.Close()
+	opts.bodyHash = signing.BodyHash(buffered)
+	if len(buffered) == 0 {
+		c.Request.Body = http.NoBody
+	} else {
+		c.Request.Body = io.NopCloser(bytes.NewReader(buffered))
+	}
+	return nil
+}
+
+// readCloser reads from a reader and closes the original body
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// Circuits returns the breaker state of every upstream the gateway has used
+func (h *Handler) Circuits() []BreakerState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	states := make([]BreakerState, 0, len(h.upstreams))
+	for rawURL, u := range h.upstreams {
+		states = append(states, u.breaker.State(rawURL))
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Upstream < states[j].Upstream })
+	return states
+}
+
+// ResetCircuit closes an upstream's circuit. It returns false if the
+// upstream is unknown.
+func (h *Handler) ResetCircuit(rawURL string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	u, ok := h.upstreams[rawURL]
+	if ok {
+		u.breaker.Reset()
+	}
+	return ok
+}
+
+// pickUpstream returns the next upstream in round-robin order whose circuit
+// will accept a request
+func (h *Handler) pickUpstream(route *registry.Route) (*upstream, error) {
+	var open *CircuitOpenError
+	for i := 0; i < len(route.Upstreams); i++ {
+		u, err := h.upstream(route.NextUpstream())
+		if err != nil {
+			return nil, err
 		}
-		
-		// Add consumer information to headers for the creator function
-		if consumerID, exists := c.Get("consumer_id"); exists {
-			req.Header.Set("X-Consumer-ID", consumerID.(string))
+		ok, retryAfter := u.breaker.Ready()
+		if ok {
+			return u, nil
 		}
-		if subscriptionID, exists := c.Get("subscription_id"); exists {
-			req.Header.Set("X-Subscription-ID", subscriptionID.(string))
+		if open == nil || retryAfter < open.RetryAfter {
+			open = &CircuitOpenError{Upstream: u.url, RetryAfter: retryAfter}
 		}
-		
-		// Remove sensitive headers
-		req.Header.Del("X-API-Key")
-		req.Header.Del("Authorization")
 	}
+	if open == nil {
+		return nil, fmt.Errorf("route has no upstreams")
+	}
+	return nil, open
+}
+
+// upstream returns the pooled proxy for an upstream URL, creating it on first use
+func (h *Handler) upstream(rawURL string) (*upstream, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 
-	// Custom error handler
-	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-		c.JSON(http.StatusBadGateway, gin.H{
-			"error": "Failed to reach API endpoint",
-			"code":  "GATEWAY_ERROR",
+	now := time.Now()
+	if now.Sub(h.lastSweep) > idleUpstreamTTL {
+		for key, u := range h.upstreams {
+			if u.idleSince(now.Add(-idleUpstreamTTL)) {
+				u.transport.CloseIdleConnections()
+				delete(h.upstreams, key)
+			}
+		}
+		h.lastSweep = now
+	}
+
+	if u, ok := h.upstreams[rawURL]; ok {
+		u.touch()
+		return u, nil
+	}
+
+	target, err := url.Parse(rawURL)
+	if err != nil || target.Scheme == "" || target.Host == "" {
+		return nil, fmt.Errorf("invalid upstream URL %q", rawURL)
+	}
+	u := newUpstream(rawURL, target, h.config)
+	h.upstreams[rawURL] = u
+	return u, nil
+}
+
+// proxyError reports upstream failures to the client
+func proxyError(w http.ResponseWriter, r *http.Request, err error) {
+	var open *CircuitOpenError
+	switch {
+	case errors.As(err, &open):
+		writeCircuitOpen(w, open)
+	case errors.Is(err, context.DeadlineExceeded) || timedOut(r.Context()):
+		writeJSON(w, http.StatusGatewayTimeout, gin.H{
+			"error": "API endpoint timed out",
+			"code":  "UPSTREAM_TIMEOUT",
+		})
+	default:
+		writeJSON(w, http.StatusBadGateway, gin.H{
+			"error":   "Failed to reach API endpoint",
+			"code":    "GATEWAY_ERROR",
 			"details": err.Error(),
 		})
 	}
+}
 
-	// Serve the request
-	proxy.ServeHTTP(c.Writer, c.Request)
+func writeCircuitOpen(w http.ResponseWriter, open *CircuitOpenError) {
+	w.Header().Set("Retry-After", fmt.Sprintf("%d", int64(math.Ceil(open.RetryAfter.Seconds()))))
+	writeJSON(w, http.StatusServiceUnavailable, gin.H{
+		"error": "API is temporarily unavailable",
+		"code":  "CIRCUIT_OPEN",
+	})
 }
 
-// GetCreatorFunctionURL determines the URL for a creator's function
-func (h *Handler) GetCreatorFunctionURL(creator, apiName string) (string, error) {
-	// In production, this would query the deployment service or database
-	// to get the actual URL of the deployed function
-	// For now, we'll use a simple pattern
-	
-	// Format: http://<api-name>-<creator>.api-direct.svc.cluster.local:8080
-	// This assumes functions are deployed as Kubernetes services
-	functionURL := fmt.Sprintf("http://%s-%s.api-direct.svc.cluster.local:8080", 
-		strings.ToLower(apiName), 
-		strings.ToLower(creator))
-	
-	return functionURL, nil
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// SelectRoute looks up the registered upstreams for the version of an API to
+// serve. An empty version is chosen by the API's routing rule, sticking to
+// stickyKey if the rule asks for it, or is the most recently deployed version.
+func (h *Handler) SelectRoute(ctx context.Context, creator, apiName, version, stickyKey string) (*registry.Route, error) {
+	return h.routes.Select(ctx, creator, apiName, version, stickyKey)
+}
+
+// SandboxRoute looks up the sandbox deployment of an API that serves test
+// mode calls
+func (h *Handler) SandboxRoute(ctx context.Context, creator, apiName string) (*registry.Route, error) {
+	return h.routes.Sandbox(ctx, creator, apiName)
 }
 
 // ValidateContentType checks if the content type is acceptable
@@ -140,3 +377,16 @@ func (h *Handler) StreamResponse(c *gin.Context, resp *http.Response) {
 		fmt.Printf("Error streaming response: %v\n", err)
 	}
 }
+
+// joinURLPath joins an upstream base path and a request path with a single slash
+func joinURLPath(base, path string) string {
+	switch {
+	case base == "" || base == "/":
+		return path
+	case strings.HasSuffix(base, "/") && strings.HasPrefix(path, "/"):
+		return base + path[1:]
+	case !strings.HasSuffix(base, "/") && !strings.HasPrefix(path, "/"):
+		return base + "/" + path
+	}
+	return base + path
+}
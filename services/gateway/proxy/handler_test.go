@@ -0,0 +1,265 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/api-direct/services/gateway/registry"
+	"github.com/api-direct/services/gateway/signing"
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// serve proxies a single request to route through a gin router
+func serve(h *Handler, route *registry.Route, method, path string) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.Any("/api/:creator/:apiName/*path", func(c *gin.Context) {
+		c.Set("consumer_id", "consumer-1")
+		h.ProxyRequest(c, route)
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("X-API-Key", "sk_secret")
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestProxyRewritesPathAndHeaders(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/prod/forecast/today" || r.URL.RawQuery != "units=metric" {
+			t.Errorf("unexpected upstream URL %s", r.URL)
+		}
+		if r.Header.Get("X-API-Key") != "" {
+			t.Error("API key leaked to upstream")
+		}
+		if r.Header.Get("X-Consumer-ID") != "consumer-1" {
+			t.Errorf("consumer header = %q", r.Header.Get("X-Consumer-ID"))
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	h := NewHandler("", nil, Config{})
+	route := &registry.Route{Upstreams: []string{upstream.URL + "/prod"}}
+
+	if w := serve(h, route, http.MethodGet, "/api/alice/weather/forecast/today?units=metric"); w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+}
+
+func TestProxyRetriesIdempotentRequests(t *testing.T) {
+	var calls int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	h := NewHandler("", nil, Config{MaxRetries: 2, RetryBackoff: time.Millisecond})
+	route := &registry.Route{Upstreams: []string{upstream.URL}}
+
+	if w := serve(h, route, http.MethodGet, "/api/alice/weather/"); w.Code != http.StatusOK {
+		t.Errorf("GET status = %d, want retried success", w.Code)
+	}
+	if calls != 2 {
+		t.Errorf("GET made %d calls, want 2", calls)
+	}
+
+	atomic.StoreInt64(&calls, 0)
+	if w := serve(h, route, http.MethodPost, "/api/alice/weather/"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("POST status = %d, want upstream 503", w.Code)
+	}
+	if calls != 1 {
+		t.Errorf("POST made %d calls, want no retry", calls)
+	}
+}
+
+func TestCircuitOpensAfterConsecutiveFailures(t *testing.T) {
+	var calls int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer upstream.Close()
+
+	h := NewHandler("", nil, Config{
+		Breaker: BreakerConfig{FailureThreshold: 3, OpenTimeout: time.Minute},
+	})
+	route := &registry.Route{Upstreams: []string{upstream.URL}}
+
+	for i := 0; i < 3; i++ {
+		serve(h, route, http.MethodPost, "/api/alice/weather/")
+	}
+
+	w := serve(h, route, http.MethodPost, "/api/alice/weather/")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected fast 503 with Retry-After, got %d %v", w.Code, w.Header())
+	}
+	if calls != 3 {
+		t.Errorf("upstream called %d times, want 3", calls)
+	}
+
+	circuits := h.Circuits()
+	if len(circuits) != 1 || circuits[0].State != StateOpen {
+		t.Fatalf("unexpected circuits %+v", circuits)
+	}
+
+	if !h.ResetCircuit(upstream.URL) {
+		t.Fatal("expected reset to find upstream")
+	}
+	if state := h.Circuits()[0].State; state != StateClosed {
+		t.Errorf("state after reset = %s", state)
+	}
+}
+
+func TestRouteTimeoutOverridesDefault(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer upstream.Close()
+
+	h := NewHandler("", nil, Config{Timeout: time.Minute})
+	noRetries := 0
+	route := &registry.Route{
+		Upstreams: []string{upstream.URL},
+		Settings:  &registry.Settings{TimeoutMs: 50, MaxRetries: &noRetries},
+	}
+
+	start := time.Now()
+	w := serve(h, route, http.MethodGet, "/api/alice/weather/")
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want 504", w.Code)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("request took %s, route timeout was ignored", elapsed)
+	}
+}
+
+func TestBreakerHalfOpenAllowsSingleProbe(t *testing.T) {
+	b := NewBreaker(BreakerConfig{FailureThreshold: 1, OpenTimeout: 10 * time.Millisecond})
+	b.Record(false)
+
+	if ok, _ := b.Allow(); ok {
+		t.Fatal("expected open breaker to reject")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if ok, _ := b.Allow(); !ok {
+		t.Fatal("expected probe after open timeout")
+	}
+	if ok, _ := b.Allow(); ok {
+		t.Fatal("expected only one concurrent probe")
+	}
+
+	b.Record(true)
+	if ok, _ := b.Allow(); !ok {
+		t.Error("expected successful probe to close the circuit")
+	}
+}
+
+func TestProxySignsRequests(t *testing.T) {
+	signer, err := signing.NewSigner(signing.Config{Key: []byte("master"), MaxBodyBytes: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := &signing.Verifier{PublicKey: signer.PublicKey("alice/weather")}
+
+	var verified, unsigned int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := verifier.Verify(r, body, time.Now()); err != nil {
+			t.Errorf("upstream rejected signature for %q: %v", body, err)
+		}
+		atomic.AddInt64(&verified, 1)
+		if r.Header.Get(signing.ContentSHA256Header) == signing.UnsignedPayload {
+			atomic.AddInt64(&unsigned, 1)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	h := NewHandler("", nil, Config{Signer: signer})
+	route := &registry.Route{
+		Creator:   "alice",
+		APIName:   "weather",
+		Upstreams: []string{upstream.URL},
+		Settings:  &registry.Settings{Signing: signing.Ed25519},
+	}
+
+	router := gin.New()
+	router.Any("/api/:creator/:apiName/*path", func(c *gin.Context) {
+		c.Set("consumer_id", "consumer-1")
+		h.ProxyRequest(c, route)
+	})
+	for _, body := range []string{"", `{"city":"Oslo"}`, strings.Repeat("x", 64)} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/alice/weather/forecast?days=3", strings.NewReader(body))
+		req.Header.Set("X-Consumer-ID", "spoofed")
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d", w.Code)
+		}
+	}
+	if verified != 3 || unsigned != 1 {
+		t.Errorf("verified %d requests with %d unsigned payloads, want 3 and 1", verified, unsigned)
+	}
+}
+
+func TestProxySignsPinnedRequestsWithTheAPIKey(t *testing.T) {
+	signer, err := signing.NewSigner(signing.Config{Key: []byte("master")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := &signing.Verifier{PublicKey: signer.PublicKey("alice/weather")}
+
+	var verified int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := verifier.Verify(r, body, time.Now()); err != nil {
+			t.Errorf("upstream rejected signature for %s: %v", r.URL.Path, err)
+		} else {
+			atomic.AddInt64(&verified, 1)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	h := NewHandler("", nil, Config{Signer: signer})
+	route := &registry.Route{
+		Creator:   "alice",
+		APIName:   "weather",
+		Version:   "v2",
+		Upstreams: []string{upstream.URL},
+		Settings:  &registry.Settings{Signing: signing.Ed25519},
+	}
+
+	router := gin.New()
+	router.Any("/api/:creator/:apiName/*path", func(c *gin.Context) {
+		h.ProxyRequest(c, route)
+	})
+	for _, path := range []string{"/api/alice/weather/forecast", "/api/alice/weather@v2/forecast"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s status = %d", path, w.Code)
+		}
+	}
+	if verified != 2 {
+		t.Errorf("verified %d of 2 requests signed for alice/weather", verified)
+	}
+}
@@ -0,0 +1,250 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/api-direct/services/gateway/signing"
+	"github.com/api-direct/services/shared/tracing"
+)
+
+// idleUpstreamTTL is how long an upstream can go unused before its
+// connections and breaker are discarded
+const idleUpstreamTTL = time.Hour
+
+// ErrUpstreamTimeout is the cause of cancellation when an upstream doesn't
+// start responding within the API's timeout
+var ErrUpstreamTimeout = errors.New("upstream timed out")
+
+// CircuitOpenError is returned when an upstream's circuit breaker rejects a request
+type CircuitOpenError struct {
+	Upstream   string
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit open for %s", e.Upstream)
+}
+
+// requestOptions carries per-request proxy settings from the handler to the
+// shared reverse proxy and transport
+type requestOptions struct {
+	path           string
+	consumerID     string
+	subscriptionID string
+	maxRetries     int
+	// signer signs the request for the API when set
+	signer    *signing.Signer
+	api       string
+	algorithm string
+	bodyHash  string
+	// headersReceived stops the response header timeout
+	headersReceived func()
+}
+
+type optionsKey struct{}
+
+func withOptions(ctx context.Context, opts *requestOptions) context.Context {
+	return context.WithValue(ctx, optionsKey{}, opts)
+}
+
+func optionsFrom(ctx context.Context) *requestOptions {
+	if opts, ok := ctx.Value(optionsKey{}).(*requestOptions); ok {
+		return opts
+	}
+	return &requestOptions{}
+}
+
+// upstream holds the reusable proxy, connection pool and breaker for one upstream URL
+type upstream struct {
+	url       string
+	target    *url.URL
+	transport *http.Transport
+	// traced sends requests through transport, tracing each attempt and
+	// passing the trace context to the upstream
+	traced  http.RoundTripper
+	breaker *Breaker
+	proxy   *httputil.ReverseProxy
+	backoff time.Duration
+
+	mu       sync.Mutex
+	lastUsed time.Time
+}
+
+func newUpstream(rawURL string, target *url.URL, config Config) *upstream {
+	u := &upstream{
+		url:    rawURL,
+		target: target,
+		transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   config.DialTimeout,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          config.MaxIdleConnsPerUpstream,
+			MaxIdleConnsPerHost:   config.MaxIdleConnsPerUpstream,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
+		breaker:  NewBreaker(config.Breaker),
+		backoff:  config.RetryBackoff,
+		lastUsed: time.Now(),
+	}
+
+	u.traced = tracing.Transport(u.transport)
+
+	u.proxy = &httputil.ReverseProxy{
+		Director:     u.direct,
+		Transport:    u,
+		ErrorHandler: proxyError,
+	}
+	return u
+}
+
+// direct rewrites an incoming request for the upstream
+func (u *upstream) direct(req *http.Request) {
+	opts := optionsFrom(req.Context())
+
+	req.URL.Scheme = u.target.Scheme
+	req.URL.Host = u.target.Host
+	// Preserve the original path after the API name, keeping any base
+	// path the upstream was registered with (e.g. BYOA stage prefixes)
+	req.URL.Path = joinURLPath(u.target.Path, opts.path)
+	req.URL.RawPath = ""
+	if u.target.RawQuery != "" && req.URL.RawQuery != "" {
+		req.URL.RawQuery = u.target.RawQuery + "&" + req.URL.RawQuery
+	} else if u.target.RawQuery != "" {
+		req.URL.RawQuery = u.target.RawQuery
+	}
+	req.Host = u.target.Host
+	if _, ok := req.Header["User-Agent"]; !ok {
+		// Explicitly disable the default Go User-Agent
+		req.Header.Set("User-Agent", "")
+	}
+
+	// Add consumer information to headers for the creator function. Only
+	// the gateway sets these, so values sent by the client are dropped.
+	req.Header.Del("X-Consumer-ID")
+	req.Header.Del("X-Subscription-ID")
+	if opts.consumerID != "" {
+		req.Header.Set("X-Consumer-ID", opts.consumerID)
+	}
+	if opts.subscriptionID != "" {
+		req.Header.Set("X-Subscription-ID", opts.subscriptionID)
+	}
+	if opts.signer != nil {
+		// Sign last so the signature covers the final path and headers
+		if err := opts.signer.Sign(req, opts.api, opts.algorithm, opts.bodyHash, time.Now()); err != nil {
+			log.Printf("Failed to sign request for %s: %v", opts.api, err)
+		}
+	}
+
+	// Remove sensitive headers
+	req.Header.Del("X-API-Key")
+	req.Header.Del("Authorization")
+}
+
+// RoundTrip sends the request through the circuit breaker, retrying
+// idempotent requests that fail before the upstream produced a usable response
+func (u *upstream) RoundTrip(req *http.Request) (*http.Response, error) {
+	opts := optionsFrom(req.Context())
+	if opts.headersReceived != nil {
+		// Once the upstream has answered, only the stream timeout applies
+		defer opts.headersReceived()
+	}
+
+	attempts := 1
+	if retryable(req) {
+		attempts += opts.maxRetries
+	}
+
+	for attempt := 1; ; attempt++ {
+		if ok, retryAfter := u.breaker.Allow(); !ok {
+			return nil, &CircuitOpenError{Upstream: u.url, RetryAfter: retryAfter}
+		}
+
+		resp, err := u.traced.RoundTrip(req)
+		if req.Context().Err() != nil {
+			// The client went away or the API's timeout elapsed
+			if err != nil && timedOut(req.Context()) {
+				u.breaker.Record(false)
+			} else {
+				u.breaker.Release()
+			}
+			return resp, err
+		}
+
+		failed := err != nil || resp.StatusCode >= http.StatusInternalServerError
+		u.breaker.Record(!failed)
+
+		if attempt >= attempts || !shouldRetry(resp, err) {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+
+		select {
+		case <-time.After(jitter(u.backoff, attempt)):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+func (u *upstream) touch() {
+	u.mu.Lock()
+	u.lastUsed = time.Now()
+	u.mu.Unlock()
+}
+
+func (u *upstream) idleSince(t time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.lastUsed.Before(t)
+}
+
+// timedOut reports whether ctx ended because an upstream took too long
+func timedOut(ctx context.Context) bool {
+	cause := context.Cause(ctx)
+	return errors.Is(cause, ErrUpstreamTimeout) || errors.Is(cause, context.DeadlineExceeded)
+}
+
+// retryable reports whether a request can safely be sent more than once
+func retryable(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody
+}
+
+// shouldRetry reports whether a failed attempt is worth repeating
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// jitter returns a randomised exponential backoff for the given attempt
+func jitter(base time.Duration, attempt int) time.Duration {
+	backoff := base << uint(attempt-1)
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
@@ -0,0 +1,207 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ConcurrencyConfig controls the distributed concurrency limiter
+type ConcurrencyConfig struct {
+	// LeaseTTL is how long a slot is held without renewal. Leases are
+	// renewed while the request runs, so this only bounds how long slots of
+	// a crashed gateway stay taken.
+	LeaseTTL time.Duration
+	// Timeout bounds each call to Redis
+	Timeout time.Duration
+	// Policy applies to limits that don't set a failure policy
+	Policy Policy
+}
+
+// ConcurrencyResult is the outcome of a concurrency limit check
+type ConcurrencyResult struct {
+	Allowed  bool
+	Limit    int
+	InFlight int
+	// Degraded is set when the result came from a failure policy rather
+	// than the shared limiter
+	Degraded bool
+	// Lease holds the slot of an allowed request until released
+	Lease *Lease
+}
+
+// ConcurrencyLimiter bounds the number of in-flight requests per key with a
+// Redis semaphore shared by every gateway instance. Each slot is a lease
+// that is renewed while the request runs and released when it finishes.
+type ConcurrencyLimiter struct {
+	client *redis.Client
+	config ConcurrencyConfig
+
+	// local counts in-flight requests when Redis is unavailable and the
+	// policy is FailLocal
+	mu    sync.Mutex
+	local map[string]int
+}
+
+// NewConcurrencyLimiter creates a Redis-backed concurrency limiter
+func NewConcurrencyLimiter(client *redis.Client, config ConcurrencyConfig) *ConcurrencyLimiter {
+	if config.LeaseTTL <= 0 {
+		config.LeaseTTL = 30 * time.Second
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 250 * time.Millisecond
+	}
+	if config.Policy == "" {
+		config.Policy = FailLocal
+	}
+	return &ConcurrencyLimiter{
+		client: client,
+		config: config,
+		local:  make(map[string]int),
+	}
+}
+
+// Acquire takes one of limit slots for key. If the slot is granted, the
+// caller must release the result's Lease when the request finishes. When
+// Redis is unavailable, onFailure (or the default policy) decides the result.
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context, key string, limit int, onFailure Policy) (*ConcurrencyResult, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("concurrency limit must be positive, got %d", limit)
+	}
+
+	token := uniqueMember()
+	callCtx, cancel := context.WithTimeout(ctx, l.config.Timeout)
+	values, err := acquireScript.Run(callCtx, l.client, []string{key}, limit, l.config.LeaseTTL.Milliseconds(), token).Slice()
+	cancel()
+	if err == nil {
+		if len(values) != 2 {
+			return nil, fmt.Errorf("unexpected concurrency script result %v", values)
+		}
+		result := &ConcurrencyResult{
+			Allowed:  toInt64(values[0]) == 1,
+			Limit:    limit,
+			InFlight: int(toInt64(values[1])),
+		}
+		if result.Allowed {
+			result.Lease = l.newLease(key, token, false)
+		}
+		return result, nil
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	log.Printf("Concurrency limiter unavailable: %v", err)
+
+	if onFailure == "" {
+		onFailure = l.config.Policy
+	}
+	switch onFailure {
+	case FailOpen:
+		return &ConcurrencyResult{Allowed: true, Limit: limit, Degraded: true, Lease: &Lease{}}, nil
+	case FailClosed:
+		return nil, ErrUnavailable
+	default:
+		return l.acquireLocal(key, limit), nil
+	}
+}
+
+// acquireLocal counts in-flight requests on this instance only
+func (l *ConcurrencyLimiter) acquireLocal(key string, limit int) *ConcurrencyResult {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	result := &ConcurrencyResult{Limit: limit, InFlight: l.local[key], Degraded: true}
+	if l.local[key] >= limit {
+		return result
+	}
+	l.local[key]++
+	result.Allowed = true
+	result.InFlight++
+	result.Lease = l.newLease(key, "", true)
+	return result
+}
+
+func (l *ConcurrencyLimiter) releaseLocal(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.local[key] <= 1 {
+		delete(l.local, key)
+		return
+	}
+	l.local[key]--
+}
+
+// newLease returns a lease that is renewed in the background until released
+func (l *ConcurrencyLimiter) newLease(key, token string, local bool) *Lease {
+	lease := &Lease{
+		limiter: l,
+		key:     key,
+		token:   token,
+		local:   local,
+		done:    make(chan struct{}),
+	}
+	if !local {
+		go lease.renew()
+	}
+	return lease
+}
+
+// Lease is a held concurrency slot
+type Lease struct {
+	limiter *ConcurrencyLimiter
+	key     string
+	token   string
+	local   bool
+
+	done chan struct{}
+	once sync.Once
+}
+
+// Release frees the slot. It is safe to call more than once.
+func (l *Lease) Release() {
+	if l == nil || l.limiter == nil {
+		return
+	}
+	l.once.Do(func() {
+		close(l.done)
+		if l.local {
+			l.limiter.releaseLocal(l.key)
+			return
+		}
+
+		// Release even if the request's context was cancelled
+		ctx, cancel := context.WithTimeout(context.Background(), l.limiter.config.Timeout)
+		defer cancel()
+		if err := l.limiter.client.ZRem(ctx, l.key, l.token).Err(); err != nil {
+			// The lease expires on its own
+			log.Printf("Failed to release concurrency slot: %v", err)
+		}
+	})
+}
+
+// renew extends the lease at a third of its TTL until it is released
+func (l *Lease) renew() {
+	ttl := l.limiter.config.LeaseTTL
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), l.limiter.config.Timeout)
+			renewed, err := renewScript.Run(ctx, l.limiter.client, []string{l.key}, ttl.Milliseconds(), l.token).Int()
+			cancel()
+			if err == nil && renewed == 0 {
+				// The slot was lost, e.g. to a Redis failover; stop renewing
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestConcurrencyLimiterLeases(t *testing.T) {
+	mr := miniredis.RunT(t)
+	now := time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC)
+	mr.SetTime(now)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	limiter := NewConcurrencyLimiter(client, ConcurrencyConfig{LeaseTTL: time.Minute})
+	ctx := context.Background()
+	key := "concurrency:key-1"
+
+	var leases []*Lease
+	for i := 0; i < 2; i++ {
+		result, err := limiter.Acquire(ctx, key, 2, "")
+		if err != nil || !result.Allowed {
+			t.Fatalf("acquire %d = %+v, %v", i, result, err)
+		}
+		leases = append(leases, result.Lease)
+	}
+
+	result, err := limiter.Acquire(ctx, key, 2, "")
+	if err != nil || result.Allowed || result.InFlight != 2 {
+		t.Fatalf("third acquire = %+v, %v", result, err)
+	}
+
+	// Releasing a slot lets the next request in; releasing twice is harmless
+	leases[0].Release()
+	leases[0].Release()
+	result, err = limiter.Acquire(ctx, key, 2, "")
+	if err != nil || !result.Allowed {
+		t.Fatalf("acquire after release = %+v, %v", result, err)
+	}
+	defer result.Lease.Release()
+
+	// Slots of a gateway that stopped renewing expire with their lease
+	leases[1].Release()
+	mr.SetTime(now.Add(2 * time.Minute))
+	for i := 0; i < 2; i++ {
+		result, err := limiter.Acquire(ctx, key, 2, "")
+		if err != nil || !result.Allowed {
+			t.Fatalf("acquire after expiry %d = %+v, %v", i, result, err)
+		}
+		defer result.Lease.Release()
+	}
+}
+
+func TestConcurrencyLimiterFailurePolicies(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	mr.Close()
+
+	limiter := NewConcurrencyLimiter(client, ConcurrencyConfig{Timeout: 50 * time.Millisecond})
+	ctx := context.Background()
+
+	if _, err := limiter.Acquire(ctx, "concurrency:key-1", 1, FailClosed); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("closed policy err = %v", err)
+	}
+
+	result, err := limiter.Acquire(ctx, "concurrency:key-1", 1, FailOpen)
+	if err != nil || !result.Allowed || !result.Degraded {
+		t.Errorf("open policy = %+v, %v", result, err)
+	}
+
+	// The local policy still enforces the limit on this instance
+	first, err := limiter.Acquire(ctx, "concurrency:key-1", 1, FailLocal)
+	if err != nil || !first.Allowed {
+		t.Fatalf("local acquire = %+v, %v", first, err)
+	}
+	if second, _ := limiter.Acquire(ctx, "concurrency:key-1", 1, FailLocal); second.Allowed {
+		t.Error("local policy allowed a second in-flight request")
+	}
+	first.Lease.Release()
+	if third, _ := limiter.Acquire(ctx, "concurrency:key-1", 1, FailLocal); !third.Allowed {
+		t.Error("local slot was not released")
+	}
+}
@@ -0,0 +1,179 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Policy decides what happens to a request when the shared limiter is unavailable
+type Policy string
+
+const (
+	// FailOpen allows the request without counting it
+	FailOpen Policy = "open"
+	// FailClosed rejects the request until the limiter recovers
+	FailClosed Policy = "closed"
+	// FailLocal counts the request in an in-memory limiter on this instance
+	FailLocal Policy = "local"
+)
+
+// ErrUnavailable is returned under FailClosed while the shared limiter is down
+var ErrUnavailable = errors.New("rate limiter unavailable")
+
+// ParsePolicy validates a failure policy name. An empty name returns "".
+func ParsePolicy(name string) (Policy, error) {
+	switch Policy(name) {
+	case "", FailOpen, FailClosed, FailLocal:
+		return Policy(name), nil
+	default:
+		return "", fmt.Errorf("unknown rate limit failure policy %q", name)
+	}
+}
+
+// FailoverConfig controls how the failover limiter detects outages
+type FailoverConfig struct {
+	// Policy applies to limits that don't set OnFailure
+	Policy Policy
+	// Timeout bounds each call to the shared limiter
+	Timeout time.Duration
+	// Cooldown is how long the shared limiter is bypassed after an error
+	// before it is tried again
+	Cooldown time.Duration
+}
+
+// Health reports the state of the shared limiter
+type Health struct {
+	Status      string     `json:"status"`
+	Algorithm   Algorithm  `json:"algorithm,omitempty"`
+	Policy      Policy     `json:"failure_policy"`
+	Failures    uint64     `json:"failures"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastFailure *time.Time `json:"last_failure,omitempty"`
+}
+
+// FailoverLimiter wraps the shared limiter and applies a failure policy when
+// it errors, so a Redis outage degrades rate limiting instead of failing
+// every request
+type FailoverLimiter struct {
+	primary Limiter
+	local   *LocalLimiter
+	config  FailoverConfig
+
+	mu          sync.Mutex
+	downUntil   time.Time
+	failures    uint64
+	lastError   string
+	lastFailure time.Time
+}
+
+// NewFailoverLimiter creates a limiter that falls back according to config
+func NewFailoverLimiter(primary Limiter, config FailoverConfig) *FailoverLimiter {
+	if config.Policy == "" {
+		config.Policy = FailLocal
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 250 * time.Millisecond
+	}
+	if config.Cooldown <= 0 {
+		config.Cooldown = 5 * time.Second
+	}
+
+	return &FailoverLimiter{
+		primary: primary,
+		local:   NewLocalLimiter(),
+		config:  config,
+	}
+}
+
+// Allow checks the shared limiter, applying the failure policy if it is unavailable
+func (f *FailoverLimiter) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	if f.available() {
+		callCtx, cancel := context.WithTimeout(ctx, f.config.Timeout)
+		result, err := f.primary.Allow(callCtx, key, limit)
+		cancel()
+		if err == nil {
+			return result, nil
+		}
+		if ctx.Err() != nil {
+			// The client went away; that says nothing about the limiter
+			return nil, ctx.Err()
+		}
+		f.recordFailure(err)
+	}
+
+	policy := limit.OnFailure
+	if policy == "" {
+		policy = f.config.Policy
+	}
+
+	switch policy {
+	case FailOpen:
+		return &Result{
+			Allowed:   true,
+			Limit:     limit.Count,
+			Remaining: limit.Count,
+			ResetAt:   time.Now().Add(f.config.Cooldown),
+			Degraded:  true,
+		}, nil
+	case FailClosed:
+		return nil, ErrUnavailable
+	default:
+		result, err := f.local.Allow(ctx, key, limit)
+		if result != nil {
+			result.Degraded = true
+		}
+		return result, err
+	}
+}
+
+// RetryAfter is how long callers rejected with ErrUnavailable should wait
+func (f *FailoverLimiter) RetryAfter() time.Duration {
+	return f.config.Cooldown
+}
+
+// Health returns the current state of the shared limiter
+func (f *FailoverLimiter) Health() Health {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	health := Health{
+		Status:    "healthy",
+		Policy:    f.config.Policy,
+		Failures:  f.failures,
+		LastError: f.lastError,
+	}
+	if r, ok := f.primary.(*RedisRateLimiter); ok {
+		health.Algorithm = r.Algorithm()
+	}
+	if !f.lastFailure.IsZero() {
+		lastFailure := f.lastFailure
+		health.LastFailure = &lastFailure
+	}
+	if time.Now().Before(f.downUntil) {
+		health.Status = "degraded"
+	}
+	return health
+}
+
+func (f *FailoverLimiter) available() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return !time.Now().Before(f.downUntil)
+}
+
+func (f *FailoverLimiter) recordFailure(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !time.Now().Before(f.downUntil) {
+		log.Printf("Rate limiter unavailable, using failure policies for %s: %v", f.config.Cooldown, err)
+	}
+	f.failures++
+	f.lastError = err.Error()
+	f.lastFailure = time.Now().UTC()
+	f.downUntil = time.Now().Add(f.config.Cooldown)
+}
@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func newUnavailableLimiter(t *testing.T, policy Policy) *FailoverLimiter {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+	mr.Close()
+
+	return NewFailoverLimiter(NewRedisRateLimiter(client, GCRA), FailoverConfig{
+		Policy:   policy,
+		Timeout:  100 * time.Millisecond,
+		Cooldown: time.Minute,
+	})
+}
+
+func TestFailoverPolicies(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("open", func(t *testing.T) {
+		limiter := newUnavailableLimiter(t, FailOpen)
+		for i := 0; i < 5; i++ {
+			result, err := limiter.Allow(ctx, "k", PerMinute(2))
+			if err != nil || !result.Allowed || !result.Degraded {
+				t.Fatalf("request %d: result=%+v err=%v", i, result, err)
+			}
+		}
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		limiter := newUnavailableLimiter(t, FailClosed)
+		if _, err := limiter.Allow(ctx, "k", PerMinute(2)); !errors.Is(err, ErrUnavailable) {
+			t.Fatalf("expected ErrUnavailable, got %v", err)
+		}
+	})
+
+	t.Run("local", func(t *testing.T) {
+		limiter := newUnavailableLimiter(t, FailLocal)
+		for i := 0; i < 2; i++ {
+			result, err := limiter.Allow(ctx, "k", PerMinute(2))
+			if err != nil || !result.Allowed {
+				t.Fatalf("request %d: result=%+v err=%v", i, result, err)
+			}
+		}
+		result, err := limiter.Allow(ctx, "k", PerMinute(2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Allowed {
+			t.Error("expected local limiter to enforce the limit")
+		}
+	})
+
+	t.Run("per-limit override", func(t *testing.T) {
+		limiter := newUnavailableLimiter(t, FailClosed)
+		limit := PerMinute(2)
+		limit.OnFailure = FailOpen
+		if result, err := limiter.Allow(ctx, "k", limit); err != nil || !result.Allowed {
+			t.Fatalf("expected plan policy to override default, got %+v %v", result, err)
+		}
+	})
+}
+
+func TestFailoverHealth(t *testing.T) {
+	limiter := newUnavailableLimiter(t, FailLocal)
+	if health := limiter.Health(); health.Status != "healthy" || health.Algorithm != GCRA {
+		t.Fatalf("unexpected initial health %+v", health)
+	}
+
+	if _, err := limiter.Allow(context.Background(), "k", PerMinute(2)); err != nil {
+		t.Fatal(err)
+	}
+
+	health := limiter.Health()
+	if health.Status != "degraded" || health.Failures != 1 || health.LastError == "" {
+		t.Errorf("unexpected health after outage %+v", health)
+	}
+
+	// Redis is bypassed during the cooldown rather than retried per request
+	if _, err := limiter.Allow(context.Background(), "k", PerMinute(2)); err != nil {
+		t.Fatal(err)
+	}
+	if health := limiter.Health(); health.Failures != 1 {
+		t.Errorf("expected cooldown to skip Redis, failures = %d", health.Failures)
+	}
+}
+
+func TestLocalLimiterCalendarWindow(t *testing.T) {
+	limiter := NewLocalLimiter()
+	now := time.Date(2026, 1, 31, 23, 0, 0, 0, time.UTC)
+	limiter.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	if result, _ := limiter.Allow(ctx, "k", PerMonth(1)); !result.Allowed {
+		t.Fatal("expected first request to be allowed")
+	}
+	if result, _ := limiter.Allow(ctx, "k", PerMonth(1)); result.Allowed {
+		t.Fatal("expected second request in January to be rejected")
+	}
+
+	now = now.Add(2 * time.Hour)
+	if result, _ := limiter.Allow(ctx, "k", PerMonth(1)); !result.Allowed {
+		t.Error("expected the limit to reset in February")
+	}
+}
@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Algorithm selects how requests are counted against a limit
+type Algorithm string
+
+const (
+	// SlidingLog records every request in a sorted set and counts the ones
+	// inside the window. Exact, but memory grows with the limit.
+	SlidingLog Algorithm = "sliding_log"
+	// TokenBucket refills Count tokens evenly over Period and allows bursts
+	// up to Count.
+	TokenBucket Algorithm = "token_bucket"
+	// GCRA is the generic cell rate algorithm. It behaves like a token bucket
+	// but stores a single timestamp per key.
+	GCRA Algorithm = "gcra"
+)
+
+// ParseAlgorithm validates an algorithm name. An empty name selects SlidingLog.
+func ParseAlgorithm(name string) (Algorithm, error) {
+	switch Algorithm(name) {
+	case "":
+		return SlidingLog, nil
+	case SlidingLog, TokenBucket, GCRA:
+		return Algorithm(name), nil
+	default:
+		return "", fmt.Errorf("unknown rate limit algorithm %q", name)
+	}
+}
+
+// Limit is the number of units allowed per period. Each request costs one
+// unit unless Cost says otherwise.
+type Limit struct {
+	Count  int
+	Period time.Duration
+	// Cost is the number of units a request consumes; 0 counts as 1
+	Cost int
+	// Calendar counts requests in fixed calendar-month windows (UTC)
+	// instead of a rolling Period
+	Calendar bool
+	// OnFailure overrides the limiter's default policy when the shared
+	// limiter is unavailable
+	OnFailure Policy
+}
+
+// PerMinute allows n requests per rolling minute
+func PerMinute(n int) Limit {
+	return Limit{Count: n, Period: time.Minute}
+}
+
+// PerDay allows n requests per rolling day
+func PerDay(n int) Limit {
+	return Limit{Count: n, Period: 24 * time.Hour}
+}
+
+// PerMonth allows n requests per calendar month
+func PerMonth(n int) Limit {
+	return Limit{Count: n, Calendar: true}
+}
+
+// WithCost returns the limit with each request consuming cost units
+func (l Limit) WithCost(cost int) Limit {
+	l.Cost = cost
+	return l
+}
+
+// units returns what a request consumes from the limit
+func (l Limit) units() int {
+	if l.Cost <= 0 {
+		return 1
+	}
+	return l.Cost
+}
+
+// Result is the outcome of a rate limit check
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// ResetAt is when the limit is fully available again
+	ResetAt time.Time
+	// RetryAfter is how long a rejected caller should wait before retrying
+	RetryAfter time.Duration
+	// Degraded is set when the result came from a failure policy rather
+	// than the shared limiter
+	Degraded bool
+}
+
+// Limiter checks and consumes rate limit capacity. Implementations must
+// perform the check and the increment atomically.
+type Limiter interface {
+	Allow(ctx context.Context, key string, limit Limit) (*Result, error)
+}
+
+// monthWindow returns the calendar month containing t and the start of the next one
+func monthWindow(t time.Time) (string, time.Time) {
+	t = t.UTC()
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start.Format("2006-01"), start.AddDate(0, 1, 0)
+}
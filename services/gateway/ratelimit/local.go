@@ -0,0 +1,130 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often expired local entries are discarded
+const sweepInterval = time.Minute
+
+type localEntry struct {
+	// tat is the GCRA theoretical arrival time for rolling windows
+	tat time.Time
+	// count and window track calendar windows
+	count  int
+	window string
+
+	expiresAt time.Time
+}
+
+// LocalLimiter is an in-memory Limiter used when Redis is unavailable. Each
+// gateway instance counts independently, so limits are approximate across a
+// fleet. Rolling windows use GCRA and calendar windows a fixed counter.
+type LocalLimiter struct {
+	mu        sync.Mutex
+	entries   map[string]*localEntry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewLocalLimiter creates a new in-memory rate limiter
+func NewLocalLimiter() *LocalLimiter {
+	return &LocalLimiter{
+		entries:   make(map[string]*localEntry),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// Allow checks whether a request is allowed under the limit and, if so,
+// counts its cost
+func (l *LocalLimiter) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	if limit.Count <= 0 {
+		return &Result{Allowed: true, Limit: limit.Count}, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastSweep) > sweepInterval {
+		l.sweep(now)
+	}
+
+	if limit.Calendar {
+		return l.allowCalendar(key, limit, now), nil
+	}
+	return l.allowGCRA(key, limit, now), nil
+}
+
+func (l *LocalLimiter) allowGCRA(key string, limit Limit, now time.Time) *Result {
+	interval := limit.Period / time.Duration(limit.Count)
+
+	entry, ok := l.entries[key]
+	if !ok {
+		entry = &localEntry{}
+		l.entries[key] = entry
+	}
+
+	tat := entry.tat
+	if tat.Before(now) {
+		tat = now
+	}
+	newTAT := tat.Add(interval * time.Duration(limit.units()))
+	allowAt := newTAT.Add(-limit.Period)
+	if now.Before(allowAt) {
+		return &Result{
+			Limit:      limit.Count,
+			ResetAt:    tat,
+			RetryAfter: allowAt.Sub(now),
+		}
+	}
+
+	entry.tat = newTAT
+	entry.expiresAt = newTAT
+	return &Result{
+		Allowed:   true,
+		Limit:     limit.Count,
+		Remaining: int(now.Sub(allowAt) / interval),
+		ResetAt:   newTAT,
+	}
+}
+
+func (l *LocalLimiter) allowCalendar(key string, limit Limit, now time.Time) *Result {
+	month, resetAt := monthWindow(now)
+
+	entry, ok := l.entries[key]
+	if !ok || entry.window != month {
+		entry = &localEntry{window: month, expiresAt: resetAt}
+		l.entries[key] = entry
+	}
+
+	if entry.count+limit.units() > limit.Count {
+		return &Result{
+			Limit:      limit.Count,
+			Remaining:  max(0, limit.Count-entry.count),
+			ResetAt:    resetAt,
+			RetryAfter: resetAt.Sub(now),
+		}
+	}
+
+	entry.count += limit.units()
+	return &Result{
+		Allowed:   true,
+		Limit:     limit.Count,
+		Remaining: limit.Count - entry.count,
+		ResetAt:   resetAt,
+	}
+}
+
+// sweep drops expired entries. Callers must hold l.mu.
+func (l *LocalLimiter) sweep(now time.Time) {
+	for key, entry := range l.entries {
+		if entry.expiresAt.Before(now) {
+			delete(l.entries, key)
+		}
+	}
+	l.lastSweep = now
+}
@@ -0,0 +1,143 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// quotaRetention keeps a period's counter around after the period ends so
+// late calls and support queries can still read it
+const quotaRetention = 7 * 24 * time.Hour
+
+// QuotaResult is the outcome of consuming quota
+type QuotaResult struct {
+	Allowed bool
+	Limit   int64
+	// Used includes the units of an allowed call
+	Used int64
+	// Degraded is set when the counter was unavailable and the failure
+	// policy let the call through uncounted
+	Degraded bool
+}
+
+// Remaining returns the units left in the period
+func (r *QuotaResult) Remaining() int64 {
+	if r.Used >= r.Limit {
+		return 0
+	}
+	return r.Limit - r.Used
+}
+
+// QuotaConfig controls the quota counter
+type QuotaConfig struct {
+	// Timeout bounds each call to Redis
+	Timeout time.Duration
+	// Policy applies to quotas that don't set a failure policy. Counts
+	// local to one instance mean little over a billing period, so FailLocal
+	// lets calls through like FailOpen.
+	Policy Policy
+}
+
+// QuotaCounter counts the units used by a subscription in each billing
+// period, shared by every gateway instance
+type QuotaCounter struct {
+	client *redis.Client
+	config QuotaConfig
+}
+
+// NewQuotaCounter creates a Redis-backed quota counter
+func NewQuotaCounter(client *redis.Client, config QuotaConfig) *QuotaCounter {
+	if config.Timeout <= 0 {
+		config.Timeout = 250 * time.Millisecond
+	}
+	if config.Policy == "" {
+		config.Policy = FailLocal
+	}
+	return &QuotaCounter{client: client, config: config}
+}
+
+// Consume adds units to the period ending at periodEnd. With block set, a
+// call that would take usage past limit is rejected and not counted;
+// otherwise it is always counted, so usage can exceed the limit. When Redis
+// is unavailable, onFailure (or the default policy) decides the result.
+func (q *QuotaCounter) Consume(ctx context.Context, key string, units int, limit int64, periodEnd time.Time, block bool, onFailure Policy) (*QuotaResult, error) {
+	if units <= 0 {
+		units = 1
+	}
+	blockArg := 0
+	if block {
+		blockArg = 1
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, q.config.Timeout)
+	values, err := quotaScript.Run(callCtx, q.client, []string{key}, limit, periodEnd.Add(quotaRetention).Unix(), units, blockArg).Slice()
+	cancel()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		log.Printf("Quota counter unavailable: %v", err)
+
+		if onFailure == "" {
+			onFailure = q.config.Policy
+		}
+		if onFailure == FailClosed {
+			return nil, ErrUnavailable
+		}
+		return &QuotaResult{Allowed: true, Limit: limit, Degraded: true}, nil
+	}
+	if len(values) != 2 {
+		return nil, fmt.Errorf("unexpected quota script result %v", values)
+	}
+	return &QuotaResult{
+		Allowed: toInt64(values[0]) == 1,
+		Limit:   limit,
+		Used:    toInt64(values[1]),
+	}, nil
+}
+
+// Refund takes units back off a period's counter, for calls that turned out
+// not to be billable
+func (q *QuotaCounter) Refund(ctx context.Context, key string, units int) error {
+	if units <= 0 {
+		units = 1
+	}
+	callCtx, cancel := context.WithTimeout(ctx, q.config.Timeout)
+	defer cancel()
+	return q.client.DecrBy(callCtx, key, int64(units)).Err()
+}
+
+// BillingPeriod returns the monthly billing period containing now for a
+// subscription that started at anchor. Periods start on the anchor's day of
+// the month, or the last day of shorter months, like card billing cycles.
+func BillingPeriod(anchor, now time.Time) (time.Time, time.Time) {
+	anchor = anchor.UTC()
+	now = now.UTC()
+	if now.Before(anchor) {
+		return anchor, addMonths(anchor, 1)
+	}
+
+	n := (now.Year()-anchor.Year())*12 + int(now.Month()-anchor.Month())
+	start := addMonths(anchor, n)
+	if start.After(now) {
+		n--
+		start = addMonths(anchor, n)
+	}
+	return start, addMonths(anchor, n+1)
+}
+
+// addMonths moves t forward n months, clamping the day to the target month
+func addMonths(t time.Time, n int) time.Time {
+	year, month := t.Year(), t.Month()+time.Month(n)
+	// Day 0 of the following month is the last day of this one
+	last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	day := t.Day()
+	if day > last {
+		day = last
+	}
+	return time.Date(year, month, day, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestBillingPeriod(t *testing.T) {
+	date := func(month time.Month, day int) time.Time {
+		return time.Date(2026, month, day, 9, 30, 0, 0, time.UTC)
+	}
+	anchor := date(1, 31)
+
+	tests := []struct {
+		now        time.Time
+		start, end time.Time
+	}{
+		{date(1, 31), date(1, 31), date(2, 28)},
+		{date(2, 15), date(1, 31), date(2, 28)},
+		// Short months end the period on their last day
+		{date(2, 28), date(2, 28), date(3, 31)},
+		{date(3, 30), date(2, 28), date(3, 31)},
+		{date(4, 30), date(4, 30), date(5, 31)},
+		{date(4, 30).Add(-time.Minute), date(3, 31), date(4, 30)},
+	}
+	for _, tt := range tests {
+		start, end := BillingPeriod(anchor, tt.now)
+		if !start.Equal(tt.start) || !end.Equal(tt.end) {
+			t.Errorf("BillingPeriod(%s) = %s - %s, want %s - %s", tt.now, start, end, tt.start, tt.end)
+		}
+	}
+}
+
+func TestQuotaCounter(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	counter := NewQuotaCounter(client, QuotaConfig{})
+	ctx := context.Background()
+	end := time.Now().Add(time.Hour)
+
+	result, err := counter.Consume(ctx, "quota:sub-1", 8, 10, end, true, "")
+	if err != nil || !result.Allowed || result.Remaining() != 2 {
+		t.Fatalf("first call = %+v, %v", result, err)
+	}
+
+	// Blocking calls past the limit aren't counted
+	result, err = counter.Consume(ctx, "quota:sub-1", 3, 10, end, true, "")
+	if err != nil || result.Allowed || result.Used != 8 {
+		t.Fatalf("blocked call = %+v, %v", result, err)
+	}
+
+	// Without blocking, usage runs past the limit
+	result, err = counter.Consume(ctx, "quota:sub-1", 3, 10, end, false, "")
+	if err != nil || !result.Allowed || result.Used != 11 || result.Remaining() != 0 {
+		t.Fatalf("overage call = %+v, %v", result, err)
+	}
+	if ttl := mr.TTL("quota:sub-1"); ttl <= time.Hour {
+		t.Errorf("counter expires in %s, want it kept past the period", ttl)
+	}
+
+	mr.Close()
+	if _, err := counter.Consume(ctx, "quota:sub-1", 1, 10, end, true, FailClosed); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("closed policy err = %v", err)
+	}
+	result, err = counter.Consume(ctx, "quota:sub-1", 1, 10, end, true, "")
+	if err != nil || !result.Allowed || !result.Degraded {
+		t.Errorf("default policy = %+v, %v", result, err)
+	}
+}
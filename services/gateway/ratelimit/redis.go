@@ -2,91 +2,136 @@ package ratelimit
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 )
 
-// RedisRateLimiter implements sliding window rate limiting using Redis
+// RedisRateLimiter implements Limiter with Lua scripts so each check and
+// increment is a single atomic round trip shared by every gateway instance
 type RedisRateLimiter struct {
-	client *redis.Client
+	client    *redis.Client
+	algorithm Algorithm
 }
 
-// NewRedisRateLimiter creates a new Redis-based rate limiter
-func NewRedisRateLimiter(client *redis.Client) *RedisRateLimiter {
+// NewRedisRateLimiter creates a new Redis-based rate limiter using the given algorithm
+func NewRedisRateLimiter(client *redis.Client, algorithm Algorithm) *RedisRateLimiter {
+	if algorithm == "" {
+		algorithm = SlidingLog
+	}
 	return &RedisRateLimiter{
-		client: client,
+		client:    client,
+		algorithm: algorithm,
 	}
 }
 
-// CheckLimit checks if a request is allowed under the rate limit
-// Returns: allowed (bool), remaining (int), resetTime (time.Time), error
-func (r *RedisRateLimiter) CheckLimit(ctx context.Context, key string, limit int, window time.Duration) (bool, int, time.Time, error) {
-	now := time.Now()
-	windowStart := now.Add(-window).UnixNano()
-	
-	// Use Redis pipeline for atomic operations
-	pipe := r.client.Pipeline()
-	
-	// Remove old entries outside the window
-	pipe.ZRemRangeByScore(ctx, key, "0", fmt.Sprintf("%d", windowStart))
-	
-	// Count current entries in the window
-	countCmd := pipe.ZCard(ctx, key)
-	
-	// Execute pipeline
-	_, err := pipe.Exec(ctx)
+// Algorithm returns the algorithm used for rolling windows
+func (r *RedisRateLimiter) Algorithm() Algorithm {
+	return r.algorithm
+}
+
+// Allow checks whether a request is allowed under the limit and, if so,
+// counts its cost
+func (r *RedisRateLimiter) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	if limit.Count <= 0 {
+		return nil, fmt.Errorf("rate limit must be positive, got %d", limit.Count)
+	}
+	if limit.Calendar {
+		return r.allowCalendar(ctx, key, limit)
+	}
+	if limit.Period < time.Millisecond {
+		return nil, fmt.Errorf("rate limit period %s is too short", limit.Period)
+	}
+
+	// Keys are namespaced by algorithm so switching algorithms never reads
+	// state written in another format
+	key = key + ":" + string(r.algorithm)
+	periodMs := limit.Period.Milliseconds()
+
+	var values []interface{}
+	var err error
+	switch r.algorithm {
+	case SlidingLog:
+		values, err = r.run(ctx, slidingLogScript, key, limit.Count, periodMs, uniqueMember(), limit.units())
+	case TokenBucket:
+		values, err = r.run(ctx, tokenBucketScript, key, limit.Count, periodMs, limit.units())
+	case GCRA:
+		values, err = r.run(ctx, gcraScript, key, limit.Count, periodMs, limit.units())
+	default:
+		return nil, fmt.Errorf("unknown rate limit algorithm %q", r.algorithm)
+	}
 	if err != nil {
-		return false, 0, time.Time{}, fmt.Errorf("failed to execute pipeline: %w", err)
+		return nil, err
 	}
-	
-	// Get current count
-	count := countCmd.Val()
-	
-	// Check if under limit
-	if count >= int64(limit) {
-		// Get the oldest entry to calculate reset time
-		oldestEntries, err := r.client.ZRange(ctx, key, 0, 0).Result()
-		if err != nil || len(oldestEntries) == 0 {
-			return false, 0, now.Add(window), nil
-		}
-		
-		// Get the score (timestamp) of the oldest entry
-		scores, err := r.client.ZMScore(ctx, key, oldestEntries[0]).Result()
-		if err != nil || len(scores) == 0 {
-			return false, 0, now.Add(window), nil
-		}
-		
-		oldestTime := time.Unix(0, int64(scores[0]))
-		resetTime := oldestTime.Add(window)
-		
-		return false, 0, resetTime, nil
+	if len(values) != 4 {
+		return nil, fmt.Errorf("unexpected rate limit script result %v", values)
 	}
-	
-	// Add current request
-	err = r.client.ZAdd(ctx, key, &redis.Z{
-		Score:  float64(now.UnixNano()),
-		Member: fmt.Sprintf("%d-%d", now.UnixNano(), now.UnixNano()%1000),
-	}).Err()
+
+	now := time.Now()
+	return &Result{
+		Allowed:    toInt64(values[0]) == 1,
+		Limit:      limit.Count,
+		Remaining:  int(toInt64(values[1])),
+		RetryAfter: time.Duration(toInt64(values[2])) * time.Millisecond,
+		ResetAt:    now.Add(time.Duration(toInt64(values[3])) * time.Millisecond),
+	}, nil
+}
+
+// allowCalendar counts requests in the current calendar month
+func (r *RedisRateLimiter) allowCalendar(ctx context.Context, key string, limit Limit) (*Result, error) {
+	month, resetAt := monthWindow(time.Now())
+	values, err := r.run(ctx, fixedWindowScript, key+":"+month, limit.Count, resetAt.Unix(), limit.units())
 	if err != nil {
-		return false, 0, time.Time{}, fmt.Errorf("failed to add request: %w", err)
+		return nil, err
+	}
+	if len(values) != 2 {
+		return nil, fmt.Errorf("unexpected rate limit script result %v", values)
+	}
+
+	result := &Result{
+		Allowed:   toInt64(values[0]) == 1,
+		Limit:     limit.Count,
+		Remaining: int(toInt64(values[1])),
+		ResetAt:   resetAt,
+	}
+	if !result.Allowed {
+		result.RetryAfter = time.Until(resetAt)
 	}
-	
-	// Set expiration on the key
-	err = r.client.Expire(ctx, key, window).Err()
+	return result, nil
+}
+
+func (r *RedisRateLimiter) run(ctx context.Context, script *redis.Script, key string, args ...interface{}) ([]interface{}, error) {
+	values, err := script.Run(ctx, r.client, []string{key}, args...).Slice()
 	if err != nil {
-		// Non-critical error, log but don't fail the request
-		fmt.Printf("Failed to set expiration on key %s: %v\n", key, err)
+		return nil, fmt.Errorf("failed to run rate limit script: %w", err)
 	}
-	
-	remaining := limit - int(count) - 1
-	resetTime := now.Add(window)
-	
-	return true, remaining, resetTime, nil
+	return values, nil
 }
 
 // Reset clears the rate limit for a specific key
 func (r *RedisRateLimiter) Reset(ctx context.Context, key string) error {
-	return r.client.Del(ctx, key).Err()
+	month, _ := monthWindow(time.Now())
+	return r.client.Del(ctx,
+		key+":"+string(SlidingLog),
+		key+":"+string(TokenBucket),
+		key+":"+string(GCRA),
+		key+":"+month,
+	).Err()
+}
+
+// uniqueMember returns a sorted set member that won't collide between gateways
+func uniqueMember() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+func toInt64(v interface{}) int64 {
+	n, _ := v.(int64)
+	return n
 }
@@ -0,0 +1,192 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func newTestLimiter(t *testing.T, algorithm Algorithm) (*RedisRateLimiter, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	mr.SetTime(time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC))
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewRedisRateLimiter(client, algorithm), mr
+}
+
+func TestAllowIsAtomicUnderConcurrency(t *testing.T) {
+	for _, algorithm := range []Algorithm{SlidingLog, TokenBucket, GCRA} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			limiter, _ := newTestLimiter(t, algorithm)
+			ctx := context.Background()
+
+			var allowed int64
+			var wg sync.WaitGroup
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					result, err := limiter.Allow(ctx, "rate:minute:key-1", PerMinute(10))
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					if result.Allowed {
+						atomic.AddInt64(&allowed, 1)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if allowed != 10 {
+				t.Errorf("allowed %d requests, want 10", allowed)
+			}
+		})
+	}
+}
+
+func TestRejectedRequestsReportRetryAfter(t *testing.T) {
+	for _, algorithm := range []Algorithm{SlidingLog, TokenBucket, GCRA} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			limiter, mr := newTestLimiter(t, algorithm)
+			ctx := context.Background()
+			limit := Limit{Count: 2, Period: 10 * time.Second}
+
+			for i := 0; i < 2; i++ {
+				result, err := limiter.Allow(ctx, "k", limit)
+				if err != nil || !result.Allowed {
+					t.Fatalf("request %d: allowed=%v err=%v", i, result != nil && result.Allowed, err)
+				}
+				if result.Remaining != 1-i {
+					t.Errorf("request %d: remaining %d, want %d", i, result.Remaining, 1-i)
+				}
+			}
+
+			result, err := limiter.Allow(ctx, "k", limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Allowed {
+				t.Fatal("expected third request to be rejected")
+			}
+			if result.RetryAfter <= 0 || result.RetryAfter > limit.Period {
+				t.Errorf("unexpected retry after %s", result.RetryAfter)
+			}
+
+			// Capacity returns once the caller has waited long enough
+			mr.SetTime(time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC).Add(result.RetryAfter))
+			result, err = limiter.Allow(ctx, "k", limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !result.Allowed {
+				t.Error("expected request to be allowed after retry delay")
+			}
+		})
+	}
+}
+
+func TestWeightedRequestsConsumeTheirCost(t *testing.T) {
+	for _, algorithm := range []Algorithm{SlidingLog, TokenBucket, GCRA} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			limiter, mr := newTestLimiter(t, algorithm)
+			ctx := context.Background()
+			limit := Limit{Count: 10, Period: 10 * time.Second}
+
+			result, err := limiter.Allow(ctx, "k", limit.WithCost(7))
+			if err != nil || !result.Allowed || result.Remaining != 3 {
+				t.Fatalf("first request = %+v, %v", result, err)
+			}
+
+			// A heavy request doesn't fit in what is left, but a light one does
+			result, err = limiter.Allow(ctx, "k", limit.WithCost(4))
+			if err != nil || result.Allowed {
+				t.Fatalf("second request = %+v, %v", result, err)
+			}
+			if result.RetryAfter <= 0 || result.RetryAfter > limit.Period {
+				t.Errorf("unexpected retry after %s", result.RetryAfter)
+			}
+			retryAfter := result.RetryAfter
+
+			result, err = limiter.Allow(ctx, "k", limit)
+			if err != nil || !result.Allowed || result.Remaining != 2 {
+				t.Fatalf("third request = %+v, %v", result, err)
+			}
+
+			mr.SetTime(time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC).Add(retryAfter + limit.Period/10))
+			result, err = limiter.Allow(ctx, "k", limit.WithCost(4))
+			if err != nil || !result.Allowed {
+				t.Errorf("request after retry delay = %+v, %v", result, err)
+			}
+		})
+	}
+}
+
+func TestGCRAStoresSingleKey(t *testing.T) {
+	limiter, mr := newTestLimiter(t, GCRA)
+	ctx := context.Background()
+
+	for i := 0; i < 100; i++ {
+		if _, err := limiter.Allow(ctx, "rate:day:key-1", PerDay(1000)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if keys := mr.Keys(); len(keys) != 1 || keys[0] != "rate:day:key-1:gcra" {
+		t.Errorf("unexpected keys %v", keys)
+	}
+}
+
+func TestCalendarMonthWindow(t *testing.T) {
+	limiter, mr := newTestLimiter(t, SlidingLog)
+	ctx := context.Background()
+
+	month, resetAt := monthWindow(time.Now())
+	for i := 0; i < 3; i++ {
+		result, err := limiter.Allow(ctx, "rate:month:key-1", PerMonth(3))
+		if err != nil || !result.Allowed {
+			t.Fatalf("request %d rejected: %v", i, err)
+		}
+		if !result.ResetAt.Equal(resetAt) {
+			t.Errorf("reset at %s, want %s", result.ResetAt, resetAt)
+		}
+	}
+
+	result, err := limiter.Allow(ctx, "rate:month:key-1", PerMonth(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Allowed {
+		t.Error("expected fourth request in the month to be rejected")
+	}
+
+	// Rejections are not counted, and the counter is a single integer
+	if got, _ := mr.Get("rate:month:key-1:" + month); got != "3" {
+		t.Errorf("counter = %q, want 3", got)
+	}
+
+	// Weighted requests count their cost against the month
+	result, err = limiter.Allow(ctx, "rate:month:key-2", PerMonth(10).WithCost(6))
+	if err != nil || !result.Allowed || result.Remaining != 4 {
+		t.Fatalf("weighted request = %+v, %v", result, err)
+	}
+	if result, _ = limiter.Allow(ctx, "rate:month:key-2", PerMonth(10).WithCost(6)); result.Allowed {
+		t.Error("expected second weighted request to be rejected")
+	}
+}
+
+func TestMonthWindow(t *testing.T) {
+	month, reset := monthWindow(time.Date(2026, 12, 31, 23, 59, 0, 0, time.UTC))
+	if month != "2026-12" {
+		t.Errorf("month = %s", month)
+	}
+	if want := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC); !reset.Equal(want) {
+		t.Errorf("reset = %s, want %s", reset, want)
+	}
+}
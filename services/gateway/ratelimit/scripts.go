@@ -0,0 +1,187 @@
+package ratelimit
+
+import "github.com/go-redis/redis/v8"
+
+// All scripts read the clock from Redis so gateway instances agree on time,
+// and work in milliseconds so timestamps stay exact in Lua numbers. Each
+// returns {allowed, remaining, retry_after_ms, reset_after_ms}.
+
+// slidingLogScript keeps one sorted set member per unit in the window.
+// KEYS[1] = log key, ARGV[1] = limit, ARGV[2] = window ms, ARGV[3] = unique
+// member, ARGV[4] = cost
+var slidingLogScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local cost = tonumber(ARGV[4])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+
+local allowed = 0
+if count + cost <= limit then
+	for i = 1, cost do
+		redis.call('ZADD', KEYS[1], now, ARGV[3] .. ':' .. i)
+	end
+	redis.call('PEXPIRE', KEYS[1], window)
+	count = count + cost
+	allowed = 1
+end
+
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+local reset = window
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+
+if allowed == 1 then
+	return {1, limit - count, 0, reset}
+end
+
+-- Wait until enough units leave the window for this cost
+local retry = reset
+if cost <= limit then
+	local freed = redis.call('ZRANGE', KEYS[1], count + cost - limit - 1, count + cost - limit - 1, 'WITHSCORES')
+	if freed[2] then
+		retry = tonumber(freed[2]) + window - now
+	end
+end
+return {0, math.max(0, limit - count), retry, reset}
+`)
+
+// tokenBucketScript stores the token count and last refill time in a hash.
+// KEYS[1] = bucket key, ARGV[1] = capacity, ARGV[2] = refill period ms,
+// ARGV[3] = cost
+var tokenBucketScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local capacity = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+local rate = capacity / period
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or capacity
+local ts = tonumber(state[2]) or now
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate)
+
+local allowed = 0
+local retry = 0
+if tokens >= cost then
+	tokens = tokens - cost
+	allowed = 1
+else
+	retry = math.ceil((cost - tokens) / rate)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tokens, 'ts', now)
+redis.call('PEXPIRE', KEYS[1], period)
+
+return {allowed, math.floor(tokens), retry, math.ceil((capacity - tokens) / rate)}
+`)
+
+// gcraScript stores the theoretical arrival time of the next request.
+// KEYS[1] = TAT key, ARGV[1] = limit, ARGV[2] = period ms, ARGV[3] = cost
+var gcraScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local limit = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+local interval = period / limit
+
+local tat = tonumber(redis.call('GET', KEYS[1])) or now
+if tat < now then
+	tat = now
+end
+
+local new_tat = tat + interval * cost
+local allow_at = new_tat - period
+if now < allow_at then
+	return {0, 0, math.ceil(allow_at - now), math.ceil(tat - now)}
+end
+
+local reset = math.ceil(new_tat - now)
+redis.call('SET', KEYS[1], new_tat, 'PX', reset)
+return {1, math.floor((now - allow_at) / interval), 0, reset}
+`)
+
+// fixedWindowScript counts requests in a window that expires at a fixed time.
+// Rejected requests are not counted.
+// KEYS[1] = counter key, ARGV[1] = limit, ARGV[2] = window end (unix seconds),
+// ARGV[3] = cost
+// Returns {allowed, remaining}.
+var fixedWindowScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local cost = tonumber(ARGV[3])
+local count = tonumber(redis.call('GET', KEYS[1]) or '0')
+if count + cost > limit then
+	return {0, math.max(0, limit - count)}
+end
+
+count = redis.call('INCRBY', KEYS[1], cost)
+if count == cost then
+	redis.call('EXPIREAT', KEYS[1], ARGV[2])
+end
+return {1, limit - count}
+`)
+
+// acquireScript takes a concurrency slot. The sorted set holds one member
+// per in-flight request scored by its lease expiry, so slots held by a
+// crashed gateway free themselves.
+// KEYS[1] = semaphore key, ARGV[1] = limit, ARGV[2] = lease ms, ARGV[3] = token
+// Returns {acquired, in_flight}.
+var acquireScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local limit = tonumber(ARGV[1])
+local lease = tonumber(ARGV[2])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+local count = redis.call('ZCARD', KEYS[1])
+if count >= limit then
+	return {0, count}
+end
+
+redis.call('ZADD', KEYS[1], now + lease, ARGV[3])
+redis.call('PEXPIRE', KEYS[1], lease)
+return {1, count + 1}
+`)
+
+// renewScript extends a held lease. It returns 0 if the lease already expired.
+// KEYS[1] = semaphore key, ARGV[1] = lease ms, ARGV[2] = token
+var renewScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local lease = tonumber(ARGV[1])
+
+local expires = redis.call('ZSCORE', KEYS[1], ARGV[2])
+if not expires or tonumber(expires) <= now then
+	return 0
+end
+redis.call('ZADD', KEYS[1], 'XX', now + lease, ARGV[2])
+if redis.call('PTTL', KEYS[1]) < lease then
+	redis.call('PEXPIRE', KEYS[1], lease)
+end
+return 1
+`)
+
+// quotaScript counts units used in a billing period.
+// KEYS[1] = counter key, ARGV[1] = limit, ARGV[2] = expiry (unix seconds),
+// ARGV[3] = units, ARGV[4] = 1 to reject calls that would exceed the limit
+// Returns {allowed, used}.
+var quotaScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local units = tonumber(ARGV[3])
+local used = tonumber(redis.call('GET', KEYS[1]) or '0')
+if ARGV[4] == '1' and used + units > limit then
+	return {0, used}
+end
+
+used = redis.call('INCRBY', KEYS[1], units)
+if used == units then
+	redis.call('EXPIREAT', KEYS[1], ARGV[2])
+end
+return {1, used}
+`)
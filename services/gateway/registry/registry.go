@@ -0,0 +1,385 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// routeKeyPrefix is the Redis hash holding every version of an API.
+	// Each field is a version name and each value is a JSON encoded Route.
+	routeKeyPrefix = "gateway:routes:"
+
+	// UpdatesChannel is the pub/sub channel writers publish to after changing
+	// a route. The message payload is "<creator>/<apiName>".
+	UpdatesChannel = "gateway:routes:updates"
+)
+
+var (
+	// ErrRouteNotFound is returned when no version of an API is registered
+	ErrRouteNotFound = errors.New("route not found")
+
+	// ErrVersionNotFound is returned when the requested version is not registered
+	ErrVersionNotFound = errors.New("version not found")
+)
+
+// Deployment modes a route can be registered with
+const (
+	ModeKubernetes = "kubernetes"
+	ModeHosted     = "hosted"
+	ModeBYOA       = "byoa"
+)
+
+// SandboxVersion is the reserved version serving test mode calls. It is
+// never selected for live traffic.
+const SandboxVersion = "sandbox"
+
+// Route describes where a single version of an API is served from
+type Route struct {
+	Creator   string    `json:"creator"`
+	APIName   string    `json:"api_name"`
+	Version   string    `json:"version"`
+	Upstreams []string  `json:"upstreams"`
+	Mode      string    `json:"mode"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// APIID is the marketplace ID of the API, when the writer knows it
+	APIID string `json:"api_id,omitempty"`
+	// Settings holds optional per-API proxy settings
+	Settings *Settings `json:"settings,omitempty"`
+
+	next uint64
+}
+
+// Settings are per-API overrides of the gateway's proxy defaults
+type Settings struct {
+	// TimeoutMs bounds how long the upstream has to start responding; 0 uses
+	// the gateway default
+	TimeoutMs int `json:"timeout_ms,omitempty"`
+	// MaxRetries is how many times idempotent requests are retried after a
+	// failure; nil uses the gateway default
+	MaxRetries *int `json:"max_retries,omitempty"`
+	// StreamTimeoutMs bounds the lifetime of streaming responses and
+	// upgraded connections; 0 uses the gateway default
+	StreamTimeoutMs int `json:"stream_timeout_ms,omitempty"`
+	// Cache opts the API into gateway response caching
+	Cache *CacheRule `json:"cache,omitempty"`
+	// ValidateRequests rejects requests that don't match the API's
+	// published OpenAPI document before they reach the upstream
+	ValidateRequests bool `json:"validate_requests,omitempty"`
+	// Signing is the algorithm requests to the upstream are signed with:
+	// hmac-sha256 or ed25519. Empty uses the gateway default.
+	Signing string `json:"signing,omitempty"`
+}
+
+// CacheRule controls how the gateway caches an API's responses
+type CacheRule struct {
+	// TTLSeconds applies when the upstream sends no Cache-Control max-age or
+	// Expires; 0 caches only responses with explicit freshness
+	TTLSeconds int `json:"ttl_seconds,omitempty"`
+	// VaryHeaders are request headers that select between cached responses
+	VaryHeaders []string `json:"vary_headers,omitempty"`
+	// PerConsumer keeps a separate cache for each consumer
+	PerConsumer bool `json:"per_consumer,omitempty"`
+	// BillableHits bills cache hits like calls served by the upstream
+	BillableHits bool `json:"billable_hits,omitempty"`
+}
+
+// Timeout returns the route's response header timeout or fallback if it has none
+func (r *Route) Timeout(fallback time.Duration) time.Duration {
+	if r.Settings != nil && r.Settings.TimeoutMs > 0 {
+		return time.Duration(r.Settings.TimeoutMs) * time.Millisecond
+	}
+	return fallback
+}
+
+// StreamTimeout returns the route's stream lifetime or fallback if it has none
+func (r *Route) StreamTimeout(fallback time.Duration) time.Duration {
+	if r.Settings != nil && r.Settings.StreamTimeoutMs > 0 {
+		return time.Duration(r.Settings.StreamTimeoutMs) * time.Millisecond
+	}
+	return fallback
+}
+
+// Retries returns the route's retry budget or fallback if it has none
+func (r *Route) Retries(fallback int) int {
+	if r.Settings != nil && r.Settings.MaxRetries != nil {
+		return *r.Settings.MaxRetries
+	}
+	return fallback
+}
+
+// CacheRule returns the route's cache rule, or nil if caching is off
+func (r *Route) CacheRule() *CacheRule {
+	if r.Settings == nil {
+		return nil
+	}
+	return r.Settings.Cache
+}
+
+// ValidatesRequests reports whether the route opted in to request validation
+func (r *Route) ValidatesRequests() bool {
+	return r.APIID != "" && r.Settings != nil && r.Settings.ValidateRequests
+}
+
+// SigningAlgorithm returns the algorithm the route's requests are signed
+// with or fallback if it doesn't choose one
+func (r *Route) SigningAlgorithm(fallback string) string {
+	if r.Settings != nil && r.Settings.Signing != "" {
+		return r.Settings.Signing
+	}
+	return fallback
+}
+
+// NextUpstream returns the next upstream URL in round-robin order
+func (r *Route) NextUpstream() string {
+	if len(r.Upstreams) == 0 {
+		return ""
+	}
+	n := atomic.AddUint64(&r.next, 1)
+	return r.Upstreams[(n-1)%uint64(len(r.Upstreams))]
+}
+
+// routeSet holds every registered version of an API as cached by the gateway
+type routeSet struct {
+	versions  map[string]*Route
+	latest    *Route
+	sandbox   *Route
+	rule      *RoutingRule
+	fetchedAt time.Time
+}
+
+// Registry resolves creator/apiName/version to upstream URLs. Routes are
+// written to Redis by the deployment and hosted services and cached in
+// memory here; writers publish on UpdatesChannel so changes are picked up
+// without waiting for the cache TTL.
+type Registry struct {
+	client *redis.Client
+	ttl    time.Duration
+
+	mu     sync.RWMutex
+	routes map[string]*routeSet
+}
+
+// NewRegistry creates a new route registry backed by Redis
+func NewRegistry(client *redis.Client, ttl time.Duration) *Registry {
+	return &Registry{
+		client: client,
+		ttl:    ttl,
+		routes: make(map[string]*routeSet),
+	}
+}
+
+// RouteKey returns the Redis key holding the routes of an API
+func RouteKey(creator, apiName string) string {
+	return routeKeyPrefix + routeID(creator, apiName)
+}
+
+func routeID(creator, apiName string) string {
+	return strings.ToLower(creator) + "/" + strings.ToLower(apiName)
+}
+
+// Resolve returns the route for a version of an API. An empty version
+// resolves to the most recently deployed version.
+func (r *Registry) Resolve(ctx context.Context, creator, apiName, version string) (*Route, error) {
+	set, err := r.lookup(ctx, creator, apiName)
+	if err != nil {
+		return nil, err
+	}
+
+	if set.latest == nil {
+		return nil, ErrRouteNotFound
+	}
+
+	if version == "" {
+		return set.latest, nil
+	}
+
+	route, ok := set.versions[version]
+	if !ok {
+		return nil, ErrVersionNotFound
+	}
+	return route, nil
+}
+
+// Sandbox returns the route serving an API's test mode calls
+func (r *Registry) Sandbox(ctx context.Context, creator, apiName string) (*Route, error) {
+	set, err := r.lookup(ctx, creator, apiName)
+	if err != nil {
+		return nil, err
+	}
+	if set.sandbox == nil {
+		return nil, ErrRouteNotFound
+	}
+	return set.sandbox, nil
+}
+
+// Versions returns every registered version of an API
+func (r *Registry) Versions(ctx context.Context, creator, apiName string) ([]*Route, error) {
+	set, err := r.lookup(ctx, creator, apiName)
+	if err != nil {
+		return nil, err
+	}
+
+	routes := make([]*Route, 0, len(set.versions))
+	for _, route := range set.versions {
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// Register stores a route and notifies every gateway instance
+func (r *Registry) Register(ctx context.Context, route *Route) error {
+	if route.Version == "" {
+		return fmt.Errorf("route version is required")
+	}
+	if len(route.Upstreams) == 0 {
+		return fmt.Errorf("at least one upstream is required")
+	}
+	if route.UpdatedAt.IsZero() {
+		route.UpdatedAt = time.Now().UTC()
+	}
+
+	data, err := json.Marshal(route)
+	if err != nil {
+		return fmt.Errorf("failed to marshal route: %w", err)
+	}
+
+	id := routeID(route.Creator, route.APIName)
+	pipe := r.client.TxPipeline()
+	pipe.HSet(ctx, routeKeyPrefix+id, route.Version, data)
+	pipe.Publish(ctx, UpdatesChannel, id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to register route: %w", err)
+	}
+
+	r.Invalidate(route.Creator, route.APIName)
+	return nil
+}
+
+// Deregister removes a version of an API. An empty version removes every version.
+func (r *Registry) Deregister(ctx context.Context, creator, apiName, version string) error {
+	id := routeID(creator, apiName)
+	pipe := r.client.TxPipeline()
+	if version == "" {
+		pipe.Del(ctx, routeKeyPrefix+id, routingKeyPrefix+id)
+	} else {
+		pipe.HDel(ctx, routeKeyPrefix+id, version)
+	}
+	pipe.Publish(ctx, UpdatesChannel, id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to deregister route: %w", err)
+	}
+
+	r.Invalidate(creator, apiName)
+	return nil
+}
+
+// Invalidate drops the cached routes of an API
+func (r *Registry) Invalidate(creator, apiName string) {
+	r.mu.Lock()
+	delete(r.routes, routeID(creator, apiName))
+	r.mu.Unlock()
+}
+
+// Watch listens for route updates and invalidates the cache until ctx is done
+func (r *Registry) Watch(ctx context.Context) {
+	pubsub := r.client.Subscribe(ctx, UpdatesChannel)
+	defer pubsub.Close()
+
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			r.mu.Lock()
+			delete(r.routes, strings.ToLower(msg.Payload))
+			r.mu.Unlock()
+		}
+	}
+}
+
+// lookup returns the cached route set of an API, loading it from Redis if
+// it is missing or older than the TTL. Unknown APIs are cached too so they
+// don't cost a Redis round trip on every request.
+func (r *Registry) lookup(ctx context.Context, creator, apiName string) (*routeSet, error) {
+	id := routeID(creator, apiName)
+
+	r.mu.RLock()
+	set, ok := r.routes[id]
+	r.mu.RUnlock()
+	if ok && time.Since(set.fetchedAt) < r.ttl {
+		return set, nil
+	}
+
+	pipe := r.client.Pipeline()
+	versionsCmd := pipe.HGetAll(ctx, routeKeyPrefix+id)
+	ruleCmd := pipe.Get(ctx, routingKeyPrefix+id)
+	pipe.Exec(ctx)
+
+	fields, err := versionsCmd.Result()
+	if err == nil {
+		if ruleErr := ruleCmd.Err(); ruleErr != nil && ruleErr != redis.Nil {
+			err = ruleErr
+		}
+	}
+	if err != nil {
+		if ok {
+			// Serve the stale entry rather than failing the request
+			log.Printf("Failed to refresh routes for %s, serving cached copy: %v", id, err)
+			return set, nil
+		}
+		return nil, fmt.Errorf("failed to load routes: %w", err)
+	}
+
+	set = &routeSet{
+		versions:  make(map[string]*Route, len(fields)),
+		fetchedAt: time.Now(),
+	}
+	for version, data := range fields {
+		var route Route
+		if err := json.Unmarshal([]byte(data), &route); err != nil {
+			log.Printf("Skipping malformed route %s@%s: %v", id, version, err)
+			continue
+		}
+		if len(route.Upstreams) == 0 {
+			continue
+		}
+		route.Version = version
+		if version == SandboxVersion {
+			set.sandbox = &route
+			continue
+		}
+		set.versions[version] = &route
+		if set.latest == nil || route.UpdatedAt.After(set.latest.UpdatedAt) {
+			set.latest = &route
+		}
+	}
+
+	if data, err := ruleCmd.Bytes(); err == nil {
+		var rule RoutingRule
+		if err := json.Unmarshal(data, &rule); err != nil {
+			log.Printf("Ignoring malformed routing rule for %s: %v", id, err)
+		} else {
+			set.rule = &rule
+		}
+	}
+
+	r.mu.Lock()
+	r.routes[id] = set
+	r.mu.Unlock()
+
+	return set, nil
+}
@@ -0,0 +1,213 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func newTestRegistry(t *testing.T, ttl time.Duration) (*Registry, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewRegistry(client, ttl), client
+}
+
+func TestResolveLatestAndPinnedVersion(t *testing.T) {
+	ctx := context.Background()
+	reg, _ := newTestRegistry(t, time.Minute)
+
+	now := time.Now().UTC()
+	if err := reg.Register(ctx, &Route{
+		Creator: "alice", APIName: "weather", Version: "v1",
+		Upstreams: []string{"http://weather-v1:8080"}, Mode: ModeKubernetes,
+		UpdatedAt: now.Add(-time.Hour),
+	}); err != nil {
+		t.Fatalf("register v1: %v", err)
+	}
+	if err := reg.Register(ctx, &Route{
+		Creator: "alice", APIName: "weather", Version: "v2",
+		Upstreams: []string{"https://abc.execute-api.us-east-1.amazonaws.com/prod"}, Mode: ModeBYOA,
+		UpdatedAt: now,
+	}); err != nil {
+		t.Fatalf("register v2: %v", err)
+	}
+
+	route, err := reg.Resolve(ctx, "Alice", "Weather", "")
+	if err != nil {
+		t.Fatalf("resolve latest: %v", err)
+	}
+	if route.Version != "v2" || route.Mode != ModeBYOA {
+		t.Errorf("expected latest v2 byoa route, got %s %s", route.Version, route.Mode)
+	}
+
+	route, err = reg.Resolve(ctx, "alice", "weather", "v1")
+	if err != nil {
+		t.Fatalf("resolve v1: %v", err)
+	}
+	if got := route.NextUpstream(); got != "http://weather-v1:8080" {
+		t.Errorf("unexpected upstream %q", got)
+	}
+
+	if _, err := reg.Resolve(ctx, "alice", "weather", "v3"); !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("expected ErrVersionNotFound, got %v", err)
+	}
+	if _, err := reg.Resolve(ctx, "bob", "weather", ""); !errors.Is(err, ErrRouteNotFound) {
+		t.Errorf("expected ErrRouteNotFound, got %v", err)
+	}
+}
+
+func TestSandboxIsNotServedToLiveTraffic(t *testing.T) {
+	ctx := context.Background()
+	reg, _ := newTestRegistry(t, time.Minute)
+
+	now := time.Now().UTC()
+	if err := reg.Register(ctx, &Route{
+		Creator: "alice", APIName: "weather", Version: "v1",
+		Upstreams: []string{"http://weather-v1:8080"}, UpdatedAt: now.Add(-time.Hour),
+	}); err != nil {
+		t.Fatalf("register v1: %v", err)
+	}
+	if err := reg.Register(ctx, &Route{
+		Creator: "alice", APIName: "weather", Version: SandboxVersion,
+		Upstreams: []string{"http://weather-sandbox:8080"}, UpdatedAt: now,
+	}); err != nil {
+		t.Fatalf("register sandbox: %v", err)
+	}
+
+	route, err := reg.Select(ctx, "alice", "weather", "", "")
+	if err != nil || route.Version != "v1" {
+		t.Fatalf("latest = %v, %v; want v1", route, err)
+	}
+	if _, err := reg.Resolve(ctx, "alice", "weather", SandboxVersion); !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("pinning the sandbox: expected ErrVersionNotFound, got %v", err)
+	}
+	route, err = reg.Sandbox(ctx, "alice", "weather")
+	if err != nil || route.NextUpstream() != "http://weather-sandbox:8080" {
+		t.Errorf("sandbox = %v, %v", route, err)
+	}
+	if _, err := reg.Sandbox(ctx, "bob", "weather"); !errors.Is(err, ErrRouteNotFound) {
+		t.Errorf("expected ErrRouteNotFound, got %v", err)
+	}
+}
+
+func TestNextUpstreamRoundRobin(t *testing.T) {
+	route := &Route{Upstreams: []string{"http://a", "http://b", "http://c"}}
+
+	var got []string
+	for i := 0; i < 4; i++ {
+		got = append(got, route.NextUpstream())
+	}
+
+	want := []string{"http://a", "http://b", "http://c", "http://a"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("round robin order = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestWatchInvalidatesCachedRoutes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reg, client := newTestRegistry(t, time.Hour)
+	other := NewRegistry(client, time.Hour)
+	go reg.Watch(ctx)
+
+	// Prime the watcher's cache with an unknown API
+	if _, err := reg.Resolve(ctx, "alice", "geo", ""); !errors.Is(err, ErrRouteNotFound) {
+		t.Fatalf("expected ErrRouteNotFound, got %v", err)
+	}
+
+	// Give the subscription time to be established before publishing
+	time.Sleep(50 * time.Millisecond)
+
+	// A different instance (e.g. the deployment service) registers the route
+	if err := other.Register(ctx, &Route{
+		Creator: "alice", APIName: "geo", Version: "v1",
+		Upstreams: []string{"http://geo:9000"},
+	}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		route, err := reg.Resolve(ctx, "alice", "geo", "")
+		if err == nil {
+			if route.NextUpstream() != "http://geo:9000" {
+				t.Fatalf("unexpected upstream %q", route.Upstreams)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("route change was not picked up: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSelectSplitsTrafficByWeight(t *testing.T) {
+	ctx := context.Background()
+	reg, _ := newTestRegistry(t, time.Minute)
+
+	now := time.Now().UTC()
+	for i, version := range []string{"v1", "v2"} {
+		if err := reg.Register(ctx, &Route{
+			Creator: "alice", APIName: "weather", Version: version,
+			Upstreams: []string{"http://weather-" + version}, UpdatedAt: now.Add(time.Duration(i) * time.Second),
+		}); err != nil {
+			t.Fatalf("register %s: %v", version, err)
+		}
+	}
+
+	if err := reg.SetRoutingRule(ctx, "alice", "weather", &RoutingRule{
+		Splits: []Split{{Version: "v1", Weight: 90}, {Version: "v2", Weight: 10}},
+	}); err != nil {
+		t.Fatalf("set routing rule: %v", err)
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 2000; i++ {
+		route, err := reg.Select(ctx, "alice", "weather", "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[route.Version]++
+	}
+	if counts["v2"] < 100 || counts["v2"] > 300 {
+		t.Errorf("canary served %d of 2000 calls, want about 200", counts["v2"])
+	}
+
+	// Pinned versions bypass the split
+	if route, _ := reg.Select(ctx, "alice", "weather", "v2", ""); route.Version != "v2" {
+		t.Errorf("pinned version served %s", route.Version)
+	}
+
+	// Sticky rules keep an API key on one version
+	if err := reg.SetRoutingRule(ctx, "alice", "weather", &RoutingRule{
+		Splits: []Split{{Version: "v1", Weight: 50}, {Version: "v2", Weight: 50}},
+		Sticky: true,
+	}); err != nil {
+		t.Fatalf("set sticky rule: %v", err)
+	}
+	first, _ := reg.Select(ctx, "alice", "weather", "", "key-1")
+	for i := 0; i < 20; i++ {
+		if route, _ := reg.Select(ctx, "alice", "weather", "", "key-1"); route.Version != first.Version {
+			t.Fatalf("sticky key moved from %s to %s", first.Version, route.Version)
+		}
+	}
+
+	// Removing the rule sends traffic to the latest version
+	if err := reg.SetRoutingRule(ctx, "alice", "weather", nil); err != nil {
+		t.Fatalf("clear routing rule: %v", err)
+	}
+	if route, _ := reg.Select(ctx, "alice", "weather", "", "key-1"); route.Version != "v2" {
+		t.Errorf("without a rule served %s, want latest v2", route.Version)
+	}
+}
@@ -0,0 +1,121 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"time"
+)
+
+// routingKeyPrefix is the Redis key holding an API's JSON encoded RoutingRule
+const routingKeyPrefix = "gateway:routing:"
+
+// Split sends a share of an API's traffic to one version
+type Split struct {
+	Version string `json:"version"`
+	Weight  int    `json:"weight"`
+}
+
+// RoutingRule splits the traffic of consumers that don't pin a version
+// between versions of an API, e.g. for a canary
+type RoutingRule struct {
+	Splits []Split `json:"splits"`
+	// Sticky keeps each API key on the same version while the splits are
+	// unchanged
+	Sticky    bool      `json:"sticky,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RoutingKey returns the Redis key holding the routing rule of an API
+func RoutingKey(creator, apiName string) string {
+	return routingKeyPrefix + routeID(creator, apiName)
+}
+
+// Select returns the version of an API to serve. A pinned version always
+// wins. Otherwise the API's routing rule picks a version by weight, keeping
+// stickyKey on the same version if the rule is sticky, and APIs without a
+// rule serve their most recently deployed version.
+func (r *Registry) Select(ctx context.Context, creator, apiName, pinned, stickyKey string) (*Route, error) {
+	if pinned != "" {
+		return r.Resolve(ctx, creator, apiName, pinned)
+	}
+
+	set, err := r.lookup(ctx, creator, apiName)
+	if err != nil {
+		return nil, err
+	}
+	if set.latest == nil {
+		return nil, ErrRouteNotFound
+	}
+	if route := set.split(routeID(creator, apiName), stickyKey); route != nil {
+		return route, nil
+	}
+	return set.latest, nil
+}
+
+// split picks a version by weight, ignoring versions that aren't registered.
+// It returns nil if the API has no usable rule.
+func (s *routeSet) split(id, stickyKey string) *Route {
+	if s.rule == nil {
+		return nil
+	}
+
+	total := 0
+	for _, split := range s.rule.Splits {
+		if _, ok := s.versions[split.Version]; ok && split.Weight > 0 {
+			total += split.Weight
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+
+	var n int
+	if s.rule.Sticky && stickyKey != "" {
+		h := fnv.New32a()
+		h.Write([]byte(id + "/" + stickyKey))
+		n = int(h.Sum32() % uint32(total))
+	} else {
+		n = rand.Intn(total)
+	}
+
+	for _, split := range s.rule.Splits {
+		route, ok := s.versions[split.Version]
+		if !ok || split.Weight <= 0 {
+			continue
+		}
+		if n < split.Weight {
+			return route
+		}
+		n -= split.Weight
+	}
+	return nil
+}
+
+// SetRoutingRule stores an API's routing rule and notifies every gateway
+// instance. A nil rule or one without splits removes it.
+func (r *Registry) SetRoutingRule(ctx context.Context, creator, apiName string, rule *RoutingRule) error {
+	id := routeID(creator, apiName)
+	pipe := r.client.TxPipeline()
+	if rule == nil || len(rule.Splits) == 0 {
+		pipe.Del(ctx, routingKeyPrefix+id)
+	} else {
+		if rule.UpdatedAt.IsZero() {
+			rule.UpdatedAt = time.Now().UTC()
+		}
+		data, err := json.Marshal(rule)
+		if err != nil {
+			return fmt.Errorf("failed to marshal routing rule: %w", err)
+		}
+		pipe.Set(ctx, routingKeyPrefix+id, data, 0)
+	}
+	pipe.Publish(ctx, UpdatesChannel, id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to store routing rule: %w", err)
+	}
+
+	r.Invalidate(creator, apiName)
+	return nil
+}
@@ -0,0 +1,196 @@
+package respcache
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const redisKeyPrefix = "gateway:respcache:"
+
+// MaxBodyBytes is the largest response body the gateway will cache
+const MaxBodyBytes = 1 << 20
+
+// Entry is a cached upstream response
+type Entry struct {
+	Status   int         `json:"status"`
+	Header   http.Header `json:"header"`
+	Body     []byte      `json:"body"`
+	StoredAt time.Time   `json:"stored_at"`
+	Expires  time.Time   `json:"expires"`
+}
+
+// Fresh reports whether the entry can still be served
+func (e *Entry) Fresh(now time.Time) bool {
+	return now.Before(e.Expires)
+}
+
+// Store holds cached responses
+type Store interface {
+	Get(ctx context.Context, key string) (*Entry, bool)
+	Set(ctx context.Context, key string, entry *Entry)
+}
+
+// Key builds a cache key from the API, the request path and query, and the
+// values of the headers the API varies on
+func Key(apiID string, r *http.Request, varyHeaders []string) string {
+	h := sha256.New()
+	h.Write([]byte(apiID))
+	h.Write([]byte{0})
+	h.Write([]byte(r.URL.Path))
+	h.Write([]byte{0})
+	// Normalise query parameter order so ?a=1&b=2 and ?b=2&a=1 share an entry
+	h.Write([]byte(r.URL.Query().Encode()))
+
+	headers := make([]string, len(varyHeaders))
+	for i, name := range varyHeaders {
+		headers[i] = http.CanonicalHeaderKey(name)
+	}
+	sort.Strings(headers)
+	for _, name := range headers {
+		h.Write([]byte{0})
+		h.Write([]byte(name + ":" + strings.Join(r.Header.Values(name), ",")))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// StorableHeader copies response headers for storage, leaving out the ones
+// the gateway sets per request, so a replay carries the replaying caller's
+// rate limit and quota state rather than the first caller's
+func StorableHeader(header http.Header) http.Header {
+	stored := make(http.Header, len(header))
+	for name, values := range header {
+		if name == "X-Cache" || strings.HasPrefix(name, "X-Ratelimit-") || strings.HasPrefix(name, "X-Quota-") || strings.HasPrefix(name, "Access-Control-") {
+			continue
+		}
+		stored[name] = append([]string(nil), values...)
+	}
+	return stored
+}
+
+type memoryItem struct {
+	key   string
+	entry *Entry
+	size  int
+}
+
+// MemoryStore is an in-process LRU store bounded by total body size
+type MemoryStore struct {
+	maxBytes int
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+	bytes int
+}
+
+// NewMemoryStore creates an in-memory store holding up to maxBytes of responses
+func NewMemoryStore(maxBytes int) *MemoryStore {
+	if maxBytes <= 0 {
+		maxBytes = 64 << 20
+	}
+	return &MemoryStore{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// Get returns a stored response
+func (s *MemoryStore) Get(ctx context.Context, key string) (*Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	item := el.Value.(*memoryItem)
+	if !item.entry.Fresh(time.Now()) {
+		s.remove(el)
+		return nil, false
+	}
+	s.ll.MoveToFront(el)
+	return item.entry, true
+}
+
+// Set stores a response, evicting the least recently used responses over the size bound
+func (s *MemoryStore) Set(ctx context.Context, key string, entry *Entry) {
+	size := len(entry.Body)
+	if size > s.maxBytes {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.items[key]; ok {
+		s.remove(el)
+	}
+	s.items[key] = s.ll.PushFront(&memoryItem{key: key, entry: entry, size: size})
+	s.bytes += size
+
+	for s.bytes > s.maxBytes {
+		s.remove(s.ll.Back())
+	}
+}
+
+// remove drops an element. Callers must hold s.mu.
+func (s *MemoryStore) remove(el *list.Element) {
+	item := el.Value.(*memoryItem)
+	s.ll.Remove(el)
+	delete(s.items, item.key)
+	s.bytes -= item.size
+}
+
+// RedisStore shares cached responses between gateway instances
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore creates a Redis-backed store
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+// Get returns a stored response
+func (s *RedisStore) Get(ctx context.Context, key string) (*Entry, bool) {
+	data, err := s.client.Get(ctx, redisKeyPrefix+key).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("Response cache lookup failed: %v", err)
+		}
+		return nil, false
+	}
+
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil || !entry.Fresh(time.Now()) {
+		return nil, false
+	}
+	return &entry, true
+}
+
+// Set stores a response until it expires
+func (s *RedisStore) Set(ctx context.Context, key string, entry *Entry) {
+	ttl := time.Until(entry.Expires)
+	if ttl <= 0 {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	if err := s.client.Set(ctx, redisKeyPrefix+key, data, ttl).Err(); err != nil {
+		log.Printf("Failed to store cached response: %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package respcache
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTTLPrefersSharedCacheDirectives(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		status int
+		header http.Header
+		want   time.Duration
+		ok     bool
+	}{
+		{"s-maxage wins", 200, http.Header{"Cache-Control": {"max-age=10, s-maxage=60"}}, 60 * time.Second, true},
+		{"age is subtracted", 200, http.Header{"Cache-Control": {"max-age=60"}, "Age": {"15"}}, 45 * time.Second, true},
+		{"expires", 200, http.Header{
+			"Date":    {now.Format(http.TimeFormat)},
+			"Expires": {now.Add(time.Minute).Format(http.TimeFormat)},
+		}, time.Minute, true},
+		{"rule default", 200, http.Header{}, 5 * time.Second, true},
+		{"no-store", 200, http.Header{"Cache-Control": {"no-store"}}, 0, false},
+		{"set-cookie", 200, http.Header{"Cache-Control": {"max-age=60"}, "Set-Cookie": {"a=b"}}, 0, false},
+		{"uncovered vary", 200, http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"Authorization"}}, 0, false},
+		{"server error", 500, http.Header{"Cache-Control": {"max-age=60"}}, 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := TTL(tt.status, tt.header, 5*time.Second, nil, now)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s: TTL = %s, %v; want %s, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestMemoryStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(10)
+	entry := func(body string) *Entry {
+		return &Entry{Status: 200, Body: []byte(body), Expires: time.Now().Add(time.Minute)}
+	}
+
+	store.Set(ctx, "a", entry("aaaa"))
+	store.Set(ctx, "b", entry("bbbb"))
+	store.Get(ctx, "a")
+	store.Set(ctx, "c", entry("cccc"))
+
+	if _, ok := store.Get(ctx, "b"); ok {
+		t.Error("expected least recently used entry to be evicted")
+	}
+	if _, ok := store.Get(ctx, "a"); !ok {
+		t.Error("expected recently used entry to be kept")
+	}
+
+	store.Set(ctx, "stale", &Entry{Status: 200, Expires: time.Now().Add(-time.Second)})
+	if _, ok := store.Get(ctx, "stale"); ok {
+		t.Error("expected expired entry to be dropped")
+	}
+}
+
+func TestStorableHeaderLeavesOutPerRequestHeaders(t *testing.T) {
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("ETag", `"v1"`)
+	header.Set("X-Cache", "MISS")
+	header.Set("X-RateLimit-Remaining", "59")
+	header.Set("X-Quota-Remaining", "9500")
+	header.Set("X-Quota-Warning", "80%")
+	header.Set("Access-Control-Allow-Origin", "*")
+
+	stored := StorableHeader(header)
+	for _, name := range []string{"X-Cache", "X-Ratelimit-Remaining", "X-Quota-Remaining", "X-Quota-Warning", "Access-Control-Allow-Origin"} {
+		if _, ok := stored[name]; ok {
+			t.Errorf("%s was stored", name)
+		}
+	}
+	if stored.Get("Content-Type") != "application/json" || stored.Get("ETag") != `"v1"` {
+		t.Errorf("stored = %v", stored)
+	}
+
+	// The copy doesn't share values with the response
+	header["Etag"][0] = `"v2"`
+	if stored.Get("ETag") != `"v1"` {
+		t.Error("stored header changed with the response")
+	}
+}
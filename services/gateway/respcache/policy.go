@@ -0,0 +1,176 @@
+package respcache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cacheableStatus are the response codes a shared cache may store
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusGone:                 true,
+}
+
+// Directives are parsed Cache-Control directives. Names are lower case;
+// directives without a value map to "".
+type Directives map[string]string
+
+// ParseCacheControl parses a Cache-Control header
+func ParseCacheControl(header string) Directives {
+	directives := make(Directives)
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(part, "=")
+		directives[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	return directives
+}
+
+// Has reports whether a directive is present
+func (d Directives) Has(name string) bool {
+	_, ok := d[name]
+	return ok
+}
+
+// seconds returns a delta-seconds directive
+func (d Directives) seconds(name string) (time.Duration, bool) {
+	value, ok := d[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// RequestBypass reports whether a request asks not to be served from cache
+// (no-cache, max-age=0) and whether its response may not be stored (no-store)
+func RequestBypass(r *http.Request) (skipLookup, skipStore bool) {
+	cc := ParseCacheControl(r.Header.Get("Cache-Control"))
+	if cc.Has("no-store") {
+		return true, true
+	}
+	if maxAge, ok := cc.seconds("max-age"); ok && maxAge == 0 {
+		return true, false
+	}
+	if cc.Has("no-cache") || strings.Contains(strings.ToLower(r.Header.Get("Pragma")), "no-cache") {
+		return true, false
+	}
+	return false, false
+}
+
+// TTL returns how long a shared cache may keep a response. defaultTTL
+// applies when the upstream sends no freshness information. varyHeaders are
+// the request headers already part of the cache key; responses that vary on
+// anything else are not stored.
+func TTL(status int, header http.Header, defaultTTL time.Duration, varyHeaders []string, now time.Time) (time.Duration, bool) {
+	if !cacheableStatus[status] {
+		return 0, false
+	}
+	if header.Get("Set-Cookie") != "" {
+		return 0, false
+	}
+	if !varyCovered(header, varyHeaders) {
+		return 0, false
+	}
+
+	cc := ParseCacheControl(header.Get("Cache-Control"))
+	if cc.Has("no-store") || cc.Has("private") || cc.Has("no-cache") {
+		return 0, false
+	}
+
+	var ttl time.Duration
+	if sMaxAge, ok := cc.seconds("s-maxage"); ok {
+		ttl = sMaxAge
+	} else if maxAge, ok := cc.seconds("max-age"); ok {
+		ttl = maxAge
+	} else if expires := header.Get("Expires"); expires != "" {
+		expiresAt, err := http.ParseTime(expires)
+		if err != nil {
+			return 0, false
+		}
+		date := now
+		if d, err := http.ParseTime(header.Get("Date")); err == nil {
+			date = d
+		}
+		ttl = expiresAt.Sub(date)
+	} else {
+		ttl = defaultTTL
+	}
+
+	if age, err := strconv.Atoi(header.Get("Age")); err == nil && age > 0 {
+		ttl -= time.Duration(age) * time.Second
+	}
+	if ttl <= 0 {
+		return 0, false
+	}
+	return ttl, true
+}
+
+// NotModified reports whether a conditional request matches a cached response
+func NotModified(r *http.Request, entry *Entry) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		etag := entry.Header.Get("ETag")
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || weakTag(candidate) == weakTag(etag) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		since, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		modified, err := http.ParseTime(entry.Header.Get("Last-Modified"))
+		return err == nil && !modified.After(since)
+	}
+	return false
+}
+
+// weakTag strips the weak validator prefix for weak comparison
+func weakTag(tag string) string {
+	return strings.TrimPrefix(tag, "W/")
+}
+
+func varyCovered(header http.Header, varyHeaders []string) bool {
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if name == "*" {
+				return false
+			}
+			covered := false
+			for _, v := range varyHeaders {
+				if strings.EqualFold(v, name) {
+					covered = true
+					break
+				}
+			}
+			if !covered {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,274 @@
+// Package signing signs requests the gateway proxies to creator functions so
+// they can verify a call came through the gateway and was not altered.
+//
+// Every API gets its own key derived from the gateway's master key, so a
+// creator holding one API's secret can't forge calls to another API. The
+// signature covers the method, the upstream path and query, a timestamp, the
+// body's SHA-256 and the consumer headers the gateway adds:
+//
+//	v1
+//	<METHOD>
+//	<path>[?<canonical query>]
+//	<timestamp>
+//	<hex sha256 of body, or UNSIGNED-PAYLOAD>
+//	<X-Consumer-ID>
+//	<X-Subscription-ID>
+//
+// The query is signed in canonical form, its parameters percent-encoded as in
+// RFC 3986 and sorted, since functions are often handed them already parsed.
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Algorithms a request can be signed with
+const (
+	HMACSHA256 = "hmac-sha256"
+	Ed25519    = "ed25519"
+)
+
+// Headers set on signed requests
+const (
+	TimestampHeader     = "X-Gateway-Timestamp"
+	ContentSHA256Header = "X-Gateway-Content-SHA256"
+	SignatureHeader     = "X-Gateway-Signature"
+)
+
+// UnsignedPayload replaces the body hash of requests whose body is too large
+// to buffer
+const UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+// version prefixes the string to sign so the format can change later
+const version = "v1"
+
+var (
+	// ErrInvalidSignature is returned when a request's signature doesn't match
+	ErrInvalidSignature = errors.New("invalid gateway signature")
+	// ErrStale is returned when a request's timestamp is outside the allowed skew
+	ErrStale = errors.New("gateway signature timestamp out of range")
+)
+
+// Config controls request signing
+type Config struct {
+	// Key is the gateway's master signing key. Per-API keys are derived from it.
+	Key []byte
+	// Algorithm is used for APIs that don't choose one
+	Algorithm string
+	// MaxBodyBytes bounds how much of a body is buffered to hash it. Larger
+	// bodies are sent as UNSIGNED-PAYLOAD.
+	MaxBodyBytes int64
+}
+
+// Signer signs upstream requests with keys derived per API
+type Signer struct {
+	config Config
+}
+
+// NewSigner creates a signer from the gateway's master key
+func NewSigner(config Config) (*Signer, error) {
+	if len(config.Key) == 0 {
+		return nil, fmt.Errorf("signing key is empty")
+	}
+	if config.Algorithm == "" {
+		config.Algorithm = HMACSHA256
+	}
+	if !Supported(config.Algorithm) {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", config.Algorithm)
+	}
+	if config.MaxBodyBytes <= 0 {
+		config.MaxBodyBytes = 10 << 20
+	}
+	return &Signer{config: config}, nil
+}
+
+// Supported reports whether algorithm is one the gateway can sign with
+func Supported(algorithm string) bool {
+	return algorithm == HMACSHA256 || algorithm == Ed25519
+}
+
+// Algorithm returns the default signing algorithm
+func (s *Signer) Algorithm() string {
+	return s.config.Algorithm
+}
+
+// MaxBodyBytes returns how much of a body is hashed before it's sent unsigned
+func (s *Signer) MaxBodyBytes() int64 {
+	return s.config.MaxBodyBytes
+}
+
+// Secret returns the HMAC-SHA256 secret of an API
+func (s *Signer) Secret(api string) []byte {
+	return s.derive(HMACSHA256, api)
+}
+
+// PublicKey returns the Ed25519 public key creators verify an API's
+// signatures with
+func (s *Signer) PublicKey(api string) ed25519.PublicKey {
+	return s.privateKey(api).Public().(ed25519.PublicKey)
+}
+
+func (s *Signer) privateKey(api string) ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(s.derive(Ed25519, api))
+}
+
+// derive returns the key of an API for one algorithm. api is
+// "<creator>/<apiName>" and is case-insensitive, like routes.
+func (s *Signer) derive(algorithm, api string) []byte {
+	mac := hmac.New(sha256.New, s.config.Key)
+	mac.Write([]byte("apidirect-signing/" + algorithm + "/" + strings.ToLower(api)))
+	return mac.Sum(nil)
+}
+
+// Sign adds the timestamp, content hash and signature headers to req.
+// bodyHash is BodyHash of the request body or UnsignedPayload. The consumer
+// headers and req.URL must already be in their final form.
+func (s *Signer) Sign(req *http.Request, api, algorithm, bodyHash string, now time.Time) error {
+	if algorithm == "" {
+		algorithm = s.config.Algorithm
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	message := []byte(StringToSign(req, timestamp, bodyHash))
+
+	var signature []byte
+	switch algorithm {
+	case HMACSHA256:
+		mac := hmac.New(sha256.New, s.Secret(api))
+		mac.Write(message)
+		signature = mac.Sum(nil)
+	case Ed25519:
+		signature = ed25519.Sign(s.privateKey(api), message)
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(ContentSHA256Header, bodyHash)
+	req.Header.Set(SignatureHeader, algorithm+"="+base64.StdEncoding.EncodeToString(signature))
+	return nil
+}
+
+// BodyHash returns the hex SHA-256 of a request body
+func BodyHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// StringToSign returns the canonical form of a request that is signed
+func StringToSign(req *http.Request, timestamp, bodyHash string) string {
+	return strings.Join([]string{
+		version,
+		req.Method,
+		canonicalURI(req.URL),
+		timestamp,
+		bodyHash,
+		req.Header.Get("X-Consumer-ID"),
+		req.Header.Get("X-Subscription-ID"),
+	}, "\n")
+}
+
+// canonicalURI returns the escaped path of a URL followed by its canonical
+// query, if it has one
+func canonicalURI(u *url.URL) string {
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if query := CanonicalQuery(u.RawQuery); query != "" {
+		path += "?" + query
+	}
+	return path
+}
+
+// CanonicalQuery returns a query string with every parameter percent-encoded
+// as in RFC 3986 and the key=value pairs sorted, so the same parameters give
+// the same string however the client encoded and ordered them
+func CanonicalQuery(rawQuery string) string {
+	// Pairs that don't parse are left out
+	values, _ := url.ParseQuery(rawQuery)
+	pairs := make([]string, 0, len(values))
+	for key, list := range values {
+		for _, value := range list {
+			pairs = append(pairs, escape(key)+"="+escape(value))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// escape percent-encodes everything but RFC 3986 unreserved characters
+func escape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// Verifier checks gateway signatures. Set Secret for hmac-sha256 or
+// PublicKey for ed25519.
+type Verifier struct {
+	Secret    []byte
+	PublicKey ed25519.PublicKey
+	// MaxSkew is how far the timestamp may be from now; 0 allows 5 minutes
+	MaxSkew time.Duration
+	// RequireBodyHash rejects requests whose body was too large to sign
+	RequireBodyHash bool
+}
+
+// Verify checks the signature of req, whose body has been read into body
+func (v *Verifier) Verify(req *http.Request, body []byte, now time.Time) error {
+	timestamp := req.Header.Get(TimestampHeader)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	maxSkew := v.MaxSkew
+	if maxSkew <= 0 {
+		maxSkew = 5 * time.Minute
+	}
+	if skew := now.Sub(time.Unix(unix, 0)); skew > maxSkew || skew < -maxSkew {
+		return ErrStale
+	}
+
+	bodyHash := req.Header.Get(ContentSHA256Header)
+	if bodyHash == UnsignedPayload {
+		if v.RequireBodyHash {
+			return ErrInvalidSignature
+		}
+	} else if !hmac.Equal([]byte(bodyHash), []byte(BodyHash(body))) {
+		return ErrInvalidSignature
+	}
+
+	algorithm, encoded, ok := strings.Cut(req.Header.Get(SignatureHeader), "=")
+	if !ok {
+		return ErrInvalidSignature
+	}
+	signature, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	message := []byte(StringToSign(req, timestamp, bodyHash))
+
+	switch {
+	case algorithm == HMACSHA256 && len(v.Secret) > 0:
+		mac := hmac.New(sha256.New, v.Secret)
+		mac.Write(message)
+		if hmac.Equal(signature, mac.Sum(nil)) {
+			return nil
+		}
+	case algorithm == Ed25519 && len(v.PublicKey) == ed25519.PublicKeySize:
+		if ed25519.Verify(v.PublicKey, message, signature) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
@@ -0,0 +1,140 @@
+package signing
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signedRequest(t *testing.T, signer *Signer, algorithm string, body string, now time.Time) *http.Request {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "http://fn.internal/forecast?days=3", strings.NewReader(body))
+	req.Header.Set("X-Consumer-ID", "consumer-1")
+	req.Header.Set("X-Subscription-ID", "sub-1")
+	if err := signer.Sign(req, "alice/weather", algorithm, BodyHash([]byte(body)), now); err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestSignAndVerify(t *testing.T) {
+	signer, err := NewSigner(Config{Key: []byte("master")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1760000000, 0)
+	body := `{"city":"Oslo"}`
+
+	verifiers := map[string]*Verifier{
+		HMACSHA256: {Secret: signer.Secret("alice/weather")},
+		Ed25519:    {PublicKey: signer.PublicKey("Alice/Weather")},
+	}
+	for algorithm, verifier := range verifiers {
+		req := signedRequest(t, signer, algorithm, body, now)
+		if !strings.HasPrefix(req.Header.Get(SignatureHeader), algorithm+"=") {
+			t.Errorf("%s: signature header = %q", algorithm, req.Header.Get(SignatureHeader))
+		}
+		if err := verifier.Verify(req, []byte(body), now.Add(time.Minute)); err != nil {
+			t.Errorf("%s: valid request rejected: %v", algorithm, err)
+		}
+
+		tampered := map[string]func(*http.Request) ([]byte, time.Time){
+			"body": func(r *http.Request) ([]byte, time.Time) { return []byte(`{"city":"Rome"}`), now },
+			"path": func(r *http.Request) ([]byte, time.Time) {
+				r.URL.RawQuery = "days=30"
+				return []byte(body), now
+			},
+			"consumer": func(r *http.Request) ([]byte, time.Time) {
+				r.Header.Set("X-Consumer-ID", "consumer-2")
+				return []byte(body), now
+			},
+			"stale": func(r *http.Request) ([]byte, time.Time) { return []byte(body), now.Add(10 * time.Minute) },
+		}
+		for name, tamper := range tampered {
+			req := signedRequest(t, signer, algorithm, body, now)
+			body, at := tamper(req)
+			if err := verifier.Verify(req, body, at); err == nil {
+				t.Errorf("%s: request with changed %s verified", algorithm, name)
+			}
+		}
+	}
+
+	// Keys differ per API
+	other := &Verifier{Secret: signer.Secret("bob/weather")}
+	if err := other.Verify(signedRequest(t, signer, HMACSHA256, body, now), []byte(body), now); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("other API's secret verified the request: %v", err)
+	}
+}
+
+func TestQueryIsSignedInCanonicalForm(t *testing.T) {
+	signer, _ := NewSigner(Config{Key: []byte("master")})
+	verifier := &Verifier{Secret: signer.Secret("alice/weather")}
+	now := time.Unix(1760000000, 0)
+
+	req := httptest.NewRequest(http.MethodGet, "/forecast?q=New+York&days=3&days=1&tz", nil)
+	if err := signer.Sign(req, "alice/weather", HMACSHA256, BodyHash(nil), now); err != nil {
+		t.Fatal(err)
+	}
+
+	// Functions that only get the parsed parameters rebuild the query in
+	// another order and encoding
+	req.URL.RawQuery = "days=1&tz=&q=New%20York&days=3"
+	if err := verifier.Verify(req, nil, now); err != nil {
+		t.Errorf("reordered query rejected: %v", err)
+	}
+	req.URL.RawQuery = "days=1&q=New%20York&days=4"
+	if err := verifier.Verify(req, nil, now); err == nil {
+		t.Error("changed query verified")
+	}
+}
+
+func TestCanonicalQuery(t *testing.T) {
+	tests := map[string]string{
+		"":                    "",
+		"b=2&a=1":             "a=1&b=2",
+		"a=2&a=10&a=1":        "a=1&a=10&a=2",
+		"flag":                "flag=",
+		"q=a+b&r=a%20b":       "q=a%20b&r=a%20b",
+		"k=%7e~*'()!":         "k=~~%2A%27%28%29%21",
+		"city=Z%C3%BCrich":    "city=Z%C3%BCrich",
+		"x=1&bad=%zz&y=2":     "x=1&y=2",
+		"&&a=1&":              "a=1",
+		"plus=1%2B1&amp=a%26": "amp=a%26&plus=1%2B1",
+	}
+	for raw, want := range tests {
+		if got := CanonicalQuery(raw); got != want {
+			t.Errorf("CanonicalQuery(%q) = %q, want %q", raw, got, want)
+		}
+	}
+}
+
+func TestUnsignedPayload(t *testing.T) {
+	signer, _ := NewSigner(Config{Key: []byte("master")})
+	now := time.Unix(1760000000, 0)
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("large body"))
+	if err := signer.Sign(req, "alice/files", "", UnsignedPayload, now); err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := &Verifier{Secret: signer.Secret("alice/files")}
+	if err := verifier.Verify(req, nil, now); err != nil {
+		t.Errorf("unsigned payload rejected: %v", err)
+	}
+	verifier.RequireBodyHash = true
+	if err := verifier.Verify(req, nil, now); err == nil {
+		t.Error("unsigned payload accepted when body hash is required")
+	}
+}
+
+func TestNewSignerValidatesConfig(t *testing.T) {
+	if _, err := NewSigner(Config{}); err == nil {
+		t.Error("empty key accepted")
+	}
+	if _, err := NewSigner(Config{Key: []byte("k"), Algorithm: "rsa"}); err == nil {
+		t.Error("unknown algorithm accepted")
+	}
+}
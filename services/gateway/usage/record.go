@@ -0,0 +1,49 @@
+package usage
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// Record is the usage of one API call as reported to the Metering Service
+type Record struct {
+	// ID identifies the record so metering stores it once however often
+	// the gateway retries
+	ID                string `json:"id"`
+	SubscriptionID    string `json:"subscription_id"`
+	APIKeyID          string `json:"api_key_id"`
+	Timestamp         string `json:"timestamp"`
+	Endpoint          string `json:"endpoint"`
+	Method            string `json:"method"`
+	StatusCode        int    `json:"status_code"`
+	ResponseTimeMs    int64  `json:"response_time_ms"`
+	RequestSizeBytes  int64  `json:"request_size_bytes"`
+	ResponseSizeBytes int64  `json:"response_size_bytes"`
+	// Streaming is set for event-stream, incrementally flushed and upgraded
+	// responses.
+	// ResponseTimeMs is then the time to first byte and DurationMs the
+	// lifetime of the stream.
+	Streaming  bool  `json:"streaming,omitempty"`
+	DurationMs int64 `json:"duration_ms"`
+	// CacheHit is set when the gateway served the response from its cache.
+	// Billable is false for calls the consumer shouldn't be charged for.
+	CacheHit bool `json:"cache_hit,omitempty"`
+	Billable bool `json:"billable"`
+	// Units is the call's weight, the number of units it is billed for.
+	// OverageUnits are the ones past the subscription's quota.
+	Units        int `json:"units"`
+	OverageUnits int `json:"overage_units,omitempty"`
+	// APIVersion is the version of the API that served the call
+	APIVersion string `json:"api_version,omitempty"`
+}
+
+// NewID returns a random version 4 UUID for a record
+func NewID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("usage: failed to generate record ID: %v", err))
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,365 @@
+package usage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/api-direct/services/shared/tracing"
+)
+
+// Config controls how usage records are batched and retried
+type Config struct {
+	// MeteringURL is the base URL of the Metering Service
+	MeteringURL string
+	// BatchSize is the most records sent in one request
+	BatchSize int
+	// FlushInterval is the longest a record waits for its batch to fill
+	FlushInterval time.Duration
+	// BufferSize is the number of records held in memory before they are
+	// written straight to the spool
+	BufferSize int
+	// SpoolDir holds batches that couldn't be delivered, so they survive
+	// restarts
+	SpoolDir string
+	// MaxSpoolBytes bounds the spool; records are dropped beyond it
+	MaxSpoolBytes int64
+	// Timeout bounds each request to the Metering Service
+	Timeout time.Duration
+	// MinBackoff and MaxBackoff bound the wait between delivery retries
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// batch is the body of a bulk ingestion request
+type batch struct {
+	Records []Record `json:"records"`
+}
+
+// permanentError is a delivery failure that retrying won't fix
+type permanentError struct {
+	status int
+	body   string
+}
+
+func (e *permanentError) Error() string {
+	return fmt.Sprintf("metering service rejected batch: %d - %s", e.status, e.body)
+}
+
+// Shipper batches usage records and delivers them to the Metering Service.
+// Batches that can't be delivered are spooled to disk and retried with
+// backoff until metering accepts them.
+type Shipper struct {
+	config Config
+	client *http.Client
+	spool  *spool
+
+	records chan Record
+	wake    chan struct{}
+
+	// mu guards closed so no record is queued after the final drain
+	mu     sync.RWMutex
+	closed bool
+	stop   chan struct{}
+
+	// ctx is cancelled when Close gives up waiting for the drain
+	ctx    context.Context
+	cancel context.CancelFunc
+	// replayCtx is cancelled as soon as Close is called
+	replayCtx    context.Context
+	cancelReplay context.CancelFunc
+
+	wg sync.WaitGroup
+}
+
+// NewShipper creates a shipper, picking up any batches spooled by a
+// previous run. Call Start to begin delivery.
+func NewShipper(config Config) (*Shipper, error) {
+	if config.BatchSize <= 0 {
+		config.BatchSize = 500
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = time.Second
+	}
+	if config.BufferSize <= 0 {
+		config.BufferSize = 10000
+	}
+	if config.SpoolDir == "" {
+		config.SpoolDir = filepath.Join(os.TempDir(), "gateway-usage")
+	}
+	if config.MaxSpoolBytes <= 0 {
+		config.MaxSpoolBytes = 512 << 20
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = time.Second
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = time.Minute
+	}
+	if config.MaxBackoff < config.MinBackoff {
+		config.MaxBackoff = config.MinBackoff
+	}
+
+	spool, err := openSpool(config.SpoolDir, config.MaxSpoolBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Shipper{
+		config:  config,
+		client:  &http.Client{Timeout: config.Timeout, Transport: tracing.Transport(nil)},
+		spool:   spool,
+		records: make(chan Record, config.BufferSize),
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.replayCtx, s.cancelReplay = context.WithCancel(context.Background())
+	return s, nil
+}
+
+// Start begins batching records and replaying the spool
+func (s *Shipper) Start() {
+	if n := s.spool.pending(); n > 0 {
+		log.Printf("Replaying %d spooled usage batches", n)
+	}
+	s.wg.Add(2)
+	go s.run()
+	go s.replay()
+}
+
+// Ship queues a record for delivery without blocking. Records that don't fit
+// in the buffer, or arrive after Close, are spooled to disk.
+func (s *Shipper) Ship(record Record) {
+	if record.ID == "" {
+		record.ID = NewID()
+	}
+
+	s.mu.RLock()
+	if !s.closed {
+		select {
+		case s.records <- record:
+			s.mu.RUnlock()
+			return
+		default:
+		}
+	}
+	s.mu.RUnlock()
+
+	s.spoolRecords([]Record{record})
+}
+
+// Close stops accepting records, sends what is buffered and waits for
+// delivery to finish. Records that can't be delivered before ctx expires are
+// left in the spool for the next run.
+func (s *Shipper) Close(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.stop)
+		s.cancelReplay()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		// Cancelled requests fail and their batches go to the spool
+		s.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// run collects records into batches and sends them when they are full or
+// the flush interval passes
+func (s *Shipper) run() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.config.FlushInterval)
+	defer ticker.Stop()
+
+	pending := make([]Record, 0, s.config.BatchSize)
+	flush := func() {
+		if len(pending) > 0 {
+			s.send(pending)
+			pending = make([]Record, 0, s.config.BatchSize)
+		}
+	}
+
+	for {
+		select {
+		case record := <-s.records:
+			pending = append(pending, record)
+			if len(pending) >= s.config.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-s.stop:
+			// Drain the buffer; nothing is queued once stop is closed
+			for {
+				select {
+				case record := <-s.records:
+					pending = append(pending, record)
+					if len(pending) >= s.config.BatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// send delivers a batch, spooling it if metering is unavailable
+func (s *Shipper) send(records []Record) {
+	body, err := json.Marshal(batch{Records: records})
+	if err != nil {
+		log.Printf("Failed to marshal %d usage records: %v", len(records), err)
+		return
+	}
+
+	// While older batches are waiting, metering is known to be down; let the
+	// replay loop retry with backoff
+	if s.spool.pending() > 0 {
+		s.spoolBatch(body, len(records))
+		return
+	}
+
+	err = s.post(s.ctx, body)
+	var permanent *permanentError
+	switch {
+	case err == nil:
+	case errors.As(err, &permanent):
+		log.Printf("Dropping %d usage records: %v", len(records), err)
+	default:
+		log.Printf("Failed to ship %d usage records, spooling: %v", len(records), err)
+		s.spoolBatch(body, len(records))
+	}
+}
+
+// replay retries spooled batches, oldest first, backing off while metering
+// is unavailable
+func (s *Shipper) replay() {
+	defer s.wg.Done()
+
+	backoff := s.config.MinBackoff
+	for {
+		name, body, ok := s.spool.oldest()
+		if !ok {
+			select {
+			case <-s.wake:
+				continue
+			case <-s.stop:
+				return
+			}
+		}
+
+		err := s.post(s.replayCtx, body)
+		var permanent *permanentError
+		if err == nil || errors.As(err, &permanent) {
+			if err != nil {
+				log.Printf("Dropping spooled usage batch %s: %v", name, err)
+			}
+			s.spool.remove(name)
+			backoff = s.config.MinBackoff
+			continue
+		}
+
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+		log.Printf("Failed to replay usage batch %s, retrying in %s: %v", name, backoff, err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-s.stop:
+			timer.Stop()
+			return
+		}
+		backoff *= 2
+		if backoff > s.config.MaxBackoff {
+			backoff = s.config.MaxBackoff
+		}
+	}
+}
+
+// post sends a batch to the bulk ingestion endpoint
+func (s *Shipper) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.MeteringURL+"/api/v1/usage/batch", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusAccepted {
+		var result struct {
+			Rejected []struct {
+				Index int    `json:"index"`
+				Error string `json:"error"`
+			} `json:"rejected"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err == nil && len(result.Rejected) > 0 {
+			log.Printf("Metering service rejected %d usage records, first: %s", len(result.Rejected), result.Rejected[0].Error)
+		}
+		return nil
+	}
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusRequestTimeout {
+		return &permanentError{status: resp.StatusCode, body: string(respBody)}
+	}
+	return fmt.Errorf("metering service returned error: %d - %s", resp.StatusCode, string(respBody))
+}
+
+func (s *Shipper) spoolRecords(records []Record) {
+	body, err := json.Marshal(batch{Records: records})
+	if err != nil {
+		log.Printf("Failed to marshal %d usage records: %v", len(records), err)
+		return
+	}
+	s.spoolBatch(body, len(records))
+}
+
+// spoolBatch writes a batch to disk and wakes the replay loop
+func (s *Shipper) spoolBatch(body []byte, n int) {
+	if err := s.spool.write(body); err != nil {
+		log.Printf("Dropping %d usage records, failed to spool: %v", n, err)
+		return
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
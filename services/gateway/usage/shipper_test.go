@@ -0,0 +1,167 @@
+package usage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeMetering records the batches it accepts and fails while down is set
+type fakeMetering struct {
+	*httptest.Server
+	down atomic.Bool
+
+	mu      sync.Mutex
+	batches int
+	ids     map[string]int
+}
+
+func newFakeMetering(t *testing.T) *fakeMetering {
+	t.Helper()
+	m := &fakeMetering{ids: make(map[string]int)}
+	m.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/usage/batch" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if m.down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var body batch
+		json.NewDecoder(r.Body).Decode(&body)
+		m.mu.Lock()
+		m.batches++
+		for _, record := range body.Records {
+			m.ids[record.ID]++
+		}
+		m.mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"accepted": 1, "rejected": []}`))
+	}))
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *fakeMetering) received() (batches, records int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.batches, len(m.ids)
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func shipRecords(s *Shipper, n int) {
+	for i := 0; i < n; i++ {
+		s.Ship(Record{SubscriptionID: "sub-1", APIKeyID: "key-1", Endpoint: fmt.Sprintf("/e/%d", i)})
+	}
+}
+
+func TestShipperBatchesRecords(t *testing.T) {
+	metering := newFakeMetering(t)
+	shipper, err := NewShipper(Config{
+		MeteringURL:   metering.URL,
+		BatchSize:     10,
+		FlushInterval: time.Hour,
+		SpoolDir:      t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	shipper.Start()
+
+	shipRecords(shipper, 25)
+	waitFor(t, "full batches", func() bool {
+		batches, _ := metering.received()
+		return batches == 2
+	})
+
+	// The partial batch is sent on shutdown
+	if err := shipper.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if batches, records := metering.received(); batches != 3 || records != 25 {
+		t.Errorf("received %d records in %d batches, want 25 in 3", records, batches)
+	}
+}
+
+func TestShipperSpoolsWhileMeteringIsDown(t *testing.T) {
+	metering := newFakeMetering(t)
+	metering.down.Store(true)
+
+	shipper, err := NewShipper(Config{
+		MeteringURL:   metering.URL,
+		BatchSize:     5,
+		FlushInterval: 10 * time.Millisecond,
+		SpoolDir:      t.TempDir(),
+		MinBackoff:    10 * time.Millisecond,
+		MaxBackoff:    20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	shipper.Start()
+	defer shipper.Close(context.Background())
+
+	shipRecords(shipper, 12)
+	waitFor(t, "spooled batches", func() bool { return shipper.spool.pending() > 0 })
+
+	metering.down.Store(false)
+	waitFor(t, "replayed records", func() bool {
+		_, records := metering.received()
+		return records == 12
+	})
+	waitFor(t, "empty spool", func() bool { return shipper.spool.pending() == 0 })
+}
+
+func TestSpooledRecordsSurviveRestart(t *testing.T) {
+	metering := newFakeMetering(t)
+	metering.down.Store(true)
+	dir := t.TempDir()
+
+	config := Config{
+		MeteringURL:   metering.URL,
+		FlushInterval: time.Hour,
+		SpoolDir:      dir,
+		MinBackoff:    time.Hour,
+	}
+	shipper, err := NewShipper(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shipper.Start()
+	shipRecords(shipper, 3)
+	if err := shipper.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if shipper.spool.pending() != 1 {
+		t.Fatalf("spooled %d batches on shutdown, want 1", shipper.spool.pending())
+	}
+
+	metering.down.Store(false)
+	restarted, err := NewShipper(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted.Start()
+	defer restarted.Close(context.Background())
+
+	waitFor(t, "records from previous run", func() bool {
+		_, records := metering.received()
+		return records == 3
+	})
+}
@@ -0,0 +1,126 @@
+package usage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errSpoolFull is returned when the spool has reached its size limit
+var errSpoolFull = errors.New("usage spool is full")
+
+// spool is a directory of batches waiting to be shipped. Each batch is one
+// file, named so that files sort in the order they were written.
+type spool struct {
+	dir      string
+	maxBytes int64
+
+	mu    sync.Mutex
+	seq   uint64
+	size  int64
+	count int
+}
+
+// openSpool creates dir if needed and picks up batches left by a previous run
+func openSpool(dir string, maxBytes int64) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create usage spool: %w", err)
+	}
+	s := &spool{dir: dir, maxBytes: maxBytes}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read usage spool: %w", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			// Partial writes from a crash
+			os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			s.size += info.Size()
+			s.count++
+		}
+	}
+	return s, nil
+}
+
+// write stores a batch. The file only appears once it is complete.
+func (s *spool) write(body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.maxBytes > 0 && s.size+int64(len(body)) > s.maxBytes {
+		return errSpoolFull
+	}
+
+	s.seq++
+	name := fmt.Sprintf("%020d-%06d.json", time.Now().UnixNano(), s.seq%1000000)
+	path := filepath.Join(s.dir, name)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, body, 0o600); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	s.size += int64(len(body))
+	s.count++
+	return nil
+}
+
+// oldest returns the oldest batch, or ok false if the spool is empty
+func (s *spool) oldest() (name string, body []byte, ok bool) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return "", nil, false
+	}
+	// ReadDir sorts by file name
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		body, err := os.ReadFile(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		return entry.Name(), body, true
+	}
+	return "", nil, false
+}
+
+// remove deletes a batch once it has been shipped
+func (s *spool) remove(name string) {
+	path := filepath.Join(s.dir, name)
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	if err := os.Remove(path); err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.size -= info.Size()
+	s.count--
+	s.mu.Unlock()
+}
+
+// pending returns the number of batches waiting in the spool
+func (s *spool) pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count
+}
@@ -0,0 +1,120 @@
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/routers"
+)
+
+// ErrNoExample is returned when the spec has no example response for an operation
+var ErrNoExample = errors.New("operation has no example response")
+
+// Example is a response documented by an API's spec
+type Example struct {
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+// Example returns the documented example response of the operation r
+// matches. r's path must be relative to the API root. The lowest documented
+// success status is used, falling back to the default response, and its
+// JSON example is taken from the media type's example, its first named
+// example or its schema's example, in that order.
+func (v *Validator) Example(r *http.Request) (*Example, error) {
+	route, _, err := v.router.FindRoute(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, routers.ErrMethodNotAllowed):
+			return nil, ErrMethodNotAllowed
+		case errors.Is(err, routers.ErrPathNotFound):
+			return nil, ErrPathNotFound
+		}
+		return nil, err
+	}
+	if route.Operation == nil || route.Operation.Responses == nil {
+		return nil, ErrNoExample
+	}
+
+	status, response := successResponse(route.Operation.Responses)
+	if response == nil {
+		return nil, ErrNoExample
+	}
+	if len(response.Content) == 0 {
+		// e.g. 204 No Content
+		return &Example{Status: status}, nil
+	}
+
+	for _, contentType := range []string{"application/json", "application/problem+json", "*/*"} {
+		mediaType := response.Content.Get(contentType)
+		if mediaType == nil {
+			continue
+		}
+		value, ok := mediaTypeExample(mediaType)
+		if !ok {
+			continue
+		}
+		body, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		if contentType == "*/*" {
+			contentType = "application/json"
+		}
+		return &Example{Status: status, ContentType: contentType, Body: body}, nil
+	}
+	return nil, ErrNoExample
+}
+
+// successResponse returns the lowest documented 2xx response, or the
+// default response as a 200
+func successResponse(responses *openapi3.Responses) (int, *openapi3.Response) {
+	var statuses []int
+	for key, ref := range responses.Map() {
+		status, err := strconv.Atoi(key)
+		if err != nil || status < 200 || status > 299 || ref == nil || ref.Value == nil {
+			continue
+		}
+		statuses = append(statuses, status)
+	}
+	if len(statuses) > 0 {
+		sort.Ints(statuses)
+		return statuses[0], responses.Status(statuses[0]).Value
+	}
+	if ref := responses.Default(); ref != nil && ref.Value != nil {
+		return http.StatusOK, ref.Value
+	}
+	if ref := responses.Value("2XX"); ref != nil && ref.Value != nil {
+		return http.StatusOK, ref.Value
+	}
+	return 0, nil
+}
+
+// mediaTypeExample returns the example value documented for a media type
+func mediaTypeExample(mediaType *openapi3.MediaType) (interface{}, bool) {
+	if mediaType.Example != nil {
+		return mediaType.Example, true
+	}
+
+	// Named examples are a map, so pick one deterministically
+	names := make([]string, 0, len(mediaType.Examples))
+	for name, ref := range mediaType.Examples {
+		if ref != nil && ref.Value != nil && ref.Value.Value != nil {
+			names = append(names, name)
+		}
+	}
+	if len(names) > 0 {
+		sort.Strings(names)
+		return mediaType.Examples[names[0]].Value.Value, true
+	}
+
+	if mediaType.Schema != nil && mediaType.Schema.Value != nil && mediaType.Schema.Value.Example != nil {
+		return mediaType.Schema.Value.Example, true
+	}
+	return nil, false
+}
@@ -0,0 +1,110 @@
+package validation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/api-direct/services/shared/tracing"
+)
+
+// ErrNoSpec is returned when an API hasn't published an OpenAPI document
+var ErrNoSpec = errors.New("API has no OpenAPI document")
+
+// documentation is the marketplace's API documentation response
+type documentation struct {
+	HasOpenAPI  bool            `json:"has_openapi"`
+	OpenAPISpec json.RawMessage `json:"openapi_spec"`
+}
+
+type cachedValidator struct {
+	validator *Validator
+	err       error
+	fetchedAt time.Time
+}
+
+// Loader fetches APIs' OpenAPI documents from the marketplace service and
+// caches the compiled validators
+type Loader struct {
+	marketplaceURL string
+	ttl            time.Duration
+	httpClient     *http.Client
+
+	mu         sync.Mutex
+	validators map[string]*cachedValidator
+}
+
+// NewLoader creates a loader that refetches documents after ttl
+func NewLoader(marketplaceURL string, ttl time.Duration) *Loader {
+	return &Loader{
+		marketplaceURL: marketplaceURL,
+		ttl:            ttl,
+		httpClient:     &http.Client{Timeout: 5 * time.Second, Transport: tracing.Transport(nil)},
+		validators:     make(map[string]*cachedValidator),
+	}
+}
+
+// Validator returns the validator for an API. Failures are cached for the
+// same ttl so an unreachable marketplace isn't asked on every request.
+func (l *Loader) Validator(ctx context.Context, apiID string) (*Validator, error) {
+	l.mu.Lock()
+	cached, ok := l.validators[apiID]
+	l.mu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < l.ttl {
+		return cached.validator, cached.err
+	}
+
+	// The result is shared, so don't let one caller's cancellation poison it
+	validator, err := l.fetch(context.WithoutCancel(ctx), apiID)
+	l.mu.Lock()
+	l.validators[apiID] = &cachedValidator{validator: validator, err: err, fetchedAt: time.Now()}
+	l.mu.Unlock()
+	return validator, err
+}
+
+func (l *Loader) fetch(ctx context.Context, apiID string) (*Validator, error) {
+	endpoint := fmt.Sprintf("%s/api/v1/marketplace/apis/%s/documentation", l.marketplaceURL, url.PathEscape(apiID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := l.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch OpenAPI document: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNoSpec
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("marketplace returned status %d", resp.StatusCode)
+	}
+
+	var doc documentation
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, err
+	}
+	if !doc.HasOpenAPI || len(doc.OpenAPISpec) == 0 || string(doc.OpenAPISpec) == "null" {
+		return nil, ErrNoSpec
+	}
+
+	// Documents uploaded as YAML are stored as a JSON string
+	spec := []byte(doc.OpenAPISpec)
+	var text string
+	if json.Unmarshal(doc.OpenAPISpec, &text) == nil {
+		spec = []byte(text)
+	}
+
+	validator, err := NewValidator(ctx, spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI document: %w", err)
+	}
+	return validator, nil
+}
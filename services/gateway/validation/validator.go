@@ -0,0 +1,192 @@
+package validation
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+)
+
+var (
+	// ErrPathNotFound is returned when the spec doesn't document the path
+	ErrPathNotFound = errors.New("path is not documented")
+
+	// ErrMethodNotAllowed is returned when the spec doesn't document the method
+	ErrMethodNotAllowed = errors.New("method is not documented for this path")
+)
+
+// FieldError describes one way a request doesn't match the API's spec
+type FieldError struct {
+	// In is where the problem is: path, query, header, cookie or body
+	In string `json:"in"`
+	// Name is the parameter name, or a JSON pointer into the body
+	Name    string `json:"name,omitempty"`
+	Message string `json:"message"`
+}
+
+// Validator checks requests against an OpenAPI document
+type Validator struct {
+	router routers.Router
+}
+
+// NewValidator compiles an OpenAPI document. Server hosts are dropped since
+// requests reach the gateway on its own host; their base paths are kept.
+func NewValidator(ctx context.Context, spec []byte) (*Validator, error) {
+	loader := openapi3.NewLoader()
+	loader.Context = ctx
+	doc, err := loader.LoadFromData(spec)
+	if err != nil {
+		return nil, err
+	}
+	if err := doc.Validate(ctx); err != nil {
+		return nil, err
+	}
+
+	doc.Servers = relativeServers(doc.Servers)
+	for _, pathItem := range doc.Paths.Map() {
+		pathItem.Servers = relativeServers(pathItem.Servers)
+	}
+
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, err
+	}
+	return &Validator{router: router}, nil
+}
+
+// Validate checks r's path, query, headers and JSON body. r's path must be
+// relative to the API root. The body is restored for forwarding.
+func (v *Validator) Validate(ctx context.Context, r *http.Request) ([]FieldError, error) {
+	route, pathParams, err := v.router.FindRoute(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, routers.ErrMethodNotAllowed):
+			return nil, ErrMethodNotAllowed
+		case errors.Is(err, routers.ErrPathNotFound):
+			return nil, ErrPathNotFound
+		}
+		return nil, err
+	}
+
+	err = openapi3filter.ValidateRequest(ctx, &openapi3filter.RequestValidationInput{
+		Request:    r,
+		PathParams: pathParams,
+		Route:      route,
+		Options: &openapi3filter.Options{
+			MultiError: true,
+			// The gateway has already authenticated the consumer
+			AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+			// Forward the request as sent
+			SkipSettingDefaults: true,
+		},
+	})
+	if err == nil {
+		return nil, nil
+	}
+	return fieldErrors(err), nil
+}
+
+// fieldErrors flattens kin-openapi's errors into FieldErrors
+func fieldErrors(err error) []FieldError {
+	// Only split the top level; a RequestError wraps its own MultiError
+	if multi, ok := err.(openapi3.MultiError); ok {
+		var fields []FieldError
+		for _, e := range multi {
+			fields = append(fields, fieldErrors(e)...)
+		}
+		return fields
+	}
+
+	var requestErr *openapi3filter.RequestError
+	if errors.As(err, &requestErr) {
+		if requestErr.Parameter != nil {
+			return []FieldError{{
+				In:      requestErr.Parameter.In,
+				Name:    requestErr.Parameter.Name,
+				Message: parameterMessage(requestErr),
+			}}
+		}
+		if requestErr.RequestBody != nil {
+			return bodyErrors(requestErr)
+		}
+		return []FieldError{{In: "request", Message: requestErr.Error()}}
+	}
+
+	return []FieldError{{In: "request", Message: err.Error()}}
+}
+
+// bodyErrors reports each schema violation in the body at its JSON pointer
+func bodyErrors(requestErr *openapi3filter.RequestError) []FieldError {
+	var schemaErrs []*openapi3.SchemaError
+	var multi openapi3.MultiError
+	if errors.As(requestErr.Err, &multi) {
+		for _, e := range multi {
+			var schemaErr *openapi3.SchemaError
+			if errors.As(e, &schemaErr) {
+				schemaErrs = append(schemaErrs, schemaErr)
+			}
+		}
+	} else {
+		var schemaErr *openapi3.SchemaError
+		if errors.As(requestErr.Err, &schemaErr) {
+			schemaErrs = append(schemaErrs, schemaErr)
+		}
+	}
+
+	if len(schemaErrs) == 0 {
+		message := requestErr.Reason
+		if requestErr.Err != nil {
+			message = requestErr.Err.Error()
+		}
+		return []FieldError{{In: "body", Message: message}}
+	}
+
+	fields := make([]FieldError, 0, len(schemaErrs))
+	for _, schemaErr := range schemaErrs {
+		fields = append(fields, FieldError{
+			In:      "body",
+			Name:    "/" + strings.Join(schemaErr.JSONPointer(), "/"),
+			Message: schemaErr.Reason,
+		})
+	}
+	return fields
+}
+
+func parameterMessage(requestErr *openapi3filter.RequestError) string {
+	var schemaErr *openapi3.SchemaError
+	if errors.As(requestErr.Err, &schemaErr) {
+		return schemaErr.Reason
+	}
+	if requestErr.Err != nil {
+		return requestErr.Err.Error()
+	}
+	return requestErr.Reason
+}
+
+// relativeServers keeps only the path of each server URL
+func relativeServers(servers openapi3.Servers) openapi3.Servers {
+	var relative openapi3.Servers
+	seen := make(map[string]bool)
+	for _, server := range servers {
+		u, err := url.Parse(server.URL)
+		if err != nil {
+			continue
+		}
+		path := strings.TrimSuffix(u.Path, "/")
+		if path == "" {
+			path = "/"
+		}
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		relative = append(relative, &openapi3.Server{URL: path})
+	}
+	return relative
+}
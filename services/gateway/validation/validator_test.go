@@ -0,0 +1,106 @@
+package validation
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSpec = `{
+  "openapi": "3.0.0",
+  "info": {"title": "Weather", "version": "1.0.0"},
+  "servers": [{"url": "https://weather.example.com/v1"}],
+  "paths": {
+    "/forecast/{city}": {
+      "get": {
+        "parameters": [
+          {"name": "city", "in": "path", "required": true, "schema": {"type": "string", "minLength": 2}},
+          {"name": "days", "in": "query", "required": true, "schema": {"type": "integer", "maximum": 14}}
+        ],
+        "responses": {"200": {"description": "OK"}}
+      }
+    },
+    "/alerts": {
+      "post": {
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {
+            "type": "object",
+            "required": ["city", "severity"],
+            "properties": {
+              "city": {"type": "string"},
+              "severity": {"type": "string", "enum": ["low", "high"]}
+            }
+          }}}
+        },
+        "responses": {"201": {"description": "Created"}}
+      }
+    }
+  }
+}`
+
+func TestValidatorReportsFieldErrors(t *testing.T) {
+	ctx := context.Background()
+	validator, err := NewValidator(ctx, []byte(testSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := httptest.NewRequest(http.MethodGet, "/v1/forecast/london?days=3", nil)
+	if errs, err := validator.Validate(ctx, valid); err != nil || len(errs) != 0 {
+		t.Fatalf("valid request rejected: %v %+v", err, errs)
+	}
+
+	badQuery := httptest.NewRequest(http.MethodGet, "/v1/forecast/london?days=30", nil)
+	errs, err := validator.Validate(ctx, badQuery)
+	if err != nil || len(errs) != 1 || errs[0].In != "query" || errs[0].Name != "days" {
+		t.Errorf("unexpected query errors %v %+v", err, errs)
+	}
+
+	body := strings.NewReader(`{"city": "london", "severity": "extreme"}`)
+	badBody := httptest.NewRequest(http.MethodPost, "/v1/alerts", body)
+	badBody.Header.Set("Content-Type", "application/json")
+	errs, err = validator.Validate(ctx, badBody)
+	if err != nil || len(errs) != 1 || errs[0].In != "body" || errs[0].Name != "/severity" {
+		t.Errorf("unexpected body errors %v %+v", err, errs)
+	}
+
+	if _, err := validator.Validate(ctx, httptest.NewRequest(http.MethodGet, "/v1/unknown", nil)); err != ErrPathNotFound {
+		t.Errorf("undocumented path err = %v", err)
+	}
+	if _, err := validator.Validate(ctx, httptest.NewRequest(http.MethodDelete, "/v1/alerts", nil)); err != ErrMethodNotAllowed {
+		t.Errorf("undocumented method err = %v", err)
+	}
+}
+
+func TestLoaderCachesDocuments(t *testing.T) {
+	var fetches int
+	marketplace := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		switch r.URL.Path {
+		case "/api/v1/marketplace/apis/api-1/documentation":
+			w.Write([]byte(`{"id": "doc-1", "api_id": "api-1", "has_openapi": true, "openapi_spec": ` + testSpec + `}`))
+		default:
+			w.Write([]byte(`{"id": "doc-2", "api_id": "api-2", "has_openapi": false, "markdown_content": "# Docs"}`))
+		}
+	}))
+	defer marketplace.Close()
+
+	loader := NewLoader(marketplace.URL, time.Minute)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := loader.Validator(ctx, "api-1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := loader.Validator(ctx, "api-2"); err != ErrNoSpec {
+		t.Errorf("markdown-only API err = %v", err)
+	}
+	if fetches != 2 {
+		t.Errorf("marketplace fetched %d times, want 2", fetches)
+	}
+}
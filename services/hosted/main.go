@@ -1,13 +1,16 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
 )
 
@@ -23,6 +26,8 @@ type BuildResponse struct {
 
 type DeployRequest struct {
 	APIName       string                 `json:"api_name"`
+	Creator       string                 `json:"creator"`
+	Version       string                 `json:"version"`
 	ImageTag      string                 `json:"image_tag"`
 	Runtime       string                 `json:"runtime"`
 	Endpoints     []Endpoint             `json:"endpoints"`
@@ -53,7 +58,30 @@ type StatusResponse struct {
 var deployments = make(map[string]*DeployResponse)
 var builds = make(map[string]*BuildResponse)
 
+// Gateway route registry, nil when REDIS_URL is not configured
+var routes *routePublisher
+
 func main() {
+	if redisURL := os.Getenv("REDIS_URL"); redisURL != "" {
+		opt, err := redis.ParseURL(redisURL)
+		if err != nil {
+			log.Fatalf("Failed to parse Redis URL: %v", err)
+		}
+		routes = &routePublisher{client: redis.NewClient(opt)}
+	} else {
+		log.Println("⚠️  REDIS_URL not set, hosted deployments will not be registered with the gateway")
+	}
+
+	// Routes are registered under the authenticated creator, so they need a
+	// user pool to verify creators against
+	var users *userVerifier
+	if userPoolID := os.Getenv("COGNITO_USER_POOL_ID"); userPoolID != "" {
+		users = newUserVerifier(os.Getenv("COGNITO_REGION"), userPoolID)
+	} else if routes != nil {
+		log.Println("⚠️  COGNITO_USER_POOL_ID not set, hosted deployments will not be registered with the gateway")
+		routes = nil
+	}
+
 	r := gin.Default()
 
 	// CORS middleware
@@ -79,7 +107,7 @@ func main() {
 	v1 := r.Group("/hosted/v1")
 	{
 		v1.POST("/build", handleBuild)
-		v1.POST("/deploy", handleDeploy)
+		v1.POST("/deploy", authRequired(users), handleDeploy)
 		v1.GET("/status/:deployment_id", handleStatus)
 		v1.GET("/deployments", handleListDeployments)
 	}
@@ -121,6 +149,20 @@ func handleDeploy(c *gin.Context) {
 		return
 	}
 
+	// The route is registered under the caller, never a creator named in
+	// the request
+	creator := c.GetString("username")
+	if routes != nil {
+		if req.Creator != "" && !strings.EqualFold(req.Creator, creator) {
+			c.JSON(403, gin.H{"error": "Cannot deploy another creator's API"})
+			return
+		}
+		if err := validateRouteName(creator, req.APIName); err != nil {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid API route: %v", err)})
+			return
+		}
+	}
+
 	deploymentID := uuid.New().String()
 	
 	// Generate subdomain
@@ -150,6 +192,21 @@ func handleDeploy(c *gin.Context) {
 		time.Sleep(5 * time.Second)
 		deployments[deploymentID].Status = "running"
 		log.Printf("✅ Deployment %s is now running", deploymentID)
+
+		// Route gateway traffic to the running deployment
+		version := req.Version
+		if version == "" {
+			version = deploymentID[:8]
+		}
+		err := routes.register(context.Background(), Route{
+			Creator:   creator,
+			APIName:   req.APIName,
+			Version:   version,
+			Upstreams: []string{endpoint},
+		})
+		if err != nil {
+			log.Printf("❌ Failed to register route for %s: %v", deploymentID, err)
+		}
 	}()
 	
 	c.JSON(200, response)
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// routeKeyPrefix and routeUpdatesChannel must match the gateway's route registry
+	routeKeyPrefix      = "gateway:routes:"
+	routeUpdatesChannel = "gateway:routes:updates"
+)
+
+// Route describes where a single version of an API is served from
+type Route struct {
+	Creator   string    `json:"creator"`
+	APIName   string    `json:"api_name"`
+	Version   string    `json:"version"`
+	Upstreams []string  `json:"upstreams"`
+	Mode      string    `json:"mode"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// routePublisher writes hosted deployments to the gateway route registry
+type routePublisher struct {
+	client *redis.Client
+}
+
+// register stores a route and notifies the gateways to reload it
+func (p *routePublisher) register(ctx context.Context, route Route) error {
+	if p == nil {
+		return nil
+	}
+
+	if err := validateRouteName(route.Creator, route.APIName); err != nil {
+		return err
+	}
+
+	route.Mode = "hosted"
+	route.UpdatedAt = time.Now().UTC()
+
+	data, err := json.Marshal(route)
+	if err != nil {
+		return fmt.Errorf("failed to marshal route: %w", err)
+	}
+
+	id := strings.ToLower(route.Creator) + "/" + strings.ToLower(route.APIName)
+	pipe := p.client.TxPipeline()
+	pipe.HSet(ctx, routeKeyPrefix+id, route.Version, data)
+	pipe.Publish(ctx, routeUpdatesChannel, id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to register route: %w", err)
+	}
+	return nil
+}
+
+// validateRouteName checks the creator and API name a route is stored
+// under. They are the gateway's /api/:creator/:apiName path segments, so
+// neither may be empty or contain a slash, and the API name can't contain
+// the @ that pins a version.
+func validateRouteName(creator, apiName string) error {
+	if creator == "" || apiName == "" {
+		return fmt.Errorf("creator and api name are required")
+	}
+	if strings.Contains(creator, "/") || strings.ContainsAny(apiName, "/@") {
+		return fmt.Errorf("creator and api name can't contain a slash, nor the api name an @")
+	}
+	return nil
+}
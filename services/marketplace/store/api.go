@@ -2,6 +2,7 @@ package store
 
 import (
     "database/sql"
+    "encoding/json"
     "fmt"
     "strings"
     "time"
@@ -160,17 +161,43 @@ func (s *APIStore) ListAPIs(params ListParams) ([]*API, int, error) {
     return apis, total, nil
 }
 
-func (s *APIStore) GetDocumentation(apiID string) (interface{}, error) {
-    // Mock implementation - return placeholder documentation
-    return map[string]interface{}{
-        "api_id": apiID,
-        "openapi": "3.0.0",
-        "info": map[string]interface{}{
-            "title": "API Documentation",
-            "version": "1.0.0",
-        },
-        "paths": map[string]interface{}{},
-    }, nil
+// Documentation is an API's published documentation
+type Documentation struct {
+    ID              string          `json:"id"`
+    APIID           string          `json:"api_id"`
+    OpenAPISpec     json.RawMessage `json:"openapi_spec,omitempty"`
+    MarkdownContent string          `json:"markdown_content,omitempty"`
+    HasOpenAPI      bool            `json:"has_openapi"`
+}
+
+func (s *APIStore) GetDocumentation(apiID string) (*Documentation, error) {
+    var doc Documentation
+    var spec []byte
+    var markdown sql.NullString
+    
+    query := `
+        SELECT id, api_id, openapi_spec, markdown_content, COALESCE(has_openapi, false)
+        FROM api_documentation
+        WHERE api_id = $1
+    `
+    
+    err := s.db.QueryRow(query, apiID).Scan(
+        &doc.ID, &doc.APIID, &spec, &markdown, &doc.HasOpenAPI,
+    )
+    
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return nil, fmt.Errorf("documentation not found")
+        }
+        return nil, err
+    }
+    
+    if len(spec) > 0 {
+        doc.OpenAPISpec = json.RawMessage(spec)
+    }
+    doc.MarkdownContent = markdown.String
+    
+    return &doc, nil
 }
 
 func (s *APIStore) GetAllPublishedAPIs() ([]*API, error) {
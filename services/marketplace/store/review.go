@@ -361,12 +361,15 @@ func (s *ReviewStore) RespondToReview(reviewID, creatorID, response string) erro
 	return nil
 }
 
-// verifyPurchase checks if a consumer has an active subscription to an API
+// verifyPurchase checks if an organization the consumer is a member of has
+// an active subscription to an API
 func (s *ReviewStore) verifyPurchase(consumerID, apiID string) (bool, error) {
 	var count int
 	err := s.db.QueryRow(`
 		SELECT COUNT(*) FROM subscriptions 
-		WHERE consumer_id = $1 
+		WHERE organization_id IN (
+			SELECT organization_id FROM organization_members WHERE consumer_id = $1
+		)
 		AND api_id = $2 
 		AND status = 'active'
 		AND started_at < NOW()
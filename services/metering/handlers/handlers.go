@@ -1,12 +1,19 @@
 package handlers
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"time"
 
 	"github.com/apidirect/metering/store"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/google/uuid"
 )
 
@@ -24,35 +31,58 @@ func NewHandler(usageStore *store.UsageStore, aggregationStore *store.Aggregatio
 	}
 }
 
-// RecordUsage handles usage recording from the API Gateway
-func (h *Handler) RecordUsage(c *gin.Context) {
-	var req struct {
-		SubscriptionID    string `json:"subscription_id" binding:"required"`
-		APIKeyID          string `json:"api_key_id" binding:"required"`
-		Timestamp         string `json:"timestamp" binding:"required"`
-		Endpoint          string `json:"endpoint" binding:"required"`
-		Method            string `json:"method" binding:"required"`
-		StatusCode        int    `json:"status_code" binding:"required"`
-		ResponseTimeMs    int64  `json:"response_time_ms"`
-		RequestSizeBytes  int64  `json:"request_size_bytes"`
-		ResponseSizeBytes int64  `json:"response_size_bytes"`
-	}
+// usageRequest is a usage record as reported by the API Gateway
+type usageRequest struct {
+	// ID is set by gateways that retry, so records are stored only once
+	ID                string `json:"id"`
+	SubscriptionID    string `json:"subscription_id" binding:"required"`
+	APIKeyID          string `json:"api_key_id" binding:"required"`
+	Timestamp         string `json:"timestamp" binding:"required"`
+	Endpoint          string `json:"endpoint" binding:"required"`
+	Method            string `json:"method" binding:"required"`
+	StatusCode        int    `json:"status_code" binding:"required"`
+	ResponseTimeMs    int64  `json:"response_time_ms"`
+	RequestSizeBytes  int64  `json:"request_size_bytes"`
+	ResponseSizeBytes int64  `json:"response_size_bytes"`
+	Streaming         bool   `json:"streaming"`
+	DurationMs        int64  `json:"duration_ms"`
+	CacheHit          bool   `json:"cache_hit"`
+	// Billable defaults to true for gateways that don't report it
+	Billable *bool `json:"billable"`
+	// Units defaults to 1 for gateways that don't weigh calls
+	Units        int    `json:"units" binding:"omitempty,min=1"`
+	OverageUnits int    `json:"overage_units" binding:"omitempty,min=0,ltefield=Units"`
+	APIVersion   string `json:"api_version"`
+}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+// toRecord converts the request into a usage record
+func (req *usageRequest) toRecord() (*store.UsageRecord, error) {
+	id := uuid.New()
+	if req.ID != "" {
+		parsed, err := uuid.Parse(req.ID)
+		if err != nil {
+			return nil, errors.New("Invalid id format")
+		}
+		id = parsed
 	}
 
 	// Parse timestamp
 	timestamp, err := time.Parse(time.RFC3339, req.Timestamp)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid timestamp format"})
-		return
+		return nil, errors.New("Invalid timestamp format")
 	}
 
-	// Create usage record
-	record := &store.UsageRecord{
-		ID:                uuid.New(),
+	billable := true
+	if req.Billable != nil {
+		billable = *req.Billable
+	}
+	units := req.Units
+	if units == 0 {
+		units = 1
+	}
+
+	return &store.UsageRecord{
+		ID:                id,
 		SubscriptionID:    req.SubscriptionID,
 		APIKeyID:          req.APIKeyID,
 		Timestamp:         timestamp,
@@ -62,24 +92,246 @@ func (h *Handler) RecordUsage(c *gin.Context) {
 		ResponseTimeMs:    req.ResponseTimeMs,
 		RequestSizeBytes:  req.RequestSizeBytes,
 		ResponseSizeBytes: req.ResponseSizeBytes,
+		Streaming:         req.Streaming,
+		DurationMs:        req.DurationMs,
+		CacheHit:          req.CacheHit,
+		Billable:          billable,
+		Units:             units,
+		OverageUnits:      req.OverageUnits,
+		APIVersion:        req.APIVersion,
+	}, nil
+}
+
+// RecordUsage handles usage recording from the API Gateway
+func (h *Handler) RecordUsage(c *gin.Context) {
+	var req usageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Create usage record
+	record, err := req.toRecord()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
 	// Store the record
-	if err := h.usageStore.RecordUsage(record); err != nil {
+	inserted, err := h.usageStore.RecordUsage(record)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record usage"})
 		return
 	}
 
+	// Retried reports were counted the first time
+	if !inserted {
+		c.JSON(http.StatusAccepted, gin.H{"status": resultDuplicate})
+		return
+	}
+
 	// Update real-time counters asynchronously
-	go func() {
-		ctx := context.Background()
-		successful := req.StatusCode < 400
-		h.aggregationStore.IncrementUsageCounter(ctx, req.SubscriptionID, successful)
-	}()
+	go h.incrementCounters([]*store.UsageRecord{record})
 
 	c.JSON(http.StatusAccepted, gin.H{"status": "accepted"})
 }
 
+// maxBatchSize caps the number of records accepted by RecordUsageBatch
+const maxBatchSize = 5000
+
+// maxBatchBytes caps the size of a RecordUsageBatch request body
+const maxBatchBytes = 32 << 20
+
+// errBatchTooLarge is returned when a batch has more than maxBatchSize records
+var errBatchTooLarge = fmt.Errorf("Batches are limited to %d records", maxBatchSize)
+
+// Per-record outcomes reported by RecordUsageBatch
+const (
+	resultInserted  = "inserted"
+	resultDuplicate = "duplicate"
+	resultRejected  = "rejected"
+)
+
+// usageResult is the outcome of one record of a batch
+type usageResult struct {
+	Index  int    `json:"index"`
+	ID     string `json:"id,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// RecordUsageBatch handles batches of usage records from the API Gateway and
+// other clients. The batch is sent as NDJSON (Content-Type
+// application/x-ndjson), a JSON array of records, or a JSON object with a
+// records array. Every record needs a client-generated UUID id: records whose
+// id was already stored are reported as duplicates and not counted twice, so
+// clients can safely retry. Invalid records are rejected individually and the
+// rest are stored.
+func (h *Handler) RecordUsageBatch(c *gin.Context) {
+	raw, err := readUsageBatch(c)
+	if errors.Is(err, errBatchTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(raw) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "records is required"})
+		return
+	}
+
+	results := make([]usageResult, len(raw))
+	reject := func(i int, err error) {
+		results[i].Status = resultRejected
+		results[i].Error = err.Error()
+	}
+
+	records := make([]*store.UsageRecord, 0, len(raw))
+	indexes := make([]int, 0, len(raw))
+	for i := range raw {
+		results[i].Index = i
+
+		var req usageRequest
+		if err := json.Unmarshal(raw[i], &req); err != nil {
+			reject(i, err)
+			continue
+		}
+		results[i].ID = req.ID
+		if req.ID == "" {
+			reject(i, errors.New("id is required"))
+			continue
+		}
+		if err := binding.Validator.ValidateStruct(&req); err != nil {
+			reject(i, err)
+			continue
+		}
+		record, err := req.toRecord()
+		if err != nil {
+			reject(i, err)
+			continue
+		}
+		records = append(records, record)
+		indexes = append(indexes, i)
+	}
+
+	var inserted []*store.UsageRecord
+	if len(records) > 0 {
+		var failed map[int]error
+		inserted, failed, err = h.usageStore.RecordUsageBatch(records)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record usage"})
+			return
+		}
+		for i, err := range failed {
+			reject(indexes[i], err)
+		}
+
+		stored := make(map[*store.UsageRecord]bool, len(inserted))
+		for _, record := range inserted {
+			stored[record] = true
+		}
+		for i, record := range records {
+			if results[indexes[i]].Status != "" {
+				continue
+			}
+			if stored[record] {
+				results[indexes[i]].Status = resultInserted
+			} else {
+				results[indexes[i]].Status = resultDuplicate
+			}
+		}
+	}
+
+	// Update real-time counters asynchronously
+	go h.incrementCounters(inserted)
+
+	type rejection struct {
+		Index int    `json:"index"`
+		Error string `json:"error"`
+	}
+	rejected := []rejection{}
+	duplicates := 0
+	for _, result := range results {
+		switch result.Status {
+		case resultRejected:
+			rejected = append(rejected, rejection{Index: result.Index, Error: result.Error})
+		case resultDuplicate:
+			duplicates++
+		}
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"accepted":   len(raw) - len(rejected),
+		"inserted":   len(inserted),
+		"duplicates": duplicates,
+		"rejected":   rejected,
+		"results":    results,
+	})
+}
+
+// readUsageBatch splits a batch request body into its records, leaving each
+// to be decoded separately so a malformed record doesn't reject the batch
+func readUsageBatch(c *gin.Context) ([]json.RawMessage, error) {
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, maxBatchBytes)
+
+	switch c.ContentType() {
+	case "application/x-ndjson", "application/ndjson":
+		var records []json.RawMessage
+		reader := bufio.NewReader(body)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if line = bytes.TrimSpace(line); len(line) > 0 {
+				if len(records) == maxBatchSize {
+					return nil, errBatchTooLarge
+				}
+				records = append(records, json.RawMessage(line))
+			}
+			if err == io.EOF {
+				return records, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+	default:
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		data = bytes.TrimSpace(data)
+
+		var records []json.RawMessage
+		if len(data) > 0 && data[0] == '[' {
+			err = json.Unmarshal(data, &records)
+		} else {
+			var req struct {
+				Records []json.RawMessage `json:"records"`
+			}
+			err = json.Unmarshal(data, &req)
+			records = req.Records
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(records) > maxBatchSize {
+			return nil, errBatchTooLarge
+		}
+		return records, nil
+	}
+}
+
+// incrementCounters updates the real-time usage counters for new records
+func (h *Handler) incrementCounters(records []*store.UsageRecord) {
+	ctx := context.Background()
+	for _, record := range records {
+		successful := record.StatusCode < 400
+		h.aggregationStore.IncrementUsageCounter(ctx, record.SubscriptionID, successful)
+	}
+}
+
 // GetUsageSummary returns usage summary for billing purposes
 func (h *Handler) GetUsageSummary(c *gin.Context) {
 	// Get query parameters
@@ -304,19 +556,42 @@ func calculateAnalytics(records []*store.UsageRecord) gin.H {
 		return gin.H{
 			"avg_response_time": 0,
 			"error_rate":        0,
+			"cache_hit_rate":    0,
 			"peak_hour":         nil,
 		}
 	}
 
 	var totalResponseTime int64
-	var errorCount int
+	var errorCount, cacheHits int
 	hourCounts := make(map[int]int)
 
+	// Per-version totals let creators compare versions during a canary
+	type versionTotals struct {
+		calls, errors     int
+		totalResponseTime int64
+	}
+	versions := make(map[string]*versionTotals)
+
 	for _, record := range records {
+		if record.APIVersion != "" {
+			v, ok := versions[record.APIVersion]
+			if !ok {
+				v = &versionTotals{}
+				versions[record.APIVersion] = v
+			}
+			v.calls++
+			v.totalResponseTime += record.ResponseTimeMs
+			if record.StatusCode >= 400 {
+				v.errors++
+			}
+		}
 		totalResponseTime += record.ResponseTimeMs
 		if record.StatusCode >= 400 {
 			errorCount++
 		}
+		if record.CacheHit {
+			cacheHits++
+		}
 		hour := record.Timestamp.Hour()
 		hourCounts[hour]++
 	}
@@ -331,11 +606,22 @@ func calculateAnalytics(records []*store.UsageRecord) gin.H {
 		}
 	}
 
+	versionAnalytics := make(gin.H, len(versions))
+	for version, v := range versions {
+		versionAnalytics[version] = gin.H{
+			"calls":             v.calls,
+			"error_rate":        float64(v.errors) / float64(v.calls),
+			"avg_response_time": v.totalResponseTime / int64(v.calls),
+		}
+	}
+
 	return gin.H{
 		"avg_response_time": totalResponseTime / int64(len(records)),
 		"error_rate":        float64(errorCount) / float64(len(records)),
+		"cache_hit_rate":    float64(cacheHits) / float64(len(records)),
 		"peak_hour":         peakHour,
 		"peak_hour_calls":   peakCount,
+		"versions":          versionAnalytics,
 	}
 }
 
@@ -348,6 +634,11 @@ func calculateSummaryFromRecords(subscriptionID string, records []*store.UsageRe
 		EndpointUsage:  make(map[string]int64),
 	}
 
+	var billableCalls, billableUnits, overageUnits int64
+	summary.BillableCalls = &billableCalls
+	summary.BillableUnits = &billableUnits
+	summary.OverageUnits = &overageUnits
+
 	for _, record := range records {
 		if record.StatusCode < 400 {
 			summary.SuccessfulCalls++
@@ -358,6 +649,14 @@ func calculateSummaryFromRecords(subscriptionID string, records []*store.UsageRe
 		summary.TotalRequestSize += record.RequestSizeBytes
 		summary.TotalResponseSize += record.ResponseSizeBytes
 		summary.EndpointUsage[record.Endpoint]++
+		if record.CacheHit {
+			summary.CacheHits++
+		}
+		if record.Billable {
+			billableCalls++
+			billableUnits += int64(record.Units)
+			overageUnits += int64(record.OverageUnits)
+		}
 	}
 
 	return summary
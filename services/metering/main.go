@@ -11,6 +11,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/api-direct/services/shared/tracing"
 	"github.com/apidirect/metering/aggregator"
 	"github.com/apidirect/metering/handlers"
 	"github.com/apidirect/metering/middleware"
@@ -76,9 +77,19 @@ func main() {
 	c.Start()
 	defer c.Stop()
 
+	// Initialize tracing to continue traces started by the gateway
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Config{
+		ServiceName: "metering",
+		Exporter:    viper.GetString("OTEL_TRACES_EXPORTER"),
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
+	}
+
 	// Initialize Gin router
 	gin.SetMode(viper.GetString("GIN_MODE"))
 	r := gin.New()
+	r.Use(tracing.Middleware("metering", "/health"))
 	r.Use(gin.Recovery())
 	r.Use(middleware.CORS())
 	r.Use(gin.Logger())
@@ -96,6 +107,7 @@ func main() {
 	{
 		// Usage ingestion endpoint (called by Gateway)
 		api.POST("/usage", h.RecordUsage)
+		api.POST("/usage/batch", h.RecordUsageBatch)
 
 		// Query endpoints (require authentication)
 		protected := api.Group("/")
@@ -145,5 +157,9 @@ func main() {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
 
+	if err := shutdownTracing(ctx); err != nil {
+		log.Printf("Error shutting down tracing: %v", err)
+	}
+
 	log.Println("Server exited")
 }
@@ -24,6 +24,16 @@ type UsageSummary struct {
 	TotalRequestSize  int64     `json:"total_request_size_bytes"`
 	TotalResponseSize int64     `json:"total_response_size_bytes"`
 	EndpointUsage     map[string]int64 `json:"endpoint_usage"`
+	CacheHits         int64     `json:"cache_hits"`
+	// BillableCalls excludes calls the API doesn't charge for. It is nil in
+	// summaries aggregated before it was tracked.
+	BillableCalls     *int64    `json:"billable_calls,omitempty"`
+	// BillableUnits weighs billable calls by their endpoint's weight. It is
+	// nil in summaries aggregated before it was tracked.
+	BillableUnits     *int64    `json:"billable_units,omitempty"`
+	// OverageUnits are the billable units past the plan's quota, included
+	// in BillableUnits
+	OverageUnits      *int64    `json:"overage_units,omitempty"`
 }
 
 // AggregationStore handles aggregated usage data
@@ -154,7 +164,11 @@ func (s *AggregationStore) calculateUsageSummary(subscriptionID string, start, e
 			SUM(CASE WHEN u.status_code >= 400 THEN 1 ELSE 0 END) as failed_calls,
 			COALESCE(SUM(u.response_time_ms), 0) as total_response_time,
 			COALESCE(SUM(u.request_size_bytes), 0) as total_request_size,
-			COALESCE(SUM(u.response_size_bytes), 0) as total_response_size
+			COALESCE(SUM(u.response_size_bytes), 0) as total_response_size,
+			SUM(CASE WHEN u.cache_hit THEN 1 ELSE 0 END) as cache_hits,
+			SUM(CASE WHEN u.billable THEN 1 ELSE 0 END) as billable_calls,
+			COALESCE(SUM(CASE WHEN u.billable THEN u.units ELSE 0 END), 0) as billable_units,
+			COALESCE(SUM(CASE WHEN u.billable THEN u.overage_units ELSE 0 END), 0) as overage_units
 		FROM api_usage u
 		JOIN subscriptions s ON u.subscription_id = s.id
 		WHERE u.subscription_id = $1 
@@ -164,6 +178,7 @@ func (s *AggregationStore) calculateUsageSummary(subscriptionID string, start, e
 	`
 	
 	var summary UsageSummary
+	var billableCalls, billableUnits, overageUnits int64
 	err := s.db.QueryRow(query, subscriptionID, start, end).Scan(
 		&summary.SubscriptionID,
 		&summary.ConsumerID,
@@ -174,6 +189,10 @@ func (s *AggregationStore) calculateUsageSummary(subscriptionID string, start, e
 		&summary.TotalResponseTime,
 		&summary.TotalRequestSize,
 		&summary.TotalResponseSize,
+		&summary.CacheHits,
+		&billableCalls,
+		&billableUnits,
+		&overageUnits,
 	)
 	if err != nil {
 		return nil, err
@@ -181,6 +200,9 @@ func (s *AggregationStore) calculateUsageSummary(subscriptionID string, start, e
 	
 	summary.PeriodStart = start
 	summary.PeriodEnd = end
+	summary.BillableCalls = &billableCalls
+	summary.BillableUnits = &billableUnits
+	summary.OverageUnits = &overageUnits
 	
 	// Get endpoint breakdown
 	endpointQuery := `
@@ -252,7 +274,11 @@ func (s *AggregationStore) GetAPIUsageSummary(apiID string, start, end time.Time
 			SUM(CASE WHEN u.status_code >= 400 THEN 1 ELSE 0 END) as failed_calls,
 			COALESCE(SUM(u.response_time_ms), 0) as total_response_time,
 			COALESCE(SUM(u.request_size_bytes), 0) as total_request_size,
-			COALESCE(SUM(u.response_size_bytes), 0) as total_response_size
+			COALESCE(SUM(u.response_size_bytes), 0) as total_response_size,
+			SUM(CASE WHEN u.cache_hit THEN 1 ELSE 0 END) as cache_hits,
+			SUM(CASE WHEN u.billable THEN 1 ELSE 0 END) as billable_calls,
+			COALESCE(SUM(CASE WHEN u.billable THEN u.units ELSE 0 END), 0) as billable_units,
+			COALESCE(SUM(CASE WHEN u.billable THEN u.overage_units ELSE 0 END), 0) as overage_units
 		FROM api_usage u
 		JOIN subscriptions s ON u.subscription_id = s.id
 		WHERE s.api_id = $1 
@@ -261,6 +287,7 @@ func (s *AggregationStore) GetAPIUsageSummary(apiID string, start, end time.Time
 	`
 	
 	var summary UsageSummary
+	var billableCalls, billableUnits, overageUnits int64
 	err := s.db.QueryRow(query, apiID, start, end).Scan(
 		&summary.APIID,
 		&summary.TotalCalls,
@@ -269,6 +296,10 @@ func (s *AggregationStore) GetAPIUsageSummary(apiID string, start, end time.Time
 		&summary.TotalResponseTime,
 		&summary.TotalRequestSize,
 		&summary.TotalResponseSize,
+		&summary.CacheHits,
+		&billableCalls,
+		&billableUnits,
+		&overageUnits,
 	)
 	if err != nil {
 		return nil, err
@@ -276,6 +307,9 @@ func (s *AggregationStore) GetAPIUsageSummary(apiID string, start, end time.Time
 	
 	summary.PeriodStart = start
 	summary.PeriodEnd = end
+	summary.BillableCalls = &billableCalls
+	summary.BillableUnits = &billableUnits
+	summary.OverageUnits = &overageUnits
 	
 	return &summary, nil
 }
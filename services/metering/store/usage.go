@@ -2,6 +2,8 @@ package store
 
 import (
 	"database/sql"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -19,8 +21,32 @@ type UsageRecord struct {
 	ResponseTimeMs    int64     `json:"response_time_ms"`
 	RequestSizeBytes  int64     `json:"request_size_bytes"`
 	ResponseSizeBytes int64     `json:"response_size_bytes"`
+	// Streaming marks SSE, chunked and WebSocket traffic, where
+	// ResponseTimeMs is the time to first byte and DurationMs the
+	// lifetime of the connection
+	Streaming  bool  `json:"streaming"`
+	DurationMs int64 `json:"duration_ms"`
+	// CacheHit marks responses served from the gateway cache; Billable is
+	// false when the API doesn't charge for them
+	CacheHit bool `json:"cache_hit"`
+	Billable bool `json:"billable"`
+	// Units is the call's weight from the plan's endpoint limits; billable
+	// calls are charged per unit. OverageUnits are the units past the
+	// subscription's quota, billed at the plan's overage price.
+	Units        int `json:"units"`
+	OverageUnits int `json:"overage_units"`
+	// APIVersion is the version that served the call, for comparing versions
+	// during a canary
+	APIVersion string `json:"api_version,omitempty"`
 }
 
+// usageColumns is the column list scanned by scanUsageRecords, qualified by
+// the api_usage alias "u"
+const usageColumns = `u.id, u.subscription_id, u.api_key_id, u.timestamp, u.endpoint, u.method,
+			   u.status_code, u.response_time_ms, u.request_size_bytes, u.response_size_bytes,
+			   u.streaming, u.duration_ms, u.cache_hit, u.billable, u.units,
+			   u.overage_units, COALESCE(u.api_version, '')`
+
 // UsageStore handles database operations for usage records
 type UsageStore struct {
 	db *sql.DB
@@ -31,21 +57,192 @@ func NewUsageStore(db *sql.DB) *UsageStore {
 	return &UsageStore{db: db}
 }
 
-// RecordUsage stores a new usage record
-func (s *UsageStore) RecordUsage(record *UsageRecord) error {
-	query := `
+// insertUsageQuery inserts a usage record, ignoring records whose ID was
+// already stored so gateways can safely retry
+const insertUsageQuery = `
 		INSERT INTO api_usage (
 			id, subscription_id, api_key_id, timestamp, endpoint, method,
-			status_code, response_time_ms, request_size_bytes, response_size_bytes
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			status_code, response_time_ms, request_size_bytes, response_size_bytes,
+			streaming, duration_ms, cache_hit, billable, units, overage_units, api_version
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, NULLIF($17, ''))
+		ON CONFLICT (id) DO NOTHING
 	`
 
+// RecordUsage stores a new usage record. It returns false if a record with
+// the same ID was already stored, e.g. for a retried report.
+func (s *UsageStore) RecordUsage(record *UsageRecord) (bool, error) {
 	if record.ID == uuid.Nil {
 		record.ID = uuid.New()
 	}
 
-	_, err := s.db.Exec(
-		query,
+	result, err := s.db.Exec(insertUsageQuery, usageArgs(record)...)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// usageInsertChunk is the number of records stored by one multi-row INSERT,
+// keeping its parameters under Postgres' limit of 65535
+const usageInsertChunk = 1000
+
+// RecordUsageBatch stores usage records in a single transaction, with one
+// multi-row INSERT per chunk of records. Records that fail their insert, e.g.
+// for an unknown subscription, are reported in rejected by index without
+// failing the batch. inserted holds the records that weren't already stored;
+// the rest are duplicates, including records repeating an earlier ID in the
+// batch.
+func (s *UsageStore) RecordUsageBatch(records []*UsageRecord) (inserted []*UsageRecord, rejected map[int]error, err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	rejected = make(map[int]error)
+	seen := make(map[uuid.UUID]bool, len(records))
+	var chunk []*UsageRecord
+	var indexes []int
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		stored, err := insertUsageChunk(tx, chunk, indexes, rejected)
+		inserted = append(inserted, stored...)
+		chunk, indexes = chunk[:0], indexes[:0]
+		return err
+	}
+
+	for i, record := range records {
+		if record.ID == uuid.Nil {
+			record.ID = uuid.New()
+		}
+		if seen[record.ID] {
+			continue
+		}
+		seen[record.ID] = true
+
+		chunk = append(chunk, record)
+		indexes = append(indexes, i)
+		if len(chunk) == usageInsertChunk {
+			if err := flush(); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return inserted, rejected, nil
+}
+
+// insertUsageChunk stores records with a single INSERT and returns those that
+// weren't already stored. If the INSERT fails, the records are stored one at
+// a time to find the ones at fault, which are added to rejected under their
+// index in the batch.
+func insertUsageChunk(tx *sql.Tx, records []*UsageRecord, indexes []int, rejected map[int]error) ([]*UsageRecord, error) {
+	// A failed statement aborts the transaction, so the INSERT gets a
+	// savepoint to roll back to
+	if _, err := tx.Exec("SAVEPOINT usage_chunk"); err != nil {
+		return nil, err
+	}
+
+	var query strings.Builder
+	query.WriteString(`
+		INSERT INTO api_usage (
+			id, subscription_id, api_key_id, timestamp, endpoint, method,
+			status_code, response_time_ms, request_size_bytes, response_size_bytes,
+			streaming, duration_ms, cache_hit, billable, units, overage_units, api_version
+		) VALUES `)
+	args := make([]interface{}, 0, len(records)*usageArgCount)
+	for i, record := range records {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		n := len(args)
+		query.WriteString("(")
+		for j := 1; j < usageArgCount; j++ {
+			fmt.Fprintf(&query, "$%d, ", n+j)
+		}
+		fmt.Fprintf(&query, "NULLIF($%d, ''))", n+usageArgCount)
+		args = append(args, usageArgs(record)...)
+	}
+	query.WriteString(" ON CONFLICT (id) DO NOTHING RETURNING id")
+
+	rows, err := tx.Query(query.String(), args...)
+	if err == nil {
+		stored := make(map[uuid.UUID]bool, len(records))
+		for rows.Next() {
+			var id uuid.UUID
+			if err = rows.Scan(&id); err != nil {
+				break
+			}
+			stored[id] = true
+		}
+		if err == nil {
+			err = rows.Err()
+		}
+		rows.Close()
+
+		if err == nil {
+			var inserted []*UsageRecord
+			for _, record := range records {
+				if stored[record.ID] {
+					inserted = append(inserted, record)
+				}
+			}
+			return inserted, nil
+		}
+	}
+
+	if _, err := tx.Exec("ROLLBACK TO SAVEPOINT usage_chunk"); err != nil {
+		return nil, err
+	}
+	return insertUsageRecords(tx, records, indexes, rejected)
+}
+
+// insertUsageRecords stores records one at a time, each under its own
+// savepoint so a failed record doesn't abort the rest
+func insertUsageRecords(tx *sql.Tx, records []*UsageRecord, indexes []int, rejected map[int]error) ([]*UsageRecord, error) {
+	stmt, err := tx.Prepare(insertUsageQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	var inserted []*UsageRecord
+	for i, record := range records {
+		if _, err := tx.Exec("SAVEPOINT usage_record"); err != nil {
+			return nil, err
+		}
+		result, err := stmt.Exec(usageArgs(record)...)
+		if err != nil {
+			if _, err := tx.Exec("ROLLBACK TO SAVEPOINT usage_record"); err != nil {
+				return nil, err
+			}
+			rejected[indexes[i]] = err
+			continue
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			inserted = append(inserted, record)
+		}
+	}
+	return inserted, nil
+}
+
+// usageArgCount is the number of values usageArgs returns for a record
+const usageArgCount = 17
+
+func usageArgs(record *UsageRecord) []interface{} {
+	return []interface{}{
 		record.ID,
 		record.SubscriptionID,
 		record.APIKeyID,
@@ -56,19 +253,23 @@ func (s *UsageStore) RecordUsage(record *UsageRecord) error {
 		record.ResponseTimeMs,
 		record.RequestSizeBytes,
 		record.ResponseSizeBytes,
-	)
-
-	return err
+		record.Streaming,
+		record.DurationMs,
+		record.CacheHit,
+		record.Billable,
+		record.Units,
+		record.OverageUnits,
+		record.APIVersion,
+	}
 }
 
 // GetUsageBySubscription retrieves usage records for a subscription within a time range
 func (s *UsageStore) GetUsageBySubscription(subscriptionID string, start, end time.Time) ([]*UsageRecord, error) {
 	query := `
-		SELECT id, subscription_id, api_key_id, timestamp, endpoint, method,
-			   status_code, response_time_ms, request_size_bytes, response_size_bytes
-		FROM api_usage
-		WHERE subscription_id = $1 AND timestamp >= $2 AND timestamp <= $3
-		ORDER BY timestamp DESC
+		SELECT ` + usageColumns + `
+		FROM api_usage u
+		WHERE u.subscription_id = $1 AND u.timestamp >= $2 AND u.timestamp <= $3
+		ORDER BY u.timestamp DESC
 	`
 
 	rows, err := s.db.Query(query, subscriptionID, start, end)
@@ -77,35 +278,13 @@ func (s *UsageStore) GetUsageBySubscription(subscriptionID string, start, end ti
 	}
 	defer rows.Close()
 
-	var records []*UsageRecord
-	for rows.Next() {
-		record := &UsageRecord{}
-		err := rows.Scan(
-			&record.ID,
-			&record.SubscriptionID,
-			&record.APIKeyID,
-			&record.Timestamp,
-			&record.Endpoint,
-			&record.Method,
-			&record.StatusCode,
-			&record.ResponseTimeMs,
-			&record.RequestSizeBytes,
-			&record.ResponseSizeBytes,
-		)
-		if err != nil {
-			return nil, err
-		}
-		records = append(records, record)
-	}
-
-	return records, rows.Err()
+	return scanUsageRecords(rows)
 }
 
 // GetUsageByConsumer retrieves all usage records for a consumer within a time range
 func (s *UsageStore) GetUsageByConsumer(consumerID string, start, end time.Time) ([]*UsageRecord, error) {
 	query := `
-		SELECT u.id, u.subscription_id, u.api_key_id, u.timestamp, u.endpoint, u.method,
-			   u.status_code, u.response_time_ms, u.request_size_bytes, u.response_size_bytes
+		SELECT ` + usageColumns + `
 		FROM api_usage u
 		JOIN subscriptions s ON u.subscription_id = s.id
 		WHERE s.consumer_id = $1 AND u.timestamp >= $2 AND u.timestamp <= $3
@@ -118,35 +297,13 @@ func (s *UsageStore) GetUsageByConsumer(consumerID string, start, end time.Time)
 	}
 	defer rows.Close()
 
-	var records []*UsageRecord
-	for rows.Next() {
-		record := &UsageRecord{}
-		err := rows.Scan(
-			&record.ID,
-			&record.SubscriptionID,
-			&record.APIKeyID,
-			&record.Timestamp,
-			&record.Endpoint,
-			&record.Method,
-			&record.StatusCode,
-			&record.ResponseTimeMs,
-			&record.RequestSizeBytes,
-			&record.ResponseSizeBytes,
-		)
-		if err != nil {
-			return nil, err
-		}
-		records = append(records, record)
-	}
-
-	return records, rows.Err()
+	return scanUsageRecords(rows)
 }
 
 // GetUsageByAPI retrieves all usage records for an API within a time range
 func (s *UsageStore) GetUsageByAPI(apiID string, start, end time.Time) ([]*UsageRecord, error) {
 	query := `
-		SELECT u.id, u.subscription_id, u.api_key_id, u.timestamp, u.endpoint, u.method,
-			   u.status_code, u.response_time_ms, u.request_size_bytes, u.response_size_bytes
+		SELECT ` + usageColumns + `
 		FROM api_usage u
 		JOIN subscriptions s ON u.subscription_id = s.id
 		WHERE s.api_id = $1 AND u.timestamp >= $2 AND u.timestamp <= $3
@@ -159,38 +316,16 @@ func (s *UsageStore) GetUsageByAPI(apiID string, start, end time.Time) ([]*Usage
 	}
 	defer rows.Close()
 
-	var records []*UsageRecord
-	for rows.Next() {
-		record := &UsageRecord{}
-		err := rows.Scan(
-			&record.ID,
-			&record.SubscriptionID,
-			&record.APIKeyID,
-			&record.Timestamp,
-			&record.Endpoint,
-			&record.Method,
-			&record.StatusCode,
-			&record.ResponseTimeMs,
-			&record.RequestSizeBytes,
-			&record.ResponseSizeBytes,
-		)
-		if err != nil {
-			return nil, err
-		}
-		records = append(records, record)
-	}
-
-	return records, rows.Err()
+	return scanUsageRecords(rows)
 }
 
 // GetRecentUsageForAggregation retrieves recent usage records that haven't been aggregated
 func (s *UsageStore) GetRecentUsageForAggregation(since time.Time) ([]*UsageRecord, error) {
 	query := `
-		SELECT id, subscription_id, api_key_id, timestamp, endpoint, method,
-			   status_code, response_time_ms, request_size_bytes, response_size_bytes
-		FROM api_usage
-		WHERE timestamp >= $1
-		ORDER BY timestamp ASC
+		SELECT ` + usageColumns + `
+		FROM api_usage u
+		WHERE u.timestamp >= $1
+		ORDER BY u.timestamp ASC
 	`
 
 	rows, err := s.db.Query(query, since)
@@ -199,6 +334,11 @@ func (s *UsageStore) GetRecentUsageForAggregation(since time.Time) ([]*UsageReco
 	}
 	defer rows.Close()
 
+	return scanUsageRecords(rows)
+}
+
+// scanUsageRecords reads rows selected with usageColumns
+func scanUsageRecords(rows *sql.Rows) ([]*UsageRecord, error) {
 	var records []*UsageRecord
 	for rows.Next() {
 		record := &UsageRecord{}
@@ -213,6 +353,13 @@ func (s *UsageStore) GetRecentUsageForAggregation(since time.Time) ([]*UsageReco
 			&record.ResponseTimeMs,
 			&record.RequestSizeBytes,
 			&record.ResponseSizeBytes,
+			&record.Streaming,
+			&record.DurationMs,
+			&record.CacheHit,
+			&record.Billable,
+			&record.Units,
+			&record.OverageUnits,
+			&record.APIVersion,
 		)
 		if err != nil {
 			return nil, err
@@ -0,0 +1,117 @@
+// Package tracing sets up OpenTelemetry tracing for the platform's
+// services, so a call's trace continues from the gateway through the
+// services it reaches.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies spans started with Start
+const tracerName = "github.com/api-direct/services/shared/tracing"
+
+// Exporters accepted by Setup
+const (
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterNone   = "none"
+)
+
+// Config selects where spans are exported
+type Config struct {
+	// ServiceName is reported as the service.name resource attribute
+	ServiceName string
+	// Exporter is one of otlp, stdout or none. The OTLP exporter is
+	// configured by the standard OTEL_EXPORTER_OTLP_* variables.
+	Exporter string
+	// SpanExporter overrides Exporter, e.g. with an in-memory exporter in tests
+	SpanExporter sdktrace.SpanExporter
+}
+
+// Setup installs the global tracer provider and W3C trace context
+// propagation. Trace context is propagated even when spans aren't exported.
+// The returned function flushes and stops the exporter.
+func Setup(ctx context.Context, config Config) (func(context.Context) error, error) {
+	exporter := config.SpanExporter
+	if exporter == nil {
+		var err error
+		switch config.Exporter {
+		case ExporterOTLP:
+			exporter, err = otlptracehttp.New(ctx)
+		case ExporterStdout, "console":
+			exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		case ExporterNone, "":
+		default:
+			return nil, fmt.Errorf("unknown trace exporter %q", config.Exporter)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s trace exporter: %w", config.Exporter, err)
+		}
+	}
+
+	options := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", config.ServiceName),
+		)),
+	}
+	if exporter != nil {
+		if config.SpanExporter != nil {
+			// Export synchronously so tests see spans as soon as they end
+			options = append(options, sdktrace.WithSyncer(exporter))
+		} else {
+			options = append(options, sdktrace.WithBatcher(exporter))
+		}
+	}
+
+	provider := sdktrace.NewTracerProvider(options...)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	return provider.Shutdown, nil
+}
+
+// Middleware starts a server span for each request, continuing the trace
+// from the caller's traceparent header. Requests to paths in skip aren't
+// traced.
+func Middleware(service string, skip ...string) gin.HandlerFunc {
+	return otelgin.Middleware(service, otelgin.WithFilter(func(r *http.Request) bool {
+		for _, path := range skip {
+			if r.URL.Path == path {
+				return false
+			}
+		}
+		return true
+	}))
+}
+
+// Transport wraps base so outgoing requests are traced and carry the trace
+// context. A nil base uses http.DefaultTransport.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return otelhttp.NewTransport(base)
+}
+
+// Start starts a span for a stage of request handling, such as a step of
+// the gateway pipeline, as a child of the request's span
+func Start(c *gin.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(c.Request.Context(), name, trace.WithAttributes(attrs...))
+}